@@ -5,3 +5,107 @@ const BIND_PORT = "BIND_PORT"
 const DISABLE_LOGGING = "DISABLE_LOGGING"
 
 const JWT_SIGN_KEY = "JWT_SIGN_KEY"
+
+const JWT_SIGNING_ALG = "JWT_SIGNING_ALG"
+
+const JWT_PRIVATE_KEY_FILE = "JWT_PRIVATE_KEY_FILE"
+
+const JWT_KEY_ID = "JWT_KEY_ID"
+
+const JWT_PREVIOUS_SIGN_KEYS = "JWT_PREVIOUS_SIGN_KEYS"
+
+const JWT_KEYRING_DIR = "JWT_KEYRING_DIR"
+
+const JWT_ISSUER = "JWT_ISSUER"
+
+const JWT_AUDIENCE = "JWT_AUDIENCE"
+
+const JWT_EXPIRES_AFTER = "JWT_EXPIRES_AFTER"
+
+const REFRESH_TOKEN_EXPIRES_AFTER = "REFRESH_TOKEN_EXPIRES_AFTER"
+
+const USER_REPO_TYPE = "USER_REPO_TYPE"
+
+const POSTGRES_DSN = "POSTGRES_DSN"
+
+const POSTGRES_MAX_OPEN_CONNS = "POSTGRES_MAX_OPEN_CONNS"
+
+const POSTGRES_MAX_IDLE_CONNS = "POSTGRES_MAX_IDLE_CONNS"
+
+const POSTGRES_CONN_MAX_LIFETIME = "POSTGRES_CONN_MAX_LIFETIME"
+
+const SQLITE_PATH = "SQLITE_PATH"
+
+const DATA_DIR = "DATA_DIR"
+
+const BACKUP_DIR = "BACKUP_DIR"
+
+const REVOCATION_STORE_TYPE = "REVOCATION_STORE_TYPE"
+
+const REVOCATION_STORE_PATH = "REVOCATION_STORE_PATH"
+
+const PASSWORD_HASH_ALG = "PASSWORD_HASH_ALG"
+
+const PASSWORD_RESET_EXPIRES_AFTER = "PASSWORD_RESET_EXPIRES_AFTER"
+
+const EMAIL_VERIFICATION_EXPIRES_AFTER = "EMAIL_VERIFICATION_EXPIRES_AFTER"
+
+const EMAIL_VERIFICATION_REQUIRED = "EMAIL_VERIFICATION_REQUIRED"
+
+const EMAIL_VERIFICATION_GRACE_PERIOD = "EMAIL_VERIFICATION_GRACE_PERIOD"
+
+const MAGIC_LINK_EXPIRES_AFTER = "MAGIC_LINK_EXPIRES_AFTER"
+
+const MFA_EXPIRES_AFTER = "MFA_EXPIRES_AFTER"
+
+const TOTP_ISSUER = "TOTP_ISSUER"
+
+const WEBAUTHN_RP_ID = "WEBAUTHN_RP_ID"
+
+const WEBAUTHN_RP_NAME = "WEBAUTHN_RP_NAME"
+
+const WEBAUTHN_ORIGINS = "WEBAUTHN_ORIGINS"
+
+const PUBLIC_URL = "PUBLIC_URL"
+
+const MAIL_TRANSPORT_TYPE = "MAIL_TRANSPORT_TYPE"
+
+const MAIL_FROM = "MAIL_FROM"
+
+const MAILDROP_DIR = "MAILDROP_DIR"
+
+const SMTP_HOST = "SMTP_HOST"
+
+const SMTP_PORT = "SMTP_PORT"
+
+const SMTP_USERNAME = "SMTP_USERNAME"
+
+const SMTP_PASSWORD = "SMTP_PASSWORD"
+
+const ACCOUNT_LOCKOUT_THRESHOLD = "ACCOUNT_LOCKOUT_THRESHOLD"
+
+const ACCOUNT_LOCKOUT_ATTEMPTS = "ACCOUNT_LOCKOUT_ATTEMPTS"
+
+const ACCOUNT_LOCKOUT_DURATION = "ACCOUNT_LOCKOUT_DURATION"
+
+const IP_LOCKOUT_THRESHOLD = "IP_LOCKOUT_THRESHOLD"
+
+const IP_LOCKOUT_ATTEMPTS = "IP_LOCKOUT_ATTEMPTS"
+
+const IP_LOCKOUT_DURATION = "IP_LOCKOUT_DURATION"
+
+const LOCKOUT_BASE_DELAY = "LOCKOUT_BASE_DELAY"
+
+const LOCKOUT_WINDOW = "LOCKOUT_WINDOW"
+
+const ADMIN_TOKEN = "ADMIN_TOKEN"
+
+const RATE_LIMIT = "RATE_LIMIT"
+
+const RATE_LIMIT_ROUTES = "RATE_LIMIT_ROUTES"
+
+const RATE_LIMIT_STORE_TYPE = "RATE_LIMIT_STORE_TYPE"
+
+const TRUSTED_PROXIES = "TRUSTED_PROXIES"
+
+const EMAIL_LOCAL_PART = "EMAIL_LOCAL_PART"
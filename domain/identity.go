@@ -0,0 +1,81 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/secure/precis"
+	"golang.org/x/text/unicode/norm"
+)
+
+var ErrInvalidUsername = fmt.Errorf("the username has characters which aren't allowed")
+var ErrInvalidEmail = fmt.Errorf("the email address is not valid")
+
+// EmailLocalPartPolicy is how the part of an email address before the @ is canonicalized
+type EmailLocalPartPolicy string
+
+const (
+	// EmailLocalPartLowercase treats Jack@gmail.com and jack@gmail.com as the
+	// same address, which is what the mail providers do in practice
+	EmailLocalPartLowercase EmailLocalPartPolicy = "lowercase"
+
+	// EmailLocalPartExact keeps the case of the local part, RFC 5321 leaves it
+	// to the receiving server to decide whether it matters
+	EmailLocalPartExact EmailLocalPartPolicy = "exact"
+)
+
+// ParseEmailLocalPartPolicy returns the policy with the name, an empty name is EmailLocalPartLowercase
+func ParseEmailLocalPartPolicy(name string) (EmailLocalPartPolicy, error) {
+	switch p := EmailLocalPartPolicy(strings.ToLower(name)); p {
+	case "":
+		return EmailLocalPartLowercase, nil
+	case EmailLocalPartLowercase, EmailLocalPartExact:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown email local part policy %q", name)
+}
+
+// Canonicalizer maps the usernames and the email addresses to the forms which
+// identify a user. The users keep the forms they registered with for display,
+// the canonical forms are the ones which are unique and looked up.
+type Canonicalizer struct {
+	// default is EmailLocalPartLowercase
+	EmailLocalPart EmailLocalPartPolicy
+}
+
+// DefaultCanonicalizer lowercases the whole email address
+var DefaultCanonicalizer = Canonicalizer{EmailLocalPart: EmailLocalPartLowercase}
+
+// Username returns the username with the PRECIS UsernameCaseMapped profile of
+// RFC 8265 applied after NFKC, so the fullwidth, the compatibility and the
+// differently cased forms of a name are the same user. The names with spaces,
+// symbols or control characters are ErrInvalidUsername.
+func (c Canonicalizer) Username(username string) (string, error) {
+	canonical, err := precis.UsernameCaseMapped.String(norm.NFKC.String(username))
+	if err != nil {
+		return "", ErrInvalidUsername
+	}
+	return canonical, nil
+}
+
+// Email returns the address in NFKC with its domain lowercased and its local
+// part handled by the EmailLocalPart policy
+func (c Canonicalizer) Email(email string) (string, error) {
+	email = norm.NFKC.String(strings.TrimSpace(email))
+
+	// the local part may have a quoted @, the domain can't
+	i := strings.LastIndex(email, "@")
+	if i <= 0 || i == len(email)-1 {
+		return "", ErrInvalidEmail
+	}
+	local, domain := email[:i], strings.ToLower(email[i+1:])
+
+	switch c.EmailLocalPart {
+	case EmailLocalPartExact:
+	case EmailLocalPartLowercase, "":
+		local = strings.ToLower(local)
+	default:
+		return "", fmt.Errorf("unknown email local part policy %q", c.EmailLocalPart)
+	}
+	return local + "@" + domain, nil
+}
@@ -0,0 +1,62 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+var ErrLoginThrottled = fmt.Errorf("too many failed login attempts")
+var ErrAccountLocked = fmt.Errorf("account is temporarily locked")
+
+// LockoutError refuses a login before the password is checked, Err is
+// ErrLoginThrottled or ErrAccountLocked
+type LockoutError struct {
+	Err error
+
+	// how long the logins are refused for
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *LockoutError) Unwrap() error {
+	return e.Err
+}
+
+// LoginFailures are the recent failed login attempts of an account or a client
+type LoginFailures struct {
+	Count        int       `json:"count"`
+	LastFailedAt time.Time `json:"last_failed_at"`
+}
+
+// LoginAttemptCounter counts the failed login attempts per key, the keys are
+// the accounts and the client addresses. Every replica must share it, otherwise
+// each of them allows its own attempts.
+type LoginAttemptCounter interface {
+	// Get returns the failures of the key, the count is 0 if there are none
+	Get(ctx context.Context, key string) (*LoginFailures, error)
+
+	// Fail records a failed attempt and returns the failures including it, they
+	// are forgotten once ttl passes without another failure
+	Fail(ctx context.Context, key string, ttl time.Duration) (*LoginFailures, error)
+
+	// Reset forgets the failures of the key
+	Reset(ctx context.Context, key string) error
+}
+
+type clientIPContextKey struct{}
+
+// WithClientIP returns a copy of the context with the address of the client
+// which sent the request, the login attempts are counted per address as well
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPContextKey{}, ip)
+}
+
+// ClientIPFromContext returns the address of the client, empty if it's unknown
+func ClientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPContextKey{}).(string)
+	return ip
+}
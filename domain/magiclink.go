@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-openapi/strfmt"
+)
+
+// MagicLinkPurpose is the purpose of the login link tokens
+const MagicLinkPurpose = "magic_link"
+
+var ErrInvalidMagicLinkToken = fmt.Errorf("login link is invalid or expired")
+
+// MagicLinkSender delivers the login links, AccountNotifier is one but
+// the links can go through another channel than the other messages
+type MagicLinkSender interface {
+	// SendMagicLink sends the single use login token to the user
+	SendMagicLink(ctx context.Context, u *User, token string) error
+}
+
+type MagicLinkDTO struct {
+	// the email address which the login link is sent to
+	//
+	// required: true
+	// example: john@provider.net
+	Email strfmt.Email `json:"email" validate:"required,email"`
+}
+
+type MagicLinkLoginDTO struct {
+	// the token of the login link
+	//
+	// required: true
+	Token string `json:"token" validate:"required"`
+}
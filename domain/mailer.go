@@ -0,0 +1,52 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+)
+
+// the names of the email templates
+const (
+	PasswordResetTemplate     = "password_reset"
+	EmailVerificationTemplate = "email_verification"
+	MagicLinkTemplate         = "magic_link"
+	EmailChangedTemplate      = "email_changed"
+)
+
+var ErrUnknownMailTemplate = fmt.Errorf("no mail template found with the provided name")
+
+// MailMessage is a rendered email, HTML is optional
+type MailMessage struct {
+	From    string
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// MailTransport delivers the rendered messages
+type MailTransport interface {
+	// Send delivers the message, From is set when it reaches the transport
+	Send(ctx context.Context, m *MailMessage) error
+}
+
+// Mailer renders the templated emails and sends them
+type Mailer interface {
+	// Send renders the template with the data into the subject, the text and
+	// the html bodies and sends the message to the address
+	Send(ctx context.Context, to string, template string, data interface{}) error
+}
+
+// AccountMailData is the data of the account flow templates
+type AccountMailData struct {
+	Username string
+
+	// the link the user follows, it contains the token
+	Link string
+
+	// the token itself, for the clients which don't follow links
+	Token string
+
+	// the new email address of the email change notice
+	Email string
+}
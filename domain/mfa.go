@@ -0,0 +1,101 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// MFARequiredPurpose is the purpose of the challenge tokens which are issued
+// instead of the jwt when the user has to complete the second factor
+const MFARequiredPurpose = "mfa_required"
+
+var ErrInvalidMFAToken = fmt.Errorf("mfa token is invalid or expired")
+var ErrInvalidMFACode = fmt.Errorf("mfa code is invalid")
+var ErrMFAAlreadyEnabled = fmt.Errorf("two-factor authentication is already enabled")
+var ErrNoMFAEnrollment = fmt.Errorf("there is no pending two-factor authentication enrollment")
+var ErrMFANotEnabled = fmt.Errorf("two-factor authentication is not enabled")
+
+// TOTPCredential is the RFC 6238 secret of a user, it's only used for the
+// login after the first code is confirmed.
+type TOTPCredential struct {
+	UserID string `json:"user_id"`
+
+	// the base32 encoded shared secret
+	Secret string `json:"secret"`
+
+	Confirmed bool `json:"confirmed"`
+
+	// the time step of the last accepted code, the codes of the same or
+	// earlier steps are rejected so a code can't be replayed
+	LastUsedStep int64 `json:"last_used_step"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type TOTPEnrollmentDTO struct {
+	// the base32 encoded secret, for the authenticator apps which can't scan the qr code
+	Secret string `json:"secret"`
+
+	// the otpauth:// uri of the secret
+	URI string `json:"uri"`
+
+	// the png image of the qr code of the uri, base64 encoded
+	QRCode []byte `json:"qrCode"`
+}
+
+type MFACodeDTO struct {
+	// the current code of the authenticator app
+	//
+	// required: true
+	// example: 123456
+	Code string `json:"code" validate:"required"`
+}
+
+type MFAVerifyDTO struct {
+	// the mfa token which is returned by the login
+	//
+	// required: true
+	MFAToken string `json:"mfaToken" validate:"required"`
+
+	// the current code of the authenticator app, required without recoveryCode
+	//
+	// example: 123456
+	Code string `json:"code" validate:"required_without=RecoveryCode"`
+
+	// one of the recovery codes, each of them can be used once, required without code
+	//
+	// example: 2k4m-7xq3-fh6p-a5zt
+	RecoveryCode string `json:"recoveryCode" validate:"required_without=Code"`
+}
+
+type RecoveryCodesDTO struct {
+	// the one time recovery codes, they are only shown once
+	Codes []string `json:"codes"`
+}
+
+// TOTPRepository represents the totp credential's repository contract
+type TOTPRepository interface {
+	// Store replaces the credential of the user
+	Store(ctx context.Context, c *TOTPCredential) error
+
+	// GetByUserID ...
+	GetByUserID(ctx context.Context, userID string) (*TOTPCredential, error)
+
+	// Confirm enables the credential, step is the time step of the confirmed code
+	Confirm(ctx context.Context, userID string, step int64) error
+
+	// UseStep records the time step of an accepted code, it returns an error
+	// if the step isn't newer than the last used one
+	UseStep(ctx context.Context, userID string, step int64) error
+}
+
+// RecoveryCodeRepository represents the recovery code's repository contract,
+// only the hashes of the codes are stored
+type RecoveryCodeRepository interface {
+	// Replace replaces every recovery code of the user with the new ones
+	Replace(ctx context.Context, userID string, hashes []string) error
+
+	// Consume deletes the code of the user, it returns an error if the user has no such code
+	Consume(ctx context.Context, userID string, hash string) error
+}
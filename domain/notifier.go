@@ -0,0 +1,18 @@
+package domain
+
+import "context"
+
+// AccountNotifier sends the messages of the account flows to the users
+type AccountNotifier interface {
+	// SendPasswordReset sends the password reset token to the user
+	SendPasswordReset(ctx context.Context, u *User, token string) error
+
+	// SendEmailVerification sends the email verification token to the user
+	SendEmailVerification(ctx context.Context, u *User, token string) error
+
+	// SendEmailChanged tells the user at the old address that the address is
+	// changed to newEmail
+	SendEmailChanged(ctx context.Context, u *User, newEmail string) error
+
+	MagicLinkSender
+}
@@ -0,0 +1,65 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+)
+
+// PasswordResetPurpose is the purpose of the password reset tokens
+const PasswordResetPurpose = "password_reset"
+
+var ErrInvalidResetToken = fmt.Errorf("reset token is invalid or expired")
+
+// OneTimeToken is the server side record of a single use token which is sent
+// to the user, only the hash of the token itself is stored.
+type OneTimeToken struct {
+	Hash      string    `json:"hash"`
+	UserID    string    `json:"user_id"`
+	Purpose   string    `json:"purpose"`
+	Used      bool      `json:"used"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ForgotPasswordDTO struct {
+	// the email address of the user who forgot the password
+	//
+	// required: true
+	// example: john@provider.net
+	Email strfmt.Email `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordDTO struct {
+	// the token which is sent to the user's email address
+	//
+	// required: true
+	Token string `json:"token" validate:"required"`
+
+	// the new password
+	//
+	// required: true
+	// example: $tR0n@p@$SW0rD
+	Password strfmt.Password `json:"password" validate:"required,min=5,max=128"`
+
+	// the repeat of the password field
+	//
+	// required: true
+	// example: $tR0n@p@$SW0rD
+	RepeatPassword strfmt.Password `json:"repeatPassword" validate:"required,min=5,max=128"`
+}
+
+// OneTimeTokenRepository represents the one time token's repository contract
+type OneTimeTokenRepository interface {
+	// Store ...
+	Store(ctx context.Context, t *OneTimeToken) error
+
+	// Consume marks the token as used and returns it, it returns an error if
+	// there is no unused token with the hash and purpose
+	Consume(ctx context.Context, hash, purpose string) (*OneTimeToken, error)
+
+	// DeleteByUser deletes the user's tokens with the purpose
+	DeleteByUser(ctx context.Context, userID, purpose string) error
+}
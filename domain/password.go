@@ -0,0 +1,23 @@
+package domain
+
+import "fmt"
+
+var ErrUnsupportedPasswordHash = fmt.Errorf("the password hash format is not supported")
+
+var ErrPasswordTooLong = fmt.Errorf("the password is too long")
+
+// MaxPasswordLength is the most characters a password can have, hashing a
+// longer one costs the server more than it adds to the security. The
+// validation of the DTOs repeats it.
+const MaxPasswordLength = 128
+
+// PasswordHasher hashes the passwords, the hashes are PHC strings which carry
+// the algorithm and its parameters alongside the salt and the hash
+type PasswordHasher interface {
+	// Hash returns the encoded hash of the password with a random salt
+	Hash(password string) (string, error)
+
+	// Verify reports whether the password matches the encoded hash and whether the
+	// hash is outdated, i.e. it should be replaced with a fresh Hash of the password
+	Verify(password, encoded string) (match bool, needsRehash bool, err error)
+}
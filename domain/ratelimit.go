@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// RateLimit is a token bucket, it holds at most Burst tokens and refills
+// completely in Period, each request takes a token
+type RateLimit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// RateLimitResult is the state of a bucket after a request tried to take a token
+type RateLimitResult struct {
+	Allowed bool
+
+	// the tokens left in the bucket
+	Remaining int
+
+	// how long until the bucket is full again
+	Reset time.Duration
+
+	// how long until the next token, 0 if the request is allowed
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the token buckets, every replica must share it,
+// otherwise each of them allows its own requests. Taking a token has to be
+// atomic, a distributed store runs the refill and the take in one operation.
+type RateLimitStore interface {
+	// Take takes a token from the bucket of the key, the bucket is created full
+	Take(ctx context.Context, key string, limit RateLimit) (*RateLimitResult, error)
+}
@@ -0,0 +1,123 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var ErrInvalidRefreshToken = fmt.Errorf("refresh token is invalid or expired")
+var ErrRefreshTokenReused = fmt.Errorf("refresh token has already been used")
+var ErrInvalidToken = fmt.Errorf("token is invalid or expired")
+
+// Claims are the claims carried by the jwt tokens, the subject is the user's ID
+// and the ID (jti) is unique for every token
+type Claims struct {
+	jwt.StandardClaims
+
+	// the refresh token family the token was issued for
+	SessionID string `json:"sid,omitempty"`
+
+	// the user's token generation when the token was issued, the tokens of
+	// older generations are revoked
+	Generation int `json:"gen"`
+
+	// what a token which isn't an access token is meant for, e.g. EmailVerificationPurpose
+	Purpose string `json:"pur,omitempty"`
+
+	// the email address the token was issued for
+	Email string `json:"email,omitempty"`
+}
+
+type LogoutDTO struct {
+	// revoke every session of the user, not only the current one
+	//
+	// example: false
+	Everywhere bool `json:"everywhere"`
+}
+
+// JSONWebKey is the public part of a signing key, see RFC 7517
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is the set of public keys the tokens can be verified with
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// KeySetProvider publishes the public keys of the token signer
+type KeySetProvider interface {
+	// KeySet never contains the symmetric keys
+	KeySet() *JSONWebKeySet
+}
+
+// TokenVerifier verifies a jwt token and returns its claims
+type TokenVerifier interface {
+	// Verify returns ErrInvalidToken if the token can't be trusted
+	Verify(ctx context.Context, token string) (*Claims, error)
+}
+
+// RefreshToken is the server side record of an opaque refresh token,
+// only the hash of the token itself is stored.
+type RefreshToken struct {
+	Hash      string    `json:"hash"`
+	FamilyID  string    `json:"family_id"`
+	UserID    string    `json:"user_id"`
+	Username  string    `json:"username"`
+	Used      bool      `json:"used"`
+	Revoked   bool      `json:"revoked"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RefreshDTO struct {
+	// the refresh token returned alongside the last jwt token
+	//
+	// required: true
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
+// RefreshTokenRepository represents the refresh token's repository contract
+type RefreshTokenRepository interface {
+	// Store ...
+	Store(ctx context.Context, t *RefreshToken) error
+
+	// GetByHash ...
+	GetByHash(ctx context.Context, hash string) (*RefreshToken, error)
+
+	// MarkUsed marks the token as used, it returns an error if the token is already used
+	MarkUsed(ctx context.Context, hash string) error
+
+	// RevokeFamily revokes every token that has been rotated from the same login
+	RevokeFamily(ctx context.Context, familyID string) error
+
+	// RevokeUser revokes every refresh token of the user
+	RevokeUser(ctx context.Context, userID string) error
+}
+
+// RevocationStore keeps the revoked jwt tokens until they expire and the
+// per user token generations
+type RevocationStore interface {
+	// Revoke denies the token with the jti until it expires
+	Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+
+	// IsRevoked ...
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+
+	// Generation returns the user's current token generation
+	Generation(ctx context.Context, userID string) (int, error)
+
+	// BumpGeneration revokes every token issued for the user so far
+	BumpGeneration(ctx context.Context, userID string) (int, error)
+}
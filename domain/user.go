@@ -3,6 +3,7 @@ package domain
 import (
 	"context"
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/go-openapi/strfmt"
@@ -13,6 +14,9 @@ var ErrEmailPasswordNotMatch = fmt.Errorf("email and the password don't match")
 var ErrEmailAlreadyTaken = fmt.Errorf("email is already taken")
 var ErrUsernameAlreadyTaken = fmt.Errorf("username is already taken")
 var ErrPasswordsDoNotMatch = fmt.Errorf("passwords don't match")
+var ErrCurrentPasswordNotMatch = fmt.Errorf("current password is wrong")
+var ErrCurrentPasswordRequired = fmt.Errorf("current password is required to change the email address")
+var ErrConflict = fmt.Errorf("the user was changed since it was read")
 
 // User ...
 type User struct {
@@ -20,29 +24,41 @@ type User struct {
 	Username  string    `json:"username"`
 	Email     string    `json:"email"`
 	Password  string    `json:"password"`
+	Verified  bool      `json:"verified"`
 	UpdatedAt time.Time `json:"updated_at"`
 	CreatedAt time.Time `json:"created_at"`
+
+	// incremented by every write, an Update must carry the version it read
+	Version int64 `json:"version"`
+
+	// the forms of the username and the email which are unique and looked up,
+	// Username and Email keep what the user typed, see Canonicalizer
+	CanonicalUsername string `json:"canonical_username"`
+	CanonicalEmail    string `json:"canonical_email"`
+
+	// the deleted users aren't returned by the repositories
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 type LoginDTO struct {
-	// the email address for this user
+	// the username or the email address of this user
 	//
 	// required: true
 	// example: john@provider.net
-	Email strfmt.Email `json:"email" validate:"required,email"`
+	Identifier string `json:"identifier" validate:"required,max=254"`
 
 	// the password for this user
 	//
 	// required: true
-	Password strfmt.Password `json:"password" validate:"required"`
+	Password strfmt.Password `json:"password" validate:"required,max=128"`
 }
 
 type RegisterDTO struct {
-	// the username fo the new user
+	// the username fo the new user, it can't have an @ so it's never taken for an email address at login
 	//
 	// required: true
 	// example: john
-	Username string `json:"username" validate:"required,min=5"`
+	Username string `json:"username" validate:"required,min=5,excludes=@"`
 
 	// the email fo the new user
 	//
@@ -54,29 +70,181 @@ type RegisterDTO struct {
 	//
 	// required: true
 	// example: $tR0n@p@$SW0rD
-	Password strfmt.Password `json:"password" validate:"required,min=5"`
+	Password strfmt.Password `json:"password" validate:"required,min=5,max=128"`
+
+	// the repeat of the password field
+	//
+	// required: true
+	// example: $tR0n@p@$SW0rD
+	RepeatPassword strfmt.Password `json:"repeatPassword" validate:"required,min=5,max=128"`
+}
+
+type ChangePasswordDTO struct {
+	// the current password of the user
+	//
+	// required: true
+	CurrentPassword strfmt.Password `json:"currentPassword" validate:"required,max=128"`
+
+	// the new password
+	//
+	// required: true
+	// example: $tR0n@p@$SW0rD
+	Password strfmt.Password `json:"password" validate:"required,min=5,max=128"`
 
 	// the repeat of the password field
 	//
 	// required: true
 	// example: $tR0n@p@$SW0rD
-	RepeatPassword strfmt.Password `json:"repeatPassword" validate:"required,min=5"`
+	RepeatPassword strfmt.Password `json:"repeatPassword" validate:"required,min=5,max=128"`
+
+	// revoke every other session of the user as well, the current session gets a new pair of tokens either way
+	//
+	// example: true
+	LogoutOtherSessions bool `json:"logoutOtherSessions"`
+}
+
+type ProfileDTO struct {
+	// the ID of the user
+	ID string `json:"id"`
+
+	// the username as the user typed it
+	//
+	// example: john
+	Username string `json:"username"`
+
+	// the email address as the user typed it
+	//
+	// example: john@provider.net
+	Email string `json:"email"`
+
+	// whether the email address is verified
+	Verified bool `json:"verified"`
+
+	// the version of the profile, the ETag header of the response has it too
+	//
+	// example: 3
+	Version int64 `json:"version"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type UpdateProfileDTO struct {
+	// the new username, it's kept if it's empty
+	//
+	// example: john
+	Username string `json:"username" validate:"omitempty,min=5,excludes=@"`
+
+	// the new email address, it's kept if it's empty. A new address has to be verified again.
+	//
+	// example: john@provider.net
+	Email strfmt.Email `json:"email" validate:"omitempty,email"`
+
+	// the current password of the user, it's required to change the email address
+	CurrentPassword strfmt.Password `json:"currentPassword" validate:"max=128"`
 }
 
 type JWTDTO struct {
 	// the jwt token for the logged in user
-	Token string `json:"token"`
+	Token string `json:"token,omitempty"`
+
+	// the opaque refresh token, it can be exchanged once for a new pair of tokens
+	RefreshToken string `json:"refreshToken,omitempty"`
+
+	// the number of seconds the jwt token, or the mfa token, is valid for
+	ExpiresIn int64 `json:"expiresIn"`
+
+	// set instead of the tokens when the user has to complete the second factor
+	MFARequired bool `json:"mfaRequired,omitempty"`
+
+	// the single use challenge token which is exchanged at /auth/mfa/verify
+	MFAToken string `json:"mfaToken,omitempty"`
 }
 
 // UserUsecase interface represents the user's usecases
 type UserUsecase interface {
+	// Verify verifies the tokens issued by Login, Create and Refresh
+	TokenVerifier
+
+	// Login logs a user in with the username or email and the password and returns a valid jwt for the user,
+	// if the user has two-factor authentication enabled it returns the mfa token instead. An unknown identifier
+	// is an ErrEmailPasswordNotMatch as well and takes as long as a wrong password.
+	Login(ctx context.Context, ld *LoginDTO) (*JWTDTO, error)
+
+	// SendMagicLink sends a single use login link to the email address, it returns
+	// no error if the email is unknown
+	SendMagicLink(ctx context.Context, md *MagicLinkDTO) error
+
+	// LoginByMagicLink exchanges the token of a login link for the jwt, like
+	// Login it returns the mfa token instead if the user has two-factor
+	// authentication enabled
+	LoginByMagicLink(ctx context.Context, ld *MagicLinkLoginDTO) (*JWTDTO, error)
+
+	// UnlockAccount forgets the failed login attempts of the user's account
+	UnlockAccount(ctx context.Context, userID string) error
+
+	// DeleteUser soft deletes the user and revokes all of its sessions
+	DeleteUser(ctx context.Context, userID string) error
+
+	// VerifyMFA exchanges the mfa token and the code of the second factor for the jwt
+	VerifyMFA(ctx context.Context, vd *MFAVerifyDTO) (*JWTDTO, error)
+
+	// EnrollTOTP creates a new totp secret for the logged in user, it's not used until it's confirmed
+	EnrollTOTP(ctx context.Context, claims *Claims) (*TOTPEnrollmentDTO, error)
+
+	// ConfirmTOTP enables two-factor authentication with the first code of the enrolled secret
+	// and returns the recovery codes
+	ConfirmTOTP(ctx context.Context, claims *Claims, cd *MFACodeDTO) (*RecoveryCodesDTO, error)
+
+	// RegenerateRecoveryCodes replaces the recovery codes of the logged in user
+	RegenerateRecoveryCodes(ctx context.Context, claims *Claims) (*RecoveryCodesDTO, error)
+
+	// BeginWebAuthnRegistration returns the options of the ceremony which registers a new credential for the logged in user
+	BeginWebAuthnRegistration(ctx context.Context, claims *Claims) (*WebAuthnCreationOptionsDTO, error)
+
+	// FinishWebAuthnRegistration verifies and stores the credential the authenticator created
+	FinishWebAuthnRegistration(ctx context.Context, claims *Claims, rd *WebAuthnRegistrationDTO) error
+
+	// BeginWebAuthnLogin returns the options of the passwordless login ceremony
+	BeginWebAuthnLogin(ctx context.Context, ld *WebAuthnLoginDTO) (*WebAuthnRequestOptionsDTO, error)
 
-	// LoginByEmail logs a user in with the email and password and returns a valid jwt for the newly registered user
-	LoginByEmail(ctx context.Context, ld *LoginDTO) (*JWTDTO, error)
+	// FinishWebAuthnLogin verifies the assertion of the authenticator and returns a valid jwt
+	FinishWebAuthnLogin(ctx context.Context, ad *WebAuthnAssertionDTO) (*JWTDTO, error)
 
 	// Create Registers the user and return a valid jwt for the newly registered user
 	Create(ctx context.Context, u *RegisterDTO) (*JWTDTO, error)
 
+	// Refresh rotates the refresh token and returns a new pair of tokens
+	Refresh(ctx context.Context, rd *RefreshDTO) (*JWTDTO, error)
+
+	// Logout revokes the token and its session, or every session of the user if everywhere is set
+	Logout(ctx context.Context, claims *Claims, everywhere bool) error
+
+	// ForgotPassword sends a password reset token to the user, it returns no error if the email is unknown
+	ForgotPassword(ctx context.Context, fd *ForgotPasswordDTO) error
+
+	// ResetPassword sets the new password with a reset token and revokes every session of the user
+	ResetPassword(ctx context.Context, rd *ResetPasswordDTO) error
+
+	// ChangePassword replaces the password of the logged in user and returns a new pair of
+	// tokens for the current session, whose old ones are revoked, the other sessions are
+	// revoked if LogoutOtherSessions is set
+	ChangePassword(ctx context.Context, claims *Claims, cd *ChangePasswordDTO) (*JWTDTO, error)
+
+	// VerifyEmail marks the email address of the user as verified with a verification token
+	VerifyEmail(ctx context.Context, token string) error
+
+	// ResendVerification sends a new verification token, it returns no error if the
+	// email is unknown or already verified
+	ResendVerification(ctx context.Context, rd *ResendVerificationDTO) error
+
+	// GetProfile returns the profile of the logged in user
+	GetProfile(ctx context.Context, claims *Claims) (*ProfileDTO, error)
+
+	// UpdateProfile changes the username and the email of the logged in user if its profile is still
+	// at the version, otherwise it's an ErrConflict. The version 0 changes whichever version is stored.
+	UpdateProfile(ctx context.Context, claims *Claims, version int64, pd *UpdateProfileDTO) (*ProfileDTO, error)
+
 	// CheckEmailAvailable returns EmailAlreadyTakenErr error if the email is not available
 	CheckEmailAvailable(ctx context.Context, email string) error
 
@@ -84,14 +252,43 @@ type UserUsecase interface {
 	CheckUsernameAvailable(ctx context.Context, username string) error
 }
 
-// UserRepository represents the user's repository contract
+// UserRepository represents the user's repository contract, the deleted
+// users are ErrNoUserFound for every method. Every write increments the
+// version of the user.
 type UserRepository interface {
-	// GetByUsername ...
+	// GetByID ...
+	GetByID(ctx context.Context, ID string) (*User, error)
+
+	// GetByUsername gets the user by the canonical form of its username
 	GetByUsername(ctx context.Context, username string) (*User, error)
 
-	// GetByEmail ...
+	// GetByEmail gets the user by the canonical form of its email
 	GetByEmail(ctx context.Context, email string) (*User, error)
 
-	// Store ...
+	// List returns up to limit users ordered by their ID, starting after the ID
+	// after, the next page starts after the ID of the last user of this one
+	List(ctx context.Context, after string, limit int) ([]*User, error)
+
+	// Store sets the ID, the times and the first version of the new user, the canonical username and email must be unique
 	Store(ctx context.Context, u *User) (*User, error)
+
+	// Update replaces the username and the email of the user, with their canonical forms, and returns the stored user,
+	// the password has its own method. u.Version must be the stored version, otherwise the user was changed since it
+	// was read and it's an ErrConflict. A new canonical email isn't verified.
+	Update(ctx context.Context, u *User) (*User, error)
+
+	// Delete marks the user deleted, its username and email stay taken
+	Delete(ctx context.Context, ID string) error
+
+	// UpdatePassword replaces the password hash of the user
+	UpdatePassword(ctx context.Context, ID string, password string) error
+
+	// MarkEmailVerified sets the Verified flag of the user
+	MarkEmailVerified(ctx context.Context, ID string) error
+}
+
+// UserRepositoryBackuper is implemented by the user repositories which can
+// write a consistent snapshot of themselves while serving the requests
+type UserRepositoryBackuper interface {
+	Backup(ctx context.Context, w io.Writer) error
 }
@@ -0,0 +1,21 @@
+package domain
+
+import (
+	"fmt"
+
+	"github.com/go-openapi/strfmt"
+)
+
+// EmailVerificationPurpose is the purpose of the email verification tokens
+const EmailVerificationPurpose = "email_verification"
+
+var ErrInvalidVerificationToken = fmt.Errorf("verification token is invalid or expired")
+var ErrEmailNotVerified = fmt.Errorf("email is not verified")
+
+type ResendVerificationDTO struct {
+	// the email address which the verification link is sent to
+	//
+	// required: true
+	// example: john@provider.net
+	Email strfmt.Email `json:"email" validate:"required,email"`
+}
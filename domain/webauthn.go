@@ -0,0 +1,185 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+)
+
+// the purposes of the WebAuthn challenges, they are stored as one time tokens
+const (
+	WebAuthnRegistrationPurpose = "webauthn_registration"
+	WebAuthnLoginPurpose        = "webauthn_login"
+)
+
+var ErrInvalidWebAuthnResponse = fmt.Errorf("webauthn response is invalid")
+var ErrWebAuthnCredentialExists = fmt.Errorf("webauthn credential is already registered")
+
+// WebAuthnCredential is a public key credential of a user, a passkey or a security key
+type WebAuthnCredential struct {
+	// the credential id which the authenticator chose
+	ID []byte `json:"id"`
+
+	UserID string `json:"user_id"`
+
+	// the cbor encoded COSE_Key
+	PublicKey []byte `json:"public_key"`
+
+	// the signature counter of the authenticator, 0 if it doesn't count
+	SignCount uint32 `json:"sign_count"`
+
+	// the authenticator model, all zeros without attestation
+	AAGUID []byte `json:"aaguid"`
+
+	// how the client can reach the authenticator, e.g. usb, nfc, internal
+	Transports []string `json:"transports"`
+
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+type WebAuthnRelyingParty struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+type WebAuthnUserEntity struct {
+	// the user handle, base64url encoded
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type WebAuthnCredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type WebAuthnCredentialDescriptor struct {
+	Type string `json:"type"`
+
+	// the credential id, base64url encoded
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type WebAuthnAuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+// WebAuthnCreationOptionsDTO is passed to navigator.credentials.create(),
+// the binary members are base64url encoded
+type WebAuthnCreationOptionsDTO struct {
+	PublicKey struct {
+		Challenge              string                         `json:"challenge"`
+		RP                     WebAuthnRelyingParty           `json:"rp"`
+		User                   WebAuthnUserEntity             `json:"user"`
+		PubKeyCredParams       []WebAuthnCredentialParameter  `json:"pubKeyCredParams"`
+		Timeout                int64                          `json:"timeout"`
+		ExcludeCredentials     []WebAuthnCredentialDescriptor `json:"excludeCredentials"`
+		AuthenticatorSelection WebAuthnAuthenticatorSelection `json:"authenticatorSelection"`
+		Attestation            string                         `json:"attestation"`
+	} `json:"publicKey"`
+}
+
+// WebAuthnRequestOptionsDTO is passed to navigator.credentials.get(),
+// the binary members are base64url encoded
+type WebAuthnRequestOptionsDTO struct {
+	PublicKey struct {
+		Challenge        string                         `json:"challenge"`
+		RPID             string                         `json:"rpId"`
+		Timeout          int64                          `json:"timeout"`
+		AllowCredentials []WebAuthnCredentialDescriptor `json:"allowCredentials"`
+		UserVerification string                         `json:"userVerification"`
+	} `json:"publicKey"`
+}
+
+type WebAuthnAttestationResponse struct {
+	// base64url encoded
+	//
+	// required: true
+	ClientDataJSON string `json:"clientDataJSON" validate:"required"`
+
+	// base64url encoded
+	//
+	// required: true
+	AttestationObject string `json:"attestationObject" validate:"required"`
+
+	Transports []string `json:"transports"`
+}
+
+// WebAuthnRegistrationDTO is the PublicKeyCredential which navigator.credentials.create() returns
+type WebAuthnRegistrationDTO struct {
+	// the credential id, base64url encoded
+	//
+	// required: true
+	RawID string `json:"rawId" validate:"required"`
+
+	// required: true
+	// example: public-key
+	Type string `json:"type" validate:"required,eq=public-key"`
+
+	// required: true
+	Response WebAuthnAttestationResponse `json:"response" validate:"required"`
+}
+
+type WebAuthnAssertionResponse struct {
+	// base64url encoded
+	//
+	// required: true
+	ClientDataJSON string `json:"clientDataJSON" validate:"required"`
+
+	// base64url encoded
+	//
+	// required: true
+	AuthenticatorData string `json:"authenticatorData" validate:"required"`
+
+	// base64url encoded
+	//
+	// required: true
+	Signature string `json:"signature" validate:"required"`
+
+	// the user handle the credential was registered with, base64url encoded
+	UserHandle string `json:"userHandle"`
+}
+
+// WebAuthnAssertionDTO is the PublicKeyCredential which navigator.credentials.get() returns
+type WebAuthnAssertionDTO struct {
+	// the credential id, base64url encoded
+	//
+	// required: true
+	RawID string `json:"rawId" validate:"required"`
+
+	// required: true
+	// example: public-key
+	Type string `json:"type" validate:"required,eq=public-key"`
+
+	// required: true
+	Response WebAuthnAssertionResponse `json:"response" validate:"required"`
+}
+
+type WebAuthnLoginDTO struct {
+	// the email address of the user, without it only the discoverable credentials (passkeys) can be used
+	//
+	// example: john@provider.net
+	Email strfmt.Email `json:"email" validate:"omitempty,email"`
+}
+
+// WebAuthnCredentialRepository represents the WebAuthn credential's repository contract
+type WebAuthnCredentialRepository interface {
+	// Store returns an error if a credential with the same ID exists
+	Store(ctx context.Context, c *WebAuthnCredential) error
+
+	// GetByID ...
+	GetByID(ctx context.Context, ID []byte) (*WebAuthnCredential, error)
+
+	// GetByUserID returns the credentials of the user, the oldest first
+	GetByUserID(ctx context.Context, userID string) ([]*WebAuthnCredential, error)
+
+	// UpdateSignCount stores the counter of the last assertion and the time of the use
+	UpdateSignCount(ctx context.Context, ID []byte, signCount uint32) error
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// Admin handles the administrative requests, they authenticate with the
+// static admin token instead of a user's jwt
+type Admin struct {
+	l       *log.Logger
+	usecase domain.UserUsecase
+	token   string
+}
+
+func (a *Admin) AttachRouter(mr *mux.Router) *mux.Router {
+	adminHandler := mr.PathPrefix("/admin").Subrouter()
+
+	adminHandler.HandleFunc("/users/{id}", a.DeleteUser).Methods(http.MethodDelete)
+	adminHandler.HandleFunc("/users/{id}/unlock", a.UnlockUser).Methods(http.MethodPost)
+
+	adminHandler.Use(postProcessMiddleware)
+	adminHandler.Use(a.middleware)
+	return adminHandler
+}
+
+// NewAdmin returns a new Admin handler, the requests must have the token as the bearer token
+func NewAdmin(l *log.Logger, usecase domain.UserUsecase, token string) *Admin {
+	return &Admin{l: l, usecase: usecase, token: token}
+}
+
+// middleware only lets the requests with the admin token through
+func (a *Admin) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// comparing the hashes keeps the length of the token from leaking as well
+		got := sha256.Sum256([]byte(bearerToken(r)))
+		want := sha256.Sum256([]byte(a.token))
+		if a.token == "" || subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+			a.l.Info("Rejected an admin request without the admin token.")
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="minaria-admin"`)
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(ErrInvalidBearerToken.HTTPStatusCode)
+			ToJSON(ErrInvalidBearerToken, rw)
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// swagger:route POST /admin/users/{id}/unlock admin unlockUser
+// Forgets the failed login attempts of the user's account, so a locked
+// account can log in again right away.
+// security:
+//	admin:
+// responses:
+//	204: noContentResponse
+//	401: genericErrorResponse
+//	404: genericErrorResponse
+// 	500: internalErrorResponse
+
+// UnlockUser unlocks the account of a user
+func (a *Admin) UnlockUser(rw http.ResponseWriter, r *http.Request) {
+	a.l.Debug("Handle unlock user request.")
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	ds, _ := time.ParseDuration("5s") // TODO
+	ctx, cancel = context.WithDeadline(r.Context(), time.Now().Add(ds))
+	defer cancel()
+
+	err := a.usecase.UnlockAccount(ctx, mux.Vars(r)["id"])
+	if err == domain.ErrNoUserFound {
+		gerr := GenericError{
+			Message:        err.Error(),
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusNotFound,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	} else if err != nil {
+		a.l.Errorf("Error while unlocking the user: %s.", err.Error())
+		gerr := GenericError{
+			Message:        "internal server error",
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// swagger:route DELETE /admin/users/{id} admin deleteUser
+// Soft deletes the user, its username and email stay taken and all of its
+// sessions are revoked.
+// security:
+//	admin:
+// responses:
+//	204: noContentResponse
+//	401: genericErrorResponse
+//	404: genericErrorResponse
+// 	500: internalErrorResponse
+
+// DeleteUser deletes a user and ends its sessions
+func (a *Admin) DeleteUser(rw http.ResponseWriter, r *http.Request) {
+	a.l.Debug("Handle delete user request.")
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	ds, _ := time.ParseDuration("5s") // TODO
+	ctx, cancel = context.WithDeadline(r.Context(), time.Now().Add(ds))
+	defer cancel()
+
+	err := a.usecase.DeleteUser(ctx, mux.Vars(r)["id"])
+	if err == domain.ErrNoUserFound {
+		gerr := GenericError{
+			Message:        err.Error(),
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusNotFound,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	} else if err != nil {
+		a.l.Errorf("Error while deleting the user: %s.", err.Error())
+		gerr := GenericError{
+			Message:        "internal server error",
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+	"github.com/vahidmostofi/minaria/repositories"
+	"github.com/vahidmostofi/minaria/usecase"
+)
+
+func TestUnlockUser(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+	router := mux.NewRouter()
+	ur, _ := repositories.NewUserRepository(
+		repositories.InMemoryKind,
+		&repositories.InMemoryArgs{Data: testUserData},
+	)
+	uc := usecase.NewUser(l, ur, usecase.UserOptions{
+		AccountLockout: &usecase.LockoutPolicy{LockoutAttempts: 2, LockoutDuration: time.Hour, Window: time.Hour},
+	})
+	NewAuth(l, uc, domain.NewValidation()).AttachRouter(router)
+	NewAdmin(l, uc, "admin-token").AttachRouter(router)
+
+	login := func(password string) *http.Response {
+		b, _ := json.Marshal(&domain.LoginDTO{Identifier: testUserData[1].Email, Password: strfmt.Password(password)})
+		req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewReader(b))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Result()
+	}
+	unlock := func(id, token string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/admin/users/"+id+"/unlock", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	login("wrong")
+	login("wrong")
+	resp := login("1234567")
+	gerr := &GenericError{}
+	if !basicHTTPResponseChecks(t, http.StatusLocked, desiredContentType, gerr, resp) {
+		return
+	}
+	assert.Equal(t, "3600", resp.Header.Get("Retry-After"))
+	assert.Equal(t, domain.ErrAccountLocked.Error(), gerr.Message)
+
+	tests := []struct {
+		name       string
+		id         string
+		token      string
+		statusCode int
+	}{
+		{name: "unauthorized - wrong token", id: testUserData[1].ID, token: "admin", statusCode: http.StatusUnauthorized},
+		{name: "unauthorized - no token", id: testUserData[1].ID, token: "", statusCode: http.StatusUnauthorized},
+		{name: "not found", id: "no-such-id", token: "admin-token", statusCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gerr := &GenericError{}
+			basicHTTPResponseChecks(t, tt.statusCode, desiredContentType, gerr, unlock(tt.id, tt.token))
+		})
+	}
+
+	assert.Equal(t, http.StatusNoContent, unlock(testUserData[1].ID, "admin-token").StatusCode)
+	assert.Equal(t, http.StatusOK, login("1234567").StatusCode)
+}
+
+func TestDeleteUser(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+	router := mux.NewRouter()
+	ur, _ := repositories.NewUserRepository(
+		repositories.InMemoryKind,
+		&repositories.InMemoryArgs{Data: testUserData},
+	)
+	uc := usecase.NewUser(l, ur, usecase.UserOptions{})
+	NewAuth(l, uc, domain.NewValidation()).AttachRouter(router)
+	NewAdmin(l, uc, "admin-token").AttachRouter(router)
+
+	b, _ := json.Marshal(&domain.LoginDTO{Identifier: testUserData[1].Email, Password: "1234567"})
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewReader(b))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	jwt := &domain.JWTDTO{}
+	if !basicHTTPResponseChecks(t, http.StatusOK, desiredContentType, jwt, w.Result()) {
+		return
+	}
+
+	remove := func(id, token string) *http.Response {
+		req := httptest.NewRequest(http.MethodDelete, "/admin/users/"+id, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	tests := []struct {
+		name       string
+		id         string
+		token      string
+		statusCode int
+	}{
+		{name: "unauthorized - wrong token", id: testUserData[1].ID, token: "admin", statusCode: http.StatusUnauthorized},
+		{name: "not found", id: "no-such-id", token: "admin-token", statusCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gerr := &GenericError{}
+			basicHTTPResponseChecks(t, tt.statusCode, desiredContentType, gerr, remove(tt.id, tt.token))
+		})
+	}
+
+	assert.Equal(t, http.StatusNoContent, remove(testUserData[1].ID, "admin-token").StatusCode)
+	assert.Equal(t, http.StatusNotFound, remove(testUserData[1].ID, "admin-token").StatusCode)
+
+	// the token of the deleted user is revoked
+	_, err := uc.Verify(context.TODO(), jwt.Token)
+	assert.Equal(t, domain.ErrInvalidToken, err)
+}
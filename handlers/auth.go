@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -25,6 +28,7 @@ type Auth struct {
 	l       *log.Logger
 	usecase domain.UserUsecase
 	v       *domain.Validation
+	authn   *Authenticator
 }
 
 func (a *Auth) AttachRouter(mr *mux.Router) *mux.Router {
@@ -32,6 +36,17 @@ func (a *Auth) AttachRouter(mr *mux.Router) *mux.Router {
 
 	heathHandler.HandleFunc("/login", a.Login).Methods(http.MethodPost)
 	heathHandler.HandleFunc("/register", a.Register).Methods(http.MethodPost)
+	heathHandler.HandleFunc("/refresh", a.Refresh).Methods(http.MethodPost)
+	heathHandler.HandleFunc("/password/forgot", a.ForgotPassword).Methods(http.MethodPost)
+	heathHandler.HandleFunc("/password/reset", a.ResetPassword).Methods(http.MethodPost)
+	heathHandler.HandleFunc("/verify", a.VerifyEmail).Methods(http.MethodGet)
+	heathHandler.HandleFunc("/verify/resend", a.ResendVerification).Methods(http.MethodPost)
+	heathHandler.HandleFunc("/magic-link", a.SendMagicLink).Methods(http.MethodPost)
+	heathHandler.HandleFunc("/magic-link/login", a.LoginByMagicLink).Methods(http.MethodPost)
+	heathHandler.HandleFunc("/mfa/verify", a.VerifyMFA).Methods(http.MethodPost)
+	heathHandler.HandleFunc("/webauthn/login/begin", a.BeginWebAuthnLogin).Methods(http.MethodPost)
+	heathHandler.HandleFunc("/webauthn/login/finish", a.FinishWebAuthnLogin).Methods(http.MethodPost)
+	heathHandler.Handle("/logout", a.authn.Middleware(http.HandlerFunc(a.Logout))).Methods(http.MethodPost)
 
 	heathHandler.Use(a.postProcessMiddleware)
 	return heathHandler
@@ -39,16 +54,21 @@ func (a *Auth) AttachRouter(mr *mux.Router) *mux.Router {
 
 // NewAuth returns a new Auth handler
 func NewAuth(l *log.Logger, usecase domain.UserUsecase, v *domain.Validation) *Auth {
-	return &Auth{l: l, usecase: usecase, v: v}
+	return &Auth{l: l, usecase: usecase, v: v, authn: NewAuthenticator(l, usecase)}
 }
 
 // swagger:route POST /auth/login auth loginUser
-// Returns the jwt token for the User if the email or password are correct
+// Returns the jwt token for the User if the username or email and the password are correct,
+// if the user has two-factor authentication enabled the response has the
+// mfaToken instead, which is exchanged for the jwt at /auth/mfa/verify.
 // responses:
 //	200: jwtDTOResponse
 //	400: genericErrorResponse
 //  400: validationErrorResponse
 //	401: usernamePasswordNotMatchResponse
+//	403: genericErrorResponse
+//	423: lockoutErrorResponse
+//	429: lockoutErrorResponse
 // 	500: internalErrorResponse
 
 // Login checks the health status
@@ -73,7 +93,10 @@ func (a *Auth) Login(rw http.ResponseWriter, r *http.Request) {
 	ctx, cancel = context.WithDeadline(r.Context(), time.Now().Add(ds))
 	defer cancel()
 
-	res, err := a.usecase.LoginByEmail(ctx, ld)
+	ctx = domain.WithClientIP(ctx, clientIP(r))
+
+	res, err := a.usecase.Login(ctx, ld)
+	var lerr *domain.LockoutError
 	if err == domain.ErrNoUserFound || err == domain.ErrEmailPasswordNotMatch {
 		a.l.Info("Username and password don't match.")
 		gerr := ErrUsernamePasswordDontMatch
@@ -81,6 +104,23 @@ func (a *Auth) Login(rw http.ResponseWriter, r *http.Request) {
 		ToJSON(gerr, rw)
 		return
 
+	} else if errors.As(err, &lerr) {
+		a.l.Infof("Login refused: %s.", err.Error())
+		writeLockoutError(rw, lerr)
+		return
+
+	} else if err == domain.ErrEmailNotVerified {
+		a.l.Info("Login of a user with an unverified email.")
+		gerr := GenericError{
+			Message:        err.Error(),
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusForbidden,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+
 	} else if err != nil {
 		a.l.Errorf("Error while loging in: %s.", err.Error())
 		gerr := GenericError{
@@ -100,11 +140,14 @@ func (a *Auth) Login(rw http.ResponseWriter, r *http.Request) {
 
 // swagger:route POST /auth/register auth registerUser
 // Stores and registers a new user and then returns
-// the jwt token for the newly created user.
+// the jwt token for the newly created user. If the users must verify
+// their email address before logging in, the response is 202 without the tokens.
 // responses:
 //	200: jwtDTOResponse
+//	202: noContentResponse
 //	400: genericErrorResponse
 //  400: validationErrorResponse
+//	429: tooManyRequestsResponse
 // 	500: internalErrorResponse
 
 // Register a new user and return the jwt token
@@ -130,7 +173,8 @@ func (a *Auth) Register(rw http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	res, err := a.usecase.Create(ctx, rd)
-	if err == domain.ErrPasswordsDoNotMatch || err == domain.ErrUsernameAlreadyTaken || err == domain.ErrEmailAlreadyTaken {
+	if err == domain.ErrPasswordsDoNotMatch || err == domain.ErrUsernameAlreadyTaken || err == domain.ErrEmailAlreadyTaken ||
+		err == domain.ErrInvalidUsername || err == domain.ErrInvalidEmail || err == domain.ErrPasswordTooLong {
 
 		a.l.Info("Username and password don't match.")
 		gerr := GenericError{
@@ -142,6 +186,10 @@ func (a *Auth) Register(rw http.ResponseWriter, r *http.Request) {
 		rw.WriteHeader(gerr.HTTPStatusCode)
 		ToJSON(gerr, rw)
 		return
+	} else if err == domain.ErrEmailNotVerified {
+		// the user is stored, the tokens are issued after the verification
+		rw.WriteHeader(http.StatusAccepted)
+		return
 	} else if err != nil {
 		a.l.Errorf("Error while registering in: %s.", err.Error())
 		gerr := GenericError{
@@ -159,7 +207,652 @@ func (a *Auth) Register(rw http.ResponseWriter, r *http.Request) {
 	ToJSON(res, rw)
 }
 
+// swagger:route POST /auth/refresh auth refreshToken
+// Exchanges a refresh token for a new jwt token and a new refresh token,
+// each refresh token can only be used once.
+// responses:
+//	200: jwtDTOResponse
+//	400: genericErrorResponse
+//  400: validationErrorResponse
+//	401: genericErrorResponse
+//	429: tooManyRequestsResponse
+// 	500: internalErrorResponse
+
+// Refresh rotates the refresh token and returns a new pair of tokens
+func (a *Auth) Refresh(rw http.ResponseWriter, r *http.Request) {
+	a.l.Debug("Handle refresh request.")
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	rd := &domain.RefreshDTO{}
+	gerr := a.validateDTO(rd, r.Body)
+
+	if gerr != nil {
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	ds, _ := time.ParseDuration("5s") // TODO
+	ctx, cancel = context.WithDeadline(r.Context(), time.Now().Add(ds))
+	defer cancel()
+
+	res, err := a.usecase.Refresh(ctx, rd)
+	if err == domain.ErrInvalidRefreshToken || err == domain.ErrRefreshTokenReused {
+		a.l.Infof("Refresh token rejected: %s.", err.Error())
+		gerr := GenericError{
+			Message:        err.Error(),
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusUnauthorized,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	} else if err != nil {
+		a.l.Errorf("Error while refreshing the token: %s.", err.Error())
+		gerr := GenericError{
+			Message:        "internal server error",
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	ToJSON(res, rw)
+}
+
+// swagger:route POST /auth/logout auth logoutUser
+// Revokes the jwt token and the refresh tokens of its session,
+// with everywhere set every session of the user is revoked.
+// security:
+//	bearer:
+// responses:
+//	204: noContentResponse
+//	400: genericErrorResponse
+//	401: genericErrorResponse
+//	429: tooManyRequestsResponse
+// 	500: internalErrorResponse
+
+// Logout revokes the current session or every session of the user
+func (a *Auth) Logout(rw http.ResponseWriter, r *http.Request) {
+	a.l.Debug("Handle logout request.")
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	// the body is optional, an empty body only logs out the current session
+	ld := &domain.LogoutDTO{}
+	if r.ContentLength != 0 {
+		gerr := a.validateDTO(ld, r.Body)
+		if gerr != nil {
+			rw.WriteHeader(gerr.HTTPStatusCode)
+			ToJSON(gerr, rw)
+			return
+		}
+	}
+
+	claims, _ := ClaimsFromContext(r.Context())
+
+	ds, _ := time.ParseDuration("5s") // TODO
+	ctx, cancel = context.WithDeadline(r.Context(), time.Now().Add(ds))
+	defer cancel()
+
+	err := a.usecase.Logout(ctx, claims, ld.Everywhere)
+	if err != nil {
+		a.l.Errorf("Error while logging out: %s.", err.Error())
+		gerr := GenericError{
+			Message:        "internal server error",
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// swagger:route POST /auth/password/forgot auth forgotPassword
+// Sends a password reset token to the email address, the response
+// is the same whether the email address is registered or not.
+// responses:
+//	202: noContentResponse
+//	400: genericErrorResponse
+//  400: validationErrorResponse
+//	429: tooManyRequestsResponse
+
+// ForgotPassword sends a password reset token to the user
+func (a *Auth) ForgotPassword(rw http.ResponseWriter, r *http.Request) {
+	a.l.Debug("Handle forgot password request.")
+
+	fd := &domain.ForgotPasswordDTO{}
+	gerr := a.validateDTO(fd, r.Body)
+
+	if gerr != nil {
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	// the token is sent in the background, so the response time doesn't
+	// tell whether the email address is registered
+	go func() {
+		ds, _ := time.ParseDuration("30s") // TODO
+		ctx, cancel := context.WithTimeout(context.Background(), ds)
+		defer cancel()
+
+		if err := a.usecase.ForgotPassword(ctx, fd); err != nil {
+			a.l.Errorf("Error while sending the password reset token: %s.", err.Error())
+		}
+	}()
+
+	rw.WriteHeader(http.StatusAccepted)
+}
+
+// swagger:route POST /auth/password/reset auth resetPassword
+// Sets a new password with the reset token, the token can only be
+// used once and every session of the user is revoked.
+// responses:
+//	204: noContentResponse
+//	400: genericErrorResponse
+//  400: validationErrorResponse
+//	429: tooManyRequestsResponse
+// 	500: internalErrorResponse
+
+// ResetPassword sets the new password with a reset token
+func (a *Auth) ResetPassword(rw http.ResponseWriter, r *http.Request) {
+	a.l.Debug("Handle reset password request.")
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	rd := &domain.ResetPasswordDTO{}
+	gerr := a.validateDTO(rd, r.Body)
+
+	if gerr != nil {
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	ds, _ := time.ParseDuration("5s") // TODO
+	ctx, cancel = context.WithDeadline(r.Context(), time.Now().Add(ds))
+	defer cancel()
+
+	err := a.usecase.ResetPassword(ctx, rd)
+	if err == domain.ErrInvalidResetToken || err == domain.ErrPasswordsDoNotMatch || err == domain.ErrPasswordTooLong {
+		a.l.Infof("Password reset rejected: %s.", err.Error())
+		gerr := GenericError{
+			Message:        err.Error(),
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusBadRequest,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	} else if err != nil {
+		a.l.Errorf("Error while resetting the password: %s.", err.Error())
+		gerr := GenericError{
+			Message:        "internal server error",
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// swagger:route GET /auth/verify auth verifyEmail
+// Marks the email address of the user as verified, the token is
+// the one in the link which is sent to the email address.
+// responses:
+//	204: noContentResponse
+//	400: genericErrorResponse
+//	429: tooManyRequestsResponse
+// 	500: internalErrorResponse
+
+// VerifyEmail verifies the email address of a user with the token of the verification link
+func (a *Auth) VerifyEmail(rw http.ResponseWriter, r *http.Request) {
+	a.l.Debug("Handle verify email request.")
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		gerr := GenericError{
+			Message:        domain.ErrInvalidVerificationToken.Error(),
+			AdditionalInfo: nil,
+			Err:            nil,
+			HTTPStatusCode: http.StatusBadRequest,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	ds, _ := time.ParseDuration("5s") // TODO
+	ctx, cancel = context.WithDeadline(r.Context(), time.Now().Add(ds))
+	defer cancel()
+
+	err := a.usecase.VerifyEmail(ctx, token)
+	if err == domain.ErrInvalidVerificationToken {
+		a.l.Info("Email verification rejected.")
+		gerr := GenericError{
+			Message:        err.Error(),
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusBadRequest,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	} else if err != nil {
+		a.l.Errorf("Error while verifying the email: %s.", err.Error())
+		gerr := GenericError{
+			Message:        "internal server error",
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// swagger:route POST /auth/verify/resend auth resendVerification
+// Sends a new verification link to the email address, the response
+// is the same whether the email address is registered, verified or not.
+// responses:
+//	202: noContentResponse
+//	400: genericErrorResponse
+//  400: validationErrorResponse
+//	429: tooManyRequestsResponse
+
+// ResendVerification sends a new email verification link to the user
+func (a *Auth) ResendVerification(rw http.ResponseWriter, r *http.Request) {
+	a.l.Debug("Handle resend verification request.")
+
+	rd := &domain.ResendVerificationDTO{}
+	gerr := a.validateDTO(rd, r.Body)
+
+	if gerr != nil {
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	// like ForgotPassword the link is sent in the background
+	go func() {
+		ds, _ := time.ParseDuration("30s") // TODO
+		ctx, cancel := context.WithTimeout(context.Background(), ds)
+		defer cancel()
+
+		if err := a.usecase.ResendVerification(ctx, rd); err != nil {
+			a.l.Errorf("Error while sending the verification link: %s.", err.Error())
+		}
+	}()
+
+	rw.WriteHeader(http.StatusAccepted)
+}
+
+// swagger:route POST /auth/magic-link auth sendMagicLink
+// Sends a single use login link to the email address, the response
+// is the same whether the email address is registered or not.
+// responses:
+//	202: noContentResponse
+//	400: genericErrorResponse
+//  400: validationErrorResponse
+//	429: tooManyRequestsResponse
+
+// SendMagicLink sends a passwordless login link to the user
+func (a *Auth) SendMagicLink(rw http.ResponseWriter, r *http.Request) {
+	a.l.Debug("Handle send magic link request.")
+
+	md := &domain.MagicLinkDTO{}
+	gerr := a.validateDTO(md, r.Body)
+
+	if gerr != nil {
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	// like ForgotPassword the link is sent in the background
+	ip := clientIP(r)
+	go func() {
+		ds, _ := time.ParseDuration("30s") // TODO
+		ctx, cancel := context.WithTimeout(context.Background(), ds)
+		defer cancel()
+
+		ctx = domain.WithClientIP(ctx, ip)
+		if err := a.usecase.SendMagicLink(ctx, md); err != nil {
+			a.l.Errorf("Error while sending the login link: %s.", err.Error())
+		}
+	}()
+
+	rw.WriteHeader(http.StatusAccepted)
+}
+
+// swagger:route POST /auth/magic-link/login auth loginByMagicLink
+// Exchanges the token of a login link for the jwt token, the link works
+// once. Like /auth/login the response has the mfaToken instead if the
+// user has two-factor authentication enabled.
+// responses:
+//	200: jwtDTOResponse
+//	400: genericErrorResponse
+//  400: validationErrorResponse
+//	401: genericErrorResponse
+//	429: lockoutErrorResponse
+// 	500: internalErrorResponse
+
+// LoginByMagicLink logs in the user with the token of a login link
+func (a *Auth) LoginByMagicLink(rw http.ResponseWriter, r *http.Request) {
+	a.l.Debug("Handle login by magic link request.")
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	ld := &domain.MagicLinkLoginDTO{}
+	gerr := a.validateDTO(ld, r.Body)
+
+	if gerr != nil {
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	ds, _ := time.ParseDuration("5s") // TODO
+	ctx, cancel = context.WithDeadline(r.Context(), time.Now().Add(ds))
+	defer cancel()
+
+	ctx = domain.WithClientIP(ctx, clientIP(r))
+
+	res, err := a.usecase.LoginByMagicLink(ctx, ld)
+	var lerr *domain.LockoutError
+	if errors.As(err, &lerr) {
+		a.l.Infof("Login by magic link refused: %s.", err.Error())
+		writeLockoutError(rw, lerr)
+		return
+	} else if err == domain.ErrInvalidMagicLinkToken {
+		a.l.Infof("Login by magic link rejected: %s.", err.Error())
+		gerr := GenericError{
+			Message:        err.Error(),
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusUnauthorized,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	} else if err != nil {
+		a.l.Errorf("Error while logging in by magic link: %s.", err.Error())
+		gerr := GenericError{
+			Message:        "internal server error",
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	ToJSON(res, rw)
+}
+
+// swagger:route POST /auth/mfa/verify auth verifyMFA
+// Exchanges the mfa token of the login and the code of the second factor
+// for the jwt token. The mfa token is single use, after a wrong code the
+// user logs in again. A wrong code counts as a failed login of the account.
+// responses:
+//	200: jwtDTOResponse
+//	400: genericErrorResponse
+//  400: validationErrorResponse
+//	401: genericErrorResponse
+//	423: lockoutErrorResponse
+//	429: lockoutErrorResponse
+// 	500: internalErrorResponse
+
+// VerifyMFA completes the login of a user with two-factor authentication
+func (a *Auth) VerifyMFA(rw http.ResponseWriter, r *http.Request) {
+	a.l.Debug("Handle verify mfa request.")
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	vd := &domain.MFAVerifyDTO{}
+	gerr := a.validateDTO(vd, r.Body)
+
+	if gerr != nil {
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	ds, _ := time.ParseDuration("5s") // TODO
+	ctx, cancel = context.WithDeadline(r.Context(), time.Now().Add(ds))
+	defer cancel()
+
+	ctx = domain.WithClientIP(ctx, clientIP(r))
+
+	res, err := a.usecase.VerifyMFA(ctx, vd)
+	var lerr *domain.LockoutError
+	if errors.As(err, &lerr) {
+		a.l.Infof("Mfa verification refused: %s.", err.Error())
+		writeLockoutError(rw, lerr)
+		return
+	} else if err == domain.ErrInvalidMFAToken || err == domain.ErrInvalidMFACode {
+		a.l.Infof("Mfa verification rejected: %s.", err.Error())
+		gerr := GenericError{
+			Message:        err.Error(),
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusUnauthorized,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	} else if err != nil {
+		a.l.Errorf("Error while verifying the mfa code: %s.", err.Error())
+		gerr := GenericError{
+			Message:        "internal server error",
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	ToJSON(res, rw)
+}
+
+// swagger:route POST /auth/webauthn/login/begin auth beginWebAuthnLogin
+// Returns the options for navigator.credentials.get(). With an email address
+// the credentials of the user are allowed, with an empty object the browser
+// offers the passkeys it has for the relying party.
+// responses:
+//	200: webAuthnRequestOptionsDTOResponse
+//	400: genericErrorResponse
+//  400: validationErrorResponse
+//	429: tooManyRequestsResponse
+// 	500: internalErrorResponse
+
+// BeginWebAuthnLogin starts the passwordless login of a user
+func (a *Auth) BeginWebAuthnLogin(rw http.ResponseWriter, r *http.Request) {
+	a.l.Debug("Handle begin webauthn login request.")
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	ld := &domain.WebAuthnLoginDTO{}
+	gerr := a.validateDTO(ld, r.Body)
+
+	if gerr != nil {
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	ds, _ := time.ParseDuration("5s") // TODO
+	ctx, cancel = context.WithDeadline(r.Context(), time.Now().Add(ds))
+	defer cancel()
+
+	res, err := a.usecase.BeginWebAuthnLogin(ctx, ld)
+	if err != nil {
+		a.l.Errorf("Error while beginning the webauthn login: %s.", err.Error())
+		gerr := GenericError{
+			Message:        "internal server error",
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	ToJSON(res, rw)
+}
+
+// swagger:route POST /auth/webauthn/login/finish auth finishWebAuthnLogin
+// Exchanges the assertion which navigator.credentials.get() returned for
+// the jwt token, the login doesn't ask for the second factor.
+// responses:
+//	200: jwtDTOResponse
+//	400: genericErrorResponse
+//  400: validationErrorResponse
+//	401: genericErrorResponse
+//	403: genericErrorResponse
+//	429: tooManyRequestsResponse
+// 	500: internalErrorResponse
+
+// FinishWebAuthnLogin completes the passwordless login of a user
+func (a *Auth) FinishWebAuthnLogin(rw http.ResponseWriter, r *http.Request) {
+	a.l.Debug("Handle finish webauthn login request.")
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	ad := &domain.WebAuthnAssertionDTO{}
+	gerr := a.validateDTO(ad, r.Body)
+
+	if gerr != nil {
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	ds, _ := time.ParseDuration("5s") // TODO
+	ctx, cancel = context.WithDeadline(r.Context(), time.Now().Add(ds))
+	defer cancel()
+
+	res, err := a.usecase.FinishWebAuthnLogin(ctx, ad)
+	if err == domain.ErrInvalidWebAuthnResponse {
+		a.l.Infof("Webauthn login rejected: %s.", err.Error())
+		gerr := GenericError{
+			Message:        err.Error(),
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusUnauthorized,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	} else if err == domain.ErrEmailNotVerified {
+		a.l.Info("Login of a user with an unverified email.")
+		gerr := GenericError{
+			Message:        err.Error(),
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusForbidden,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	} else if err != nil {
+		a.l.Errorf("Error while finishing the webauthn login: %s.", err.Error())
+		gerr := GenericError{
+			Message:        "internal server error",
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	ToJSON(res, rw)
+}
+
+// writeLockoutError refuses the login with the Retry-After header, the
+// status is 423 if the account is locked and 429 if it only has to wait
+func writeLockoutError(rw http.ResponseWriter, lerr *domain.LockoutError) {
+	status := http.StatusTooManyRequests
+	if lerr.Err == domain.ErrAccountLocked {
+		status = http.StatusLocked
+	}
+
+	gerr := GenericError{
+		Message:        lerr.Error(),
+		AdditionalInfo: nil,
+		Err:            lerr,
+		HTTPStatusCode: status,
+	}
+	rw.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(lerr.RetryAfter.Seconds())), 10))
+	rw.WriteHeader(gerr.HTTPStatusCode)
+	ToJSON(gerr, rw)
+}
+
 func (a *Auth) validateDTO(in interface{}, r io.Reader) *GenericError {
+	return validateDTO(a.v, in, r)
+}
+
+func (a *Auth) postProcessMiddleware(next http.Handler) http.Handler {
+	return postProcessMiddleware(next)
+}
+
+// validateDTO parses the json body into the DTO and validates its fields
+func validateDTO(v *domain.Validation, in interface{}, r io.Reader) *GenericError {
 	err := FromJSON(in, r)
 
 	// check if the DTO can be parsed into json
@@ -175,7 +868,7 @@ func (a *Auth) validateDTO(in interface{}, r io.Reader) *GenericError {
 	}
 
 	// validate each field of the DTO
-	verrs := a.v.Validate(in)
+	verrs := v.Validate(in)
 	if len(verrs) != 0 {
 		gerr := &GenericError{
 			Message:        "FieldError",
@@ -188,7 +881,8 @@ func (a *Auth) validateDTO(in interface{}, r io.Reader) *GenericError {
 	return nil
 }
 
-func (a *Auth) postProcessMiddleware(next http.Handler) http.Handler {
+// postProcessMiddleware sets the json content type of the responses
+func postProcessMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Content-Type", "application/json")
 		next.ServeHTTP(w, r)
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/dgrijalva/jwt-go"
@@ -29,40 +30,45 @@ func TestMain(m *testing.M) {
 func TestLoginSuccessful(t *testing.T) {
 	router := getNewRouter()
 	testUserDbIdx := 0
-	loginDTOSuccessful := &domain.LoginDTO{Email: strfmt.Email(testUserData[testUserDbIdx].Email), Password: "1234567"}
-	loginDTOBytesSuccessful, _ := json.Marshal(loginDTOSuccessful)
 
-	req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewReader(loginDTOBytesSuccessful))
-	w := httptest.NewRecorder()
+	for _, identifier := range []string{testUserData[testUserDbIdx].Email, testUserData[testUserDbIdx].Username} {
+		loginDTOSuccessful := &domain.LoginDTO{Identifier: identifier, Password: "1234567"}
+		loginDTOBytesSuccessful, _ := json.Marshal(loginDTOSuccessful)
 
-	router.ServeHTTP(w, req)
-	resp := w.Result()
-	jwtDTO := &domain.JWTDTO{}
+		req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewReader(loginDTOBytesSuccessful))
+		w := httptest.NewRecorder()
 
-	if !basicHTTPResponseChecks(t, http.StatusOK, desiredContentType, jwtDTO, resp) {
-		return
-	}
+		router.ServeHTTP(w, req)
+		resp := w.Result()
+		jwtDTO := &domain.JWTDTO{}
 
-	assert.NotNil(t, jwtDTO)
-	assert.Greater(t, len(jwtDTO.Token), 0)
-	claims := jwt.MapClaims{}
+		if !basicHTTPResponseChecks(t, http.StatusOK, desiredContentType, jwtDTO, resp) {
+			return
+		}
 
-	jwt.ParseWithClaims(jwtDTO.Token, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte("<YOUR VERIFICATION KEY>"), nil
-	})
+		assert.NotNil(t, jwtDTO)
+		assert.Greater(t, len(jwtDTO.Token), 0)
+		claims := jwt.MapClaims{}
 
-	assert.Nil(t, claims.Valid())
-	assert.True(t, claims.VerifyAudience(testUserData[testUserDbIdx].ID, false))
+		jwt.ParseWithClaims(jwtDTO.Token, claims, func(token *jwt.Token) (interface{}, error) {
+			return []byte("<YOUR VERIFICATION KEY>"), nil
+		})
+
+		assert.Nil(t, claims.Valid())
+		assert.True(t, claims.VerifyAudience(testUserData[testUserDbIdx].ID, false), identifier)
+	}
 }
 
 func TestLoginError(t *testing.T) {
 	router := getNewRouter()
 	testUserDbIdx := 0
 
-	loginDTOInvalidEmail := &domain.LoginDTO{Email: strfmt.Email(testUserData[testUserDbIdx].Username), Password: "1234567"}
-	loginDTONoEmail := &domain.LoginDTO{Email: "", Password: "1234567"}
-	loginDTOEmailNotFound := &domain.LoginDTO{Email: "vahid@gmail.com", Password: "1234567"}
-	loginDTOWrongPassword := &domain.LoginDTO{Email: strfmt.Email(testUserData[testUserDbIdx].Email), Password: "123456"}
+	loginDTOLongIdentifier := &domain.LoginDTO{Identifier: strings.Repeat("a", 255), Password: "1234567"}
+	loginDTONoIdentifier := &domain.LoginDTO{Identifier: "", Password: "1234567"}
+	loginDTOEmailNotFound := &domain.LoginDTO{Identifier: "vahid@gmail.com", Password: "1234567"}
+	loginDTOUsernameNotFound := &domain.LoginDTO{Identifier: "vahid", Password: "1234567"}
+	loginDTOWrongPassword := &domain.LoginDTO{Identifier: testUserData[testUserDbIdx].Email, Password: "123456"}
+	loginDTOUsernameWrongPassword := &domain.LoginDTO{Identifier: testUserData[testUserDbIdx].Username, Password: "123456"}
 
 	tests := []struct {
 		name       string
@@ -71,14 +77,14 @@ func TestLoginError(t *testing.T) {
 		statusCode int
 	}{
 		{
-			name:       "bad request - invalid email",
-			dto:        loginDTOInvalidEmail,
+			name:       "bad request - identifier too long",
+			dto:        loginDTOLongIdentifier,
 			statusCode: http.StatusBadRequest,
 			errMessage: "FieldError",
 		},
 		{
-			name:       "bad request - no email",
-			dto:        loginDTONoEmail,
+			name:       "bad request - no identifier",
+			dto:        loginDTONoIdentifier,
 			statusCode: http.StatusBadRequest,
 			errMessage: "FieldError",
 		},
@@ -88,12 +94,24 @@ func TestLoginError(t *testing.T) {
 			statusCode: http.StatusUnauthorized,
 			errMessage: "email and the password don't match",
 		},
+		{
+			name:       "unauthorized - username not found",
+			dto:        loginDTOUsernameNotFound,
+			statusCode: http.StatusUnauthorized,
+			errMessage: "email and the password don't match",
+		},
 		{
 			name:       "unauthorized - password is wrong",
 			dto:        loginDTOWrongPassword,
 			statusCode: http.StatusUnauthorized,
 			errMessage: "email and the password don't match",
 		},
+		{
+			name:       "unauthorized - password is wrong for the username",
+			dto:        loginDTOUsernameWrongPassword,
+			statusCode: http.StatusUnauthorized,
+			errMessage: "email and the password don't match",
+		},
 	}
 
 	for _, tt := range tests {
@@ -115,6 +133,31 @@ func TestLoginError(t *testing.T) {
 
 }
 
+func TestLoginThrottled(t *testing.T) {
+	router := getNewRouter()
+
+	login := func(password string) *http.Response {
+		b, _ := json.Marshal(&domain.LoginDTO{Identifier: testUserData[0].Email, Password: strfmt.Password(password)})
+		req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewReader(b))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	for i := 0; i < usecase.DefaultAccountLockout.Threshold; i++ {
+		assert.Equal(t, http.StatusUnauthorized, login("wrong").StatusCode)
+	}
+
+	// the right password has to wait as well
+	resp := login("1234567")
+	gerr := &GenericError{}
+	if !basicHTTPResponseChecks(t, http.StatusTooManyRequests, desiredContentType, gerr, resp) {
+		return
+	}
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+	assert.Equal(t, domain.ErrLoginThrottled.Error(), gerr.Message)
+}
+
 func TestRegisterSuccessful(t *testing.T) {
 	router := getNewRouter()
 	registerDTO := &domain.RegisterDTO{Email: "gholi@gmail.com", Username: "gholi", Password: "1234567", RepeatPassword: "1234567"}
@@ -148,6 +191,9 @@ func TestRegisterError(t *testing.T) {
 	registerDTONoUsernameProvided := &domain.RegisterDTO{Email: "vahid@gmail.com", Password: "1234567", RepeatPassword: "123456"}
 	registerDTOPasswordsDontMatch := &domain.RegisterDTO{Email: "vahid@gmail.com", Username: "vahid", Password: "1234567", RepeatPassword: "123456"}
 	registerDTOEmailAlreadyTaken := &domain.RegisterDTO{Email: "jack@gmail.com", Username: "vahid", Password: "1234567", RepeatPassword: "1234567"}
+	registerDTOUsernameWithAt := &domain.RegisterDTO{Email: "vahid@gmail.com", Username: "vahid@home", Password: "1234567", RepeatPassword: "1234567"}
+	long := strfmt.Password(strings.Repeat("a", domain.MaxPasswordLength+1))
+	registerDTOPasswordTooLong := &domain.RegisterDTO{Email: "vahid@gmail.com", Username: "vahid", Password: long, RepeatPassword: long}
 
 	tests := []struct {
 		name       string
@@ -169,6 +215,19 @@ func TestRegisterError(t *testing.T) {
 			more:       map[string]string{"Username": "Username is a required field"},
 			errMessage: "FieldError",
 		},
+		{
+			name:       "bad request - username with an @",
+			dto:        registerDTOUsernameWithAt,
+			statusCode: http.StatusBadRequest,
+			errMessage: "FieldError",
+		},
+		{
+			name:       "bad request - password too long",
+			dto:        registerDTOPasswordTooLong,
+			statusCode: http.StatusBadRequest,
+			more:       map[string]string{"Password": "Password must be a maximum of 128 characters in length"},
+			errMessage: "FieldError",
+		},
 		{
 			name:       "bad request - passwords don't match",
 			dto:        registerDTOPasswordsDontMatch,
@@ -207,35 +266,425 @@ func TestRegisterError(t *testing.T) {
 
 }
 
+func TestRefresh(t *testing.T) {
+	router := getNewRouter()
+	testUserDbIdx := 0
+	loginDTO := &domain.LoginDTO{Identifier: testUserData[testUserDbIdx].Email, Password: "1234567"}
+	loginDTOBytes, _ := json.Marshal(loginDTO)
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewReader(loginDTOBytes))
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+	loginJWTDTO := &domain.JWTDTO{}
+
+	if !basicHTTPResponseChecks(t, http.StatusOK, desiredContentType, loginJWTDTO, w.Result()) {
+		return
+	}
+
+	refresh := func(refreshToken string) *http.Response {
+		b, _ := json.Marshal(&domain.RefreshDTO{RefreshToken: refreshToken})
+		req := httptest.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewReader(b))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	jwtDTO := &domain.JWTDTO{}
+	if !basicHTTPResponseChecks(t, http.StatusOK, desiredContentType, jwtDTO, refresh(loginJWTDTO.RefreshToken)) {
+		return
+	}
+	assert.Greater(t, len(jwtDTO.Token), 0)
+	assert.Greater(t, len(jwtDTO.RefreshToken), 0)
+	assert.NotEqual(t, loginJWTDTO.RefreshToken, jwtDTO.RefreshToken)
+
+	gerr := &GenericError{}
+	if !basicHTTPResponseChecks(t, http.StatusUnauthorized, desiredContentType, gerr, refresh(loginJWTDTO.RefreshToken)) {
+		return
+	}
+	assert.Equal(t, domain.ErrRefreshTokenReused.Error(), gerr.Message)
+
+	gerr = &GenericError{}
+	if !basicHTTPResponseChecks(t, http.StatusUnauthorized, desiredContentType, gerr, refresh(jwtDTO.RefreshToken)) {
+		return
+	}
+	assert.Equal(t, domain.ErrInvalidRefreshToken.Error(), gerr.Message)
+}
+
+func TestLogout(t *testing.T) {
+	router := getNewRouter()
+	testUserDbIdx := 0
+
+	login := func() *domain.JWTDTO {
+		b, _ := json.Marshal(&domain.LoginDTO{Identifier: testUserData[testUserDbIdx].Email, Password: "1234567"})
+		req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewReader(b))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		jwtDTO := &domain.JWTDTO{}
+		basicHTTPResponseChecks(t, http.StatusOK, desiredContentType, jwtDTO, w.Result())
+		return jwtDTO
+	}
+	logout := func(token string, body interface{}) *http.Response {
+		var req *http.Request
+		if body != nil {
+			b, _ := json.Marshal(body)
+			req = httptest.NewRequest(http.MethodPost, "/auth/logout", bytes.NewReader(b))
+		} else {
+			req = httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Result()
+	}
+	refresh := func(refreshToken string) *http.Response {
+		b, _ := json.Marshal(&domain.RefreshDTO{RefreshToken: refreshToken})
+		req := httptest.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewReader(b))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	first := login()
+	second := login()
+	third := login()
+
+	assert.Equal(t, http.StatusNoContent, logout(first.Token, nil).StatusCode)
+
+	// the token and the refresh token of the first session are revoked
+	assert.Equal(t, http.StatusUnauthorized, logout(first.Token, nil).StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, refresh(first.RefreshToken).StatusCode)
+	assert.Equal(t, http.StatusOK, refresh(second.RefreshToken).StatusCode)
+
+	assert.Equal(t, http.StatusNoContent, logout(third.Token, &domain.LogoutDTO{Everywhere: true}).StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, logout(second.Token, nil).StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, refresh(third.RefreshToken).StatusCode)
+
+	// logging in again after logging out everywhere works
+	fourth := login()
+	assert.Equal(t, http.StatusNoContent, logout(fourth.Token, nil).StatusCode)
+}
+
+func TestForgotPassword(t *testing.T) {
+	router := getNewRouter()
+
+	tests := []struct {
+		name       string
+		dto        *domain.ForgotPasswordDTO
+		statusCode int
+	}{
+		{name: "known email", dto: &domain.ForgotPasswordDTO{Email: strfmt.Email(testUserData[0].Email)}, statusCode: http.StatusAccepted},
+		{name: "unknown email", dto: &domain.ForgotPasswordDTO{Email: "vahid@gmail.com"}, statusCode: http.StatusAccepted},
+		{name: "invalid email", dto: &domain.ForgotPasswordDTO{Email: "vahid"}, statusCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := json.Marshal(tt.dto)
+			req := httptest.NewRequest(http.MethodPost, "/auth/password/forgot", bytes.NewReader(b))
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.statusCode, w.Result().StatusCode)
+		})
+	}
+}
+
+func TestResetPasswordError(t *testing.T) {
+	router := getNewRouter()
+
+	tests := []struct {
+		name       string
+		dto        *domain.ResetPasswordDTO
+		errMessage string
+		statusCode int
+	}{
+		{
+			name:       "bad request - invalid token",
+			dto:        &domain.ResetPasswordDTO{Token: "token", Password: "1234567", RepeatPassword: "1234567"},
+			statusCode: http.StatusBadRequest,
+			errMessage: "reset token is invalid or expired",
+		},
+		{
+			name:       "bad request - passwords don't match",
+			dto:        &domain.ResetPasswordDTO{Token: "token", Password: "1234567", RepeatPassword: "123456"},
+			statusCode: http.StatusBadRequest,
+			errMessage: "passwords don't match",
+		},
+		{
+			name:       "bad request - no token",
+			dto:        &domain.ResetPasswordDTO{Password: "1234567", RepeatPassword: "1234567"},
+			statusCode: http.StatusBadRequest,
+			errMessage: "FieldError",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := json.Marshal(tt.dto)
+			req := httptest.NewRequest(http.MethodPost, "/auth/password/reset", bytes.NewReader(b))
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			gerr := &GenericError{}
+
+			if !basicHTTPResponseChecks(t, tt.statusCode, desiredContentType, gerr, w.Result()) {
+				return
+			}
+			assert.Equal(t, tt.errMessage, gerr.Message)
+		})
+	}
+}
+
+func TestVerifyEmailError(t *testing.T) {
+	router := getNewRouter()
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{name: "no token", query: ""},
+		{name: "invalid token", query: "?token=token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/auth/verify"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			gerr := &GenericError{}
+
+			if !basicHTTPResponseChecks(t, http.StatusBadRequest, desiredContentType, gerr, w.Result()) {
+				return
+			}
+			assert.Equal(t, "verification token is invalid or expired", gerr.Message)
+		})
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	router := getNewRouter()
+
+	tests := []struct {
+		name       string
+		dto        *domain.ResendVerificationDTO
+		statusCode int
+	}{
+		{name: "known email", dto: &domain.ResendVerificationDTO{Email: strfmt.Email(testUserData[0].Email)}, statusCode: http.StatusAccepted},
+		{name: "unknown email", dto: &domain.ResendVerificationDTO{Email: "vahid@gmail.com"}, statusCode: http.StatusAccepted},
+		{name: "invalid email", dto: &domain.ResendVerificationDTO{Email: "vahid"}, statusCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := json.Marshal(tt.dto)
+			req := httptest.NewRequest(http.MethodPost, "/auth/verify/resend", bytes.NewReader(b))
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.statusCode, w.Result().StatusCode)
+		})
+	}
+}
+
+func TestMagicLink(t *testing.T) {
+	router := getNewRouter()
+
+	tests := []struct {
+		name       string
+		dto        *domain.MagicLinkDTO
+		statusCode int
+	}{
+		{name: "known email", dto: &domain.MagicLinkDTO{Email: strfmt.Email(testUserData[0].Email)}, statusCode: http.StatusAccepted},
+		{name: "unknown email", dto: &domain.MagicLinkDTO{Email: "vahid@gmail.com"}, statusCode: http.StatusAccepted},
+		{name: "invalid email", dto: &domain.MagicLinkDTO{Email: "vahid"}, statusCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := json.Marshal(tt.dto)
+			req := httptest.NewRequest(http.MethodPost, "/auth/magic-link", bytes.NewReader(b))
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.statusCode, w.Result().StatusCode)
+		})
+	}
+}
+
+func TestLoginByMagicLinkError(t *testing.T) {
+	router := getNewRouter()
+
+	tests := []struct {
+		name       string
+		dto        *domain.MagicLinkLoginDTO
+		errMessage string
+		statusCode int
+	}{
+		{
+			name:       "unauthorized - invalid token",
+			dto:        &domain.MagicLinkLoginDTO{Token: "token"},
+			statusCode: http.StatusUnauthorized,
+			errMessage: "login link is invalid or expired",
+		},
+		{
+			name:       "bad request - no token",
+			dto:        &domain.MagicLinkLoginDTO{},
+			statusCode: http.StatusBadRequest,
+			errMessage: "FieldError",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := json.Marshal(tt.dto)
+			req := httptest.NewRequest(http.MethodPost, "/auth/magic-link/login", bytes.NewReader(b))
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			gerr := &GenericError{}
+
+			if !basicHTTPResponseChecks(t, tt.statusCode, desiredContentType, gerr, w.Result()) {
+				return
+			}
+			assert.Equal(t, tt.errMessage, gerr.Message)
+		})
+	}
+}
+
+func TestVerifyMFAError(t *testing.T) {
+	router := getNewRouter()
+
+	tests := []struct {
+		name       string
+		dto        *domain.MFAVerifyDTO
+		errMessage string
+		statusCode int
+	}{
+		{
+			name:       "unauthorized - invalid mfa token",
+			dto:        &domain.MFAVerifyDTO{MFAToken: "token", Code: "123456"},
+			statusCode: http.StatusUnauthorized,
+			errMessage: "mfa token is invalid or expired",
+		},
+		{
+			name:       "bad request - no code",
+			dto:        &domain.MFAVerifyDTO{MFAToken: "token"},
+			statusCode: http.StatusBadRequest,
+			errMessage: "FieldError",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := json.Marshal(tt.dto)
+			req := httptest.NewRequest(http.MethodPost, "/auth/mfa/verify", bytes.NewReader(b))
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			gerr := &GenericError{}
+
+			if !basicHTTPResponseChecks(t, tt.statusCode, desiredContentType, gerr, w.Result()) {
+				return
+			}
+			assert.Equal(t, tt.errMessage, gerr.Message)
+		})
+	}
+}
+
+func TestWebAuthnLogin(t *testing.T) {
+	router := getNewRouter()
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/webauthn/login/begin", strings.NewReader("{}"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	options := &domain.WebAuthnRequestOptionsDTO{}
+	if !basicHTTPResponseChecks(t, http.StatusOK, desiredContentType, options, w.Result()) {
+		return
+	}
+	assert.NotEmpty(t, options.PublicKey.Challenge)
+	assert.Empty(t, options.PublicKey.AllowCredentials)
+
+	tests := []struct {
+		name       string
+		dto        *domain.WebAuthnAssertionDTO
+		errMessage string
+		statusCode int
+	}{
+		{
+			name: "unauthorized - unknown challenge",
+			dto: &domain.WebAuthnAssertionDTO{RawID: "AAAA", Type: "public-key", Response: domain.WebAuthnAssertionResponse{
+				ClientDataJSON:    "eyJ0eXBlIjoid2ViYXV0aG4uZ2V0IiwiY2hhbGxlbmdlIjoiQUFBQSIsIm9yaWdpbiI6Imh0dHA6Ly9sb2NhbGhvc3Q6OTA5MCJ9",
+				AuthenticatorData: "AAAA",
+				Signature:         "AAAA",
+			}},
+			statusCode: http.StatusUnauthorized,
+			errMessage: "webauthn response is invalid",
+		},
+		{
+			name:       "bad request - no response",
+			dto:        &domain.WebAuthnAssertionDTO{RawID: "AAAA", Type: "public-key"},
+			statusCode: http.StatusBadRequest,
+			errMessage: "FieldError",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := json.Marshal(tt.dto)
+			req := httptest.NewRequest(http.MethodPost, "/auth/webauthn/login/finish", bytes.NewReader(b))
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			gerr := &GenericError{}
+
+			if !basicHTTPResponseChecks(t, tt.statusCode, desiredContentType, gerr, w.Result()) {
+				return
+			}
+			assert.Equal(t, tt.errMessage, gerr.Message)
+		})
+	}
+}
+
 func getNewRouter() *mux.Router {
 	l := logrus.New()
 	l.SetOutput(ioutil.Discard)
 	router := mux.NewRouter()
 	ur, _ := repositories.NewUserRepository(
 		repositories.InMemoryKind,
-		repositories.InMemoryArgs{testUserData},
+		&repositories.InMemoryArgs{Data: testUserData},
 	)
 	uc := usecase.NewUser(l, ur, usecase.UserOptions{})
 	ah := NewAuth(l, uc, domain.NewValidation())
 	ah.AttachRouter(router)
+	uh := NewUsers(l, uc, domain.NewValidation())
+	uh.AttachRouter(router)
 	return router
 }
 
 var testUserData = []*domain.User{{
-	ID:       "54215f2a-b752-11eb-8529-0242ac130003",
-	Username: "jack",
-	Email:    "jack@gmail.com",
-	Password: "8bb0cf6eb9b17d0f7d22b456f121257dc1254e1f01665370476383ea776df414",
+	ID:                "54215f2a-b752-11eb-8529-0242ac130003",
+	Username:          "jack",
+	Email:             "jack@gmail.com",
+	CanonicalUsername: "jack",
+	CanonicalEmail:    "jack@gmail.com",
+	Password:          "8bb0cf6eb9b17d0f7d22b456f121257dc1254e1f01665370476383ea776df414",
 }, {
-	ID:       "5a823a9c-b752-11eb-8529-0242ac130003",
-	Username: "john",
-	Email:    "john@gmail.com",
-	Password: "8bb0cf6eb9b17d0f7d22b456f121257dc1254e1f01665370476383ea776df414",
+	ID:                "5a823a9c-b752-11eb-8529-0242ac130003",
+	Username:          "john",
+	Email:             "john@gmail.com",
+	CanonicalUsername: "john",
+	CanonicalEmail:    "john@gmail.com",
+	Password:          "8bb0cf6eb9b17d0f7d22b456f121257dc1254e1f01665370476383ea776df414",
 }, {
-	ID:       "601427c2-b752-11eb-8529-0242ac130003",
-	Username: "jill",
-	Email:    "jill@gmail.com",
-	Password: "8bb0cf6eb9b17d0f7d22b456f121257dc1254e1f01665370476383ea776df414",
+	ID:                "601427c2-b752-11eb-8529-0242ac130003",
+	Username:          "jill",
+	Email:             "jill@gmail.com",
+	CanonicalUsername: "jill",
+	CanonicalEmail:    "jill@gmail.com",
+	Password:          "8bb0cf6eb9b17d0f7d22b456f121257dc1254e1f01665370476383ea776df414",
 }}
 
 func basicHTTPResponseChecks(t *testing.T, desiredStatusCode int, desiredContentType string, bodyResult interface{}, resp *http.Response) bool {
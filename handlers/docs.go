@@ -12,6 +12,16 @@
 //	Produces:
 //	- application/json
 //
+//	SecurityDefinitions:
+//	bearer:
+//	  type: apiKey
+//	  name: Authorization
+//	  in: header
+//	admin:
+//	  type: apiKey
+//	  name: Authorization
+//	  in: header
+//
 // swagger:meta
 package handlers
 
@@ -33,6 +43,53 @@ type jwtDTOResponseWrapper struct {
 	Body domain.JWTDTO
 }
 
+// The profile of the logged in user
+// swagger:response profileDTOResponse
+type profileDTOResponseWrapper struct {
+	// the strong ETag of the version of the profile
+	//
+	// in: header
+	ETag string `json:"ETag"`
+
+	// in: body
+	Body domain.ProfileDTO
+}
+
+// The totp secret of the two-factor authentication enrollment
+// swagger:response totpEnrollmentDTOResponse
+type totpEnrollmentDTOResponseWrapper struct {
+	// in: body
+	Body domain.TOTPEnrollmentDTO
+}
+
+// The one time recovery codes of the two-factor authentication
+// swagger:response recoveryCodesDTOResponse
+type recoveryCodesDTOResponseWrapper struct {
+	// in: body
+	Body domain.RecoveryCodesDTO
+}
+
+// The options of navigator.credentials.create() for the WebAuthn registration
+// swagger:response webAuthnCreationOptionsDTOResponse
+type webAuthnCreationOptionsDTOResponseWrapper struct {
+	// in: body
+	Body domain.WebAuthnCreationOptionsDTO
+}
+
+// The options of navigator.credentials.get() for the WebAuthn login
+// swagger:response webAuthnRequestOptionsDTOResponse
+type webAuthnRequestOptionsDTOResponseWrapper struct {
+	// in: body
+	Body domain.WebAuthnRequestOptionsDTO
+}
+
+// JSON Web Key Set response contains the public signing keys
+// swagger:response jwksResponse
+type jwksResponseWrapper struct {
+	// in: body
+	Body domain.JSONWebKeySet
+}
+
 // Generic Error respones contains an error object returned
 // swagger:response genericErrorResponse
 type genericErrorResponseWrapper struct {
@@ -58,6 +115,48 @@ type usernamePasswordNotMatchResponseWrapper struct {
 	Body GenericError
 }
 
+// Lockout Error response refuses a login after too many failed
+// attempts, the Retry-After header tells when to try again. The
+// status is 423 if the account is locked and 429 otherwise.
+// swagger:response lockoutErrorResponse
+type lockoutErrorResponseWrapper struct {
+	// the seconds to wait before the next attempt
+	//
+	// in: header
+	RetryAfter int `json:"Retry-After"`
+
+	// in: body
+	Body GenericError
+}
+
+// Too Many Requests response is returned when the client used up the
+// rate limit of the route, the message field is "too many requests".
+// swagger:response tooManyRequestsResponse
+type tooManyRequestsResponseWrapper struct {
+	// the seconds to wait before the next request
+	//
+	// in: header
+	RetryAfter int `json:"Retry-After"`
+
+	// the requests the bucket holds
+	//
+	// in: header
+	RateLimitLimit int `json:"RateLimit-Limit"`
+
+	// the requests left, always 0
+	//
+	// in: header
+	RateLimitRemaining int `json:"RateLimit-Remaining"`
+
+	// the seconds until the bucket is full again
+	//
+	// in: header
+	RateLimitReset int `json:"RateLimit-Reset"`
+
+	// in: body
+	Body GenericError
+}
+
 // Internal Server error response contains an error object
 // returned, the message field is:
 // "internal server error".
@@ -78,3 +177,111 @@ type registerDTOWrapper struct {
 	// in: body
 	Body domain.RegisterDTO
 }
+
+//swagger:parameters refreshToken
+type refreshDTOWrapper struct {
+	// in: body
+	Body domain.RefreshDTO
+}
+
+//swagger:parameters logoutUser
+type logoutDTOWrapper struct {
+	// in: body
+	Body domain.LogoutDTO
+}
+
+//swagger:parameters forgotPassword
+type forgotPasswordDTOWrapper struct {
+	// in: body
+	Body domain.ForgotPasswordDTO
+}
+
+//swagger:parameters resetPassword
+type resetPasswordDTOWrapper struct {
+	// in: body
+	Body domain.ResetPasswordDTO
+}
+
+//swagger:parameters verifyEmail
+type verifyEmailParamsWrapper struct {
+	// the token of the verification link
+	//
+	// in: query
+	// required: true
+	Token string `json:"token"`
+}
+
+//swagger:parameters resendVerification
+type resendVerificationDTOWrapper struct {
+	// in: body
+	Body domain.ResendVerificationDTO
+}
+
+//swagger:parameters sendMagicLink
+type magicLinkDTOWrapper struct {
+	// in: body
+	Body domain.MagicLinkDTO
+}
+
+//swagger:parameters loginByMagicLink
+type magicLinkLoginDTOWrapper struct {
+	// in: body
+	Body domain.MagicLinkLoginDTO
+}
+
+//swagger:parameters verifyMFA
+type mfaVerifyDTOWrapper struct {
+	// in: body
+	Body domain.MFAVerifyDTO
+}
+
+//swagger:parameters confirmTOTP
+type mfaCodeDTOWrapper struct {
+	// in: body
+	Body domain.MFACodeDTO
+}
+
+//swagger:parameters beginWebAuthnLogin
+type webAuthnLoginDTOWrapper struct {
+	// in: body
+	Body domain.WebAuthnLoginDTO
+}
+
+//swagger:parameters finishWebAuthnLogin
+type webAuthnAssertionDTOWrapper struct {
+	// in: body
+	Body domain.WebAuthnAssertionDTO
+}
+
+//swagger:parameters finishWebAuthnRegistration
+type webAuthnRegistrationDTOWrapper struct {
+	// in: body
+	Body domain.WebAuthnRegistrationDTO
+}
+
+//swagger:parameters unlockUser deleteUser
+type userIDParamsWrapper struct {
+	// the ID of the user
+	//
+	// in: path
+	// required: true
+	ID string `json:"id"`
+}
+
+//swagger:parameters updateProfile
+type updateProfileDTOWrapper struct {
+	// the ETag of the profile the change is based on, or * for any version
+	//
+	// in: header
+	// required: true
+	IfMatch string `json:"If-Match"`
+
+	// in: body
+	Body domain.UpdateProfileDTO
+}
+
+//swagger:parameters changePassword
+type changePasswordDTOWrapper struct {
+	// in: body
+	Body domain.ChangePasswordDTO
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+type JWKS struct {
+	l        *log.Logger
+	provider domain.KeySetProvider
+}
+
+func (j *JWKS) AttachRouter(mr *mux.Router) *mux.Router {
+	jwksHandler := mr.PathPrefix("/.well-known").Subrouter()
+	jwksHandler.HandleFunc("/jwks.json", j.GetKeySet).Methods(http.MethodGet)
+	return jwksHandler
+}
+
+// NewJWKS returns a new JWKS handler
+func NewJWKS(l *log.Logger, provider domain.KeySetProvider) *JWKS {
+	return &JWKS{l: l, provider: provider}
+}
+
+// swagger:route GET /.well-known/jwks.json jwks getKeySet
+// Returns the public keys the jwt tokens can be verified with,
+// the set is empty if the tokens are signed with a symmetric key.
+// responses:
+//	200: jwksResponse
+
+// GetKeySet returns the JSON Web Key Set
+func (j *JWKS) GetKeySet(rw http.ResponseWriter, r *http.Request) {
+	j.l.Debug("Handle jwks request.")
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "public, max-age=300")
+	rw.WriteHeader(http.StatusOK)
+	ToJSON(j.provider.KeySet(), rw)
+}
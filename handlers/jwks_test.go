@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+	"github.com/vahidmostofi/minaria/usecase"
+)
+
+func TestGetKeySet(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := usecase.NewSigningKey("key-1", "ES256", private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	NewJWKS(l, usecase.NewJWT(usecase.JWTOptions{Key: key})).AttachRouter(router)
+
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+	ks := &domain.JSONWebKeySet{}
+
+	if !basicHTTPResponseChecks(t, http.StatusOK, desiredContentType, ks, w.Result()) {
+		return
+	}
+
+	if assert.Len(t, ks.Keys, 1) {
+		assert.Equal(t, "key-1", ks.Keys[0].Kid)
+		assert.Equal(t, "EC", ks.Keys[0].Kty)
+		assert.Equal(t, "P-256", ks.Keys[0].Crv)
+		assert.NotEmpty(t, ks.Keys[0].X)
+		assert.NotEmpty(t, ks.Keys[0].Y)
+	}
+}
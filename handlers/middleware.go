@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+type contextKey int
+
+const claimsContextKey contextKey = iota
+
+var ErrMissingBearerToken = GenericError{
+	Message:        "missing bearer token",
+	AdditionalInfo: nil,
+	Err:            nil,
+	HTTPStatusCode: http.StatusUnauthorized,
+}
+
+var ErrInvalidBearerToken = GenericError{
+	Message:        domain.ErrInvalidToken.Error(),
+	AdditionalInfo: nil,
+	Err:            domain.ErrInvalidToken,
+	HTTPStatusCode: http.StatusUnauthorized,
+}
+
+// Authenticator only lets the requests with a valid bearer token through
+type Authenticator struct {
+	l        *log.Logger
+	verifier domain.TokenVerifier
+}
+
+// NewAuthenticator returns a new Authenticator
+func NewAuthenticator(l *log.Logger, verifier domain.TokenVerifier) *Authenticator {
+	return &Authenticator{l: l, verifier: verifier}
+}
+
+// Middleware verifies the bearer token and puts its claims into the request's
+// context, it can be passed to mux.Router.Use.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			a.unauthorized(rw, ErrMissingBearerToken, "")
+			return
+		}
+
+		claims, err := a.verifier.Verify(r.Context(), token)
+		if err == domain.ErrInvalidToken {
+			a.l.Debug("Rejected an invalid bearer token.")
+			a.unauthorized(rw, ErrInvalidBearerToken, "invalid_token")
+			return
+		} else if err != nil {
+			a.l.Errorf("Error while verifying the bearer token: %s.", err.Error())
+			gerr := GenericError{
+				Message:        "internal server error",
+				AdditionalInfo: nil,
+				Err:            err,
+				HTTPStatusCode: http.StatusInternalServerError,
+			}
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(gerr.HTTPStatusCode)
+			ToJSON(gerr, rw)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+		next.ServeHTTP(rw, r.WithContext(ctx))
+	})
+}
+
+func (a *Authenticator) unauthorized(rw http.ResponseWriter, gerr GenericError, code string) {
+	challenge := `Bearer realm="minaria"`
+	if code != "" {
+		challenge += `, error="` + code + `"`
+	}
+	rw.Header().Set("WWW-Authenticate", challenge)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(gerr.HTTPStatusCode)
+	ToJSON(gerr, rw)
+}
+
+// ClaimsFromContext returns the claims of the authenticated request
+func ClaimsFromContext(ctx context.Context) (*domain.Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*domain.Claims)
+	return claims, ok
+}
+
+// UserIDFromContext returns the ID of the authenticated user
+func UserIDFromContext(ctx context.Context) (string, bool) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return claims.Subject, true
+}
+
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(h[7:])
+}
+
+// clientIP returns the address of the client which sent the request, the
+// forwarding headers aren't trusted as any client can set them, RealIP
+// replaces the address of the trusted proxies
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RealIP replaces the RemoteAddr of the requests which come from the trusted
+// proxies with the client address they put in X-Forwarded-For, the rightmost
+// address which isn't a trusted proxy, since a client can prepend anything
+func RealIP(trusted []*net.IPNet) func(http.Handler) http.Handler {
+	isTrusted := func(s string) bool {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return false
+		}
+		for _, n := range trusted {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if !isTrusted(clientIP(r)) {
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				hop := strings.TrimSpace(hops[i])
+				if hop == "" || isTrusted(hop) {
+					continue
+				}
+				if net.ParseIP(hop) != nil {
+					r.RemoteAddr = net.JoinHostPort(hop, "0")
+				}
+				break
+			}
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// ParseTrustedProxies parses the comma separated addresses and networks of the
+// proxies, like 10.0.0.0/8,192.0.2.1
+func ParseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip == nil {
+				return nil, fmt.Errorf("the trusted proxy %q isn't an address", p)
+			} else if ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("the trusted proxy %q isn't a network: %w", p, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
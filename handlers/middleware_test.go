@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+	"github.com/vahidmostofi/minaria/usecase"
+)
+
+func TestAuthenticatorMiddleware(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	opts := usecase.JWTOptions{Key: usecase.NewHMACSigningKey("test", []byte("secret")), Issuer: "minaria", Audience: "tests"}
+	j := usecase.NewJWT(opts)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/protected", func(rw http.ResponseWriter, r *http.Request) {
+		userID, ok := UserIDFromContext(r.Context())
+		assert.True(t, ok)
+		claims, ok := ClaimsFromContext(r.Context())
+		assert.True(t, ok)
+		assert.Equal(t, userID, claims.Subject)
+		rw.Write([]byte(userID))
+	})
+	router.Use(NewAuthenticator(l, j).Middleware)
+
+	sign := func(j *usecase.JWT, expiresAt time.Time) string {
+		token, err := j.Sign(context.TODO(), &domain.Claims{StandardClaims: jwt.StandardClaims{Subject: "user-id", ExpiresAt: expiresAt.Unix()}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, &domain.Claims{StandardClaims: jwt.StandardClaims{
+		Subject: "user-id", Issuer: "minaria", Audience: "tests", ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	tests := []struct {
+		name          string
+		authorization string
+		statusCode    int
+	}{
+		{
+			name:          "valid token",
+			authorization: "Bearer " + sign(j, time.Now().Add(time.Hour)),
+			statusCode:    http.StatusOK,
+		},
+		{
+			name:          "no token",
+			authorization: "",
+			statusCode:    http.StatusUnauthorized,
+		},
+		{
+			name:          "not a bearer token",
+			authorization: "Basic dXNlcjpwYXNz",
+			statusCode:    http.StatusUnauthorized,
+		},
+		{
+			name:          "expired token",
+			authorization: "Bearer " + sign(j, time.Now().Add(-time.Minute)),
+			statusCode:    http.StatusUnauthorized,
+		},
+		{
+			name:          "wrong signature",
+			authorization: "Bearer " + sign(usecase.NewJWT(usecase.JWTOptions{Key: usecase.NewHMACSigningKey("test", []byte("other")), Issuer: "minaria", Audience: "tests"}), time.Now().Add(time.Hour)),
+			statusCode:    http.StatusUnauthorized,
+		},
+		{
+			name:          "wrong issuer",
+			authorization: "Bearer " + sign(usecase.NewJWT(usecase.JWTOptions{Key: usecase.NewHMACSigningKey("test", []byte("secret")), Issuer: "other", Audience: "tests"}), time.Now().Add(time.Hour)),
+			statusCode:    http.StatusUnauthorized,
+		},
+		{
+			name:          "wrong audience",
+			authorization: "Bearer " + sign(usecase.NewJWT(usecase.JWTOptions{Key: usecase.NewHMACSigningKey("test", []byte("secret")), Issuer: "minaria", Audience: "other"}), time.Now().Add(time.Hour)),
+			statusCode:    http.StatusUnauthorized,
+		},
+		{
+			name:          "unsigned token",
+			authorization: "Bearer " + unsigned,
+			statusCode:    http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			resp := w.Result()
+
+			if tt.statusCode == http.StatusOK {
+				b, _ := ioutil.ReadAll(resp.Body)
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+				assert.Equal(t, "user-id", string(b))
+				return
+			}
+
+			gerr := &GenericError{}
+			if !basicHTTPResponseChecks(t, tt.statusCode, desiredContentType, gerr, resp) {
+				return
+			}
+			assert.Contains(t, resp.Header.Get("WWW-Authenticate"), "Bearer")
+		})
+	}
+}
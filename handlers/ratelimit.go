@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+var ErrTooManyRequests = GenericError{
+	Message:        "too many requests",
+	AdditionalInfo: nil,
+	Err:            nil,
+	HTTPStatusCode: http.StatusTooManyRequests,
+}
+
+// RateLimiter limits the requests of each client address to each route with token buckets
+type RateLimiter struct {
+	l      *log.Logger
+	store  domain.RateLimitStore
+	limit  domain.RateLimit
+	routes map[string]domain.RateLimit
+}
+
+// NewRateLimiter returns a new RateLimiter, routes has the limits of the path
+// templates which don't use the default limit
+func NewRateLimiter(l *log.Logger, store domain.RateLimitStore, limit domain.RateLimit, routes map[string]domain.RateLimit) *RateLimiter {
+	return &RateLimiter{l: l, store: store, limit: limit, routes: routes}
+}
+
+// Middleware takes a token for the request and refuses it with 429 if there's
+// none left, it can be passed to mux.Router.Use. The responses have the
+// RateLimit headers of draft-ietf-httpapi-ratelimit-headers.
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// the template keeps the paths with variables in one bucket
+		route := r.URL.Path
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tpl, err := cr.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		limit, ok := rl.routes[route]
+		if !ok {
+			limit = rl.limit
+		}
+
+		res, err := rl.store.Take(r.Context(), route+" "+clientIP(r), limit)
+		if err != nil {
+			// the limiter fails open, the lockout still guards the logins
+			rl.l.Errorf("Error while taking a rate limit token: %s.", err.Error())
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		h := rw.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", seconds(res.Reset))
+		h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%s", limit.Burst, seconds(limit.Period)))
+
+		if !res.Allowed {
+			rl.l.Infof("Rate limited %s on %s.", clientIP(r), route)
+			h.Set("Retry-After", seconds(res.RetryAfter))
+			h.Set("Content-Type", "application/json")
+			rw.WriteHeader(ErrTooManyRequests.HTTPStatusCode)
+			ToJSON(ErrTooManyRequests, rw)
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// seconds formats the duration as the whole seconds the headers use, rounded up
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// ParseRateLimit parses a limit like 10/1m, the burst and the period to refill it in
+func ParseRateLimit(s string) (domain.RateLimit, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), "/", 2)
+	if len(parts) != 2 {
+		return domain.RateLimit{}, fmt.Errorf("the rate limit %q isn't like 10/1m", s)
+	}
+
+	burst, err := strconv.Atoi(parts[0])
+	if err != nil || burst <= 0 {
+		return domain.RateLimit{}, fmt.Errorf("the burst of the rate limit %q isn't a positive number", s)
+	}
+	period, err := time.ParseDuration(parts[1])
+	if err != nil || period <= 0 {
+		return domain.RateLimit{}, fmt.Errorf("the period of the rate limit %q isn't a positive duration", s)
+	}
+	return domain.RateLimit{Burst: burst, Period: period}, nil
+}
+
+// ParseRouteRateLimits parses the comma separated limits of the routes,
+// like /auth/login=10/1m,/auth/register=5/1h
+func ParseRouteRateLimits(s string) (map[string]domain.RateLimit, error) {
+	routes := make(map[string]domain.RateLimit)
+	for _, rl := range strings.Split(s, ",") {
+		if strings.TrimSpace(rl) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(rl, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("the route rate limit %q isn't like /auth/login=10/1m", rl)
+		}
+		limit, err := ParseRateLimit(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		routes[strings.TrimSpace(parts[0])] = limit
+	}
+	return routes, nil
+}
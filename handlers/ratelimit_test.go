@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+	"github.com/vahidmostofi/minaria/repositories"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	limit, err := ParseRateLimit(" 10/1m ")
+	assert.Nil(t, err)
+	assert.Equal(t, domain.RateLimit{Burst: 10, Period: time.Minute}, limit)
+
+	for _, invalid := range []string{"", "10", "10/", "/1m", "0/1m", "-1/1m", "ten/1m", "10/0s", "10/soon"} {
+		_, err := ParseRateLimit(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+
+	routes, err := ParseRouteRateLimits("/auth/login=10/1m, /auth/register=5/1h,")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]domain.RateLimit{
+		"/auth/login":    {Burst: 10, Period: time.Minute},
+		"/auth/register": {Burst: 5, Period: time.Hour},
+	}, routes)
+
+	routes, err = ParseRouteRateLimits("")
+	assert.Nil(t, err)
+	assert.Len(t, routes, 0)
+
+	for _, invalid := range []string{"/auth/login", "/auth/login=10", "/auth/login=10/1m,/auth/register"} {
+		_, err := ParseRouteRateLimits(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+	store, _ := repositories.NewRateLimitStore(repositories.InMemoryKind, nil)
+	rl := NewRateLimiter(l, store, domain.RateLimit{Burst: 2, Period: time.Minute}, map[string]domain.RateLimit{
+		"/items/{id}": {Burst: 1, Period: time.Hour},
+	})
+
+	router := mux.NewRouter()
+	ok := func(rw http.ResponseWriter, r *http.Request) { rw.WriteHeader(http.StatusNoContent) }
+	router.HandleFunc("/things", ok)
+	router.HandleFunc("/items/{id}", ok)
+	router.Use(rl.Middleware)
+
+	get := func(path, remoteAddr string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	resp := get("/things", "192.0.2.1:1234")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "1", resp.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "2;w=60", resp.Header.Get("RateLimit-Policy"))
+
+	resp = get("/things", "192.0.2.1:4321")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+
+	resp = get("/things", "192.0.2.1:1234")
+	gerr := &GenericError{}
+	if basicHTTPResponseChecks(t, http.StatusTooManyRequests, desiredContentType, gerr, resp) {
+		assert.Equal(t, ErrTooManyRequests.Message, gerr.Message)
+		assert.Equal(t, "30", resp.Header.Get("Retry-After"))
+		assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+	}
+
+	// another client has its own bucket
+	resp = get("/things", "192.0.2.2:1234")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// the paths of a template share the bucket of the route
+	resp = get("/items/1", "192.0.2.1:1234")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("RateLimit-Limit"))
+	resp = get("/items/2", "192.0.2.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "3600", resp.Header.Get("Retry-After"))
+}
+
+func TestRealIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = ParseTrustedProxies("10.0.0.0/8,proxy")
+	assert.NotNil(t, err)
+
+	var got string
+	h := RealIP(trusted)(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		got = clientIP(r)
+	}))
+
+	tests := []struct {
+		remoteAddr string
+		xff        []string
+		want       string
+	}{
+		{"203.0.113.5:1234", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"192.0.2.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"10.1.2.3:1234", []string{"6.6.6.6, 198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"10.1.2.3:1234", []string{"6.6.6.6", "198.51.100.1"}, "198.51.100.1"},
+		{"10.1.2.3:1234", []string{"10.0.0.2"}, "10.1.2.3"},
+		{"10.1.2.3:1234", []string{"garbage"}, "10.1.2.3"},
+		{"10.1.2.3:1234", nil, "10.1.2.3"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		for _, v := range tt.xff {
+			req.Header.Add("X-Forwarded-For", v)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		assert.Equal(t, tt.want, got, tt.remoteAddr, tt.xff)
+	}
+}
@@ -0,0 +1,611 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// Users handles the requests of the logged in users about their own account
+type Users struct {
+	l       *log.Logger
+	usecase domain.UserUsecase
+	v       *domain.Validation
+	authn   *Authenticator
+}
+
+func (u *Users) AttachRouter(mr *mux.Router) *mux.Router {
+	usersHandler := mr.PathPrefix("/users").Subrouter()
+
+	usersHandler.HandleFunc("/me", u.GetProfile).Methods(http.MethodGet)
+	usersHandler.HandleFunc("/me", u.UpdateProfile).Methods(http.MethodPatch)
+	usersHandler.HandleFunc("/me/password", u.ChangePassword).Methods(http.MethodPost)
+	usersHandler.HandleFunc("/me/mfa/totp", u.EnrollTOTP).Methods(http.MethodPost)
+	usersHandler.HandleFunc("/me/mfa/totp/confirm", u.ConfirmTOTP).Methods(http.MethodPost)
+	usersHandler.HandleFunc("/me/mfa/recovery-codes", u.RegenerateRecoveryCodes).Methods(http.MethodPost)
+	usersHandler.HandleFunc("/me/webauthn/register/begin", u.BeginWebAuthnRegistration).Methods(http.MethodPost)
+	usersHandler.HandleFunc("/me/webauthn/register/finish", u.FinishWebAuthnRegistration).Methods(http.MethodPost)
+
+	usersHandler.Use(postProcessMiddleware)
+	usersHandler.Use(u.authn.Middleware)
+	return usersHandler
+}
+
+// NewUsers returns a new Users handler
+func NewUsers(l *log.Logger, usecase domain.UserUsecase, v *domain.Validation) *Users {
+	return &Users{l: l, usecase: usecase, v: v, authn: NewAuthenticator(l, usecase)}
+}
+
+// ErrIfMatchRequired is returned when a change doesn't say which version it's based on
+var ErrIfMatchRequired = fmt.Errorf("the If-Match header with the ETag of the profile is required")
+
+// ErrInvalidIfMatch is returned when the If-Match header isn't one ETag of a profile or *
+var ErrInvalidIfMatch = fmt.Errorf("the If-Match header must be one ETag of the profile or *")
+
+// profileETag returns the strong ETag of the version of a profile
+func profileETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseIfMatch returns the version the If-Match header asks for, 0 for *. The weak
+// ETags never match as the If-Match compares them strongly, they're ErrConflict.
+func parseIfMatch(header string) (int64, error) {
+	header = strings.TrimSpace(header)
+	switch {
+	case header == "":
+		return 0, ErrIfMatchRequired
+	case header == "*":
+		return 0, nil
+	case strings.HasPrefix(header, "W/"):
+		return 0, domain.ErrConflict
+	}
+
+	unquoted := strings.TrimSuffix(strings.TrimPrefix(header, `"`), `"`)
+	if len(unquoted) != len(header)-2 {
+		return 0, ErrInvalidIfMatch
+	}
+	version, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, ErrInvalidIfMatch
+	}
+	return version, nil
+}
+
+// swagger:route GET /users/me users getProfile
+// Returns the profile of the logged in user, the ETag header has its
+// version for the If-Match header of the changes.
+// security:
+//	bearer:
+// responses:
+//	200: profileDTOResponse
+//	401: genericErrorResponse
+//	404: genericErrorResponse
+// 	500: internalErrorResponse
+
+// GetProfile returns the profile of the logged in user
+func (u *Users) GetProfile(rw http.ResponseWriter, r *http.Request) {
+	u.l.Debug("Handle get profile request.")
+
+	claims, _ := ClaimsFromContext(r.Context())
+
+	ds, _ := time.ParseDuration("5s") // TODO
+	ctx, cancel := context.WithDeadline(r.Context(), time.Now().Add(ds))
+	defer cancel()
+
+	res, err := u.usecase.GetProfile(ctx, claims)
+	if err == domain.ErrNoUserFound {
+		gerr := GenericError{
+			Message:        err.Error(),
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusNotFound,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	} else if err != nil {
+		u.l.Errorf("Error while getting the profile: %s.", err.Error())
+		gerr := GenericError{
+			Message:        "internal server error",
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	rw.Header().Set("ETag", profileETag(res.Version))
+	rw.WriteHeader(http.StatusOK)
+	ToJSON(res, rw)
+}
+
+// swagger:route PATCH /users/me users updateProfile
+// Changes the username or the email address of the logged in user. The
+// If-Match header must have the ETag of the profile the change is based
+// on, or * to change whichever version is stored; if the profile was
+// changed since, nothing is changed and the status is 412. A new email
+// address needs the current password and has to be verified again, the old
+// address is told about the change. A wrong password counts as a failed
+// login of the account.
+// security:
+//	bearer:
+// responses:
+//	200: profileDTOResponse
+//	400: genericErrorResponse
+//  400: validationErrorResponse
+//	401: genericErrorResponse
+//	404: genericErrorResponse
+//	412: genericErrorResponse
+//	423: lockoutErrorResponse
+//	428: genericErrorResponse
+//	429: lockoutErrorResponse
+// 	500: internalErrorResponse
+
+// UpdateProfile changes the username or the email of the logged in user
+func (u *Users) UpdateProfile(rw http.ResponseWriter, r *http.Request) {
+	u.l.Debug("Handle update profile request.")
+
+	version, err := parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		status := http.StatusBadRequest
+		if err == ErrIfMatchRequired {
+			status = http.StatusPreconditionRequired
+		} else if err == domain.ErrConflict {
+			status = http.StatusPreconditionFailed
+		}
+		gerr := GenericError{
+			Message:        err.Error(),
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: status,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	pd := &domain.UpdateProfileDTO{}
+	gerr := validateDTO(u.v, pd, r.Body)
+
+	if gerr != nil {
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	claims, _ := ClaimsFromContext(r.Context())
+
+	ds, _ := time.ParseDuration("5s") // TODO
+	ctx, cancel := context.WithDeadline(r.Context(), time.Now().Add(ds))
+	defer cancel()
+	ctx = domain.WithClientIP(ctx, clientIP(r))
+
+	res, err := u.usecase.UpdateProfile(ctx, claims, version, pd)
+	var lerr *domain.LockoutError
+	if errors.As(err, &lerr) {
+		u.l.Infof("Profile change refused: %s.", err.Error())
+		writeLockoutError(rw, lerr)
+		return
+	} else if err != nil {
+		status := http.StatusInternalServerError
+		switch err {
+		case domain.ErrConflict:
+			status = http.StatusPreconditionFailed
+		case domain.ErrNoUserFound:
+			status = http.StatusNotFound
+		case domain.ErrUsernameAlreadyTaken, domain.ErrEmailAlreadyTaken, domain.ErrInvalidUsername, domain.ErrInvalidEmail,
+			domain.ErrCurrentPasswordRequired, domain.ErrCurrentPasswordNotMatch:
+			status = http.StatusBadRequest
+		}
+
+		message := err.Error()
+		if status == http.StatusInternalServerError {
+			u.l.Errorf("Error while updating the profile: %s.", err.Error())
+			message = "internal server error"
+		} else {
+			u.l.Infof("Profile change rejected: %s.", err.Error())
+		}
+		gerr := GenericError{
+			Message:        message,
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: status,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	rw.Header().Set("ETag", profileETag(res.Version))
+	rw.WriteHeader(http.StatusOK)
+	ToJSON(res, rw)
+}
+
+// swagger:route POST /users/me/password users changePassword
+// Changes the password of the logged in user and returns a new pair of
+// tokens for the current session, with logoutOtherSessions set every other
+// session is revoked as well. A wrong current password counts as a failed
+// login of the account.
+// security:
+//	bearer:
+// responses:
+//	200: jwtDTOResponse
+//	400: genericErrorResponse
+//  400: validationErrorResponse
+//	401: genericErrorResponse
+//	423: lockoutErrorResponse
+//	429: lockoutErrorResponse
+// 	500: internalErrorResponse
+
+// ChangePassword changes the password of the logged in user
+func (u *Users) ChangePassword(rw http.ResponseWriter, r *http.Request) {
+	u.l.Debug("Handle change password request.")
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	cd := &domain.ChangePasswordDTO{}
+	gerr := validateDTO(u.v, cd, r.Body)
+
+	if gerr != nil {
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	claims, _ := ClaimsFromContext(r.Context())
+
+	ds, _ := time.ParseDuration("5s") // TODO
+	ctx, cancel = context.WithDeadline(r.Context(), time.Now().Add(ds))
+	defer cancel()
+	ctx = domain.WithClientIP(ctx, clientIP(r))
+
+	res, err := u.usecase.ChangePassword(ctx, claims, cd)
+	var lerr *domain.LockoutError
+	if errors.As(err, &lerr) {
+		u.l.Infof("Password change refused: %s.", err.Error())
+		writeLockoutError(rw, lerr)
+		return
+	} else if err == domain.ErrPasswordsDoNotMatch || err == domain.ErrCurrentPasswordNotMatch || err == domain.ErrPasswordTooLong {
+		u.l.Infof("Password change rejected: %s.", err.Error())
+		gerr := GenericError{
+			Message:        err.Error(),
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusBadRequest,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	} else if err != nil {
+		u.l.Errorf("Error while changing the password: %s.", err.Error())
+		gerr := GenericError{
+			Message:        "internal server error",
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	ToJSON(res, rw)
+}
+
+// swagger:route POST /users/me/mfa/totp users enrollTOTP
+// Creates a new totp secret for the logged in user and returns it as an
+// otpauth:// uri and a qr code. Two-factor authentication is enabled once
+// the first code is confirmed, enrolling again replaces an unconfirmed secret.
+// security:
+//	bearer:
+// responses:
+//	200: totpEnrollmentDTOResponse
+//	401: genericErrorResponse
+//	409: genericErrorResponse
+// 	500: internalErrorResponse
+
+// EnrollTOTP starts the two-factor authentication enrollment of the logged in user
+func (u *Users) EnrollTOTP(rw http.ResponseWriter, r *http.Request) {
+	u.l.Debug("Handle enroll totp request.")
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	claims, _ := ClaimsFromContext(r.Context())
+
+	ds, _ := time.ParseDuration("5s") // TODO
+	ctx, cancel = context.WithDeadline(r.Context(), time.Now().Add(ds))
+	defer cancel()
+
+	res, err := u.usecase.EnrollTOTP(ctx, claims)
+	if err == domain.ErrMFAAlreadyEnabled {
+		gerr := GenericError{
+			Message:        err.Error(),
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusConflict,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	} else if err != nil {
+		u.l.Errorf("Error while enrolling totp: %s.", err.Error())
+		gerr := GenericError{
+			Message:        "internal server error",
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	ToJSON(res, rw)
+}
+
+// swagger:route POST /users/me/mfa/totp/confirm users confirmTOTP
+// Enables two-factor authentication for the logged in user with the
+// first code of the enrolled secret and returns the recovery codes,
+// they are only shown this once.
+// security:
+//	bearer:
+// responses:
+//	200: recoveryCodesDTOResponse
+//	400: genericErrorResponse
+//  400: validationErrorResponse
+//	401: genericErrorResponse
+//	409: genericErrorResponse
+// 	500: internalErrorResponse
+
+// ConfirmTOTP enables two-factor authentication for the logged in user
+func (u *Users) ConfirmTOTP(rw http.ResponseWriter, r *http.Request) {
+	u.l.Debug("Handle confirm totp request.")
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	cd := &domain.MFACodeDTO{}
+	gerr := validateDTO(u.v, cd, r.Body)
+
+	if gerr != nil {
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	claims, _ := ClaimsFromContext(r.Context())
+
+	ds, _ := time.ParseDuration("5s") // TODO
+	ctx, cancel = context.WithDeadline(r.Context(), time.Now().Add(ds))
+	defer cancel()
+
+	res, err := u.usecase.ConfirmTOTP(ctx, claims, cd)
+	if err == domain.ErrInvalidMFACode || err == domain.ErrNoMFAEnrollment {
+		u.l.Infof("Totp confirmation rejected: %s.", err.Error())
+		gerr := GenericError{
+			Message:        err.Error(),
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusBadRequest,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	} else if err == domain.ErrMFAAlreadyEnabled {
+		gerr := GenericError{
+			Message:        err.Error(),
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusConflict,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	} else if err != nil {
+		u.l.Errorf("Error while confirming totp: %s.", err.Error())
+		gerr := GenericError{
+			Message:        "internal server error",
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	ToJSON(res, rw)
+}
+
+// swagger:route POST /users/me/mfa/recovery-codes users regenerateRecoveryCodes
+// Replaces the recovery codes of the logged in user, the old codes
+// stop working and the new ones are only shown this once.
+// security:
+//	bearer:
+// responses:
+//	200: recoveryCodesDTOResponse
+//	400: genericErrorResponse
+//	401: genericErrorResponse
+// 	500: internalErrorResponse
+
+// RegenerateRecoveryCodes replaces the recovery codes of the logged in user
+func (u *Users) RegenerateRecoveryCodes(rw http.ResponseWriter, r *http.Request) {
+	u.l.Debug("Handle regenerate recovery codes request.")
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	claims, _ := ClaimsFromContext(r.Context())
+
+	ds, _ := time.ParseDuration("5s") // TODO
+	ctx, cancel = context.WithDeadline(r.Context(), time.Now().Add(ds))
+	defer cancel()
+
+	res, err := u.usecase.RegenerateRecoveryCodes(ctx, claims)
+	if err == domain.ErrMFANotEnabled {
+		gerr := GenericError{
+			Message:        err.Error(),
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusBadRequest,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	} else if err != nil {
+		u.l.Errorf("Error while regenerating the recovery codes: %s.", err.Error())
+		gerr := GenericError{
+			Message:        "internal server error",
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	ToJSON(res, rw)
+}
+
+// swagger:route POST /users/me/webauthn/register/begin users beginWebAuthnRegistration
+// Returns the options for navigator.credentials.create() to register a
+// passkey or a security key for the logged in user.
+// security:
+//	bearer:
+// responses:
+//	200: webAuthnCreationOptionsDTOResponse
+//	401: genericErrorResponse
+// 	500: internalErrorResponse
+
+// BeginWebAuthnRegistration starts the registration of a WebAuthn credential
+func (u *Users) BeginWebAuthnRegistration(rw http.ResponseWriter, r *http.Request) {
+	u.l.Debug("Handle begin webauthn registration request.")
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	claims, _ := ClaimsFromContext(r.Context())
+
+	ds, _ := time.ParseDuration("5s") // TODO
+	ctx, cancel = context.WithDeadline(r.Context(), time.Now().Add(ds))
+	defer cancel()
+
+	res, err := u.usecase.BeginWebAuthnRegistration(ctx, claims)
+	if err != nil {
+		u.l.Errorf("Error while beginning the webauthn registration: %s.", err.Error())
+		gerr := GenericError{
+			Message:        "internal server error",
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	ToJSON(res, rw)
+}
+
+// swagger:route POST /users/me/webauthn/register/finish users finishWebAuthnRegistration
+// Stores the credential which navigator.credentials.create() returned,
+// afterwards the user can log in with it at /auth/webauthn/login/begin.
+// security:
+//	bearer:
+// responses:
+//	204: noContentResponse
+//	400: genericErrorResponse
+//  400: validationErrorResponse
+//	401: genericErrorResponse
+//	409: genericErrorResponse
+// 	500: internalErrorResponse
+
+// FinishWebAuthnRegistration stores the WebAuthn credential of the logged in user
+func (u *Users) FinishWebAuthnRegistration(rw http.ResponseWriter, r *http.Request) {
+	u.l.Debug("Handle finish webauthn registration request.")
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	rd := &domain.WebAuthnRegistrationDTO{}
+	gerr := validateDTO(u.v, rd, r.Body)
+
+	if gerr != nil {
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	claims, _ := ClaimsFromContext(r.Context())
+
+	ds, _ := time.ParseDuration("5s") // TODO
+	ctx, cancel = context.WithDeadline(r.Context(), time.Now().Add(ds))
+	defer cancel()
+
+	err := u.usecase.FinishWebAuthnRegistration(ctx, claims, rd)
+	if err == domain.ErrInvalidWebAuthnResponse {
+		u.l.Infof("Webauthn registration rejected: %s.", err.Error())
+		gerr := GenericError{
+			Message:        err.Error(),
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusBadRequest,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	} else if err == domain.ErrWebAuthnCredentialExists {
+		gerr := GenericError{
+			Message:        err.Error(),
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusConflict,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	} else if err != nil {
+		u.l.Errorf("Error while finishing the webauthn registration: %s.", err.Error())
+		gerr := GenericError{
+			Message:        "internal server error",
+			AdditionalInfo: nil,
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+		}
+		rw.WriteHeader(gerr.HTTPStatusCode)
+		ToJSON(gerr, rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,377 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+func login(t *testing.T, router *mux.Router, email, password string) *domain.JWTDTO {
+	b, _ := json.Marshal(&domain.LoginDTO{Identifier: email, Password: strfmt.Password(password)})
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewReader(b))
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+	jwtDTO := &domain.JWTDTO{}
+	basicHTTPResponseChecks(t, http.StatusOK, desiredContentType, jwtDTO, w.Result())
+	return jwtDTO
+}
+
+func changePassword(router *mux.Router, token string, cd *domain.ChangePasswordDTO) *http.Response {
+	b, _ := json.Marshal(cd)
+	req := httptest.NewRequest(http.MethodPost, "/users/me/password", bytes.NewReader(b))
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+	return w.Result()
+}
+
+func TestChangePasswordError(t *testing.T) {
+	router := getNewRouter()
+	testUserDbIdx := 1
+	session := login(t, router, testUserData[testUserDbIdx].Email, "1234567")
+
+	tests := []struct {
+		name       string
+		token      string
+		dto        *domain.ChangePasswordDTO
+		more       map[string]string
+		errMessage string
+		statusCode int
+	}{
+		{
+			name:       "unauthorized - no token",
+			dto:        &domain.ChangePasswordDTO{CurrentPassword: "1234567", Password: "7654321", RepeatPassword: "7654321"},
+			statusCode: http.StatusUnauthorized,
+			errMessage: "missing bearer token",
+		},
+		{
+			name:       "bad request - wrong current password",
+			token:      session.Token,
+			dto:        &domain.ChangePasswordDTO{CurrentPassword: "123456", Password: "7654321", RepeatPassword: "7654321"},
+			statusCode: http.StatusBadRequest,
+			errMessage: "current password is wrong",
+		},
+		{
+			name:       "bad request - passwords don't match",
+			token:      session.Token,
+			dto:        &domain.ChangePasswordDTO{CurrentPassword: "1234567", Password: "7654321", RepeatPassword: "765432"},
+			statusCode: http.StatusBadRequest,
+			errMessage: "passwords don't match",
+		},
+		{
+			name:       "bad request - password too short",
+			token:      session.Token,
+			dto:        &domain.ChangePasswordDTO{CurrentPassword: "1234567", Password: "1234", RepeatPassword: "1234"},
+			statusCode: http.StatusBadRequest,
+			more:       map[string]string{"Password": "Password must be at least 5 characters in length"},
+			errMessage: "FieldError",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gerr := &GenericError{}
+			if !basicHTTPResponseChecks(t, tt.statusCode, desiredContentType, gerr, changePassword(router, tt.token, tt.dto)) {
+				return
+			}
+			assert.Equal(t, tt.errMessage, gerr.Message)
+			for key, value := range tt.more {
+				assert.Equal(t, value, gerr.AdditionalInfo.(map[string]interface{})[key])
+			}
+		})
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	router := getNewRouter()
+	testUserDbIdx := 1
+	email := testUserData[testUserDbIdx].Email
+
+	other := login(t, router, email, "1234567")
+	current := login(t, router, email, "1234567")
+
+	resp := changePassword(router, current.Token, &domain.ChangePasswordDTO{CurrentPassword: "1234567", Password: "7654321", RepeatPassword: "7654321"})
+	rotated := &domain.JWTDTO{}
+	if !basicHTTPResponseChecks(t, http.StatusOK, desiredContentType, rotated, resp) {
+		return
+	}
+	assert.Greater(t, len(rotated.Token), 0)
+
+	// the tokens the current session had are replaced
+	resp = changePassword(router, current.Token, &domain.ChangePasswordDTO{CurrentPassword: "7654321", Password: "abcdefg", RepeatPassword: "abcdefg"})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// the other sessions stay logged in unless asked otherwise
+	resp = changePassword(router, other.Token, &domain.ChangePasswordDTO{CurrentPassword: "7654321", Password: "abcdefg", RepeatPassword: "abcdefg", LogoutOtherSessions: true})
+	renewed := &domain.JWTDTO{}
+	if !basicHTTPResponseChecks(t, http.StatusOK, desiredContentType, renewed, resp) {
+		return
+	}
+	assert.Greater(t, len(renewed.Token), 0)
+
+	resp = changePassword(router, rotated.Token, &domain.ChangePasswordDTO{CurrentPassword: "abcdefg", Password: "1234567", RepeatPassword: "1234567"})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = changePassword(router, renewed.Token, &domain.ChangePasswordDTO{CurrentPassword: "abcdefg", Password: "1234567", RepeatPassword: "1234567"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestEnrollTOTP(t *testing.T) {
+	router := getNewRouter()
+	session := login(t, router, testUserData[2].Email, "1234567")
+
+	req := httptest.NewRequest(http.MethodPost, "/users/me/mfa/totp", nil)
+	req.Header.Set("Authorization", "Bearer "+session.Token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	enrollment := &domain.TOTPEnrollmentDTO{}
+	if !basicHTTPResponseChecks(t, http.StatusOK, desiredContentType, enrollment, w.Result()) {
+		return
+	}
+	assert.NotEmpty(t, enrollment.Secret)
+	assert.Contains(t, enrollment.URI, "secret="+enrollment.Secret)
+	assert.NotEmpty(t, enrollment.QRCode)
+
+	tests := []struct {
+		name       string
+		dto        *domain.MFACodeDTO
+		errMessage string
+	}{
+		{name: "bad request - wrong code", dto: &domain.MFACodeDTO{Code: "000000"}, errMessage: "mfa code is invalid"},
+		{name: "bad request - no code", dto: &domain.MFACodeDTO{}, errMessage: "FieldError"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := json.Marshal(tt.dto)
+			req := httptest.NewRequest(http.MethodPost, "/users/me/mfa/totp/confirm", bytes.NewReader(b))
+			req.Header.Set("Authorization", "Bearer "+session.Token)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			gerr := &GenericError{}
+
+			if !basicHTTPResponseChecks(t, http.StatusBadRequest, desiredContentType, gerr, w.Result()) {
+				return
+			}
+			assert.Equal(t, tt.errMessage, gerr.Message)
+		})
+	}
+}
+
+func TestRegenerateRecoveryCodesError(t *testing.T) {
+	router := getNewRouter()
+	session := login(t, router, testUserData[2].Email, "1234567")
+
+	req := httptest.NewRequest(http.MethodPost, "/users/me/mfa/recovery-codes", nil)
+	req.Header.Set("Authorization", "Bearer "+session.Token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	gerr := &GenericError{}
+	if !basicHTTPResponseChecks(t, http.StatusBadRequest, desiredContentType, gerr, w.Result()) {
+		return
+	}
+	assert.Equal(t, "two-factor authentication is not enabled", gerr.Message)
+}
+
+func TestWebAuthnRegistration(t *testing.T) {
+	router := getNewRouter()
+	session := login(t, router, testUserData[2].Email, "1234567")
+
+	req := httptest.NewRequest(http.MethodPost, "/users/me/webauthn/register/begin", nil)
+	req.Header.Set("Authorization", "Bearer "+session.Token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	options := &domain.WebAuthnCreationOptionsDTO{}
+	if !basicHTTPResponseChecks(t, http.StatusOK, desiredContentType, options, w.Result()) {
+		return
+	}
+	assert.NotEmpty(t, options.PublicKey.Challenge)
+	assert.NotEmpty(t, options.PublicKey.User.ID)
+	assert.Equal(t, testUserData[2].Email, options.PublicKey.User.Name)
+
+	tests := []struct {
+		name       string
+		dto        *domain.WebAuthnRegistrationDTO
+		errMessage string
+	}{
+		{
+			name: "bad request - invalid response",
+			dto: &domain.WebAuthnRegistrationDTO{RawID: "AAAA", Type: "public-key", Response: domain.WebAuthnAttestationResponse{
+				ClientDataJSON: "e30", AttestationObject: "oA",
+			}},
+			errMessage: "webauthn response is invalid",
+		},
+		{
+			name:       "bad request - wrong type",
+			dto:        &domain.WebAuthnRegistrationDTO{RawID: "AAAA", Type: "password"},
+			errMessage: "FieldError",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := json.Marshal(tt.dto)
+			req := httptest.NewRequest(http.MethodPost, "/users/me/webauthn/register/finish", bytes.NewReader(b))
+			req.Header.Set("Authorization", "Bearer "+session.Token)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			gerr := &GenericError{}
+
+			if !basicHTTPResponseChecks(t, http.StatusBadRequest, desiredContentType, gerr, w.Result()) {
+				return
+			}
+			assert.Equal(t, tt.errMessage, gerr.Message)
+		})
+	}
+}
+
+func updateProfile(router *mux.Router, token, ifMatch string, pd *domain.UpdateProfileDTO) *http.Response {
+	b, _ := json.Marshal(pd)
+	req := httptest.NewRequest(http.MethodPatch, "/users/me", bytes.NewReader(b))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+	return w.Result()
+}
+
+func getProfile(t *testing.T, router *mux.Router, token string) (*domain.ProfileDTO, string) {
+	req := httptest.NewRequest(http.MethodGet, "/users/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+	p := &domain.ProfileDTO{}
+	basicHTTPResponseChecks(t, http.StatusOK, desiredContentType, p, w.Result())
+	return p, w.Result().Header.Get("ETag")
+}
+
+func TestUpdateProfileError(t *testing.T) {
+	router := getNewRouter()
+	testUserDbIdx := 2
+	session := login(t, router, testUserData[testUserDbIdx].Email, "1234567")
+	p, etag := getProfile(t, router, session.Token)
+	stale := profileETag(p.Version - 1)
+
+	tests := []struct {
+		name       string
+		ifMatch    string
+		dto        *domain.UpdateProfileDTO
+		errMessage string
+		statusCode int
+	}{
+		{
+			name:       "precondition required - no If-Match",
+			dto:        &domain.UpdateProfileDTO{Username: "jillian"},
+			statusCode: http.StatusPreconditionRequired,
+			errMessage: ErrIfMatchRequired.Error(),
+		},
+		{
+			name:       "bad request - malformed If-Match",
+			ifMatch:    "1",
+			dto:        &domain.UpdateProfileDTO{Username: "jillian"},
+			statusCode: http.StatusBadRequest,
+			errMessage: ErrInvalidIfMatch.Error(),
+		},
+		{
+			name:       "precondition failed - weak ETag",
+			ifMatch:    `W/"1"`,
+			dto:        &domain.UpdateProfileDTO{Username: "jillian"},
+			statusCode: http.StatusPreconditionFailed,
+			errMessage: domain.ErrConflict.Error(),
+		},
+		{
+			name:       "precondition failed - stale ETag",
+			ifMatch:    stale,
+			dto:        &domain.UpdateProfileDTO{Username: "jillian"},
+			statusCode: http.StatusPreconditionFailed,
+			errMessage: domain.ErrConflict.Error(),
+		},
+		{
+			name:       "bad request - username too short",
+			ifMatch:    etag,
+			dto:        &domain.UpdateProfileDTO{Username: "jill"},
+			statusCode: http.StatusBadRequest,
+			errMessage: "FieldError",
+		},
+		{
+			name:       "bad request - email without the current password",
+			ifMatch:    "*",
+			dto:        &domain.UpdateProfileDTO{Email: "jill@provider.net"},
+			statusCode: http.StatusBadRequest,
+			errMessage: domain.ErrCurrentPasswordRequired.Error(),
+		},
+		{
+			name:       "bad request - email with a wrong current password",
+			ifMatch:    "*",
+			dto:        &domain.UpdateProfileDTO{Email: "jill@provider.net", CurrentPassword: "7654321"},
+			statusCode: http.StatusBadRequest,
+			errMessage: domain.ErrCurrentPasswordNotMatch.Error(),
+		},
+		{
+			name:       "bad request - email taken",
+			ifMatch:    "*",
+			dto:        &domain.UpdateProfileDTO{Email: "JACK@gmail.com", CurrentPassword: "1234567"},
+			statusCode: http.StatusBadRequest,
+			errMessage: domain.ErrEmailAlreadyTaken.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gerr := &GenericError{}
+			if !basicHTTPResponseChecks(t, tt.statusCode, desiredContentType, gerr, updateProfile(router, session.Token, tt.ifMatch, tt.dto)) {
+				return
+			}
+			assert.Equal(t, tt.errMessage, gerr.Message)
+		})
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	router := getNewRouter()
+	testUserDbIdx := 2
+	session := login(t, router, testUserData[testUserDbIdx].Email, "1234567")
+
+	p, etag := getProfile(t, router, session.Token)
+	assert.Equal(t, profileETag(p.Version), etag)
+	assert.Equal(t, "jill", p.Username)
+	read := p.Version
+
+	resp := updateProfile(router, session.Token, etag, &domain.UpdateProfileDTO{Username: "jillian"})
+	if !basicHTTPResponseChecks(t, http.StatusOK, desiredContentType, p, resp) {
+		return
+	}
+	assert.Equal(t, "jillian", p.Username)
+	assert.Equal(t, "jill@gmail.com", p.Email)
+	assert.Equal(t, profileETag(read+1), resp.Header.Get("ETag"))
+
+	// the second change based on the same read loses
+	resp = updateProfile(router, session.Token, etag, &domain.UpdateProfileDTO{Username: "jilly"})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp = updateProfile(router, session.Token, "*", &domain.UpdateProfileDTO{Email: "jill@provider.net", CurrentPassword: "1234567"})
+	if !basicHTTPResponseChecks(t, http.StatusOK, desiredContentType, p, resp) {
+		return
+	}
+	assert.Equal(t, "jillian", p.Username)
+	assert.Equal(t, "jill@provider.net", p.Email)
+	assert.Equal(t, profileETag(read+2), resp.Header.Get("ETag"))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/vahidmostofi/minaria/common"
+	"github.com/vahidmostofi/minaria/usecase"
+)
+
+const keysUsage = `usage: minaria keys <command> [flags]
+
+Manages the signing keyring in MINARIA_JWT_KEYRING_DIR, send SIGHUP to the
+running server to pick up the changes.
+
+commands:
+  list                                     lists the keys and their states
+  rotate [-alg ES256] [-retire-after 24h]  makes a new key active, the current one becomes verify-only
+  retire <kid>                             stops accepting the tokens signed with the key
+`
+
+// runKeysCommand runs the keys sub command with the arguments after "keys"
+func runKeysCommand(args []string) error {
+	dir := viper.GetString(common.JWT_KEYRING_DIR)
+	if dir == "" {
+		return fmt.Errorf("MINARIA_%s is not set", common.JWT_KEYRING_DIR)
+	}
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, keysUsage)
+		return fmt.Errorf("no command given")
+	}
+
+	alg := viper.GetString(common.JWT_SIGNING_ALG)
+	if alg == "" {
+		alg = "ES256"
+	}
+
+	switch args[0] {
+	case "list":
+		kr, err := usecase.LoadKeyring(dir, alg)
+		if err != nil {
+			return err
+		}
+		printKeyring(kr)
+
+	case "rotate":
+		fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+		alg := fs.String("alg", alg, "the signing algorithm of the new key")
+		retireAfter := fs.Duration("retire-after", 0, "retire the verify-only keys deactivated longer ago than this, it must be longer than the lifetime of the tokens; zero keeps them")
+		fs.Parse(args[1:])
+
+		kr, err := usecase.LoadKeyring(dir, *alg)
+		if err != nil {
+			return err
+		}
+		key, err := kr.Rotate(*alg, *retireAfter)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s is the new active key\n", key.ID)
+		printKeyring(kr)
+
+	case "retire":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: minaria keys retire <kid>")
+		}
+		kr, err := usecase.LoadKeyring(dir, alg)
+		if err != nil {
+			return err
+		}
+		if err := kr.Retire(args[1]); err != nil {
+			return err
+		}
+		printKeyring(kr)
+
+	default:
+		fmt.Fprint(os.Stderr, keysUsage)
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+
+	return nil
+}
+
+func printKeyring(kr *usecase.Keyring) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KID\tALG\tSTATE\tCREATED")
+	for _, e := range kr.Entries() {
+		alg := ""
+		if e.Key.Method != nil {
+			alg = e.Key.Method.Alg()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Key.ID, alg, e.State, e.CreatedAt.Format(time.RFC3339))
+	}
+	w.Flush()
+}
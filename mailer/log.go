@@ -0,0 +1,25 @@
+package mailer
+
+import (
+	"context"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// logTransport writes the messages to the log instead of sending them,
+// the bodies are only logged at debug level since they contain tokens
+type logTransport struct {
+	l *log.Logger
+}
+
+func newLogTransport(l *log.Logger) *logTransport {
+	return &logTransport{l: l}
+}
+
+func (lt *logTransport) Send(ctx context.Context, m *domain.MailMessage) error {
+	lt.l.Infof("Mail %q to %s.", m.Subject, strings.Join(m.To, ", "))
+	lt.l.Debugf("Mail %q text body:\n%s", m.Subject, m.Text)
+	return nil
+}
@@ -0,0 +1,40 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vahidmostofi/minaria/common"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// maildropTransport writes every message to its own .eml file, it's meant
+// for the tests and the local development
+type maildropTransport struct {
+	dir string
+}
+
+func newMaildropTransport(dir string) (*maildropTransport, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error while creating the maildrop directory: %w", err)
+	}
+	return &maildropTransport{dir: dir}, nil
+}
+
+func (mt *maildropTransport) Send(ctx context.Context, m *domain.MailMessage) error {
+	b, err := encodeMessage(m)
+	if err != nil {
+		return err
+	}
+
+	// the names sort in the order the messages were sent
+	name := time.Now().UTC().Format("20060102T150405.000000000") + "-" + uuid.New().String()[:8] + ".eml"
+	if err := common.WriteFileAtomic(filepath.Join(mt.dir, name), b, 0600); err != nil {
+		return fmt.Errorf("error while writing the message: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/mail"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// ErrUnknownTransport ...
+var ErrUnknownTransport = fmt.Errorf("no mail transport found with provided kind")
+
+const (
+	LogKind      string = "Log"
+	MaildropKind string = "Maildrop"
+	SMTPKind     string = "SMTP"
+)
+
+type LogArgs struct {
+	Logger *log.Logger
+}
+
+type MaildropArgs struct {
+	// the directory the .eml files are written to, it's created if it doesn't exist
+	Dir string
+}
+
+type SMTPArgs struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+
+	// default verifies the certificate of Host with the system roots
+	TLSConfig *tls.Config
+}
+
+func NewTransport(kind string, args interface{}) (domain.MailTransport, error) {
+
+	switch kind {
+	case LogKind:
+		la, ok := args.(*LogArgs)
+		if !ok || la.Logger == nil {
+			return nil, fmt.Errorf("the log transport needs a logger")
+		}
+		return newLogTransport(la.Logger), nil
+	case MaildropKind:
+		ma, ok := args.(*MaildropArgs)
+		if !ok || ma.Dir == "" {
+			return nil, fmt.Errorf("the maildrop transport needs a directory")
+		}
+		return newMaildropTransport(ma.Dir)
+	case SMTPKind:
+		sa, ok := args.(*SMTPArgs)
+		if !ok || sa.Host == "" {
+			return nil, fmt.Errorf("the smtp transport needs a host")
+		}
+		return newSMTPTransport(sa), nil
+	}
+
+	return nil, errors.Wrap(ErrUnknownTransport, fmt.Sprintf("kind: %s", kind))
+}
+
+type mailer struct {
+	from      string
+	t         domain.MailTransport
+	templates *Templates
+}
+
+// NewMailer returns a mailer which renders the templates and sends the messages
+// from the address with the transport, default templates are DefaultTemplates.
+func NewMailer(from string, t domain.MailTransport, templates *Templates) (domain.Mailer, error) {
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("invalid from address: %w", err)
+	}
+	if templates == nil {
+		var err error
+		if templates, err = ParseTemplates(DefaultTemplates); err != nil {
+			return nil, err
+		}
+	}
+	return &mailer{from: from, t: t, templates: templates}, nil
+}
+
+func (m *mailer) Send(ctx context.Context, to string, template string, data interface{}) error {
+	msg, err := m.templates.Render(template, data)
+	if err != nil {
+		return err
+	}
+	msg.From = m.from
+	msg.To = []string{to}
+
+	return m.t.Send(ctx, msg)
+}
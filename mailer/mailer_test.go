@@ -0,0 +1,115 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+func TestRender(t *testing.T) {
+	templates, err := ParseTemplates(DefaultTemplates)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := templates.Render(domain.EmailVerificationTemplate, &domain.AccountMailData{
+		Username: "<jack>",
+		Link:     "http://localhost:9090/auth/verify?token=a&b",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Verify your email address", m.Subject)
+	assert.Contains(t, m.Text, "Hi <jack>,")
+	assert.Contains(t, m.Text, "http://localhost:9090/auth/verify?token=a&b")
+	// only the html body is escaped
+	assert.Contains(t, m.HTML, "Hi &lt;jack&gt;,")
+	assert.Contains(t, m.HTML, `href="http://localhost:9090/auth/verify?token=a&amp;b"`)
+
+	_, err = templates.Render("unknown", nil)
+	assert.True(t, errors.Is(err, domain.ErrUnknownMailTemplate))
+
+	_, err = ParseTemplates(map[string]Template{"no subject": {Text: "text"}})
+	assert.NotNil(t, err)
+}
+
+func TestNewTransport(t *testing.T) {
+	_, err := NewTransport("unknown", nil)
+	assert.True(t, errors.Is(err, ErrUnknownTransport))
+
+	_, err = NewTransport(LogKind, nil)
+	assert.NotNil(t, err)
+	_, err = NewTransport(MaildropKind, &MaildropArgs{})
+	assert.NotNil(t, err)
+	_, err = NewTransport(SMTPKind, &SMTPArgs{})
+	assert.NotNil(t, err)
+
+	_, err = NewMailer("not an address", nil, nil)
+	assert.NotNil(t, err)
+}
+
+func TestMaildrop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maildrop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir = filepath.Join(dir, "mails")
+
+	mt, err := NewTransport(MaildropKind, &MaildropArgs{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMailer("Minaria <no-reply@minaria.io>", mt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Send(context.TODO(), "jack@gmail.com", domain.PasswordResetTemplate, &domain.AccountMailData{
+		Username: "jack",
+		Link:     "http://localhost:9090/password/reset?token=token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if !assert.Len(t, files, 1) {
+		return
+	}
+	b, _ := ioutil.ReadFile(files[0])
+	msg, err := mail.ReadMessage(strings.NewReader(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `"Minaria" <no-reply@minaria.io>`, msg.Header.Get("From"))
+	assert.Equal(t, "<jack@gmail.com>", msg.Header.Get("To"))
+	assert.Equal(t, "Reset your password", msg.Header.Get("Subject"))
+	assert.True(t, strings.HasSuffix(msg.Header.Get("Message-ID"), "@minaria.io>"))
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	assert.Nil(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	var types []string
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		// the reader decodes the quoted-printable bodies
+		body, _ := ioutil.ReadAll(p)
+		assert.Contains(t, string(body), "http://localhost:9090/password/reset?token=token")
+		types = append(types, p.Header.Get("Content-Type"))
+	}
+	assert.Equal(t, []string{"text/plain; charset=utf-8", "text/html; charset=utf-8"}, types)
+}
@@ -0,0 +1,110 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+var ErrStartTLSNotSupported = fmt.Errorf("the smtp server doesn't support STARTTLS")
+
+// smtpTransport sends the messages to an SMTP server, the connection is always
+// upgraded with STARTTLS before the credentials or the message are sent.
+type smtpTransport struct {
+	host      string
+	addr      string
+	auth      smtp.Auth
+	tlsConfig *tls.Config
+}
+
+func newSMTPTransport(sa *SMTPArgs) *smtpTransport {
+	port := sa.Port
+	if port == 0 {
+		port = 587
+	}
+
+	st := &smtpTransport{host: sa.Host, addr: net.JoinHostPort(sa.Host, strconv.Itoa(port))}
+	if sa.Username != "" {
+		st.auth = smtp.PlainAuth("", sa.Username, sa.Password, sa.Host)
+	}
+	if sa.TLSConfig != nil {
+		st.tlsConfig = sa.TLSConfig.Clone()
+	} else {
+		st.tlsConfig = &tls.Config{}
+	}
+	if st.tlsConfig.ServerName == "" {
+		st.tlsConfig.ServerName = sa.Host
+	}
+	return st
+}
+
+func (st *smtpTransport) Send(ctx context.Context, m *domain.MailMessage) error {
+	b, err := encodeMessage(m)
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("invalid from address: %w", err)
+	}
+
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, "tcp", st.addr)
+	if err != nil {
+		return fmt.Errorf("error while connecting to the smtp server: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, st.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error while connecting to the smtp server: %w", err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); !ok {
+		return ErrStartTLSNotSupported
+	}
+	if err := c.StartTLS(st.tlsConfig); err != nil {
+		return fmt.Errorf("error while starting tls: %w", err)
+	}
+	if st.auth != nil {
+		if err := c.Auth(st.auth); err != nil {
+			return fmt.Errorf("error while authenticating to the smtp server: %w", err)
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return fmt.Errorf("error while sending the sender: %w", err)
+	}
+	for _, to := range m.To {
+		a, err := mail.ParseAddress(to)
+		if err != nil {
+			return fmt.Errorf("invalid to address: %w", err)
+		}
+		if err := c.Rcpt(a.Address); err != nil {
+			return fmt.Errorf("error while sending the recipient: %w", err)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("error while sending the message: %w", err)
+	}
+	if _, err := w.Write(b); err != nil {
+		return fmt.Errorf("error while sending the message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error while sending the message: %w", err)
+	}
+
+	return c.Quit()
+}
@@ -0,0 +1,185 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// fakeSMTPServer accepts a single session and records what the client sent
+type fakeSMTPServer struct {
+	l        net.Listener
+	tls      *tls.Config
+	startTLS bool
+
+	done   chan struct{}
+	auth   string
+	from   string
+	rcpt   []string
+	data   string
+	wasTLS bool
+}
+
+func newFakeSMTPServer(t *testing.T, startTLS bool) (*fakeSMTPServer, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTPServer{
+		l:        l,
+		tls:      &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		startTLS: startTLS,
+		done:     make(chan struct{}),
+	}
+	go s.serve()
+	return s, pool
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.l.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) serve() {
+	defer close(s.done)
+	defer s.l.Close()
+
+	conn, err := s.l.Accept()
+	if err != nil {
+		return
+	}
+	defer func() { conn.Close() }()
+	tc := textproto.NewConn(conn)
+	tc.PrintfLine("220 localhost ESMTP")
+
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO":
+			if s.startTLS && !s.wasTLS {
+				tc.PrintfLine("250-localhost\r\n250-STARTTLS\r\n250 AUTH PLAIN")
+			} else {
+				tc.PrintfLine("250-localhost\r\n250 AUTH PLAIN")
+			}
+		case "STARTTLS":
+			tc.PrintfLine("220 go ahead")
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			tc = textproto.NewConn(conn)
+			s.wasTLS = true
+		case "AUTH":
+			parts := strings.Fields(line)
+			b, _ := base64.StdEncoding.DecodeString(parts[len(parts)-1])
+			s.auth = string(b)
+			tc.PrintfLine("235 ok")
+		case "MAIL":
+			s.from = line
+			tc.PrintfLine("250 ok")
+		case "RCPT":
+			s.rcpt = append(s.rcpt, line)
+			tc.PrintfLine("250 ok")
+		case "DATA":
+			tc.PrintfLine("354 go ahead")
+			b, _ := bufio.NewReader(tc.DotReader()).ReadString(0)
+			s.data = b
+			tc.PrintfLine("250 ok")
+		case "QUIT":
+			tc.PrintfLine("221 bye")
+			return
+		default:
+			tc.PrintfLine("502 unknown command")
+		}
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	s, pool := newFakeSMTPServer(t, true)
+
+	mt, err := NewTransport(SMTPKind, &SMTPArgs{
+		Host:      "127.0.0.1",
+		Port:      s.port(),
+		Username:  "minaria",
+		Password:  "secret",
+		TLSConfig: &tls.Config{RootCAs: pool},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = mt.Send(ctx, &domain.MailMessage{
+		From:    "Minaria <no-reply@minaria.io>",
+		To:      []string{"jack@gmail.com"},
+		Subject: "Hello",
+		Text:    "Hi jack,\n",
+	})
+	assert.Nil(t, err)
+	<-s.done
+
+	assert.True(t, s.wasTLS)
+	assert.Equal(t, "\x00minaria\x00secret", s.auth)
+	assert.Equal(t, "MAIL FROM:<no-reply@minaria.io>", strings.SplitN(s.from, " BODY", 2)[0])
+	assert.Equal(t, []string{"RCPT TO:<jack@gmail.com>"}, s.rcpt)
+	assert.Contains(t, s.data, "Subject: Hello\n")
+	assert.Contains(t, s.data, "Hi jack,")
+}
+
+func TestSMTPRequiresStartTLS(t *testing.T) {
+	s, _ := newFakeSMTPServer(t, false)
+
+	mt, _ := NewTransport(SMTPKind, &SMTPArgs{Host: "127.0.0.1", Port: s.port()})
+	err := mt.Send(context.TODO(), &domain.MailMessage{
+		From:    "no-reply@minaria.io",
+		To:      []string{"jack@gmail.com"},
+		Subject: "Hello",
+		Text:    "Hi jack,\n",
+	})
+	assert.Equal(t, ErrStartTLSNotSupported, err)
+	<-s.done
+
+	// nothing is sent over the plain connection
+	assert.Equal(t, "", s.auth+s.from+s.data)
+}
@@ -0,0 +1,150 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/pkg/errors"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// Template is the source of an email template, the html body is optional
+type Template struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// DefaultTemplates are the templates of the account flows, their data is domain.AccountMailData
+var DefaultTemplates = map[string]Template{
+	domain.PasswordResetTemplate: {
+		Subject: `Reset your password`,
+		Text: `Hi {{.Username}},
+
+Somebody asked to reset the password of your account. If it was you, follow the link below to choose a new password:
+
+{{.Link}}
+
+If it wasn't you, ignore this email, your password stays the same.
+`,
+		HTML: `<p>Hi {{.Username}},</p>
+<p>Somebody asked to reset the password of your account. If it was you, follow the link below to choose a new password:</p>
+<p><a href="{{.Link}}">Reset your password</a></p>
+<p>If it wasn't you, ignore this email, your password stays the same.</p>
+`,
+	},
+	domain.EmailVerificationTemplate: {
+		Subject: `Verify your email address`,
+		Text: `Hi {{.Username}},
+
+Follow the link below to verify your email address:
+
+{{.Link}}
+`,
+		HTML: `<p>Hi {{.Username}},</p>
+<p>Follow the link below to verify your email address:</p>
+<p><a href="{{.Link}}">Verify your email address</a></p>
+`,
+	},
+	domain.MagicLinkTemplate: {
+		Subject: `Log in to your account`,
+		Text: `Hi {{.Username}},
+
+Follow the link below to log in, it works once and expires soon:
+
+{{.Link}}
+
+If you didn't ask for it, ignore this email.
+`,
+		HTML: `<p>Hi {{.Username}},</p>
+<p>Follow the link below to log in, it works once and expires soon:</p>
+<p><a href="{{.Link}}">Log in</a></p>
+<p>If you didn't ask for it, ignore this email.</p>
+`,
+	},
+	domain.EmailChangedTemplate: {
+		Subject: `Your email address was changed`,
+		Text: `Hi {{.Username}},
+
+The email address of your account was changed to {{.Email}}, the emails of the account are sent there from now on.
+
+If it wasn't you, contact us right away, somebody else may have access to your account.
+`,
+		HTML: `<p>Hi {{.Username}},</p>
+<p>The email address of your account was changed to {{.Email}}, the emails of the account are sent there from now on.</p>
+<p>If it wasn't you, contact us right away, somebody else may have access to your account.</p>
+`,
+	},
+}
+
+type parsedTemplate struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+// Templates are the parsed email templates
+type Templates struct {
+	m map[string]*parsedTemplate
+}
+
+// ParseTemplates parses the templates, every template needs a subject and a text body
+func ParseTemplates(defs map[string]Template) (*Templates, error) {
+	t := &Templates{m: make(map[string]*parsedTemplate)}
+	for name, def := range defs {
+		if def.Subject == "" || def.Text == "" {
+			return nil, fmt.Errorf("the template %s needs a subject and a text body", name)
+		}
+
+		pt := &parsedTemplate{}
+		var err error
+		if pt.subject, err = texttemplate.New(name).Parse(def.Subject); err != nil {
+			return nil, fmt.Errorf("error while parsing the subject of %s: %w", name, err)
+		}
+		if pt.text, err = texttemplate.New(name).Parse(def.Text); err != nil {
+			return nil, fmt.Errorf("error while parsing the text body of %s: %w", name, err)
+		}
+		if def.HTML != "" {
+			if pt.html, err = htmltemplate.New(name).Parse(def.HTML); err != nil {
+				return nil, fmt.Errorf("error while parsing the html body of %s: %w", name, err)
+			}
+		}
+		t.m[name] = pt
+	}
+	return t, nil
+}
+
+// Render executes the template with the data, the html body is escaped
+func (t *Templates) Render(name string, data interface{}) (*domain.MailMessage, error) {
+	pt, ok := t.m[name]
+	if !ok {
+		return nil, errors.Wrap(domain.ErrUnknownMailTemplate, fmt.Sprintf("name: %s", name))
+	}
+
+	m := &domain.MailMessage{}
+	var b bytes.Buffer
+	if err := pt.subject.Execute(&b, data); err != nil {
+		return nil, fmt.Errorf("error while rendering the subject of %s: %w", name, err)
+	}
+	// a new line would end the header
+	m.Subject = strings.Join(strings.Fields(b.String()), " ")
+
+	b.Reset()
+	if err := pt.text.Execute(&b, data); err != nil {
+		return nil, fmt.Errorf("error while rendering the text body of %s: %w", name, err)
+	}
+	m.Text = b.String()
+
+	if pt.html != nil {
+		b.Reset()
+		if err := pt.html.Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("error while rendering the html body of %s: %w", name, err)
+		}
+		m.HTML = b.String()
+	}
+
+	return m, nil
+}
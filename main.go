@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/spf13/viper"
 )
@@ -12,14 +13,36 @@ func main() {
 	viper.SetEnvPrefix("MINARIA")
 	viper.AutomaticEnv()
 
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		if err := runKeysCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	server := NewServer()
 	server.Start()
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	signal.Notify(c, os.Kill)
+	signal.Notify(c, syscall.SIGHUP)
+	if backupSignal != nil {
+		signal.Notify(c, backupSignal)
+	}
 
-	sig := <-c
-	fmt.Println("got", sig)
+	for sig := range c {
+		if sig == syscall.SIGHUP {
+			server.ReloadKeys()
+			continue
+		}
+		if backupSignal != nil && sig == backupSignal {
+			server.Backup()
+			continue
+		}
+		fmt.Println("got", sig)
+		break
+	}
 	server.ShutDown()
 }
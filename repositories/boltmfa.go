@@ -0,0 +1,175 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/vahidmostofi/minaria/domain"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltTOTPBucket          = []byte("totp_credentials")
+	boltRecoveryCodesBucket = []byte("recovery_codes")
+)
+
+// createBoltBucket creates the bucket of a store if the database doesn't have it yet
+func createBoltBucket(db *bolt.DB, name []byte) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(name)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error while preparing the database: %w", err)
+	}
+	return nil
+}
+
+// boltTOTPRepository keeps the totp credentials by user ID in the database of
+// the bolt user repository
+type boltTOTPRepository struct {
+	db *bolt.DB
+}
+
+func newBoltTOTPRepository(db *bolt.DB) (*boltTOTPRepository, error) {
+	if err := createBoltBucket(db, boltTOTPBucket); err != nil {
+		return nil, err
+	}
+	return &boltTOTPRepository{db: db}, nil
+}
+
+func getBoltTOTP(tx *bolt.Tx, userID string) (*domain.TOTPCredential, error) {
+	v := tx.Bucket(boltTOTPBucket).Get([]byte(userID))
+	if v == nil {
+		return nil, ErrNoTOTPFound
+	}
+	c := &domain.TOTPCredential{}
+	if err := json.Unmarshal(v, c); err != nil {
+		return nil, fmt.Errorf("error while unmarshaling the totp credential: %w", err)
+	}
+	return c, nil
+}
+
+func putBoltTOTP(tx *bolt.Tx, c *domain.TOTPCredential) error {
+	v, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("error while marshaling the totp credential: %w", err)
+	}
+	return tx.Bucket(boltTOTPBucket).Put([]byte(c.UserID), v)
+}
+
+func (br *boltTOTPRepository) Store(ctx context.Context, c *domain.TOTPCredential) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return br.db.Update(func(tx *bolt.Tx) error {
+		return putBoltTOTP(tx, c)
+	})
+}
+
+func (br *boltTOTPRepository) GetByUserID(ctx context.Context, userID string) (*domain.TOTPCredential, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var c *domain.TOTPCredential
+	err := br.db.View(func(tx *bolt.Tx) error {
+		var err error
+		c, err = getBoltTOTP(tx, userID)
+		return err
+	})
+	return c, err
+}
+
+func (br *boltTOTPRepository) Confirm(ctx context.Context, userID string, step int64) error {
+	return br.update(ctx, userID, func(c *domain.TOTPCredential) error {
+		c.Confirmed = true
+		c.LastUsedStep = step
+		return nil
+	})
+}
+
+func (br *boltTOTPRepository) UseStep(ctx context.Context, userID string, step int64) error {
+	return br.update(ctx, userID, func(c *domain.TOTPCredential) error {
+		if step <= c.LastUsedStep {
+			return ErrTOTPStepUsed
+		}
+		c.LastUsedStep = step
+		return nil
+	})
+}
+
+// update changes the credential with set in one transaction
+func (br *boltTOTPRepository) update(ctx context.Context, userID string, set func(c *domain.TOTPCredential) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return br.db.Update(func(tx *bolt.Tx) error {
+		c, err := getBoltTOTP(tx, userID)
+		if err != nil {
+			return err
+		}
+		if err := set(c); err != nil {
+			return err
+		}
+		return putBoltTOTP(tx, c)
+	})
+}
+
+// boltRecoveryCodeRepository keeps the hashes of the unused recovery codes of
+// a user as one record in the database of the bolt user repository
+type boltRecoveryCodeRepository struct {
+	db *bolt.DB
+}
+
+func newBoltRecoveryCodeRepository(db *bolt.DB) (*boltRecoveryCodeRepository, error) {
+	if err := createBoltBucket(db, boltRecoveryCodesBucket); err != nil {
+		return nil, err
+	}
+	return &boltRecoveryCodeRepository{db: db}, nil
+}
+
+func (br *boltRecoveryCodeRepository) Replace(ctx context.Context, userID string, hashes []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	v, err := json.Marshal(hashes)
+	if err != nil {
+		return fmt.Errorf("error while marshaling the recovery codes: %w", err)
+	}
+	return br.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltRecoveryCodesBucket).Put([]byte(userID), v)
+	})
+}
+
+func (br *boltRecoveryCodeRepository) Consume(ctx context.Context, userID string, hash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return br.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltRecoveryCodesBucket)
+		v := b.Get([]byte(userID))
+		if v == nil {
+			return ErrNoRecoveryCodeFound
+		}
+		var hashes []string
+		if err := json.Unmarshal(v, &hashes); err != nil {
+			return fmt.Errorf("error while unmarshaling the recovery codes: %w", err)
+		}
+
+		for i, h := range hashes {
+			if h != hash {
+				continue
+			}
+			v, err := json.Marshal(append(hashes[:i], hashes[i+1:]...))
+			if err != nil {
+				return fmt.Errorf("error while marshaling the recovery codes: %w", err)
+			}
+			return b.Put([]byte(userID), v)
+		}
+		return ErrNoRecoveryCodeFound
+	})
+}
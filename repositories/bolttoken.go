@@ -0,0 +1,252 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/vahidmostofi/minaria/domain"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltRefreshTokensBucket = []byte("refresh_tokens")
+	boltOneTimeTokensBucket = []byte("one_time_tokens")
+)
+
+// boltRefreshTokenRepository keeps the refresh tokens by their hash in the
+// database of the bolt user repository, the revocations scan the bucket like
+// the in memory repository scans its map
+type boltRefreshTokenRepository struct {
+	db *bolt.DB
+}
+
+func newBoltRefreshTokenRepository(db *bolt.DB) (*boltRefreshTokenRepository, error) {
+	if err := createBoltBucket(db, boltRefreshTokensBucket); err != nil {
+		return nil, err
+	}
+	return &boltRefreshTokenRepository{db: db}, nil
+}
+
+func getBoltRefreshToken(tx *bolt.Tx, hash string) (*domain.RefreshToken, error) {
+	v := tx.Bucket(boltRefreshTokensBucket).Get([]byte(hash))
+	if v == nil {
+		return nil, ErrNoRefreshTokenFound
+	}
+	t := &domain.RefreshToken{}
+	if err := json.Unmarshal(v, t); err != nil {
+		return nil, fmt.Errorf("error while unmarshaling the refresh token: %w", err)
+	}
+	return t, nil
+}
+
+func putBoltRefreshToken(tx *bolt.Tx, t *domain.RefreshToken) error {
+	v, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("error while marshaling the refresh token: %w", err)
+	}
+	return tx.Bucket(boltRefreshTokensBucket).Put([]byte(t.Hash), v)
+}
+
+// matchingBoltRefreshTokens returns the tokens which match
+func matchingBoltRefreshTokens(tx *bolt.Tx, match func(t *domain.RefreshToken) bool) ([]*domain.RefreshToken, error) {
+	var res []*domain.RefreshToken
+	err := tx.Bucket(boltRefreshTokensBucket).ForEach(func(k, v []byte) error {
+		t := &domain.RefreshToken{}
+		if err := json.Unmarshal(v, t); err != nil {
+			return fmt.Errorf("error while unmarshaling the refresh token: %w", err)
+		}
+		if match(t) {
+			res = append(res, t)
+		}
+		return nil
+	})
+	return res, err
+}
+
+func (br *boltRefreshTokenRepository) Store(ctx context.Context, t *domain.RefreshToken) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return br.db.Update(func(tx *bolt.Tx) error {
+		// drop the expired tokens, they can't be exchanged anymore
+		expired, err := matchingBoltRefreshTokens(tx, func(t *domain.RefreshToken) bool { return now.After(t.ExpiresAt) })
+		if err != nil {
+			return err
+		}
+		for _, e := range expired {
+			if err := tx.Bucket(boltRefreshTokensBucket).Delete([]byte(e.Hash)); err != nil {
+				return err
+			}
+		}
+		return putBoltRefreshToken(tx, t)
+	})
+}
+
+func (br *boltRefreshTokenRepository) GetByHash(ctx context.Context, hash string) (*domain.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var t *domain.RefreshToken
+	err := br.db.View(func(tx *bolt.Tx) error {
+		var err error
+		t, err = getBoltRefreshToken(tx, hash)
+		return err
+	})
+	return t, err
+}
+
+func (br *boltRefreshTokenRepository) MarkUsed(ctx context.Context, hash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return br.db.Update(func(tx *bolt.Tx) error {
+		t, err := getBoltRefreshToken(tx, hash)
+		if err != nil {
+			return err
+		}
+		if t.Used {
+			return ErrRefreshTokenAlreadyUsed
+		}
+		t.Used = true
+		return putBoltRefreshToken(tx, t)
+	})
+}
+
+func (br *boltRefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	return br.revoke(ctx, func(t *domain.RefreshToken) bool { return t.FamilyID == familyID })
+}
+
+func (br *boltRefreshTokenRepository) RevokeUser(ctx context.Context, userID string) error {
+	return br.revoke(ctx, func(t *domain.RefreshToken) bool { return t.UserID == userID })
+}
+
+// revoke revokes the tokens which match in one transaction
+func (br *boltRefreshTokenRepository) revoke(ctx context.Context, match func(t *domain.RefreshToken) bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return br.db.Update(func(tx *bolt.Tx) error {
+		// the bucket can't change while it's iterated, the tokens are written after
+		revoked, err := matchingBoltRefreshTokens(tx, func(t *domain.RefreshToken) bool { return !t.Revoked && match(t) })
+		if err != nil {
+			return err
+		}
+		for _, t := range revoked {
+			t.Revoked = true
+			if err := putBoltRefreshToken(tx, t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// boltOneTimeTokenRepository keeps the one time tokens by their hash in the
+// database of the bolt user repository
+type boltOneTimeTokenRepository struct {
+	db *bolt.DB
+}
+
+func newBoltOneTimeTokenRepository(db *bolt.DB) (*boltOneTimeTokenRepository, error) {
+	if err := createBoltBucket(db, boltOneTimeTokensBucket); err != nil {
+		return nil, err
+	}
+	return &boltOneTimeTokenRepository{db: db}, nil
+}
+
+func (br *boltOneTimeTokenRepository) Store(ctx context.Context, t *domain.OneTimeToken) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	v, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("error while marshaling the one time token: %w", err)
+	}
+	now := time.Now()
+	return br.db.Update(func(tx *bolt.Tx) error {
+		// drop the expired tokens, they can't be consumed anymore
+		err := br.deleteWhere(tx, func(t *domain.OneTimeToken) bool { return now.After(t.ExpiresAt) })
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(boltOneTimeTokensBucket).Put([]byte(t.Hash), v)
+	})
+}
+
+func (br *boltOneTimeTokenRepository) Consume(ctx context.Context, hash, purpose string) (*domain.OneTimeToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var t *domain.OneTimeToken
+	err := br.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltOneTimeTokensBucket)
+		v := b.Get([]byte(hash))
+		if v == nil {
+			return ErrNoOneTimeTokenFound
+		}
+		t = &domain.OneTimeToken{}
+		if err := json.Unmarshal(v, t); err != nil {
+			return fmt.Errorf("error while unmarshaling the one time token: %w", err)
+		}
+		if t.Used || t.Purpose != purpose {
+			return ErrNoOneTimeTokenFound
+		}
+
+		t.Used = true
+		v, err := json.Marshal(t)
+		if err != nil {
+			return fmt.Errorf("error while marshaling the one time token: %w", err)
+		}
+		return b.Put([]byte(hash), v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (br *boltOneTimeTokenRepository) DeleteByUser(ctx context.Context, userID, purpose string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return br.db.Update(func(tx *bolt.Tx) error {
+		return br.deleteWhere(tx, func(t *domain.OneTimeToken) bool { return t.UserID == userID && t.Purpose == purpose })
+	})
+}
+
+// deleteWhere deletes the tokens which match
+func (br *boltOneTimeTokenRepository) deleteWhere(tx *bolt.Tx, match func(t *domain.OneTimeToken) bool) error {
+	b := tx.Bucket(boltOneTimeTokensBucket)
+	var deleted [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		t := &domain.OneTimeToken{}
+		if err := json.Unmarshal(v, t); err != nil {
+			return fmt.Errorf("error while unmarshaling the one time token: %w", err)
+		}
+		if match(t) {
+			deleted = append(deleted, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// the bucket can't change while it's iterated
+	for _, k := range deleted {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,409 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vahidmostofi/minaria/domain"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltUsersBucket           = []byte("users")
+	boltUsersByEmailBucket    = []byte("users_by_email")
+	boltUsersByUsernameBucket = []byte("users_by_username")
+)
+
+// boltUser is the record of a user, it's kept apart from domain.User so its
+// json can change without changing what's on the disk
+type boltUser struct {
+	ID                string    `json:"id"`
+	Username          string    `json:"username"`
+	Email             string    `json:"email"`
+	CanonicalUsername string    `json:"canonical_username"`
+	CanonicalEmail    string    `json:"canonical_email"`
+	Password          string    `json:"password"`
+	Verified          bool      `json:"verified"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+	Version           int64     `json:"version"`
+
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// boltUserRepository keeps the users in a bolt database, by ID with an index
+// bucket for the canonical emails and one for the canonical usernames. Every write is a
+// transaction which is synced to the disk before it returns, so a crash
+// leaves either the old or the new state.
+type boltUserRepository struct {
+	db *bolt.DB
+}
+
+func newBoltUserRepository(ba *BoltArgs) (*boltUserRepository, error) {
+	if err := os.MkdirAll(ba.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("error while creating the data directory: %w", err)
+	}
+
+	// the file is locked while it's open, another process waits for the timeout
+	db, err := bolt.Open(filepath.Join(ba.Dir, "users.db"), 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error while opening the database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{boltUsersBucket, boltUsersByEmailBucket, boltUsersByUsernameBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return canonicalizeBolt(tx, canonicalizer(ba.Canonicalizer))
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error while preparing the database: %w", err)
+	}
+
+	return &boltUserRepository{db: db}, nil
+}
+
+// canonicalizeBolt gives the users the canonical forms of canon and moves their
+// indexes to them, the forms change with the email local part policy and with
+// the canonicalization itself
+func canonicalizeBolt(tx *bolt.Tx, canon domain.Canonicalizer) error {
+	var users []*domain.User
+	err := tx.Bucket(boltUsersBucket).ForEach(func(k, v []byte) error {
+		u, err := unmarshalBoltUser(v)
+		if err != nil {
+			return err
+		}
+		users = append(users, u)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	changed, err := recanonicalize(users, canon)
+	if err != nil || len(changed) == 0 {
+		return err
+	}
+
+	byUsername := tx.Bucket(boltUsersByUsernameBucket)
+	byEmail := tx.Bucket(boltUsersByEmailBucket)
+	old := make(map[string]*domain.User, len(users))
+	for _, u := range users {
+		old[u.ID] = u
+	}
+	// the old keys first, so a user can take a form another one is leaving
+	for _, c := range changed {
+		u := old[c.ID]
+		if err := byUsername.Delete([]byte(u.CanonicalUsername)); err != nil {
+			return err
+		}
+		if err := byEmail.Delete([]byte(u.CanonicalEmail)); err != nil {
+			return err
+		}
+	}
+	for _, u := range changed {
+		if err := putBoltUser(tx, u); err != nil {
+			return err
+		}
+		if err := byUsername.Put([]byte(u.CanonicalUsername), []byte(u.ID)); err != nil {
+			return err
+		}
+		if err := byEmail.Put([]byte(u.CanonicalEmail), []byte(u.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boltDB returns the database of the user repository in the UserStoreArgs
+func boltDB(args interface{}) (*bolt.DB, error) {
+	if ua, ok := args.(*UserStoreArgs); ok {
+		if br, ok := ua.Users.(*boltUserRepository); ok {
+			return br.db, nil
+		}
+	}
+	return nil, fmt.Errorf("the bolt stores need the bolt user repository")
+}
+
+// Close closes the database and releases its lock
+func (br *boltUserRepository) Close() error {
+	return br.db.Close()
+}
+
+// Backup writes a consistent snapshot of the database to w without blocking
+// the writers, the snapshot is a bolt database which can replace users.db
+func (br *boltUserRepository) Backup(ctx context.Context, w io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return br.db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(w)
+		return err
+	})
+}
+
+// getBoltUser returns the user unless it's deleted
+func getBoltUser(tx *bolt.Tx, ID []byte) (*domain.User, error) {
+	v := tx.Bucket(boltUsersBucket).Get(ID)
+	if v == nil {
+		return nil, ErrNoUserFound
+	}
+	u, err := unmarshalBoltUser(v)
+	if err != nil {
+		return nil, err
+	}
+	if u.DeletedAt != nil {
+		return nil, ErrNoUserFound
+	}
+	return u, nil
+}
+
+func unmarshalBoltUser(v []byte) (*domain.User, error) {
+	bu := &boltUser{}
+	if err := json.Unmarshal(v, bu); err != nil {
+		return nil, fmt.Errorf("error while unmarshaling the user: %w", err)
+	}
+	return &domain.User{
+		ID:                bu.ID,
+		Username:          bu.Username,
+		Email:             bu.Email,
+		CanonicalUsername: bu.CanonicalUsername,
+		CanonicalEmail:    bu.CanonicalEmail,
+		Password:          bu.Password,
+		Verified:          bu.Verified,
+		CreatedAt:         bu.CreatedAt,
+		UpdatedAt:         bu.UpdatedAt,
+		Version:           bu.Version,
+		DeletedAt:         bu.DeletedAt,
+	}, nil
+}
+
+func putBoltUser(tx *bolt.Tx, u *domain.User) error {
+	v, err := json.Marshal(&boltUser{
+		ID:                u.ID,
+		Username:          u.Username,
+		Email:             u.Email,
+		CanonicalUsername: u.CanonicalUsername,
+		CanonicalEmail:    u.CanonicalEmail,
+		Password:          u.Password,
+		Verified:          u.Verified,
+		CreatedAt:         u.CreatedAt,
+		UpdatedAt:         u.UpdatedAt,
+		Version:           u.Version,
+		DeletedAt:         u.DeletedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("error while marshaling the user: %w", err)
+	}
+	return tx.Bucket(boltUsersBucket).Put([]byte(u.ID), v)
+}
+
+// getBy gets the user with the ID the index has for the key
+func (br *boltUserRepository) getBy(ctx context.Context, index []byte, key string) (*domain.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var u *domain.User
+	err := br.db.View(func(tx *bolt.Tx) error {
+		ID := []byte(key)
+		if index != nil {
+			if ID = tx.Bucket(index).Get([]byte(key)); ID == nil {
+				return ErrNoUserFound
+			}
+		}
+
+		var err error
+		u, err = getBoltUser(tx, ID)
+		return err
+	})
+	return u, err
+}
+
+func (br *boltUserRepository) GetByID(ctx context.Context, ID string) (*domain.User, error) {
+	return br.getBy(ctx, nil, ID)
+}
+
+func (br *boltUserRepository) GetByUsername(ctx context.Context, username string) (*domain.User, error) {
+	return br.getBy(ctx, boltUsersByUsernameBucket, username)
+}
+
+func (br *boltUserRepository) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
+	return br.getBy(ctx, boltUsersByEmailBucket, email)
+}
+
+func (br *boltUserRepository) List(ctx context.Context, after string, limit int) ([]*domain.User, error) {
+	if err := checkPage(after, limit); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	users := make([]*domain.User, 0, limit)
+	err := br.db.View(func(tx *bolt.Tx) error {
+		// the keys are sorted, Seek finds after or the one which would follow it
+		c := tx.Bucket(boltUsersBucket).Cursor()
+		k, v := c.Seek([]byte(after))
+		if k != nil && string(k) == after {
+			k, v = c.Next()
+		}
+		for ; k != nil && len(users) < limit; k, v = c.Next() {
+			u, err := unmarshalBoltUser(v)
+			if err != nil {
+				return err
+			}
+			if u.DeletedAt == nil {
+				users = append(users, u)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error while listing the users: %w", err)
+	}
+	return users, nil
+}
+
+func (br *boltUserRepository) Store(ctx context.Context, u *domain.User) (*domain.User, error) {
+	if _, err := uuid.Parse(u.ID); err == nil {
+		return nil, fmt.Errorf("can't store the the object already has an ID.")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	stored := *u
+	stored.ID = uuid.New().String()
+	stored.CreatedAt = time.Now()
+	stored.UpdatedAt = stored.CreatedAt
+	stored.Version = 1
+	stored.DeletedAt = nil
+
+	// the indexes are checked and written in the same transaction as the user
+	err := br.db.Update(func(tx *bolt.Tx) error {
+		byUsername := tx.Bucket(boltUsersByUsernameBucket)
+		byEmail := tx.Bucket(boltUsersByEmailBucket)
+		if byUsername.Get([]byte(stored.CanonicalUsername)) != nil {
+			return ErrUsernameNotUnique
+		}
+		if byEmail.Get([]byte(stored.CanonicalEmail)) != nil {
+			return ErrEmailNotUnique
+		}
+
+		if err := putBoltUser(tx, &stored); err != nil {
+			return err
+		}
+		if err := byUsername.Put([]byte(stored.CanonicalUsername), []byte(stored.ID)); err != nil {
+			return err
+		}
+		return byEmail.Put([]byte(stored.CanonicalEmail), []byte(stored.ID))
+	})
+	if err == ErrUsernameNotUnique || err == ErrEmailNotUnique {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("error while storing the user: %w", err)
+	}
+
+	*u = stored
+	return u, nil
+}
+
+func (br *boltUserRepository) Update(ctx context.Context, u *domain.User) (*domain.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var updated *domain.User
+	err := br.db.Update(func(tx *bolt.Tx) error {
+		stored, err := getBoltUser(tx, []byte(u.ID))
+		if err != nil {
+			return err
+		}
+		if stored.Version != u.Version {
+			return ErrConflict
+		}
+
+		byUsername := tx.Bucket(boltUsersByUsernameBucket)
+		byEmail := tx.Bucket(boltUsersByEmailBucket)
+		if ID := byUsername.Get([]byte(u.CanonicalUsername)); ID != nil && string(ID) != u.ID {
+			return ErrUsernameNotUnique
+		}
+		if ID := byEmail.Get([]byte(u.CanonicalEmail)); ID != nil && string(ID) != u.ID {
+			return ErrEmailNotUnique
+		}
+
+		// the indexes move with the username and the email
+		if err := byUsername.Delete([]byte(stored.CanonicalUsername)); err != nil {
+			return err
+		}
+		if err := byEmail.Delete([]byte(stored.CanonicalEmail)); err != nil {
+			return err
+		}
+		if stored.CanonicalEmail != u.CanonicalEmail {
+			stored.Verified = false
+		}
+		stored.Username = u.Username
+		stored.Email = u.Email
+		stored.CanonicalUsername = u.CanonicalUsername
+		stored.CanonicalEmail = u.CanonicalEmail
+		stored.UpdatedAt = time.Now()
+		stored.Version++
+		if err := putBoltUser(tx, stored); err != nil {
+			return err
+		}
+		if err := byUsername.Put([]byte(stored.CanonicalUsername), []byte(stored.ID)); err != nil {
+			return err
+		}
+		updated = stored
+		return byEmail.Put([]byte(stored.CanonicalEmail), []byte(stored.ID))
+	})
+	if err == ErrNoUserFound || err == ErrConflict || err == ErrUsernameNotUnique || err == ErrEmailNotUnique {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("error while updating the user: %w", err)
+	}
+	return updated, nil
+}
+
+func (br *boltUserRepository) UpdatePassword(ctx context.Context, ID string, password string) error {
+	return br.update(ctx, ID, func(u *domain.User) { u.Password = password })
+}
+
+func (br *boltUserRepository) MarkEmailVerified(ctx context.Context, ID string) error {
+	return br.update(ctx, ID, func(u *domain.User) { u.Verified = true })
+}
+
+func (br *boltUserRepository) Delete(ctx context.Context, ID string) error {
+	// the indexes keep pointing at the user, so its username and email stay taken
+	return br.update(ctx, ID, func(u *domain.User) {
+		now := time.Now()
+		u.DeletedAt = &now
+	})
+}
+
+// update changes the user with set and bumps its UpdatedAt and Version, set mustn't change the indexed fields
+func (br *boltUserRepository) update(ctx context.Context, ID string, set func(u *domain.User)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return br.db.Update(func(tx *bolt.Tx) error {
+		u, err := getBoltUser(tx, []byte(ID))
+		if err != nil {
+			return err
+		}
+		set(u)
+		u.UpdatedAt = time.Now()
+		u.Version++
+		return putBoltUser(tx, u)
+	})
+}
@@ -0,0 +1,161 @@
+package repositories
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+func TestBoltUserRepository(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minaria-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dataDir := filepath.Join(dir, "data")
+
+	ur, err := NewUserRepository(BoltKind, &BoltArgs{Dir: dataDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := ur.(*boltUserRepository)
+	ctx := context.TODO()
+
+	u, err := br.Store(ctx, testUser("", "jack", "jack@gmail.com", "hash"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.NotEmpty(t, u.ID)
+
+	_, err = br.Store(ctx, testUser(u.ID, "jill", "jill@gmail.com", ""))
+	assert.NotNil(t, err)
+	_, err = br.Store(ctx, testUser("", "jack", "another@gmail.com", ""))
+	assert.Equal(t, ErrUsernameNotUnique, err)
+	_, err = br.Store(ctx, testUser("", "another", "jack@gmail.com", ""))
+	assert.Equal(t, ErrEmailNotUnique, err)
+	// the failed stores didn't leave an index behind
+	_, err = br.GetByUsername(ctx, "another")
+	assert.Equal(t, ErrNoUserFound, err)
+
+	for _, get := range []func() (*domain.User, error){
+		func() (*domain.User, error) { return br.GetByID(ctx, u.ID) },
+		func() (*domain.User, error) { return br.GetByUsername(ctx, "jack") },
+		func() (*domain.User, error) { return br.GetByEmail(ctx, "jack@gmail.com") },
+	} {
+		got, err := get()
+		if assert.Nil(t, err) {
+			assert.Equal(t, u.ID, got.ID)
+			assert.Equal(t, "hash", got.Password)
+			assert.True(t, u.CreatedAt.Equal(got.CreatedAt))
+		}
+	}
+	_, err = br.GetByID(ctx, "no-such-id")
+	assert.Equal(t, ErrNoUserFound, err)
+	_, err = br.GetByEmail(ctx, "nobody@gmail.com")
+	assert.Equal(t, ErrNoUserFound, err)
+
+	assert.Nil(t, br.UpdatePassword(ctx, u.ID, "new hash"))
+	assert.Nil(t, br.MarkEmailVerified(ctx, u.ID))
+	assert.Equal(t, ErrNoUserFound, br.MarkEmailVerified(ctx, "no-such-id"))
+
+	// the returned users are copies
+	got, _ := br.GetByID(ctx, u.ID)
+	got.Password = "changed"
+	got, _ = br.GetByID(ctx, u.ID)
+	assert.Equal(t, "new hash", got.Password)
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = br.GetByID(cctx, u.ID)
+	assert.Equal(t, context.Canceled, err)
+
+	var backup bytes.Buffer
+	assert.Nil(t, br.Backup(ctx, &backup))
+	br.Store(ctx, testUser("", "jill", "jill@gmail.com", ""))
+	assert.Nil(t, br.Close())
+
+	// a reopened repository keeps the users
+	ur, err = NewUserRepository(BoltKind, &BoltArgs{Dir: dataDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	br = ur.(*boltUserRepository)
+	got, err = br.GetByEmail(ctx, "jack@gmail.com")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "new hash", got.Password)
+		assert.True(t, got.Verified)
+	}
+	_, err = br.GetByUsername(ctx, "jill")
+	assert.Nil(t, err)
+	assert.Nil(t, br.Close())
+
+	// the backup is a database of its own, from before jill registered
+	restoreDir := filepath.Join(dir, "restored")
+	os.MkdirAll(restoreDir, 0700)
+	if err := ioutil.WriteFile(filepath.Join(restoreDir, "users.db"), backup.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ur, err = NewUserRepository(BoltKind, &BoltArgs{Dir: restoreDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	br = ur.(*boltUserRepository)
+	defer br.Close()
+	got, err = br.GetByUsername(ctx, "jack")
+	if assert.Nil(t, err) {
+		assert.Equal(t, u.ID, got.ID)
+	}
+	_, err = br.GetByUsername(ctx, "jill")
+	assert.Equal(t, ErrNoUserFound, err)
+}
+
+func TestBoltUserRepositoryCanonicalizes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minaria-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	br, err := newBoltUserRepository(&BoltArgs{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.TODO()
+	u, err := br.Store(ctx, testUser("", "Jack", "Jack@Gmail.com", ""))
+	if !assert.Nil(t, err) {
+		return
+	}
+	br.Close()
+
+	// the users get the forms of a changed policy as the database opens
+	exact := &domain.Canonicalizer{EmailLocalPart: domain.EmailLocalPartExact}
+	br, err = newBoltUserRepository(&BoltArgs{Dir: dir, Canonicalizer: exact})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := br.GetByEmail(ctx, "Jack@gmail.com")
+	if assert.Nil(t, err) {
+		assert.Equal(t, u.ID, got.ID)
+		assert.Equal(t, "Jack@Gmail.com", got.Email)
+	}
+	_, err = br.GetByEmail(ctx, "jack@gmail.com")
+	assert.Equal(t, ErrNoUserFound, err)
+	got, err = br.GetByUsername(ctx, "jack")
+	if assert.Nil(t, err) {
+		assert.Equal(t, u.ID, got.ID)
+	}
+	_, err = br.Store(ctx, &domain.User{Username: "another", Email: "jack@gmail.com", CanonicalUsername: "another", CanonicalEmail: "jack@gmail.com"})
+	assert.Nil(t, err)
+	br.Close()
+
+	// which collide under the lowercased local parts
+	_, err = newBoltUserRepository(&BoltArgs{Dir: dir})
+	assert.True(t, errors.Is(err, ErrEmailNotUnique), err)
+}
@@ -0,0 +1,131 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/vahidmostofi/minaria/domain"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltWebAuthnBucket       = []byte("webauthn_credentials")
+	boltWebAuthnByUserBucket = []byte("webauthn_credentials_by_user")
+)
+
+// boltWebAuthnCredentialRepository keeps the webauthn credentials by their ID
+// in the database of the bolt user repository, the by user bucket has a
+// bucket of the credential IDs for every user
+type boltWebAuthnCredentialRepository struct {
+	db *bolt.DB
+}
+
+func newBoltWebAuthnCredentialRepository(db *bolt.DB) (*boltWebAuthnCredentialRepository, error) {
+	if err := createBoltBucket(db, boltWebAuthnBucket); err != nil {
+		return nil, err
+	}
+	if err := createBoltBucket(db, boltWebAuthnByUserBucket); err != nil {
+		return nil, err
+	}
+	return &boltWebAuthnCredentialRepository{db: db}, nil
+}
+
+func getBoltWebAuthnCredential(tx *bolt.Tx, ID []byte) (*domain.WebAuthnCredential, error) {
+	v := tx.Bucket(boltWebAuthnBucket).Get(ID)
+	if v == nil {
+		return nil, ErrNoWebAuthnCredentialFound
+	}
+	c := &domain.WebAuthnCredential{}
+	if err := json.Unmarshal(v, c); err != nil {
+		return nil, fmt.Errorf("error while unmarshaling the webauthn credential: %w", err)
+	}
+	return c, nil
+}
+
+func putBoltWebAuthnCredential(tx *bolt.Tx, c *domain.WebAuthnCredential) error {
+	v, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("error while marshaling the webauthn credential: %w", err)
+	}
+	return tx.Bucket(boltWebAuthnBucket).Put(c.ID, v)
+}
+
+func (br *boltWebAuthnCredentialRepository) Store(ctx context.Context, c *domain.WebAuthnCredential) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return br.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltWebAuthnBucket).Get(c.ID) != nil {
+			return ErrWebAuthnCredentialNotUnique
+		}
+		if err := putBoltWebAuthnCredential(tx, c); err != nil {
+			return err
+		}
+		ub, err := tx.Bucket(boltWebAuthnByUserBucket).CreateBucketIfNotExists([]byte(c.UserID))
+		if err != nil {
+			return err
+		}
+		return ub.Put(c.ID, []byte{})
+	})
+}
+
+func (br *boltWebAuthnCredentialRepository) GetByID(ctx context.Context, ID []byte) (*domain.WebAuthnCredential, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var c *domain.WebAuthnCredential
+	err := br.db.View(func(tx *bolt.Tx) error {
+		var err error
+		c, err = getBoltWebAuthnCredential(tx, ID)
+		return err
+	})
+	return c, err
+}
+
+func (br *boltWebAuthnCredentialRepository) GetByUserID(ctx context.Context, userID string) ([]*domain.WebAuthnCredential, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	res := make([]*domain.WebAuthnCredential, 0)
+	err := br.db.View(func(tx *bolt.Tx) error {
+		ub := tx.Bucket(boltWebAuthnByUserBucket).Bucket([]byte(userID))
+		if ub == nil {
+			return nil
+		}
+		return ub.ForEach(func(ID, _ []byte) error {
+			c, err := getBoltWebAuthnCredential(tx, ID)
+			if err != nil {
+				return err
+			}
+			res = append(res, c)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].CreatedAt.Before(res[j].CreatedAt) })
+	return res, nil
+}
+
+func (br *boltWebAuthnCredentialRepository) UpdateSignCount(ctx context.Context, ID []byte, signCount uint32) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return br.db.Update(func(tx *bolt.Tx) error {
+		c, err := getBoltWebAuthnCredential(tx, ID)
+		if err != nil {
+			return err
+		}
+		c.SignCount = signCount
+		c.LastUsedAt = time.Now()
+		return putBoltWebAuthnCredential(tx, c)
+	})
+}
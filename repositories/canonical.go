@@ -0,0 +1,59 @@
+package repositories
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// canonicalizer returns c, or domain.DefaultCanonicalizer without it
+func canonicalizer(c *domain.Canonicalizer) domain.Canonicalizer {
+	if c == nil {
+		return domain.DefaultCanonicalizer
+	}
+	return *c
+}
+
+// canonicalPlaceholder is the canonical username and email of a user while its
+// forms are changed, it's unique and neither a valid username nor an email address
+func canonicalPlaceholder(ID string) string {
+	return " " + ID
+}
+
+// recanonicalize returns copies of the users whose canonical forms differ from
+// the ones canon gives them, with the new forms. The usernames which canon
+// rejects keep their lowercased forms. Two users
+// with the same form, the deleted ones too, are an error which names both, the
+// store can't be opened until one of them is changed by hand.
+func recanonicalize(users []*domain.User, canon domain.Canonicalizer) ([]*domain.User, error) {
+	byUsername := make(map[string]string, len(users))
+	byEmail := make(map[string]string, len(users))
+	var changed []*domain.User
+	for _, u := range users {
+		username, err := canon.Username(u.Username)
+		if err != nil {
+			username = strings.ToLower(u.Username)
+		}
+		email, err := canon.Email(u.Email)
+		if err != nil {
+			email = strings.ToLower(u.Email)
+		}
+
+		if other, ok := byUsername[username]; ok {
+			return nil, fmt.Errorf("the users %s and %s have the same canonical username %q: %w", other, u.ID, username, ErrUsernameNotUnique)
+		}
+		if other, ok := byEmail[email]; ok {
+			return nil, fmt.Errorf("the users %s and %s have the same canonical email %q: %w", other, u.ID, email, ErrEmailNotUnique)
+		}
+		byUsername[username] = u.ID
+		byEmail[email] = u.ID
+
+		if username != u.CanonicalUsername || email != u.CanonicalEmail {
+			cp := *u
+			cp.CanonicalUsername, cp.CanonicalEmail = username, email
+			changed = append(changed, &cp)
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,206 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/vahidmostofi/minaria/common"
+)
+
+// fileRevocationStore is an inMemoryRevocationStore which logs every change
+// to a file before it applies it, so revocations survive restarts. The log
+// is appended to and compacted to a snapshot of the store once it has grown
+// to twice the entries the store has.
+type fileRevocationStore struct {
+	*inMemoryRevocationStore
+	path string
+
+	// the log, opened for appending, its size and the records in it
+	f       *os.File
+	size    int64
+	records int
+}
+
+// revocationRecord is one change in the log, or a snapshot of the whole
+// store which is the first record after a compaction. The files written
+// before the log are a single snapshot.
+type revocationRecord struct {
+	Revoked     map[string]time.Time `json:"revoked,omitempty"`
+	Generations map[string]int       `json:"generations,omitempty"`
+
+	JTI       string    `json:"jti,omitempty"`
+	ExpiresAt time.Time `json:"exp,omitempty"`
+
+	UserID     string `json:"user,omitempty"`
+	Generation int    `json:"gen,omitempty"`
+}
+
+// the log is compacted when it has this many records and twice the entries of the store
+var minRevocationRecordsToCompact = 1024
+
+func newFileRevocationStore(path string) (*fileRevocationStore, error) {
+	fs := &fileRevocationStore{inMemoryRevocationStore: newInMemoryRevocationStore(), path: path}
+
+	torn, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+	// a crash in the middle of an append leaves a partial record at the end,
+	// the change wasn't applied and the next append must not follow it
+	if torn {
+		if err := fs.compact(); err != nil {
+			return nil, err
+		}
+		return fs, nil
+	}
+
+	if err := fs.open(os.O_CREATE); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// open opens the log for appending, the caller must hold the lock
+func (fs *fileRevocationStore) open(flag int) error {
+	f, err := os.OpenFile(fs.path, os.O_WRONLY|os.O_APPEND|flag, 0600)
+	if err != nil {
+		return fmt.Errorf("error while opening the revocation store: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("error while opening the revocation store: %w", err)
+	}
+	if fs.f != nil {
+		fs.f.Close()
+	}
+	fs.f, fs.size = f, fi.Size()
+	return nil
+}
+
+// load replays the log, it reports whether the last record is incomplete
+func (fs *fileRevocationStore) load() (bool, error) {
+	f, err := os.Open(fs.path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("error while reading the revocation store: %w", err)
+	}
+	defer f.Close()
+
+	now := time.Now()
+	d := json.NewDecoder(f)
+	for {
+		rec := &revocationRecord{}
+		err := d.Decode(rec)
+		if err == io.EOF {
+			return false, nil
+		} else if err == io.ErrUnexpectedEOF {
+			return true, nil
+		} else if err != nil {
+			return false, fmt.Errorf("error while parsing the revocation store: %w", err)
+		}
+
+		for jti, exp := range rec.Revoked {
+			if now.Before(exp) {
+				fs.revoked[jti] = exp
+			}
+		}
+		for userID, g := range rec.Generations {
+			fs.generations[userID] = g
+		}
+		if rec.JTI != "" && now.Before(rec.ExpiresAt) {
+			fs.revoked[rec.JTI] = rec.ExpiresAt
+		}
+		if rec.UserID != "" {
+			fs.generations[rec.UserID] = rec.Generation
+		}
+		fs.records++
+	}
+}
+
+// Close closes the log
+func (fs *fileRevocationStore) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.f.Close()
+}
+
+func (fs *fileRevocationStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.append(&revocationRecord{JTI: jti, ExpiresAt: expiresAt}); err != nil {
+		return err
+	}
+	fs.revoke(jti, expiresAt)
+	fs.compactIfGrown()
+	return nil
+}
+
+func (fs *fileRevocationStore) BumpGeneration(ctx context.Context, userID string) (int, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	g := fs.generations[userID] + 1
+	if err := fs.append(&revocationRecord{UserID: userID, Generation: g}); err != nil {
+		return 0, err
+	}
+	fs.generations[userID] = g
+	fs.compactIfGrown()
+	return g, nil
+}
+
+// append writes the record to the log and syncs it, the caller must hold the lock
+func (fs *fileRevocationStore) append(rec *revocationRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("error while marshaling the revocation: %w", err)
+	}
+	b = append(b, '\n')
+
+	// a partial record would make the ones after it unreadable, it's cut off
+	_, err = fs.f.Write(b)
+	if err == nil {
+		err = fs.f.Sync()
+	}
+	if err != nil {
+		fs.f.Truncate(fs.size)
+		return fmt.Errorf("error while writing the revocation store: %w", err)
+	}
+	fs.size += int64(len(b))
+	fs.records++
+	return nil
+}
+
+// compactIfGrown compacts the log once most of its records are stale, the
+// change is already in the log so a failure only postpones the compaction to
+// the next change. The caller must hold the lock.
+func (fs *fileRevocationStore) compactIfGrown() {
+	if fs.records < minRevocationRecordsToCompact || fs.records < 2*(len(fs.revoked)+len(fs.generations)) {
+		return
+	}
+	fs.compact()
+}
+
+// compact replaces the log with a snapshot of the store, the caller must hold the lock
+func (fs *fileRevocationStore) compact() error {
+	b, err := json.Marshal(revocationRecord{Revoked: fs.revoked, Generations: fs.generations})
+	if err != nil {
+		return fmt.Errorf("error while marshaling the revocation store: %w", err)
+	}
+	if err := common.WriteFileAtomic(fs.path, append(b, '\n'), 0600); err != nil {
+		return fmt.Errorf("error while compacting the revocation store: %w", err)
+	}
+
+	if err := fs.open(0); err != nil {
+		return err
+	}
+	fs.records = 1
+	return nil
+}
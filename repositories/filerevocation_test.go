@@ -0,0 +1,117 @@
+package repositories
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileRevocationStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minaria-revocations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "revocations.json")
+
+	rs, err := NewRevocationStore(FileKind, &FileArgs{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.TODO()
+	assert.Nil(t, rs.Revoke(ctx, "revoked", time.Now().Add(time.Hour)))
+	assert.Nil(t, rs.Revoke(ctx, "expired", time.Now().Add(-time.Second)))
+	g, err := rs.BumpGeneration(ctx, "user-id")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, g)
+
+	// a restarted server sees the same revocations
+	rs, err = NewRevocationStore(FileKind, &FileArgs{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revoked, _ := rs.IsRevoked(ctx, "revoked")
+	assert.True(t, revoked)
+	revoked, _ = rs.IsRevoked(ctx, "expired")
+	assert.False(t, revoked)
+	revoked, _ = rs.IsRevoked(ctx, "unknown")
+	assert.False(t, revoked)
+
+	g, _ = rs.Generation(ctx, "user-id")
+	assert.Equal(t, 1, g)
+	g, _ = rs.Generation(ctx, "another-user-id")
+	assert.Equal(t, 0, g)
+}
+
+func TestFileRevocationStoreLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minaria-revocations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "revocations.json")
+	ctx := context.TODO()
+
+	// the files written before the log are a single snapshot
+	exp := time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano)
+	old := `{"revoked":{"old":"` + exp + `"},"generations":{"user-id":2}}`
+	if err := ioutil.WriteFile(path, []byte(old), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := newFileRevocationStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, _ := fs.IsRevoked(ctx, "old")
+	assert.True(t, revoked)
+	g, _ := fs.BumpGeneration(ctx, "user-id")
+	assert.Equal(t, 3, g)
+
+	// a crash in the middle of an append loses only that change
+	assert.Nil(t, fs.Revoke(ctx, "new", time.Now().Add(time.Hour)))
+	fs.Close()
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString(`{"jti":"torn","exp":"20`)
+	f.Close()
+	fs, err = newFileRevocationStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for jti, want := range map[string]bool{"old": true, "new": true, "torn": false} {
+		revoked, _ := fs.IsRevoked(ctx, jti)
+		assert.Equal(t, want, revoked, jti)
+	}
+	assert.Nil(t, fs.Revoke(ctx, "after", time.Now().Add(time.Hour)))
+	fs.Close()
+	fs, err = newFileRevocationStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, _ = fs.IsRevoked(ctx, "after")
+	assert.True(t, revoked)
+
+	// the stale records are compacted away
+	defer func(n int) { minRevocationRecordsToCompact = n }(minRevocationRecordsToCompact)
+	minRevocationRecordsToCompact = 8
+	for i := 0; i < 20; i++ {
+		fs.BumpGeneration(ctx, "user-id")
+	}
+	assert.Less(t, fs.records, 8)
+	fs.Close()
+	fs, err = newFileRevocationStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	g, _ = fs.Generation(ctx, "user-id")
+	assert.Equal(t, 23, g)
+	revoked, _ = fs.IsRevoked(ctx, "new")
+	assert.True(t, revoked)
+}
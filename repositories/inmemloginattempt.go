@@ -0,0 +1,75 @@
+package repositories
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// the expired entries are swept after this many failures, so the addresses
+// which never come back don't stay in memory
+const loginAttemptSweepEvery = 1024
+
+type loginAttemptEntry struct {
+	failures  domain.LoginFailures
+	expiresAt time.Time
+}
+
+type inMemoryLoginAttemptCounter struct {
+	mu    sync.Mutex
+	cache map[string]*loginAttemptEntry
+	fails int
+}
+
+func newInMemoryLoginAttemptCounter() *inMemoryLoginAttemptCounter {
+	return &inMemoryLoginAttemptCounter{cache: make(map[string]*loginAttemptEntry)}
+}
+
+func (im *inMemoryLoginAttemptCounter) Get(ctx context.Context, key string) (*domain.LoginFailures, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	e, ok := im.cache[key]
+	if !ok || time.Now().After(e.expiresAt) {
+		return &domain.LoginFailures{}, nil
+	}
+	f := e.failures
+	return &f, nil
+}
+
+func (im *inMemoryLoginAttemptCounter) Fail(ctx context.Context, key string, ttl time.Duration) (*domain.LoginFailures, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	now := time.Now()
+	im.fails++
+	if im.fails%loginAttemptSweepEvery == 0 {
+		for k, e := range im.cache {
+			if now.After(e.expiresAt) {
+				delete(im.cache, k)
+			}
+		}
+	}
+
+	e, ok := im.cache[key]
+	if !ok || now.After(e.expiresAt) {
+		e = &loginAttemptEntry{}
+		im.cache[key] = e
+	}
+	e.failures.Count++
+	e.failures.LastFailedAt = now
+	e.expiresAt = now.Add(ttl)
+
+	f := e.failures
+	return &f, nil
+}
+
+func (im *inMemoryLoginAttemptCounter) Reset(ctx context.Context, key string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	delete(im.cache, key)
+	return nil
+}
@@ -0,0 +1,61 @@
+package repositories
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+type inMemoryOneTimeTokenRepository struct {
+	mu    sync.Mutex
+	cache map[string]*domain.OneTimeToken
+}
+
+func newInMemoryOneTimeTokenRepository() *inMemoryOneTimeTokenRepository {
+	return &inMemoryOneTimeTokenRepository{cache: make(map[string]*domain.OneTimeToken)}
+}
+
+func (im *inMemoryOneTimeTokenRepository) Store(ctx context.Context, t *domain.OneTimeToken) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	// drop the expired tokens, they can't be consumed anymore
+	now := time.Now()
+	for h, c := range im.cache {
+		if now.After(c.ExpiresAt) {
+			delete(im.cache, h)
+		}
+	}
+
+	cp := *t
+	im.cache[t.Hash] = &cp
+	return nil
+}
+
+func (im *inMemoryOneTimeTokenRepository) Consume(ctx context.Context, hash, purpose string) (*domain.OneTimeToken, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	t, ok := im.cache[hash]
+	if !ok || t.Used || t.Purpose != purpose {
+		return nil, ErrNoOneTimeTokenFound
+	}
+	t.Used = true
+
+	cp := *t
+	return &cp, nil
+}
+
+func (im *inMemoryOneTimeTokenRepository) DeleteByUser(ctx context.Context, userID, purpose string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	for h, t := range im.cache {
+		if t.UserID == userID && t.Purpose == purpose {
+			delete(im.cache, h)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package repositories
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// the full buckets are swept after this many requests, a full bucket is
+// the same as no bucket
+const rateLimitSweepEvery = 1024
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	period time.Duration
+}
+
+type inMemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	takes   int
+}
+
+func newInMemoryRateLimitStore() *inMemoryRateLimitStore {
+	return &inMemoryRateLimitStore{buckets: make(map[string]*tokenBucket)}
+}
+
+func (im *inMemoryRateLimitStore) Take(ctx context.Context, key string, limit domain.RateLimit) (*domain.RateLimitResult, error) {
+	if limit.Burst <= 0 || limit.Period <= 0 {
+		return nil, ErrInvalidRateLimit
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	now := time.Now()
+	im.takes++
+	if im.takes%rateLimitSweepEvery == 0 {
+		for k, b := range im.buckets {
+			if now.Sub(b.last) >= b.period {
+				delete(im.buckets, k)
+			}
+		}
+	}
+
+	b, ok := im.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		im.buckets[key] = b
+	}
+	var res *domain.RateLimitResult
+	b.tokens, res = takeToken(b.tokens, now.Sub(b.last), limit)
+	b.last = now
+	b.period = limit.Period
+	return res, nil
+}
+
+// takeToken refills a bucket which had tokens elapsed ago and takes a token
+// from it, it returns the tokens left and the result
+func takeToken(tokens float64, elapsed time.Duration, limit domain.RateLimit) (float64, *domain.RateLimitResult) {
+	burst := float64(limit.Burst)
+	// the tokens per nanosecond
+	rate := burst / float64(limit.Period)
+	if elapsed > 0 {
+		tokens = math.Min(burst, tokens+float64(elapsed)*rate)
+	}
+
+	res := &domain.RateLimitResult{}
+	if tokens >= 1 {
+		tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration(math.Ceil((1 - tokens) / rate))
+	}
+	res.Remaining = int(tokens)
+	res.Reset = time.Duration(math.Ceil((burst - tokens) / rate))
+	return tokens, res
+}
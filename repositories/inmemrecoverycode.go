@@ -0,0 +1,39 @@
+package repositories
+
+import (
+	"context"
+	"sync"
+)
+
+type inMemoryRecoveryCodeRepository struct {
+	mu sync.Mutex
+	// user ID to the set of the unused code hashes
+	cache map[string]map[string]struct{}
+}
+
+func newInMemoryRecoveryCodeRepository() *inMemoryRecoveryCodeRepository {
+	return &inMemoryRecoveryCodeRepository{cache: make(map[string]map[string]struct{})}
+}
+
+func (im *inMemoryRecoveryCodeRepository) Replace(ctx context.Context, userID string, hashes []string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	set := make(map[string]struct{}, len(hashes))
+	for _, h := range hashes {
+		set[h] = struct{}{}
+	}
+	im.cache[userID] = set
+	return nil
+}
+
+func (im *inMemoryRecoveryCodeRepository) Consume(ctx context.Context, userID string, hash string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if _, ok := im.cache[userID][hash]; !ok {
+		return ErrNoRecoveryCodeFound
+	}
+	delete(im.cache[userID], hash)
+	return nil
+}
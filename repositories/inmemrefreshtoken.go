@@ -0,0 +1,86 @@
+package repositories
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+type inMemoryRefreshTokenRepository struct {
+	mu    sync.Mutex
+	cache map[string]*domain.RefreshToken
+}
+
+func newInMemoryRefreshTokenRepository() *inMemoryRefreshTokenRepository {
+	return &inMemoryRefreshTokenRepository{cache: make(map[string]*domain.RefreshToken)}
+}
+
+func (im *inMemoryRefreshTokenRepository) Store(ctx context.Context, t *domain.RefreshToken) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	// drop the expired tokens, they can't be exchanged anymore
+	now := time.Now()
+	for h, c := range im.cache {
+		if now.After(c.ExpiresAt) {
+			delete(im.cache, h)
+		}
+	}
+
+	cp := *t
+	im.cache[t.Hash] = &cp
+	return nil
+}
+
+func (im *inMemoryRefreshTokenRepository) GetByHash(ctx context.Context, hash string) (*domain.RefreshToken, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	t, ok := im.cache[hash]
+	if !ok {
+		return nil, ErrNoRefreshTokenFound
+	}
+	cp := *t
+	return &cp, nil
+}
+
+func (im *inMemoryRefreshTokenRepository) MarkUsed(ctx context.Context, hash string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	t, ok := im.cache[hash]
+	if !ok {
+		return ErrNoRefreshTokenFound
+	}
+	if t.Used {
+		return ErrRefreshTokenAlreadyUsed
+	}
+	t.Used = true
+	return nil
+}
+
+func (im *inMemoryRefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	for _, t := range im.cache {
+		if t.FamilyID == familyID {
+			t.Revoked = true
+		}
+	}
+	return nil
+}
+
+func (im *inMemoryRefreshTokenRepository) RevokeUser(ctx context.Context, userID string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	for _, t := range im.cache {
+		if t.UserID == userID {
+			t.Revoked = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package repositories
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type inMemoryRevocationStore struct {
+	mu          sync.RWMutex
+	revoked     map[string]time.Time
+	generations map[string]int
+}
+
+func newInMemoryRevocationStore() *inMemoryRevocationStore {
+	return &inMemoryRevocationStore{
+		revoked:     make(map[string]time.Time),
+		generations: make(map[string]int),
+	}
+}
+
+func (im *inMemoryRevocationStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.revoke(jti, expiresAt)
+	return nil
+}
+
+// revoke adds the jti and drops the expired ones, the caller must hold the lock
+func (im *inMemoryRevocationStore) revoke(jti string, expiresAt time.Time) {
+	now := time.Now()
+	for j, exp := range im.revoked {
+		if now.After(exp) {
+			delete(im.revoked, j)
+		}
+	}
+	im.revoked[jti] = expiresAt
+}
+
+func (im *inMemoryRevocationStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	_, ok := im.revoked[jti]
+	return ok, nil
+}
+
+func (im *inMemoryRevocationStore) Generation(ctx context.Context, userID string) (int, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	return im.generations[userID], nil
+}
+
+func (im *inMemoryRevocationStore) BumpGeneration(ctx context.Context, userID string) (int, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.generations[userID]++
+	return im.generations[userID], nil
+}
@@ -0,0 +1,66 @@
+package repositories
+
+import (
+	"context"
+	"sync"
+
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+type inMemoryTOTPRepository struct {
+	mu    sync.Mutex
+	cache map[string]*domain.TOTPCredential
+}
+
+func newInMemoryTOTPRepository() *inMemoryTOTPRepository {
+	return &inMemoryTOTPRepository{cache: make(map[string]*domain.TOTPCredential)}
+}
+
+func (im *inMemoryTOTPRepository) Store(ctx context.Context, c *domain.TOTPCredential) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	cp := *c
+	im.cache[c.UserID] = &cp
+	return nil
+}
+
+func (im *inMemoryTOTPRepository) GetByUserID(ctx context.Context, userID string) (*domain.TOTPCredential, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	c, ok := im.cache[userID]
+	if !ok {
+		return nil, ErrNoTOTPFound
+	}
+	cp := *c
+	return &cp, nil
+}
+
+func (im *inMemoryTOTPRepository) Confirm(ctx context.Context, userID string, step int64) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	c, ok := im.cache[userID]
+	if !ok {
+		return ErrNoTOTPFound
+	}
+	c.Confirmed = true
+	c.LastUsedStep = step
+	return nil
+}
+
+func (im *inMemoryTOTPRepository) UseStep(ctx context.Context, userID string, step int64) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	c, ok := im.cache[userID]
+	if !ok {
+		return ErrNoTOTPFound
+	}
+	if step <= c.LastUsedStep {
+		return ErrTOTPStepUsed
+	}
+	c.LastUsedStep = step
+	return nil
+}
@@ -2,108 +2,266 @@ package repositories
 
 import (
 	"context"
-	"crypto/md5"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/vahidmostofi/minaria/domain"
 )
 
+// inMemoryUserRepository keeps the users by ID with an index for the canonical
+// emails and one for the canonical usernames. The users are copied in and out, so the callers
+// can't change the stored ones without the lock.
 type inMemoryUserRepository struct {
-	cache []*domain.User
+	mu         sync.RWMutex
+	byID       map[string]*domain.User
+	byEmail    map[string]string
+	byUsername map[string]string
 }
 
-func newInMemoryUserRepository(ima *InMemoryArgs) *inMemoryUserRepository {
-	im := &inMemoryUserRepository{}
+func newInMemoryUserRepository(ima *InMemoryArgs) (*inMemoryUserRepository, error) {
+	im := &inMemoryUserRepository{
+		byID:       make(map[string]*domain.User),
+		byEmail:    make(map[string]string),
+		byUsername: make(map[string]string),
+	}
+
+	data := []*domain.User{{
+		ID:                "54215f2a-b752-11eb-8529-0242ac130003",
+		Username:          "jack",
+		Email:             "jack@gmail.com",
+		CanonicalUsername: "jack",
+		CanonicalEmail:    "jack@gmail.com",
+		Password:          "8bb0cf6eb9b17d0f7d22b456f121257dc1254e1f01665370476383ea776df414",
+	}, {
+		ID:                "5a823a9c-b752-11eb-8529-0242ac130003",
+		Username:          "john",
+		Email:             "john@gmail.com",
+		CanonicalUsername: "john",
+		CanonicalEmail:    "john@gmail.com",
+		Password:          "8bb0cf6eb9b17d0f7d22b456f121257dc1254e1f01665370476383ea776df414",
+	}, {
+		ID:                "601427c2-b752-11eb-8529-0242ac130003",
+		Username:          "jill",
+		Email:             "jill@gmail.com",
+		CanonicalUsername: "jill",
+		CanonicalEmail:    "jill@gmail.com",
+		Password:          "8bb0cf6eb9b17d0f7d22b456f121257dc1254e1f01665370476383ea776df414",
+	}}
+	canon := domain.DefaultCanonicalizer
 	if ima != nil {
-		im.cache = ima.Data
-	} else {
-		im.cache = []*domain.User{{
-			ID:       "54215f2a-b752-11eb-8529-0242ac130003",
-			Username: "jack",
-			Email:    "jack@gmail.com",
-			Password: "8bb0cf6eb9b17d0f7d22b456f121257dc1254e1f01665370476383ea776df414",
-		}, {
-			ID:       "5a823a9c-b752-11eb-8529-0242ac130003",
-			Username: "john",
-			Email:    "john@gmail.com",
-			Password: "8bb0cf6eb9b17d0f7d22b456f121257dc1254e1f01665370476383ea776df414",
-		}, {
-			ID:       "601427c2-b752-11eb-8529-0242ac130003",
-			Username: "jill",
-			Email:    "jill@gmail.com",
-			Password: "8bb0cf6eb9b17d0f7d22b456f121257dc1254e1f01665370476383ea776df414",
-		}}
-	}
-	return im
+		data = ima.Data
+		canon = canonicalizer(ima.Canonicalizer)
+	}
+	// like the persistent stores the seeds get the canonical forms of canon, the
+	// ones without them would all be indexed by an empty form
+	changed, err := recanonicalize(data, canon)
+	if err != nil {
+		return nil, err
+	}
+	canonical := make(map[string]*domain.User, len(changed))
+	for _, u := range changed {
+		canonical[u.ID] = u
+	}
+	for _, u := range data {
+		if c, ok := canonical[u.ID]; ok {
+			u = c
+		}
+		im.put(u)
+		// the seeds without a version get the first one, as if they were stored
+		if im.byID[u.ID].Version == 0 {
+			im.byID[u.ID].Version = 1
+		}
+	}
+	return im, nil
 }
 
-func (im *inMemoryUserRepository) GetByID(ctx context.Context, ID string) (*domain.User, error) {
-	for _, u := range im.cache {
-		if u.ID == ID {
-			return u, nil
-		}
+// put stores a copy of the user and indexes it, the caller must hold the lock
+func (im *inMemoryUserRepository) put(u *domain.User) {
+	c := *u
+	im.byID[c.ID] = &c
+	im.byEmail[c.CanonicalEmail] = c.ID
+	im.byUsername[c.CanonicalUsername] = c.ID
+}
+
+// live returns the stored user unless it's deleted, the caller must hold the lock
+func (im *inMemoryUserRepository) live(ID string) (*domain.User, bool) {
+	u, ok := im.byID[ID]
+	if !ok || u.DeletedAt != nil {
+		return nil, false
+	}
+	return u, true
+}
+
+// get returns a copy of the user, the caller must hold the lock
+func (im *inMemoryUserRepository) get(ID string) (*domain.User, error) {
+	u, ok := im.live(ID)
+	if !ok {
+		return nil, ErrNoUserFound
 	}
-	return nil, ErrNoUserFound
+	c := *u
+	return &c, nil
+}
+
+func (im *inMemoryUserRepository) GetByID(ctx context.Context, ID string) (*domain.User, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	return im.get(ID)
 }
 
 func (im *inMemoryUserRepository) GetByUsername(ctx context.Context, username string) (*domain.User, error) {
-	for _, u := range im.cache {
-		if u.Username == username {
-			return u, nil
-		}
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	ID, ok := im.byUsername[username]
+	if !ok {
+		return nil, ErrNoUserFound
 	}
-	return nil, ErrNoUserFound
+	return im.get(ID)
 }
+
 func (im *inMemoryUserRepository) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
-	for _, u := range im.cache {
-		if u.Email == email {
-			return u, nil
-		}
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	ID, ok := im.byEmail[email]
+	if !ok {
+		return nil, ErrNoUserFound
 	}
-	return nil, ErrNoUserFound
+	return im.get(ID)
 }
-func (im *inMemoryUserRepository) Store(ctx context.Context, u *domain.User) (*domain.User, error) {
-	if u, errU := im.GetByUsername(ctx, u.Username); u != nil && errU != ErrNoUserFound {
-		return nil, ErrUsernameNotUnique
+
+func (im *inMemoryUserRepository) List(ctx context.Context, after string, limit int) ([]*domain.User, error) {
+	if err := checkPage(after, limit); err != nil {
+		return nil, err
 	}
 
-	if u, errU := im.GetByEmail(ctx, u.Email); u != nil && errU != ErrNoUserFound {
-		return nil, ErrEmailNotUnique
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	IDs := make([]string, 0, len(im.byID))
+	for ID, u := range im.byID {
+		if ID > after && u.DeletedAt == nil {
+			IDs = append(IDs, ID)
+		}
 	}
+	sort.Strings(IDs)
+	if len(IDs) > limit {
+		IDs = IDs[:limit]
+	}
+
+	users := make([]*domain.User, len(IDs))
+	for i, ID := range IDs {
+		users[i], _ = im.get(ID)
+	}
+	return users, nil
+}
 
+func (im *inMemoryUserRepository) Store(ctx context.Context, u *domain.User) (*domain.User, error) {
 	if _, err := uuid.Parse(u.ID); err == nil {
 		return nil, fmt.Errorf("can't store the the object already has an ID.")
 	}
 
-	u.ID = uuid.New().String()
+	im.mu.Lock()
+	defer im.mu.Unlock()
 
-	im.cache = append(im.cache, u)
+	// the checks and the insert are under the same lock, so two registrations
+	// of the same email can't both pass the checks
+	if _, ok := im.byUsername[u.CanonicalUsername]; ok {
+		return nil, ErrUsernameNotUnique
+	}
+	if _, ok := im.byEmail[u.CanonicalEmail]; ok {
+		return nil, ErrEmailNotUnique
+	}
+
+	u.ID = uuid.New().String()
+	u.CreatedAt = time.Now()
+	u.UpdatedAt = u.CreatedAt
+	u.Version = 1
+	im.put(u)
 
 	return u, nil
 }
 
+// Update changes the username and the email of the user, the password and the
+// verification have their own methods
 func (im *inMemoryUserRepository) Update(ctx context.Context, u *domain.User) (*domain.User, error) {
-	if _, errU := im.GetByUsername(ctx, u.Username); errU != nil {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	stored, ok := im.live(u.ID)
+	if !ok {
+		return nil, ErrNoUserFound
+	}
+	if stored.Version != u.Version {
+		return nil, ErrConflict
+	}
+	if ID, ok := im.byUsername[u.CanonicalUsername]; ok && ID != u.ID {
 		return nil, ErrUsernameNotUnique
 	}
-
-	if _, errU := im.GetByEmail(ctx, u.Email); errU != nil {
+	if ID, ok := im.byEmail[u.CanonicalEmail]; ok && ID != u.ID {
 		return nil, ErrEmailNotUnique
 	}
 
-	for i, candidateUser := range im.cache {
-		if candidateUser.ID == u.ID {
-			im.cache[i].Username = u.Username
-			im.cache[i].Email = u.Email
-			if len(im.cache[i].Password) > 0 {
-				im.cache[i].Password = string(md5.New().Sum([]byte(im.cache[i].Password)))
-			}
-			im.cache[i].UpdatedAt = time.Now()
-			return im.cache[i], nil
-		}
+	delete(im.byUsername, stored.CanonicalUsername)
+	delete(im.byEmail, stored.CanonicalEmail)
+	updated := *stored
+	updated.Username = u.Username
+	updated.Email = u.Email
+	if updated.CanonicalEmail != u.CanonicalEmail {
+		updated.Verified = false
+	}
+	updated.CanonicalUsername = u.CanonicalUsername
+	updated.CanonicalEmail = u.CanonicalEmail
+	updated.UpdatedAt = time.Now()
+	updated.Version++
+	im.put(&updated)
+
+	return im.get(u.ID)
+}
+
+func (im *inMemoryUserRepository) UpdatePassword(ctx context.Context, ID string, password string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	u, ok := im.live(ID)
+	if !ok {
+		return ErrNoUserFound
 	}
+	u.Password = password
+	u.UpdatedAt = time.Now()
+	u.Version++
+	return nil
+}
+
+func (im *inMemoryUserRepository) MarkEmailVerified(ctx context.Context, ID string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
 
-	return nil, ErrNoUserFound
+	u, ok := im.live(ID)
+	if !ok {
+		return ErrNoUserFound
+	}
+	u.Verified = true
+	u.UpdatedAt = time.Now()
+	u.Version++
+	return nil
+}
+
+func (im *inMemoryUserRepository) Delete(ctx context.Context, ID string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	u, ok := im.live(ID)
+	if !ok {
+		return ErrNoUserFound
+	}
+	now := time.Now()
+	u.DeletedAt = &now
+	u.UpdatedAt = now
+	u.Version++
+	return nil
 }
@@ -0,0 +1,156 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+func TestInMemoryUserRepository(t *testing.T) {
+	seed := testUser("54215f2a-b752-11eb-8529-0242ac130003", "jack", "jack@gmail.com", "hash")
+	ur, _ := NewUserRepository(InMemoryKind, &InMemoryArgs{Data: []*domain.User{seed}})
+	ctx := context.TODO()
+
+	// the seed, the stored and the returned users are copies
+	seed.Password = "changed"
+	got, err := ur.GetByEmail(ctx, "jack@gmail.com")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "hash", got.Password)
+	got.Password = "changed"
+	got, _ = ur.GetByUsername(ctx, "jack")
+	assert.Equal(t, "hash", got.Password)
+
+	u := testUser("", "jill", "jill@gmail.com", "hash")
+	_, err = ur.Store(ctx, u)
+	assert.Nil(t, err)
+	u.Email = "changed@gmail.com"
+	got, err = ur.GetByID(ctx, u.ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, "jill@gmail.com", got.Email)
+	}
+
+	_, err = ur.Store(ctx, testUser("", "jack", "another@gmail.com", ""))
+	assert.Equal(t, ErrUsernameNotUnique, err)
+	_, err = ur.Store(ctx, testUser("", "another", "jack@gmail.com", ""))
+	assert.Equal(t, ErrEmailNotUnique, err)
+
+	// the update moves the indexes
+	im := ur.(*inMemoryUserRepository)
+	changed := testUser(u.ID, "jack", "jill@gmail.com", "")
+	changed.Version = u.Version
+	_, err = im.Update(ctx, changed)
+	assert.Equal(t, ErrUsernameNotUnique, err)
+	changed = testUser(u.ID, "jillian", "jillian@gmail.com", "")
+	changed.Version = u.Version
+	_, err = im.Update(ctx, changed)
+	assert.Nil(t, err)
+	_, err = ur.GetByEmail(ctx, "jill@gmail.com")
+	assert.Equal(t, ErrNoUserFound, err)
+	got, err = ur.GetByUsername(ctx, "jillian")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "hash", got.Password)
+	}
+
+	// without the args it has the demo users
+	ur, _ = NewUserRepository(InMemoryKind, nil)
+	_, err = ur.GetByEmail(ctx, "john@gmail.com")
+	assert.Nil(t, err)
+}
+
+func TestInMemoryUserRepositoryCanonicalizesSeeds(t *testing.T) {
+	ctx := context.TODO()
+
+	// the seeds without the canonical forms get them instead of all sharing the empty one
+	ur, err := NewUserRepository(InMemoryKind, &InMemoryArgs{Data: []*domain.User{
+		{ID: "54215f2a-b752-11eb-8529-0242ac130003", Username: "Jack", Email: "Jack@Gmail.com"},
+		{ID: "5a823a9c-b752-11eb-8529-0242ac130003", Username: "john", Email: "john@gmail.com"},
+	}})
+	if !assert.Nil(t, err) {
+		return
+	}
+	got, err := ur.GetByUsername(ctx, "jack")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "54215f2a-b752-11eb-8529-0242ac130003", got.ID)
+		assert.Equal(t, "jack@gmail.com", got.CanonicalEmail)
+	}
+	got, err = ur.GetByEmail(ctx, "john@gmail.com")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "john", got.Username)
+	}
+
+	exact := &domain.Canonicalizer{EmailLocalPart: domain.EmailLocalPartExact}
+	ur, err = NewUserRepository(InMemoryKind, &InMemoryArgs{Canonicalizer: exact, Data: []*domain.User{
+		{ID: "54215f2a-b752-11eb-8529-0242ac130003", Username: "Jack", Email: "Jack@Gmail.com"},
+	}})
+	if assert.Nil(t, err) {
+		_, err = ur.GetByEmail(ctx, "Jack@gmail.com")
+		assert.Nil(t, err)
+	}
+
+	// the seeds which collide are rejected
+	_, err = NewUserRepository(InMemoryKind, &InMemoryArgs{Data: []*domain.User{
+		{ID: "54215f2a-b752-11eb-8529-0242ac130003", Username: "jack", Email: "jack@gmail.com"},
+		{ID: "5a823a9c-b752-11eb-8529-0242ac130003", Username: "JACK", Email: "another@gmail.com"},
+	}})
+	assert.True(t, errors.Is(err, ErrUsernameNotUnique), err)
+}
+
+// run with -race
+func TestInMemoryUserRepositoryConcurrentRegistrations(t *testing.T) {
+	ur, _ := NewUserRepository(InMemoryKind, &InMemoryArgs{})
+	ctx := context.TODO()
+
+	const n = 50
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		stored  int
+		ids     = make(map[string]bool)
+		unknown []error
+	)
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+
+			// everyone registers the same email, and reads while the others write
+			u, err := ur.Store(ctx, testUser("", fmt.Sprintf("user%d", i), "same@gmail.com", ""))
+			ur.GetByEmail(ctx, "same@gmail.com")
+			ur.GetByUsername(ctx, fmt.Sprintf("user%d", i))
+			if err == nil {
+				ur.UpdatePassword(ctx, u.ID, "hash")
+				ur.MarkEmailVerified(ctx, u.ID)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch err {
+			case nil:
+				stored++
+				ids[u.ID] = true
+			case ErrEmailNotUnique:
+			default:
+				unknown = append(unknown, err)
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	assert.Equal(t, 1, stored)
+	assert.Len(t, unknown, 0)
+	got, err := ur.GetByEmail(ctx, "same@gmail.com")
+	if assert.Nil(t, err) {
+		assert.True(t, ids[got.ID])
+		assert.True(t, got.Verified)
+	}
+}
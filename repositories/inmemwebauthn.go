@@ -0,0 +1,78 @@
+package repositories
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+type inMemoryWebAuthnCredentialRepository struct {
+	mu sync.Mutex
+	// keyed by the string of the credential id
+	cache map[string]*domain.WebAuthnCredential
+}
+
+func newInMemoryWebAuthnCredentialRepository() *inMemoryWebAuthnCredentialRepository {
+	return &inMemoryWebAuthnCredentialRepository{cache: make(map[string]*domain.WebAuthnCredential)}
+}
+
+func copyWebAuthnCredential(c *domain.WebAuthnCredential) *domain.WebAuthnCredential {
+	cp := *c
+	cp.ID = append([]byte{}, c.ID...)
+	cp.PublicKey = append([]byte{}, c.PublicKey...)
+	cp.AAGUID = append([]byte{}, c.AAGUID...)
+	cp.Transports = append([]string{}, c.Transports...)
+	return &cp
+}
+
+func (im *inMemoryWebAuthnCredentialRepository) Store(ctx context.Context, c *domain.WebAuthnCredential) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if _, ok := im.cache[string(c.ID)]; ok {
+		return ErrWebAuthnCredentialNotUnique
+	}
+	im.cache[string(c.ID)] = copyWebAuthnCredential(c)
+	return nil
+}
+
+func (im *inMemoryWebAuthnCredentialRepository) GetByID(ctx context.Context, ID []byte) (*domain.WebAuthnCredential, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	c, ok := im.cache[string(ID)]
+	if !ok {
+		return nil, ErrNoWebAuthnCredentialFound
+	}
+	return copyWebAuthnCredential(c), nil
+}
+
+func (im *inMemoryWebAuthnCredentialRepository) GetByUserID(ctx context.Context, userID string) ([]*domain.WebAuthnCredential, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	res := make([]*domain.WebAuthnCredential, 0)
+	for _, c := range im.cache {
+		if c.UserID == userID {
+			res = append(res, copyWebAuthnCredential(c))
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].CreatedAt.Before(res[j].CreatedAt) })
+	return res, nil
+}
+
+func (im *inMemoryWebAuthnCredentialRepository) UpdateSignCount(ctx context.Context, ID []byte, signCount uint32) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	c, ok := im.cache[string(ID)]
+	if !ok {
+		return ErrNoWebAuthnCredentialFound
+	}
+	c.SignCount = signCount
+	c.LastUsedAt = time.Now()
+	return nil
+}
@@ -0,0 +1,18 @@
+package repositories
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+func NewLoginAttemptCounter(kind string, args interface{}) (domain.LoginAttemptCounter, error) {
+
+	switch kind {
+	case InMemoryKind:
+		return newInMemoryLoginAttemptCounter(), nil
+	}
+
+	return nil, errors.Wrap(ErrUnknownRepository, fmt.Sprintf("kind: %s", kind))
+}
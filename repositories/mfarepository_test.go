@@ -0,0 +1,141 @@
+package repositories
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// TestMFARepositoryConformance runs the contracts of the totp and the recovery
+// code repositories against every kind, next to a user repository of the kind
+func TestMFARepositoryConformance(t *testing.T) {
+	for kind, newRepository := range userRepositoryBackends() {
+		kind, newRepository := kind, newRepository
+		t.Run(kind, func(t *testing.T) {
+			ur := newRepository(t)
+			var args interface{}
+			if kind != InMemoryKind {
+				args = &UserStoreArgs{Users: ur}
+			}
+			totpr, err := NewTOTPRepository(kind, args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rcr, err := NewRecoveryCodeRepository(kind, args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			jack, err := ur.Store(context.TODO(), testUser("", "jack", "jack@gmail.com", "hash"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Run("TOTP", func(t *testing.T) { testTOTPRepository(t, totpr, jack.ID) })
+			t.Run("RecoveryCodes", func(t *testing.T) { testRecoveryCodeRepository(t, rcr, jack.ID) })
+		})
+	}
+
+	// the persistent kinds need the user repository of the same kind
+	im, _ := newInMemoryUserRepository(&InMemoryArgs{})
+	_, err := NewTOTPRepository(SQLiteKind, &UserStoreArgs{Users: im})
+	assert.NotNil(t, err)
+	_, err = NewRecoveryCodeRepository(BoltKind, nil)
+	assert.NotNil(t, err)
+}
+
+func testTOTPRepository(t *testing.T, totpr domain.TOTPRepository, userID string) {
+	ctx := context.TODO()
+
+	_, err := totpr.GetByUserID(ctx, userID)
+	assert.Equal(t, ErrNoTOTPFound, err)
+	assert.Equal(t, ErrNoTOTPFound, totpr.Confirm(ctx, userID, 1))
+	assert.Equal(t, ErrNoTOTPFound, totpr.UseStep(ctx, userID, 1))
+
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	assert.Nil(t, totpr.Store(ctx, &domain.TOTPCredential{UserID: userID, Secret: "first", CreatedAt: createdAt}))
+	// enrolling again replaces the secret
+	assert.Nil(t, totpr.Store(ctx, &domain.TOTPCredential{UserID: userID, Secret: "second", CreatedAt: createdAt}))
+	c, err := totpr.GetByUserID(ctx, userID)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "second", c.Secret)
+	assert.False(t, c.Confirmed)
+	assert.True(t, createdAt.Equal(c.CreatedAt), c.CreatedAt)
+
+	assert.Nil(t, totpr.Confirm(ctx, userID, 100))
+	c, _ = totpr.GetByUserID(ctx, userID)
+	assert.True(t, c.Confirmed)
+	assert.Equal(t, int64(100), c.LastUsedStep)
+
+	// a step can't be used twice, nor an earlier one
+	assert.Equal(t, ErrTOTPStepUsed, totpr.UseStep(ctx, userID, 100))
+	assert.Nil(t, totpr.UseStep(ctx, userID, 101))
+	assert.Equal(t, ErrTOTPStepUsed, totpr.UseStep(ctx, userID, 99))
+	c, _ = totpr.GetByUserID(ctx, userID)
+	assert.Equal(t, int64(101), c.LastUsedStep)
+}
+
+func testRecoveryCodeRepository(t *testing.T, rcr domain.RecoveryCodeRepository, userID string) {
+	ctx := context.TODO()
+
+	assert.Equal(t, ErrNoRecoveryCodeFound, rcr.Consume(ctx, userID, "a"))
+
+	assert.Nil(t, rcr.Replace(ctx, userID, []string{"a", "b"}))
+	assert.Nil(t, rcr.Consume(ctx, userID, "a"))
+	assert.Equal(t, ErrNoRecoveryCodeFound, rcr.Consume(ctx, userID, "a"))
+
+	// the new codes replace the unused ones
+	assert.Nil(t, rcr.Replace(ctx, userID, []string{"c"}))
+	assert.Equal(t, ErrNoRecoveryCodeFound, rcr.Consume(ctx, userID, "b"))
+	assert.Nil(t, rcr.Consume(ctx, userID, "c"))
+}
+
+// the whole point of the persistent kinds, a restarted server still asks for the second factor
+func TestMFASurvivesRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minaria-mfa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.TODO()
+
+	kinds := map[string]interface{}{
+		SQLiteKind: &SQLiteArgs{Path: filepath.Join(dir, "minaria.db")},
+		BoltKind:   &BoltArgs{Dir: dir},
+	}
+	for kind, urArgs := range kinds {
+		ur, err := NewUserRepository(kind, urArgs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jack, _ := ur.Store(ctx, testUser("", "jack", "jack@gmail.com", "hash"))
+		totpr, _ := NewTOTPRepository(kind, &UserStoreArgs{Users: ur})
+		rcr, _ := NewRecoveryCodeRepository(kind, &UserStoreArgs{Users: ur})
+		assert.Nil(t, totpr.Store(ctx, &domain.TOTPCredential{UserID: jack.ID, Secret: "secret", CreatedAt: time.Now()}))
+		assert.Nil(t, totpr.Confirm(ctx, jack.ID, 100))
+		assert.Nil(t, rcr.Replace(ctx, jack.ID, []string{"a"}))
+		ur.(io.Closer).Close()
+
+		ur, err = NewUserRepository(kind, urArgs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		totpr, _ = NewTOTPRepository(kind, &UserStoreArgs{Users: ur})
+		rcr, _ = NewRecoveryCodeRepository(kind, &UserStoreArgs{Users: ur})
+		c, err := totpr.GetByUserID(ctx, jack.ID)
+		if assert.Nil(t, err, kind) {
+			assert.True(t, c.Confirmed, kind)
+		}
+		assert.Equal(t, ErrTOTPStepUsed, totpr.UseStep(ctx, jack.ID, 100), kind)
+		assert.Nil(t, rcr.Consume(ctx, jack.ID, "a"), kind)
+		ur.(io.Closer).Close()
+	}
+}
@@ -0,0 +1,41 @@
+package repositories
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// ErrNoOneTimeTokenFound ...
+var ErrNoOneTimeTokenFound = fmt.Errorf("no unused one time token found")
+
+// NewOneTimeTokenRepository returns the repository of the kind, the persistent
+// kinds take UserStoreArgs and keep the tokens next to the users
+func NewOneTimeTokenRepository(kind string, args interface{}) (domain.OneTimeTokenRepository, error) {
+
+	switch kind {
+	case InMemoryKind:
+		return newInMemoryOneTimeTokenRepository(), nil
+	case PostgresKind:
+		db, err := postgresDB(args)
+		if err != nil {
+			return nil, err
+		}
+		return &postgresOneTimeTokenRepository{db: db}, nil
+	case SQLiteKind:
+		db, err := sqliteDB(args)
+		if err != nil {
+			return nil, err
+		}
+		return &sqliteOneTimeTokenRepository{db: db}, nil
+	case BoltKind:
+		db, err := boltDB(args)
+		if err != nil {
+			return nil, err
+		}
+		return newBoltOneTimeTokenRepository(db)
+	}
+
+	return nil, errors.Wrap(ErrUnknownRepository, fmt.Sprintf("kind: %s", kind))
+}
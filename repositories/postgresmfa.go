@@ -0,0 +1,133 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// postgresTOTPRepository keeps the totp credentials in the database of the
+// postgres user repository
+type postgresTOTPRepository struct {
+	db *sql.DB
+}
+
+func (pr *postgresTOTPRepository) Store(ctx context.Context, c *domain.TOTPCredential) error {
+	if _, err := uuid.Parse(c.UserID); err != nil {
+		return ErrNoUserFound
+	}
+
+	_, err := pr.db.ExecContext(ctx, `INSERT INTO totp_credentials (user_id, secret, confirmed, last_used_step, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, confirmed = EXCLUDED.confirmed,
+			last_used_step = EXCLUDED.last_used_step, created_at = EXCLUDED.created_at`,
+		c.UserID, c.Secret, c.Confirmed, c.LastUsedStep, c.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error while storing the totp credential: %w", err)
+	}
+	return nil
+}
+
+func (pr *postgresTOTPRepository) GetByUserID(ctx context.Context, userID string) (*domain.TOTPCredential, error) {
+	// the column is a uuid, anything else can't match and would be a syntax error
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrNoTOTPFound
+	}
+
+	c := &domain.TOTPCredential{}
+	err := pr.db.QueryRowContext(ctx, `SELECT user_id, secret, confirmed, last_used_step, created_at
+		FROM totp_credentials WHERE user_id = $1`, userID).Scan(&c.UserID, &c.Secret, &c.Confirmed, &c.LastUsedStep, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoTOTPFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error while getting the totp credential: %w", err)
+	}
+	return c, nil
+}
+
+func (pr *postgresTOTPRepository) Confirm(ctx context.Context, userID string, step int64) error {
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrNoTOTPFound
+	}
+
+	res, err := pr.db.ExecContext(ctx, `UPDATE totp_credentials SET confirmed = true, last_used_step = $2 WHERE user_id = $1`, userID, step)
+	if err != nil {
+		return fmt.Errorf("error while confirming the totp credential: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("error while confirming the totp credential: %w", err)
+	} else if n == 0 {
+		return ErrNoTOTPFound
+	}
+	return nil
+}
+
+func (pr *postgresTOTPRepository) UseStep(ctx context.Context, userID string, step int64) error {
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrNoTOTPFound
+	}
+
+	// the comparison and the update are one statement, so two logins can't use the same step
+	res, err := pr.db.ExecContext(ctx, `UPDATE totp_credentials SET last_used_step = $2 WHERE user_id = $1 AND last_used_step < $2`, userID, step)
+	if err != nil {
+		return fmt.Errorf("error while using the totp step: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("error while using the totp step: %w", err)
+	} else if n == 1 {
+		return nil
+	}
+
+	if _, err := pr.GetByUserID(ctx, userID); err != nil {
+		return err
+	}
+	return ErrTOTPStepUsed
+}
+
+// postgresRecoveryCodeRepository keeps the hashes of the recovery codes in
+// the database of the postgres user repository
+type postgresRecoveryCodeRepository struct {
+	db *sql.DB
+}
+
+func (pr *postgresRecoveryCodeRepository) Replace(ctx context.Context, userID string, hashes []string) error {
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrNoUserFound
+	}
+
+	tx, err := pr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error while replacing the recovery codes: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("error while replacing the recovery codes: %w", err)
+	}
+	for _, h := range hashes {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, hash) VALUES ($1, $2) ON CONFLICT DO NOTHING`, userID, h); err != nil {
+			return fmt.Errorf("error while replacing the recovery codes: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (pr *postgresRecoveryCodeRepository) Consume(ctx context.Context, userID string, hash string) error {
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrNoRecoveryCodeFound
+	}
+
+	res, err := pr.db.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1 AND hash = $2`, userID, hash)
+	if err != nil {
+		return fmt.Errorf("error while consuming the recovery code: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("error while consuming the recovery code: %w", err)
+	} else if n == 0 {
+		return ErrNoRecoveryCodeFound
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// postgresMigrations are applied in order, the version of a migration is its
+// index plus one. The applied ones must never change, add a new one instead.
+var postgresMigrations = []string{
+	// 1: the users
+	`CREATE TABLE users (
+		id         uuid PRIMARY KEY,
+		username   text NOT NULL,
+		email      text NOT NULL,
+		password   text NOT NULL,
+		verified   boolean NOT NULL DEFAULT false,
+		created_at timestamptz NOT NULL,
+		updated_at timestamptz NOT NULL,
+		CONSTRAINT users_username_key UNIQUE (username),
+		CONSTRAINT users_email_key UNIQUE (email)
+	)`,
+	// 2: the soft deletes
+	`ALTER TABLE users ADD COLUMN deleted_at timestamptz`,
+	// 3: the canonical forms are the unique ones, the existing users get
+	// placeholders which canonicalizePostgres replaces in the same transaction
+	`ALTER TABLE users ADD COLUMN canonical_username text, ADD COLUMN canonical_email text;
+	UPDATE users SET canonical_username = ' ' || id, canonical_email = ' ' || id;
+	ALTER TABLE users
+		ALTER COLUMN canonical_username SET NOT NULL,
+		ALTER COLUMN canonical_email SET NOT NULL,
+		DROP CONSTRAINT users_username_key,
+		DROP CONSTRAINT users_email_key,
+		ADD CONSTRAINT users_canonical_username_key UNIQUE (canonical_username),
+		ADD CONSTRAINT users_canonical_email_key UNIQUE (canonical_email)`,
+	// 4: the versions of the optimistic concurrency control
+	`ALTER TABLE users ADD COLUMN version bigint NOT NULL DEFAULT 1`,
+	// 5: the second factors
+	`CREATE TABLE totp_credentials (
+		user_id        uuid PRIMARY KEY REFERENCES users (id),
+		secret         text NOT NULL,
+		confirmed      boolean NOT NULL DEFAULT false,
+		last_used_step bigint NOT NULL DEFAULT 0,
+		created_at     timestamptz NOT NULL
+	);
+	CREATE TABLE recovery_codes (
+		user_id uuid NOT NULL REFERENCES users (id),
+		hash    text NOT NULL,
+		PRIMARY KEY (user_id, hash)
+	)`,
+	// 6: the webauthn credentials
+	`CREATE TABLE webauthn_credentials (
+		id           bytea PRIMARY KEY,
+		user_id      uuid NOT NULL REFERENCES users (id),
+		public_key   bytea NOT NULL,
+		sign_count   bigint NOT NULL DEFAULT 0,
+		aaguid       bytea NOT NULL,
+		transports   text NOT NULL,
+		created_at   timestamptz NOT NULL,
+		last_used_at timestamptz NOT NULL
+	);
+	CREATE INDEX webauthn_credentials_user_id_idx ON webauthn_credentials (user_id)`,
+	// 7: the rate limits shared by the replicas
+	`CREATE TABLE rate_limit_buckets (
+		key        text PRIMARY KEY,
+		tokens     double precision NOT NULL,
+		updated_at timestamptz NOT NULL,
+		full_at    timestamptz NOT NULL
+	);
+	CREATE INDEX rate_limit_buckets_full_at_idx ON rate_limit_buckets (full_at)`,
+	// 8: the refresh tokens and the one time tokens, the webauthn challenges of
+	// the discoverable logins have no user
+	`CREATE TABLE refresh_tokens (
+		hash       text PRIMARY KEY,
+		family_id  text NOT NULL,
+		user_id    text NOT NULL,
+		username   text NOT NULL,
+		used       boolean NOT NULL DEFAULT false,
+		revoked    boolean NOT NULL DEFAULT false,
+		expires_at timestamptz NOT NULL,
+		created_at timestamptz NOT NULL
+	);
+	CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+	CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+	CREATE INDEX refresh_tokens_expires_at_idx ON refresh_tokens (expires_at);
+	CREATE TABLE one_time_tokens (
+		hash       text PRIMARY KEY,
+		user_id    text NOT NULL,
+		purpose    text NOT NULL,
+		used       boolean NOT NULL DEFAULT false,
+		expires_at timestamptz NOT NULL,
+		created_at timestamptz NOT NULL
+	);
+	CREATE INDEX one_time_tokens_user_id_idx ON one_time_tokens (user_id, purpose);
+	CREATE INDEX one_time_tokens_expires_at_idx ON one_time_tokens (expires_at)`,
+	// 9: the revoked jwt tokens and the token generations shared by the replicas
+	`CREATE TABLE revoked_tokens (
+		jti        text PRIMARY KEY,
+		expires_at timestamptz NOT NULL
+	);
+	CREATE INDEX revoked_tokens_expires_at_idx ON revoked_tokens (expires_at);
+	CREATE TABLE token_generations (
+		user_id    text PRIMARY KEY,
+		generation integer NOT NULL
+	)`,
+}
+
+// postgresMigrationLock is the key of the advisory lock which keeps the
+// replicas starting together from migrating at the same time
+const postgresMigrationLock = 7261634867
+
+// migratePostgres applies the migrations the database doesn't have yet and
+// gives the users the canonical forms of canon, in one transaction
+func migratePostgres(ctx context.Context, db *sql.DB, canon domain.Canonicalizer) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error while starting the migration: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, postgresMigrationLock); err != nil {
+		return fmt.Errorf("error while locking the migrations: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    integer PRIMARY KEY,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`); err != nil {
+		return fmt.Errorf("error while creating the migrations table: %w", err)
+	}
+
+	var version int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return fmt.Errorf("error while getting the schema version: %w", err)
+	}
+	if version > len(postgresMigrations) {
+		return fmt.Errorf("the schema version %d is newer than this build knows, %d", version, len(postgresMigrations))
+	}
+
+	for i := version; i < len(postgresMigrations); i++ {
+		if _, err := tx.ExecContext(ctx, postgresMigrations[i]); err != nil {
+			return fmt.Errorf("error while applying the migration %d: %w", i+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, i+1); err != nil {
+			return fmt.Errorf("error while recording the migration %d: %w", i+1, err)
+		}
+	}
+
+	if err := canonicalizePostgres(ctx, tx, canon); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// canonicalizePostgres gives the users the canonical forms of canon, the forms
+// change with the email local part policy and with the canonicalization itself
+func canonicalizePostgres(ctx context.Context, tx *sql.Tx, canon domain.Canonicalizer) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, username, email, canonical_username, canonical_email FROM users`)
+	if err != nil {
+		return fmt.Errorf("error while reading the canonical forms: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*domain.User
+	for rows.Next() {
+		u := &domain.User{}
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.CanonicalUsername, &u.CanonicalEmail); err != nil {
+			return fmt.Errorf("error while reading the canonical forms: %w", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error while reading the canonical forms: %w", err)
+	}
+	rows.Close()
+
+	changed, err := recanonicalize(users, canon)
+	if err != nil {
+		return err
+	}
+	// the placeholders first, so a user can take a form another one is leaving
+	for _, u := range changed {
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET canonical_username = $2, canonical_email = $2 WHERE id = $1`,
+			u.ID, canonicalPlaceholder(u.ID)); err != nil {
+			return fmt.Errorf("error while canonicalizing the user %s: %w", u.ID, err)
+		}
+	}
+	for _, u := range changed {
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET canonical_username = $2, canonical_email = $3 WHERE id = $1`,
+			u.ID, u.CanonicalUsername, u.CanonicalEmail); err != nil {
+			return fmt.Errorf("error while canonicalizing the user %s: %w", u.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// postgresRateLimitStore keeps the token buckets in the database of the
+// postgres user repository. The row of a bucket is locked while a token is
+// taken, so the refill and the take are one step for all the replicas, and
+// the clock of the database is the one every replica uses.
+type postgresRateLimitStore struct {
+	db    *sql.DB
+	takes int64
+}
+
+func (pr *postgresRateLimitStore) Take(ctx context.Context, key string, limit domain.RateLimit) (*domain.RateLimitResult, error) {
+	if limit.Burst <= 0 || limit.Period <= 0 {
+		return nil, ErrInvalidRateLimit
+	}
+
+	// a full bucket is the same as no bucket
+	if atomic.AddInt64(&pr.takes, 1)%rateLimitSweepEvery == 0 {
+		if _, err := pr.db.ExecContext(ctx, `DELETE FROM rate_limit_buckets WHERE full_at < clock_timestamp()`); err != nil {
+			return nil, fmt.Errorf("error while sweeping the rate limits: %w", err)
+		}
+	}
+
+	tx, err := pr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while taking the rate limit token: %w", err)
+	}
+	defer tx.Rollback()
+
+	// a new bucket starts full, the no-op update of an existing one locks its
+	// row until the commit, either way in one statement
+	var (
+		tokens    float64
+		last, now time.Time
+	)
+	err = tx.QueryRowContext(ctx, `INSERT INTO rate_limit_buckets AS b (key, tokens, updated_at, full_at)
+		VALUES ($1, $2, clock_timestamp(), clock_timestamp())
+		ON CONFLICT (key) DO UPDATE SET tokens = b.tokens
+		RETURNING b.tokens, b.updated_at, clock_timestamp()`, key, float64(limit.Burst)).Scan(&tokens, &last, &now)
+	if err != nil {
+		return nil, fmt.Errorf("error while taking the rate limit token: %w", err)
+	}
+
+	tokens, res := takeToken(tokens, now.Sub(last), limit)
+	if _, err := tx.ExecContext(ctx, `UPDATE rate_limit_buckets SET tokens = $2, updated_at = $3, full_at = $4 WHERE key = $1`,
+		key, tokens, now, now.Add(res.Reset)); err != nil {
+		return nil, fmt.Errorf("error while taking the rate limit token: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error while taking the rate limit token: %w", err)
+	}
+	return res, nil
+}
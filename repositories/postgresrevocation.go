@@ -0,0 +1,62 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// postgresRevocationStore keeps the revoked tokens and the token generations
+// in the database of the postgres user repository, so a logout on one replica
+// is seen by all of them
+type postgresRevocationStore struct {
+	db *sql.DB
+}
+
+func (pr *postgresRevocationStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	// drop the expired ones, the tokens are refused by their expiry anyway
+	if _, err := pr.db.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at < now()`); err != nil {
+		return fmt.Errorf("error while deleting the expired revocations: %w", err)
+	}
+
+	_, err := pr.db.ExecContext(ctx, `INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2)
+		ON CONFLICT (jti) DO UPDATE SET expires_at = EXCLUDED.expires_at`, jti, expiresAt)
+	if err != nil {
+		return fmt.Errorf("error while revoking the token: %w", err)
+	}
+	return nil
+}
+
+func (pr *postgresRevocationStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	err := pr.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1 AND expires_at >= now())`, jti).
+		Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("error while checking the revocation: %w", err)
+	}
+	return revoked, nil
+}
+
+func (pr *postgresRevocationStore) Generation(ctx context.Context, userID string) (int, error) {
+	var g int
+	err := pr.db.QueryRowContext(ctx, `SELECT generation FROM token_generations WHERE user_id = $1`, userID).Scan(&g)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("error while getting the token generation: %w", err)
+	}
+	return g, nil
+}
+
+func (pr *postgresRevocationStore) BumpGeneration(ctx context.Context, userID string) (int, error) {
+	// the read and the increment are one statement, so no bump is lost
+	var g int
+	err := pr.db.QueryRowContext(ctx, `INSERT INTO token_generations AS g (user_id, generation) VALUES ($1, 1)
+		ON CONFLICT (user_id) DO UPDATE SET generation = g.generation + 1
+		RETURNING g.generation`, userID).Scan(&g)
+	if err != nil {
+		return 0, fmt.Errorf("error while bumping the token generation: %w", err)
+	}
+	return g, nil
+}
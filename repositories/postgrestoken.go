@@ -0,0 +1,124 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// postgresRefreshTokenRepository keeps the refresh tokens in the database of
+// the postgres user repository, so every replica can rotate the tokens of the
+// others and a reused token revokes its family for all of them
+type postgresRefreshTokenRepository struct {
+	db *sql.DB
+}
+
+func (pr *postgresRefreshTokenRepository) Store(ctx context.Context, t *domain.RefreshToken) error {
+	// drop the expired tokens, they can't be exchanged anymore
+	if _, err := pr.db.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE expires_at < now()`); err != nil {
+		return fmt.Errorf("error while deleting the expired refresh tokens: %w", err)
+	}
+
+	_, err := pr.db.ExecContext(ctx, `INSERT INTO refresh_tokens (hash, family_id, user_id, username, used, revoked, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (hash) DO UPDATE SET family_id = EXCLUDED.family_id, user_id = EXCLUDED.user_id,
+			username = EXCLUDED.username, used = EXCLUDED.used, revoked = EXCLUDED.revoked,
+			expires_at = EXCLUDED.expires_at, created_at = EXCLUDED.created_at`,
+		t.Hash, t.FamilyID, t.UserID, t.Username, t.Used, t.Revoked, t.ExpiresAt, t.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error while storing the refresh token: %w", err)
+	}
+	return nil
+}
+
+func (pr *postgresRefreshTokenRepository) GetByHash(ctx context.Context, hash string) (*domain.RefreshToken, error) {
+	t := &domain.RefreshToken{}
+	err := pr.db.QueryRowContext(ctx, `SELECT hash, family_id, user_id, username, used, revoked, expires_at, created_at
+		FROM refresh_tokens WHERE hash = $1`, hash).
+		Scan(&t.Hash, &t.FamilyID, &t.UserID, &t.Username, &t.Used, &t.Revoked, &t.ExpiresAt, &t.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoRefreshTokenFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error while getting the refresh token: %w", err)
+	}
+	return t, nil
+}
+
+func (pr *postgresRefreshTokenRepository) MarkUsed(ctx context.Context, hash string) error {
+	// the check and the update are one statement, so only one rotation of a token succeeds
+	res, err := pr.db.ExecContext(ctx, `UPDATE refresh_tokens SET used = true WHERE hash = $1 AND NOT used`, hash)
+	if err != nil {
+		return fmt.Errorf("error while marking the refresh token used: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("error while marking the refresh token used: %w", err)
+	} else if n == 1 {
+		return nil
+	}
+
+	if _, err := pr.GetByHash(ctx, hash); err != nil {
+		return err
+	}
+	return ErrRefreshTokenAlreadyUsed
+}
+
+func (pr *postgresRefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	if _, err := pr.db.ExecContext(ctx, `UPDATE refresh_tokens SET revoked = true WHERE family_id = $1`, familyID); err != nil {
+		return fmt.Errorf("error while revoking the refresh token family: %w", err)
+	}
+	return nil
+}
+
+func (pr *postgresRefreshTokenRepository) RevokeUser(ctx context.Context, userID string) error {
+	if _, err := pr.db.ExecContext(ctx, `UPDATE refresh_tokens SET revoked = true WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("error while revoking the refresh tokens of the user: %w", err)
+	}
+	return nil
+}
+
+// postgresOneTimeTokenRepository keeps the one time tokens in the database of
+// the postgres user repository, so a token can be consumed on any replica
+type postgresOneTimeTokenRepository struct {
+	db *sql.DB
+}
+
+func (pr *postgresOneTimeTokenRepository) Store(ctx context.Context, t *domain.OneTimeToken) error {
+	// drop the expired tokens, they can't be consumed anymore
+	if _, err := pr.db.ExecContext(ctx, `DELETE FROM one_time_tokens WHERE expires_at < now()`); err != nil {
+		return fmt.Errorf("error while deleting the expired one time tokens: %w", err)
+	}
+
+	_, err := pr.db.ExecContext(ctx, `INSERT INTO one_time_tokens (hash, user_id, purpose, used, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (hash) DO UPDATE SET user_id = EXCLUDED.user_id, purpose = EXCLUDED.purpose,
+			used = EXCLUDED.used, expires_at = EXCLUDED.expires_at, created_at = EXCLUDED.created_at`,
+		t.Hash, t.UserID, t.Purpose, t.Used, t.ExpiresAt, t.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error while storing the one time token: %w", err)
+	}
+	return nil
+}
+
+func (pr *postgresOneTimeTokenRepository) Consume(ctx context.Context, hash, purpose string) (*domain.OneTimeToken, error) {
+	// the check and the update are one statement, so a token is consumed once
+	t := &domain.OneTimeToken{}
+	err := pr.db.QueryRowContext(ctx, `UPDATE one_time_tokens SET used = true
+		WHERE hash = $1 AND purpose = $2 AND NOT used
+		RETURNING hash, user_id, purpose, used, expires_at, created_at`, hash, purpose).
+		Scan(&t.Hash, &t.UserID, &t.Purpose, &t.Used, &t.ExpiresAt, &t.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoOneTimeTokenFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error while consuming the one time token: %w", err)
+	}
+	return t, nil
+}
+
+func (pr *postgresOneTimeTokenRepository) DeleteByUser(ctx context.Context, userID, purpose string) error {
+	if _, err := pr.db.ExecContext(ctx, `DELETE FROM one_time_tokens WHERE user_id = $1 AND purpose = $2`, userID, purpose); err != nil {
+		return fmt.Errorf("error while deleting the one time tokens: %w", err)
+	}
+	return nil
+}
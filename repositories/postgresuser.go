@@ -0,0 +1,244 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+type postgresUserRepository struct {
+	db *sql.DB
+}
+
+func newPostgresUserRepository(pa *PostgresArgs) (*postgresUserRepository, error) {
+	db, err := sql.Open("postgres", pa.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("error while opening the database: %w", err)
+	}
+
+	maxOpen := pa.MaxOpenConns
+	if maxOpen <= 0 {
+		maxOpen = 10
+	}
+	maxIdle := pa.MaxIdleConns
+	if maxIdle <= 0 || maxIdle > maxOpen {
+		maxIdle = maxOpen
+	}
+	lifetime := pa.ConnMaxLifetime
+	if lifetime <= 0 {
+		lifetime = 30 * time.Minute
+	}
+	db.SetMaxOpenConns(maxOpen)
+	db.SetMaxIdleConns(maxIdle)
+	db.SetConnMaxLifetime(lifetime)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error while connecting to the database: %w", err)
+	}
+	if err := migratePostgres(ctx, db, canonicalizer(pa.Canonicalizer)); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &postgresUserRepository{db: db}, nil
+}
+
+// postgresDB returns the database of the user repository in the UserStoreArgs
+func postgresDB(args interface{}) (*sql.DB, error) {
+	if ua, ok := args.(*UserStoreArgs); ok {
+		if pr, ok := ua.Users.(*postgresUserRepository); ok {
+			return pr.db, nil
+		}
+	}
+	return nil, fmt.Errorf("the postgres stores need the postgres user repository")
+}
+
+// Close closes the connections of the pool
+func (pr *postgresUserRepository) Close() error {
+	return pr.db.Close()
+}
+
+const postgresUserColumns = `id, username, email, canonical_username, canonical_email, password, verified, created_at, updated_at, version, deleted_at`
+
+// postgresRow is a *sql.Row or *sql.Rows
+type postgresRow interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPostgresUser(row postgresRow) (*domain.User, error) {
+	u := &domain.User{}
+	var deletedAt sql.NullTime
+	if err := row.Scan(&u.ID, &u.Username, &u.Email, &u.CanonicalUsername, &u.CanonicalEmail, &u.Password, &u.Verified, &u.CreatedAt, &u.UpdatedAt, &u.Version, &deletedAt); err != nil {
+		return nil, err
+	}
+	if deletedAt.Valid {
+		u.DeletedAt = &deletedAt.Time
+	}
+	return u, nil
+}
+
+func (pr *postgresUserRepository) getBy(ctx context.Context, column, value string) (*domain.User, error) {
+	row := pr.db.QueryRowContext(ctx, `SELECT `+postgresUserColumns+` FROM users WHERE `+column+` = $1 AND deleted_at IS NULL`, value)
+	u, err := scanPostgresUser(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoUserFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error while getting the user: %w", err)
+	}
+	return u, nil
+}
+
+func (pr *postgresUserRepository) GetByID(ctx context.Context, ID string) (*domain.User, error) {
+	// the column is a uuid, anything else can't match and would be a syntax error
+	if _, err := uuid.Parse(ID); err != nil {
+		return nil, ErrNoUserFound
+	}
+	return pr.getBy(ctx, "id", ID)
+}
+
+func (pr *postgresUserRepository) GetByUsername(ctx context.Context, username string) (*domain.User, error) {
+	return pr.getBy(ctx, "canonical_username", username)
+}
+
+func (pr *postgresUserRepository) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
+	return pr.getBy(ctx, "canonical_email", email)
+}
+
+func (pr *postgresUserRepository) List(ctx context.Context, after string, limit int) ([]*domain.User, error) {
+	if err := checkPage(after, limit); err != nil {
+		return nil, err
+	}
+	if after == "" {
+		after = uuid.Nil.String()
+	}
+
+	rows, err := pr.db.QueryContext(ctx, `SELECT `+postgresUserColumns+` FROM users
+		WHERE id > $1 AND deleted_at IS NULL ORDER BY id LIMIT $2`, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error while listing the users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]*domain.User, 0, limit)
+	for rows.Next() {
+		u, err := scanPostgresUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error while listing the users: %w", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error while listing the users: %w", err)
+	}
+	return users, nil
+}
+
+func (pr *postgresUserRepository) Store(ctx context.Context, u *domain.User) (*domain.User, error) {
+	if _, err := uuid.Parse(u.ID); err == nil {
+		return nil, fmt.Errorf("can't store the the object already has an ID.")
+	}
+
+	stored := *u
+	stored.ID = uuid.New().String()
+	// postgres keeps microseconds, the returned user matches the one read back
+	stored.CreatedAt = time.Now().Truncate(time.Microsecond)
+	stored.UpdatedAt = stored.CreatedAt
+	stored.Version = 1
+	stored.DeletedAt = nil
+
+	_, err := pr.db.ExecContext(ctx, `INSERT INTO users (`+postgresUserColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULL)`,
+		stored.ID, stored.Username, stored.Email, stored.CanonicalUsername, stored.CanonicalEmail, stored.Password, stored.Verified, stored.CreatedAt, stored.UpdatedAt, stored.Version)
+	if uerr := postgresUniqueError(err); uerr != nil {
+		return nil, uerr
+	} else if err != nil {
+		return nil, fmt.Errorf("error while storing the user: %w", err)
+	}
+
+	*u = stored
+	return u, nil
+}
+
+func (pr *postgresUserRepository) Update(ctx context.Context, u *domain.User) (*domain.User, error) {
+	if _, err := uuid.Parse(u.ID); err != nil {
+		return nil, ErrNoUserFound
+	}
+
+	// the right hand sides see the row before the update
+	row := pr.db.QueryRowContext(ctx, `UPDATE users
+		SET username = $2, email = $3, canonical_username = $4, canonical_email = $5,
+			verified = CASE WHEN canonical_email = $5 THEN verified ELSE false END,
+			updated_at = now(), version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL AND version = $6 RETURNING `+postgresUserColumns,
+		u.ID, u.Username, u.Email, u.CanonicalUsername, u.CanonicalEmail, u.Version)
+	updated, err := scanPostgresUser(row)
+	if err == sql.ErrNoRows {
+		// either the user is gone or its version moved on
+		var version int64
+		err := pr.db.QueryRowContext(ctx, `SELECT version FROM users WHERE id = $1 AND deleted_at IS NULL`, u.ID).Scan(&version)
+		if err == sql.ErrNoRows {
+			return nil, ErrNoUserFound
+		} else if err != nil {
+			return nil, fmt.Errorf("error while updating the user: %w", err)
+		}
+		return nil, ErrConflict
+	} else if uerr := postgresUniqueError(err); uerr != nil {
+		return nil, uerr
+	} else if err != nil {
+		return nil, fmt.Errorf("error while updating the user: %w", err)
+	}
+	return updated, nil
+}
+
+func (pr *postgresUserRepository) UpdatePassword(ctx context.Context, ID string, password string) error {
+	return pr.update(ctx, ID, `password = $2`, password)
+}
+
+func (pr *postgresUserRepository) MarkEmailVerified(ctx context.Context, ID string) error {
+	return pr.update(ctx, ID, `verified = $2`, true)
+}
+
+func (pr *postgresUserRepository) Delete(ctx context.Context, ID string) error {
+	return pr.update(ctx, ID, `deleted_at = now()`)
+}
+
+// update sets the columns of the user and the updated_at
+func (pr *postgresUserRepository) update(ctx context.Context, ID, set string, args ...interface{}) error {
+	if _, err := uuid.Parse(ID); err != nil {
+		return ErrNoUserFound
+	}
+
+	res, err := pr.db.ExecContext(ctx, `UPDATE users SET `+set+`, updated_at = now(), version = version + 1 WHERE id = $1 AND deleted_at IS NULL`,
+		append([]interface{}{ID}, args...)...)
+	if err != nil {
+		return fmt.Errorf("error while updating the user: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("error while updating the user: %w", err)
+	} else if n == 0 {
+		return ErrNoUserFound
+	}
+	return nil
+}
+
+// postgresUniqueError returns the error of the unique constraint err violates, if it does
+func postgresUniqueError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		switch pqErr.Constraint {
+		case "users_canonical_username_key":
+			return ErrUsernameNotUnique
+		case "users_canonical_email_key":
+			return ErrEmailNotUnique
+		}
+	}
+	return nil
+}
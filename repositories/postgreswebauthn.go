@@ -0,0 +1,90 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// postgresWebAuthnCredentialRepository keeps the webauthn credentials in the
+// database of the postgres user repository
+type postgresWebAuthnCredentialRepository struct {
+	db *sql.DB
+}
+
+func (pr *postgresWebAuthnCredentialRepository) Store(ctx context.Context, c *domain.WebAuthnCredential) error {
+	if _, err := uuid.Parse(c.UserID); err != nil {
+		return ErrNoUserFound
+	}
+	transports, err := marshalTransports(c)
+	if err != nil {
+		return err
+	}
+
+	res, err := pr.db.ExecContext(ctx, `INSERT INTO webauthn_credentials (`+webAuthnCredentialColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (id) DO NOTHING`,
+		c.ID, c.UserID, c.PublicKey, int64(c.SignCount), c.AAGUID, transports, c.CreatedAt, c.LastUsedAt)
+	if err != nil {
+		return fmt.Errorf("error while storing the webauthn credential: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("error while storing the webauthn credential: %w", err)
+	} else if n == 0 {
+		return ErrWebAuthnCredentialNotUnique
+	}
+	return nil
+}
+
+func (pr *postgresWebAuthnCredentialRepository) GetByID(ctx context.Context, ID []byte) (*domain.WebAuthnCredential, error) {
+	c, err := scanWebAuthnCredential(pr.db.QueryRowContext(ctx, `SELECT `+webAuthnCredentialColumns+`
+		FROM webauthn_credentials WHERE id = $1`, ID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNoWebAuthnCredentialFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error while getting the webauthn credential: %w", err)
+	}
+	return c, nil
+}
+
+func (pr *postgresWebAuthnCredentialRepository) GetByUserID(ctx context.Context, userID string) ([]*domain.WebAuthnCredential, error) {
+	res := make([]*domain.WebAuthnCredential, 0)
+	// the column is a uuid, anything else can't match and would be a syntax error
+	if _, err := uuid.Parse(userID); err != nil {
+		return res, nil
+	}
+
+	rows, err := pr.db.QueryContext(ctx, `SELECT `+webAuthnCredentialColumns+`
+		FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting the webauthn credentials: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanWebAuthnCredential(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error while getting the webauthn credentials: %w", err)
+		}
+		res = append(res, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error while getting the webauthn credentials: %w", err)
+	}
+	return res, nil
+}
+
+func (pr *postgresWebAuthnCredentialRepository) UpdateSignCount(ctx context.Context, ID []byte, signCount uint32) error {
+	res, err := pr.db.ExecContext(ctx, `UPDATE webauthn_credentials SET sign_count = $2, last_used_at = now() WHERE id = $1`, ID, int64(signCount))
+	if err != nil {
+		return fmt.Errorf("error while updating the sign count: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("error while updating the sign count: %w", err)
+	} else if n == 0 {
+		return ErrNoWebAuthnCredentialFound
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package repositories
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// ErrInvalidRateLimit ...
+var ErrInvalidRateLimit = fmt.Errorf("the rate limit needs a positive burst and period")
+
+// NewRateLimitStore returns the store of the kind, the in memory one is only
+// shared by the requests of one replica. The Postgres kind takes UserStoreArgs
+// and keeps the buckets in the database of the users, where every replica
+// takes from the same buckets.
+func NewRateLimitStore(kind string, args interface{}) (domain.RateLimitStore, error) {
+
+	switch kind {
+	case InMemoryKind:
+		return newInMemoryRateLimitStore(), nil
+	case PostgresKind:
+		db, err := postgresDB(args)
+		if err != nil {
+			return nil, err
+		}
+		return &postgresRateLimitStore{db: db}, nil
+	}
+
+	return nil, errors.Wrap(ErrUnknownRepository, fmt.Sprintf("kind: %s", kind))
+}
@@ -0,0 +1,101 @@
+package repositories
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// TestRateLimitStoreConformance runs the token bucket contract against every kind
+func TestRateLimitStoreConformance(t *testing.T) {
+	kinds := map[string]func(t *testing.T) domain.RateLimitStore{
+		InMemoryKind: func(t *testing.T) domain.RateLimitStore {
+			return newInMemoryRateLimitStore()
+		},
+		PostgresKind: func(t *testing.T) domain.RateLimitStore {
+			return getPostgresRateLimitStore(t)
+		},
+	}
+	for kind, newStore := range kinds {
+		newStore := newStore
+		t.Run(kind, func(t *testing.T) {
+			testRateLimitStore(t, newStore(t))
+		})
+	}
+
+	_, err := NewRateLimitStore(PostgresKind, nil)
+	assert.NotNil(t, err)
+}
+
+func getPostgresRateLimitStore(t *testing.T) *postgresRateLimitStore {
+	pr := getPostgresUserRepository(t)
+	if _, err := pr.db.Exec(`TRUNCATE rate_limit_buckets`); err != nil {
+		t.Fatal(err)
+	}
+	rls, err := NewRateLimitStore(PostgresKind, &UserStoreArgs{Users: pr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rls.(*postgresRateLimitStore)
+}
+
+func testRateLimitStore(t *testing.T, rls domain.RateLimitStore) {
+	ctx := context.TODO()
+	limit := domain.RateLimit{Burst: 2, Period: time.Second}
+
+	_, err := rls.Take(ctx, "a", domain.RateLimit{Burst: 0, Period: time.Second})
+	assert.Equal(t, ErrInvalidRateLimit, err)
+
+	res, err := rls.Take(ctx, "a", limit)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 1, res.Remaining)
+	res, _ = rls.Take(ctx, "a", limit)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+
+	res, _ = rls.Take(ctx, "a", limit)
+	assert.False(t, res.Allowed)
+	assert.True(t, res.RetryAfter > 0 && res.RetryAfter <= limit.Period/2, res.RetryAfter)
+	assert.True(t, res.Reset > res.RetryAfter && res.Reset <= limit.Period, res.Reset)
+
+	// the other keys have their own buckets
+	res, _ = rls.Take(ctx, "b", limit)
+	assert.True(t, res.Allowed)
+
+	time.Sleep(limit.Period / 2)
+	res, _ = rls.Take(ctx, "a", limit)
+	assert.True(t, res.Allowed)
+}
+
+// the replicas share the buckets, together they allow a burst once
+func TestPostgresRateLimitStoreReplicas(t *testing.T) {
+	replicas := []domain.RateLimitStore{getPostgresRateLimitStore(t), getPostgresRateLimitStore(t)}
+	limit := domain.RateLimit{Burst: 10, Period: time.Hour}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(rls domain.RateLimitStore) {
+			defer wg.Done()
+			res, err := rls.Take(context.TODO(), "shared", limit)
+			if assert.Nil(t, err) && res.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}(replicas[i%2])
+	}
+	wg.Wait()
+	assert.Equal(t, limit.Burst, allowed)
+}
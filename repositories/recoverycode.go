@@ -0,0 +1,41 @@
+package repositories
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// ErrNoRecoveryCodeFound ...
+var ErrNoRecoveryCodeFound = fmt.Errorf("no unused recovery code found")
+
+// NewRecoveryCodeRepository returns the repository of the kind, the persistent kinds
+// take UserStoreArgs and keep the codes next to the users
+func NewRecoveryCodeRepository(kind string, args interface{}) (domain.RecoveryCodeRepository, error) {
+
+	switch kind {
+	case InMemoryKind:
+		return newInMemoryRecoveryCodeRepository(), nil
+	case PostgresKind:
+		db, err := postgresDB(args)
+		if err != nil {
+			return nil, err
+		}
+		return &postgresRecoveryCodeRepository{db: db}, nil
+	case SQLiteKind:
+		db, err := sqliteDB(args)
+		if err != nil {
+			return nil, err
+		}
+		return &sqliteRecoveryCodeRepository{db: db}, nil
+	case BoltKind:
+		db, err := boltDB(args)
+		if err != nil {
+			return nil, err
+		}
+		return newBoltRecoveryCodeRepository(db)
+	}
+
+	return nil, errors.Wrap(ErrUnknownRepository, fmt.Sprintf("kind: %s", kind))
+}
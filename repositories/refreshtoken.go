@@ -0,0 +1,44 @@
+package repositories
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// ErrNoRefreshTokenFound ...
+var ErrNoRefreshTokenFound = fmt.Errorf("no refresh token found")
+
+// ErrRefreshTokenAlreadyUsed ...
+var ErrRefreshTokenAlreadyUsed = fmt.Errorf("refresh token is already used")
+
+// NewRefreshTokenRepository returns the repository of the kind, the persistent
+// kinds take UserStoreArgs and keep the tokens next to the users
+func NewRefreshTokenRepository(kind string, args interface{}) (domain.RefreshTokenRepository, error) {
+
+	switch kind {
+	case InMemoryKind:
+		return newInMemoryRefreshTokenRepository(), nil
+	case PostgresKind:
+		db, err := postgresDB(args)
+		if err != nil {
+			return nil, err
+		}
+		return &postgresRefreshTokenRepository{db: db}, nil
+	case SQLiteKind:
+		db, err := sqliteDB(args)
+		if err != nil {
+			return nil, err
+		}
+		return &sqliteRefreshTokenRepository{db: db}, nil
+	case BoltKind:
+		db, err := boltDB(args)
+		if err != nil {
+			return nil, err
+		}
+		return newBoltRefreshTokenRepository(db)
+	}
+
+	return nil, errors.Wrap(ErrUnknownRepository, fmt.Sprintf("kind: %s", kind))
+}
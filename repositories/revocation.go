@@ -0,0 +1,41 @@
+package repositories
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+const FileKind string = "File"
+
+type FileArgs struct {
+	// the file the store is persisted to, it's created if it doesn't exist
+	Path string
+}
+
+// NewRevocationStore returns the store of the kind, the in memory and the file
+// ones are only seen by one replica. The Postgres kind takes UserStoreArgs and
+// keeps the revocations in the database of the users, where every replica
+// sees them.
+func NewRevocationStore(kind string, args interface{}) (domain.RevocationStore, error) {
+
+	switch kind {
+	case InMemoryKind:
+		return newInMemoryRevocationStore(), nil
+	case PostgresKind:
+		db, err := postgresDB(args)
+		if err != nil {
+			return nil, err
+		}
+		return &postgresRevocationStore{db: db}, nil
+	case FileKind:
+		fa, ok := args.(*FileArgs)
+		if !ok || fa.Path == "" {
+			return nil, fmt.Errorf("the file revocation store needs a path")
+		}
+		return newFileRevocationStore(fa.Path)
+	}
+
+	return nil, errors.Wrap(ErrUnknownRepository, fmt.Sprintf("kind: %s", kind))
+}
@@ -0,0 +1,89 @@
+package repositories
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// TestRevocationStoreConformance runs the revocation contract against the kinds
+// which need no file
+func TestRevocationStoreConformance(t *testing.T) {
+	kinds := map[string]func(t *testing.T) domain.RevocationStore{
+		InMemoryKind: func(t *testing.T) domain.RevocationStore {
+			return newInMemoryRevocationStore()
+		},
+		PostgresKind: func(t *testing.T) domain.RevocationStore {
+			return getPostgresRevocationStore(t)
+		},
+	}
+	for kind, newStore := range kinds {
+		newStore := newStore
+		t.Run(kind, func(t *testing.T) {
+			testRevocationStore(t, newStore(t))
+		})
+	}
+
+	_, err := NewRevocationStore(PostgresKind, nil)
+	assert.NotNil(t, err)
+}
+
+func getPostgresRevocationStore(t *testing.T) *postgresRevocationStore {
+	rs, err := NewRevocationStore(PostgresKind, &UserStoreArgs{Users: getPostgresUserRepository(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs.(*postgresRevocationStore)
+}
+
+func testRevocationStore(t *testing.T, rs domain.RevocationStore) {
+	ctx := context.TODO()
+
+	revoked, err := rs.IsRevoked(ctx, "revoked")
+	assert.Nil(t, err)
+	assert.False(t, revoked)
+	assert.Nil(t, rs.Revoke(ctx, "revoked", time.Now().Add(time.Hour)))
+	revoked, _ = rs.IsRevoked(ctx, "revoked")
+	assert.True(t, revoked)
+	revoked, _ = rs.IsRevoked(ctx, "unknown")
+	assert.False(t, revoked)
+
+	g, err := rs.Generation(ctx, "user-id")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, g)
+	g, _ = rs.BumpGeneration(ctx, "user-id")
+	assert.Equal(t, 1, g)
+	g, _ = rs.BumpGeneration(ctx, "user-id")
+	assert.Equal(t, 2, g)
+	g, _ = rs.Generation(ctx, "user-id")
+	assert.Equal(t, 2, g)
+	g, _ = rs.Generation(ctx, "another-user-id")
+	assert.Equal(t, 0, g)
+}
+
+// a logout on one replica is seen by the others, and no bump is lost
+func TestPostgresRevocationStoreReplicas(t *testing.T) {
+	replicas := []domain.RevocationStore{getPostgresRevocationStore(t), getPostgresRevocationStore(t)}
+	ctx := context.TODO()
+
+	assert.Nil(t, replicas[0].Revoke(ctx, "jti", time.Now().Add(time.Hour)))
+	revoked, _ := replicas[1].IsRevoked(ctx, "jti")
+	assert.True(t, revoked)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(rs domain.RevocationStore) {
+			defer wg.Done()
+			_, err := rs.BumpGeneration(ctx, "user-id")
+			assert.Nil(t, err)
+		}(replicas[i%2])
+	}
+	wg.Wait()
+	g, _ := replicas[1].Generation(ctx, "user-id")
+	assert.Equal(t, 20, g)
+}
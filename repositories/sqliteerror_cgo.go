@@ -0,0 +1,32 @@
+//go:build cgo
+// +build cgo
+
+package repositories
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// checkSQLiteDriver returns an error if the build has no sqlite driver
+func checkSQLiteDriver() error {
+	return nil
+}
+
+// sqliteUniqueError returns the error of the unique constraint err violates, if it does.
+// sqlite only names the column in the message, like UNIQUE constraint failed: users.email
+func sqliteUniqueError(err error) error {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.ExtendedCode != sqlite3.ErrConstraintUnique {
+		return nil
+	}
+	switch msg := sqliteErr.Error(); {
+	case strings.HasSuffix(msg, "users.canonical_username"), strings.HasSuffix(msg, "users.username"):
+		return ErrUsernameNotUnique
+	case strings.HasSuffix(msg, "users.canonical_email"), strings.HasSuffix(msg, "users.email"):
+		return ErrEmailNotUnique
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !cgo
+// +build !cgo
+
+package repositories
+
+import "fmt"
+
+// checkSQLiteDriver returns an error if the build has no sqlite driver, the
+// driver is a cgo binding of sqlite and the builds without cgo only have a stub
+func checkSQLiteDriver() error {
+	return fmt.Errorf("sqlite requires cgo, build with CGO_ENABLED=1 or use another user repository type")
+}
+
+// sqliteUniqueError is never reached, the repository can't be created without cgo
+func sqliteUniqueError(err error) error {
+	return nil
+}
@@ -0,0 +1,105 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// sqliteTOTPRepository keeps the totp credentials in the database of the
+// sqlite user repository
+type sqliteTOTPRepository struct {
+	db *sql.DB
+}
+
+func (sr *sqliteTOTPRepository) Store(ctx context.Context, c *domain.TOTPCredential) error {
+	_, err := sr.db.ExecContext(ctx, `INSERT OR REPLACE INTO totp_credentials (user_id, secret, confirmed, last_used_step, created_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		c.UserID, c.Secret, c.Confirmed, c.LastUsedStep, c.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error while storing the totp credential: %w", err)
+	}
+	return nil
+}
+
+func (sr *sqliteTOTPRepository) GetByUserID(ctx context.Context, userID string) (*domain.TOTPCredential, error) {
+	c := &domain.TOTPCredential{}
+	err := sr.db.QueryRowContext(ctx, `SELECT user_id, secret, confirmed, last_used_step, created_at
+		FROM totp_credentials WHERE user_id = ?`, userID).Scan(&c.UserID, &c.Secret, &c.Confirmed, &c.LastUsedStep, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoTOTPFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error while getting the totp credential: %w", err)
+	}
+	return c, nil
+}
+
+func (sr *sqliteTOTPRepository) Confirm(ctx context.Context, userID string, step int64) error {
+	res, err := sr.db.ExecContext(ctx, `UPDATE totp_credentials SET confirmed = 1, last_used_step = ? WHERE user_id = ?`, step, userID)
+	if err != nil {
+		return fmt.Errorf("error while confirming the totp credential: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("error while confirming the totp credential: %w", err)
+	} else if n == 0 {
+		return ErrNoTOTPFound
+	}
+	return nil
+}
+
+func (sr *sqliteTOTPRepository) UseStep(ctx context.Context, userID string, step int64) error {
+	// the comparison and the update are one statement, so two logins can't use the same step
+	res, err := sr.db.ExecContext(ctx, `UPDATE totp_credentials SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?`, step, userID, step)
+	if err != nil {
+		return fmt.Errorf("error while using the totp step: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("error while using the totp step: %w", err)
+	} else if n == 1 {
+		return nil
+	}
+
+	if _, err := sr.GetByUserID(ctx, userID); err != nil {
+		return err
+	}
+	return ErrTOTPStepUsed
+}
+
+// sqliteRecoveryCodeRepository keeps the hashes of the recovery codes in the
+// database of the sqlite user repository
+type sqliteRecoveryCodeRepository struct {
+	db *sql.DB
+}
+
+func (sr *sqliteRecoveryCodeRepository) Replace(ctx context.Context, userID string, hashes []string) error {
+	tx, err := sr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error while replacing the recovery codes: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("error while replacing the recovery codes: %w", err)
+	}
+	for _, h := range hashes {
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO recovery_codes (user_id, hash) VALUES (?, ?)`, userID, h); err != nil {
+			return fmt.Errorf("error while replacing the recovery codes: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (sr *sqliteRecoveryCodeRepository) Consume(ctx context.Context, userID string, hash string) error {
+	res, err := sr.db.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ? AND hash = ?`, userID, hash)
+	if err != nil {
+		return fmt.Errorf("error while consuming the recovery code: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("error while consuming the recovery code: %w", err)
+	} else if n == 0 {
+		return ErrNoRecoveryCodeFound
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// sqliteMigrations are applied in order, the version of a migration is its
+// index plus one, it's kept in the user_version of the database. The applied
+// ones must never change, add a new one instead.
+var sqliteMigrations = []string{
+	// 1: the users
+	`CREATE TABLE users (
+		id         TEXT PRIMARY KEY,
+		username   TEXT NOT NULL UNIQUE,
+		email      TEXT NOT NULL UNIQUE,
+		password   TEXT NOT NULL,
+		verified   BOOLEAN NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	)`,
+	// 2: the soft deletes
+	`ALTER TABLE users ADD COLUMN deleted_at DATETIME`,
+	// 3: the canonical forms are the unique ones, the existing users get
+	// placeholders which canonicalizeSQLite replaces in the same transaction.
+	// sqlite can't drop the constraints of the first migration, they're implied
+	// by these as equal names have equal canonical forms.
+	`ALTER TABLE users ADD COLUMN canonical_username TEXT NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN canonical_email TEXT NOT NULL DEFAULT '';
+	UPDATE users SET canonical_username = ' ' || id, canonical_email = ' ' || id;
+	CREATE UNIQUE INDEX users_canonical_username ON users (canonical_username);
+	CREATE UNIQUE INDEX users_canonical_email ON users (canonical_email)`,
+	// 4: the versions of the optimistic concurrency control
+	`ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+	// 5: the second factors
+	`CREATE TABLE totp_credentials (
+		user_id        TEXT PRIMARY KEY REFERENCES users (id),
+		secret         TEXT NOT NULL,
+		confirmed      BOOLEAN NOT NULL DEFAULT 0,
+		last_used_step INTEGER NOT NULL DEFAULT 0,
+		created_at     DATETIME NOT NULL
+	);
+	CREATE TABLE recovery_codes (
+		user_id TEXT NOT NULL REFERENCES users (id),
+		hash    TEXT NOT NULL,
+		PRIMARY KEY (user_id, hash)
+	)`,
+	// 6: the webauthn credentials
+	`CREATE TABLE webauthn_credentials (
+		id           BLOB PRIMARY KEY,
+		user_id      TEXT NOT NULL REFERENCES users (id),
+		public_key   BLOB NOT NULL,
+		sign_count   INTEGER NOT NULL DEFAULT 0,
+		aaguid       BLOB NOT NULL,
+		transports   TEXT NOT NULL,
+		created_at   DATETIME NOT NULL,
+		last_used_at DATETIME NOT NULL
+	);
+	CREATE INDEX webauthn_credentials_user_id_idx ON webauthn_credentials (user_id)`,
+	// 7: the refresh tokens and the one time tokens, the webauthn challenges of
+	// the discoverable logins have no user
+	`CREATE TABLE refresh_tokens (
+		hash       TEXT PRIMARY KEY,
+		family_id  TEXT NOT NULL,
+		user_id    TEXT NOT NULL,
+		username   TEXT NOT NULL,
+		used       BOOLEAN NOT NULL DEFAULT 0,
+		revoked    BOOLEAN NOT NULL DEFAULT 0,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+	CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+	CREATE INDEX refresh_tokens_expires_at_idx ON refresh_tokens (expires_at);
+	CREATE TABLE one_time_tokens (
+		hash       TEXT PRIMARY KEY,
+		user_id    TEXT NOT NULL,
+		purpose    TEXT NOT NULL,
+		used       BOOLEAN NOT NULL DEFAULT 0,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX one_time_tokens_user_id_idx ON one_time_tokens (user_id, purpose);
+	CREATE INDEX one_time_tokens_expires_at_idx ON one_time_tokens (expires_at)`,
+}
+
+// migrateSQLite applies the migrations the database doesn't have yet and gives
+// the users the canonical forms of canon, in one transaction, which takes the
+// write lock as the connections begin immediately
+func migrateSQLite(ctx context.Context, db *sql.DB, canon domain.Canonicalizer) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error while starting the migration: %w", err)
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("error while getting the schema version: %w", err)
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("the schema version %d is newer than this build knows, %d", version, len(sqliteMigrations))
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		if _, err := tx.ExecContext(ctx, sqliteMigrations[i]); err != nil {
+			return fmt.Errorf("error while applying the migration %d: %w", i+1, err)
+		}
+	}
+	// the pragma can't take a parameter
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, len(sqliteMigrations))); err != nil {
+		return fmt.Errorf("error while recording the schema version: %w", err)
+	}
+
+	if err := canonicalizeSQLite(ctx, tx, canon); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// canonicalizeSQLite gives the users the canonical forms of canon, the forms
+// change with the email local part policy and with the canonicalization itself
+func canonicalizeSQLite(ctx context.Context, tx *sql.Tx, canon domain.Canonicalizer) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, username, email, canonical_username, canonical_email FROM users`)
+	if err != nil {
+		return fmt.Errorf("error while reading the canonical forms: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*domain.User
+	for rows.Next() {
+		u := &domain.User{}
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.CanonicalUsername, &u.CanonicalEmail); err != nil {
+			return fmt.Errorf("error while reading the canonical forms: %w", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error while reading the canonical forms: %w", err)
+	}
+	rows.Close()
+
+	changed, err := recanonicalize(users, canon)
+	if err != nil {
+		return err
+	}
+	// the placeholders first, so a user can take a form another one is leaving
+	for _, u := range changed {
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET canonical_username = ?, canonical_email = ? WHERE id = ?`,
+			canonicalPlaceholder(u.ID), canonicalPlaceholder(u.ID), u.ID); err != nil {
+			return fmt.Errorf("error while canonicalizing the user %s: %w", u.ID, err)
+		}
+	}
+	for _, u := range changed {
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET canonical_username = ?, canonical_email = ? WHERE id = ?`,
+			u.CanonicalUsername, u.CanonicalEmail, u.ID); err != nil {
+			return fmt.Errorf("error while canonicalizing the user %s: %w", u.ID, err)
+		}
+	}
+	return nil
+}
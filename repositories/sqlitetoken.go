@@ -0,0 +1,137 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// sqliteRefreshTokenRepository keeps the refresh tokens in the database of the
+// sqlite user repository, the driver writes the times as text so the expiries
+// are kept in UTC to compare in order
+type sqliteRefreshTokenRepository struct {
+	db *sql.DB
+}
+
+func (sr *sqliteRefreshTokenRepository) Store(ctx context.Context, t *domain.RefreshToken) error {
+	// drop the expired tokens, they can't be exchanged anymore
+	if _, err := sr.db.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE expires_at < ?`, time.Now().UTC()); err != nil {
+		return fmt.Errorf("error while deleting the expired refresh tokens: %w", err)
+	}
+
+	_, err := sr.db.ExecContext(ctx, `INSERT OR REPLACE INTO refresh_tokens (hash, family_id, user_id, username, used, revoked, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.Hash, t.FamilyID, t.UserID, t.Username, t.Used, t.Revoked, t.ExpiresAt.UTC(), t.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error while storing the refresh token: %w", err)
+	}
+	return nil
+}
+
+func (sr *sqliteRefreshTokenRepository) GetByHash(ctx context.Context, hash string) (*domain.RefreshToken, error) {
+	t := &domain.RefreshToken{}
+	err := sr.db.QueryRowContext(ctx, `SELECT hash, family_id, user_id, username, used, revoked, expires_at, created_at
+		FROM refresh_tokens WHERE hash = ?`, hash).
+		Scan(&t.Hash, &t.FamilyID, &t.UserID, &t.Username, &t.Used, &t.Revoked, &t.ExpiresAt, &t.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoRefreshTokenFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error while getting the refresh token: %w", err)
+	}
+	return t, nil
+}
+
+func (sr *sqliteRefreshTokenRepository) MarkUsed(ctx context.Context, hash string) error {
+	// the check and the update are one statement, so only one rotation of a token succeeds
+	res, err := sr.db.ExecContext(ctx, `UPDATE refresh_tokens SET used = 1 WHERE hash = ? AND used = 0`, hash)
+	if err != nil {
+		return fmt.Errorf("error while marking the refresh token used: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("error while marking the refresh token used: %w", err)
+	} else if n == 1 {
+		return nil
+	}
+
+	if _, err := sr.GetByHash(ctx, hash); err != nil {
+		return err
+	}
+	return ErrRefreshTokenAlreadyUsed
+}
+
+func (sr *sqliteRefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	if _, err := sr.db.ExecContext(ctx, `UPDATE refresh_tokens SET revoked = 1 WHERE family_id = ?`, familyID); err != nil {
+		return fmt.Errorf("error while revoking the refresh token family: %w", err)
+	}
+	return nil
+}
+
+func (sr *sqliteRefreshTokenRepository) RevokeUser(ctx context.Context, userID string) error {
+	if _, err := sr.db.ExecContext(ctx, `UPDATE refresh_tokens SET revoked = 1 WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("error while revoking the refresh tokens of the user: %w", err)
+	}
+	return nil
+}
+
+// sqliteOneTimeTokenRepository keeps the one time tokens in the database of
+// the sqlite user repository, with the expiries in UTC like the refresh tokens
+type sqliteOneTimeTokenRepository struct {
+	db *sql.DB
+}
+
+func (sr *sqliteOneTimeTokenRepository) Store(ctx context.Context, t *domain.OneTimeToken) error {
+	// drop the expired tokens, they can't be consumed anymore
+	if _, err := sr.db.ExecContext(ctx, `DELETE FROM one_time_tokens WHERE expires_at < ?`, time.Now().UTC()); err != nil {
+		return fmt.Errorf("error while deleting the expired one time tokens: %w", err)
+	}
+
+	_, err := sr.db.ExecContext(ctx, `INSERT OR REPLACE INTO one_time_tokens (hash, user_id, purpose, used, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		t.Hash, t.UserID, t.Purpose, t.Used, t.ExpiresAt.UTC(), t.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error while storing the one time token: %w", err)
+	}
+	return nil
+}
+
+func (sr *sqliteOneTimeTokenRepository) Consume(ctx context.Context, hash, purpose string) (*domain.OneTimeToken, error) {
+	// the driver can't type the returned columns, the update and the read are
+	// one transaction instead
+	tx, err := sr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while consuming the one time token: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE one_time_tokens SET used = 1 WHERE hash = ? AND purpose = ? AND used = 0`, hash, purpose)
+	if err != nil {
+		return nil, fmt.Errorf("error while consuming the one time token: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, fmt.Errorf("error while consuming the one time token: %w", err)
+	} else if n == 0 {
+		return nil, ErrNoOneTimeTokenFound
+	}
+
+	t := &domain.OneTimeToken{}
+	err = tx.QueryRowContext(ctx, `SELECT hash, user_id, purpose, used, expires_at, created_at
+		FROM one_time_tokens WHERE hash = ?`, hash).
+		Scan(&t.Hash, &t.UserID, &t.Purpose, &t.Used, &t.ExpiresAt, &t.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error while consuming the one time token: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error while consuming the one time token: %w", err)
+	}
+	return t, nil
+}
+
+func (sr *sqliteOneTimeTokenRepository) DeleteByUser(ctx context.Context, userID, purpose string) error {
+	if _, err := sr.db.ExecContext(ctx, `DELETE FROM one_time_tokens WHERE user_id = ? AND purpose = ?`, userID, purpose); err != nil {
+		return fmt.Errorf("error while deleting the one time tokens: %w", err)
+	}
+	return nil
+}
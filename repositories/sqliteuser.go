@@ -0,0 +1,222 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+type sqliteUserRepository struct {
+	db *sql.DB
+}
+
+func newSQLiteUserRepository(sa *SQLiteArgs) (*sqliteUserRepository, error) {
+	if err := checkSQLiteDriver(); err != nil {
+		return nil, err
+	}
+
+	// WAL lets the readers go on while a write is in progress, the writers wait
+	// for each other up to the busy timeout and take the lock as they begin,
+	// so a transaction never fails upgrading its read lock
+	params := url.Values{}
+	params.Set("_journal_mode", "WAL")
+	params.Set("_synchronous", "NORMAL")
+	params.Set("_busy_timeout", "5000")
+	params.Set("_txlock", "immediate")
+	db, err := sql.Open("sqlite3", "file:"+sa.Path+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("error while opening the database: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := migrateSQLite(ctx, db, canonicalizer(sa.Canonicalizer)); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &sqliteUserRepository{db: db}, nil
+}
+
+// sqliteDB returns the database of the user repository in the UserStoreArgs
+func sqliteDB(args interface{}) (*sql.DB, error) {
+	if ua, ok := args.(*UserStoreArgs); ok {
+		if sr, ok := ua.Users.(*sqliteUserRepository); ok {
+			return sr.db, nil
+		}
+	}
+	return nil, fmt.Errorf("the sqlite stores need the sqlite user repository")
+}
+
+// Close closes the database
+func (sr *sqliteUserRepository) Close() error {
+	return sr.db.Close()
+}
+
+const sqliteUserColumns = `id, username, email, canonical_username, canonical_email, password, verified, created_at, updated_at, version, deleted_at`
+
+// sqliteRow is a *sql.Row or *sql.Rows
+type sqliteRow interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSQLiteUser(row sqliteRow) (*domain.User, error) {
+	u := &domain.User{}
+	var deletedAt sql.NullTime
+	if err := row.Scan(&u.ID, &u.Username, &u.Email, &u.CanonicalUsername, &u.CanonicalEmail, &u.Password, &u.Verified, &u.CreatedAt, &u.UpdatedAt, &u.Version, &deletedAt); err != nil {
+		return nil, err
+	}
+	if deletedAt.Valid {
+		u.DeletedAt = &deletedAt.Time
+	}
+	return u, nil
+}
+
+func (sr *sqliteUserRepository) getBy(ctx context.Context, column, value string) (*domain.User, error) {
+	row := sr.db.QueryRowContext(ctx, `SELECT `+sqliteUserColumns+` FROM users WHERE `+column+` = ? AND deleted_at IS NULL`, value)
+	u, err := scanSQLiteUser(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoUserFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error while getting the user: %w", err)
+	}
+	return u, nil
+}
+
+func (sr *sqliteUserRepository) GetByID(ctx context.Context, ID string) (*domain.User, error) {
+	return sr.getBy(ctx, "id", ID)
+}
+
+func (sr *sqliteUserRepository) GetByUsername(ctx context.Context, username string) (*domain.User, error) {
+	return sr.getBy(ctx, "canonical_username", username)
+}
+
+func (sr *sqliteUserRepository) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
+	return sr.getBy(ctx, "canonical_email", email)
+}
+
+func (sr *sqliteUserRepository) List(ctx context.Context, after string, limit int) ([]*domain.User, error) {
+	if err := checkPage(after, limit); err != nil {
+		return nil, err
+	}
+
+	rows, err := sr.db.QueryContext(ctx, `SELECT `+sqliteUserColumns+` FROM users
+		WHERE id > ? AND deleted_at IS NULL ORDER BY id LIMIT ?`, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error while listing the users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]*domain.User, 0, limit)
+	for rows.Next() {
+		u, err := scanSQLiteUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error while listing the users: %w", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error while listing the users: %w", err)
+	}
+	return users, nil
+}
+
+func (sr *sqliteUserRepository) Store(ctx context.Context, u *domain.User) (*domain.User, error) {
+	if _, err := uuid.Parse(u.ID); err == nil {
+		return nil, fmt.Errorf("can't store the the object already has an ID.")
+	}
+
+	stored := *u
+	stored.ID = uuid.New().String()
+	stored.CreatedAt = time.Now()
+	stored.UpdatedAt = stored.CreatedAt
+	stored.Version = 1
+	stored.DeletedAt = nil
+
+	_, err := sr.db.ExecContext(ctx, `INSERT INTO users (`+sqliteUserColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULL)`,
+		stored.ID, stored.Username, stored.Email, stored.CanonicalUsername, stored.CanonicalEmail, stored.Password, stored.Verified, stored.CreatedAt, stored.UpdatedAt, stored.Version)
+	if uerr := sqliteUniqueError(err); uerr != nil {
+		return nil, uerr
+	} else if err != nil {
+		return nil, fmt.Errorf("error while storing the user: %w", err)
+	}
+
+	*u = stored
+	return u, nil
+}
+
+func (sr *sqliteUserRepository) Update(ctx context.Context, u *domain.User) (*domain.User, error) {
+	// the driver can't type the columns of RETURNING, so the user is read back
+	// in the same transaction, which holds the write lock from its start
+	tx, err := sr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while updating the user: %w", err)
+	}
+	defer tx.Rollback()
+
+	var version int64
+	err = tx.QueryRowContext(ctx, `SELECT version FROM users WHERE id = ? AND deleted_at IS NULL`, u.ID).Scan(&version)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoUserFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error while updating the user: %w", err)
+	}
+	if version != u.Version {
+		return nil, ErrConflict
+	}
+
+	// the right hand sides see the row before the update
+	_, err = tx.ExecContext(ctx, `UPDATE users
+		SET username = ?, email = ?, canonical_username = ?, canonical_email = ?,
+			verified = CASE WHEN canonical_email = ? THEN verified ELSE 0 END,
+			updated_at = ?, version = version + 1
+		WHERE id = ?`,
+		u.Username, u.Email, u.CanonicalUsername, u.CanonicalEmail, u.CanonicalEmail, time.Now(), u.ID)
+	if uerr := sqliteUniqueError(err); uerr != nil {
+		return nil, uerr
+	} else if err != nil {
+		return nil, fmt.Errorf("error while updating the user: %w", err)
+	}
+
+	updated, err := scanSQLiteUser(tx.QueryRowContext(ctx, `SELECT `+sqliteUserColumns+` FROM users WHERE id = ?`, u.ID))
+	if err != nil {
+		return nil, fmt.Errorf("error while updating the user: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error while updating the user: %w", err)
+	}
+	return updated, nil
+}
+
+func (sr *sqliteUserRepository) UpdatePassword(ctx context.Context, ID string, password string) error {
+	return sr.update(ctx, ID, `password = ?`, password)
+}
+
+func (sr *sqliteUserRepository) MarkEmailVerified(ctx context.Context, ID string) error {
+	return sr.update(ctx, ID, `verified = ?`, true)
+}
+
+func (sr *sqliteUserRepository) Delete(ctx context.Context, ID string) error {
+	return sr.update(ctx, ID, `deleted_at = ?`, time.Now())
+}
+
+// update sets the columns of the user and the updated_at
+func (sr *sqliteUserRepository) update(ctx context.Context, ID, set string, args ...interface{}) error {
+	res, err := sr.db.ExecContext(ctx, `UPDATE users SET `+set+`, updated_at = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL`,
+		append(args, time.Now(), ID)...)
+	if err != nil {
+		return fmt.Errorf("error while updating the user: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("error while updating the user: %w", err)
+	} else if n == 0 {
+		return ErrNoUserFound
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+func TestSQLiteUserRepository(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minaria-sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "minaria.db")
+
+	ur, err := NewUserRepository(SQLiteKind, &SQLiteArgs{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr := ur.(*sqliteUserRepository)
+	ctx := context.TODO()
+
+	var mode string
+	sr.db.QueryRow(`PRAGMA journal_mode`).Scan(&mode)
+	assert.Equal(t, "wal", mode)
+
+	u, err := sr.Store(ctx, testUser("", "jack", "jack@gmail.com", "hash"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.NotEmpty(t, u.ID)
+
+	_, err = sr.Store(ctx, testUser(u.ID, "jill", "jill@gmail.com", ""))
+	assert.NotNil(t, err)
+	_, err = sr.Store(ctx, testUser("", "jack", "another@gmail.com", ""))
+	assert.Equal(t, ErrUsernameNotUnique, err)
+	_, err = sr.Store(ctx, testUser("", "another", "jack@gmail.com", ""))
+	assert.Equal(t, ErrEmailNotUnique, err)
+	// the canonical forms are unique, not the typed ones
+	_, err = sr.Store(ctx, testUser("", "Jack", "another@gmail.com", ""))
+	assert.Equal(t, ErrUsernameNotUnique, err)
+
+	for _, get := range []func() (*domain.User, error){
+		func() (*domain.User, error) { return sr.GetByID(ctx, u.ID) },
+		func() (*domain.User, error) { return sr.GetByUsername(ctx, "jack") },
+		func() (*domain.User, error) { return sr.GetByEmail(ctx, "jack@gmail.com") },
+	} {
+		got, err := get()
+		if assert.Nil(t, err) {
+			assert.Equal(t, u.ID, got.ID)
+			assert.Equal(t, "hash", got.Password)
+			assert.True(t, u.CreatedAt.Equal(got.CreatedAt))
+		}
+	}
+	_, err = sr.GetByID(ctx, "no-such-id")
+	assert.Equal(t, ErrNoUserFound, err)
+	_, err = sr.GetByEmail(ctx, "nobody@gmail.com")
+	assert.Equal(t, ErrNoUserFound, err)
+
+	assert.Nil(t, sr.UpdatePassword(ctx, u.ID, "new hash"))
+	assert.Nil(t, sr.MarkEmailVerified(ctx, u.ID))
+	assert.Equal(t, ErrNoUserFound, sr.MarkEmailVerified(ctx, "no-such-id"))
+
+	// the writers wait for each other instead of failing with a busy database
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := sr.Store(ctx, testUser("", fmt.Sprintf("user%d", i), fmt.Sprintf("user%d@gmail.com", i), ""))
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, sr.Close())
+
+	// a reopened database keeps the users and skips the applied migrations
+	ur, err = NewUserRepository(SQLiteKind, &SQLiteArgs{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr = ur.(*sqliteUserRepository)
+	defer sr.Close()
+
+	var version int
+	sr.db.QueryRow(`PRAGMA user_version`).Scan(&version)
+	assert.Equal(t, len(sqliteMigrations), version)
+
+	got, err := sr.GetByID(ctx, u.ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, "new hash", got.Password)
+		assert.True(t, got.Verified)
+	}
+	_, err = sr.GetByUsername(ctx, "user19")
+	assert.Nil(t, err)
+}
+
+func TestSQLiteMigrationCanonicalizes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minaria-sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "minaria.db")
+
+	// a database of the second version with users in it, lower() wouldn't
+	// give the fullwidth name its canonical form
+	db, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range append(sqliteMigrations[:2:2], `PRAGMA user_version = 2`,
+		`INSERT INTO users (id, username, email, password, created_at, updated_at)
+		VALUES ('54215f2a-b752-11eb-8529-0242ac130003', 'Jack', 'Jack@Gmail.com', 'hash', datetime(), datetime())`,
+		`INSERT INTO users (id, username, email, password, created_at, updated_at)
+		VALUES ('601427c2-b752-11eb-8529-0242ac130003', 'ｊｉｌｌ', 'jill@gmail.com', 'hash', datetime(), datetime())`) {
+		if _, err := db.Exec(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	ur, err := NewUserRepository(SQLiteKind, &SQLiteArgs{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr := ur.(*sqliteUserRepository)
+	defer sr.Close()
+	ctx := context.TODO()
+
+	got, err := sr.GetByUsername(ctx, "jack")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "Jack", got.Username)
+		assert.Equal(t, "jack@gmail.com", got.CanonicalEmail)
+	}
+	_, err = sr.Store(ctx, testUser("", "another", "JACK@gmail.com", ""))
+	assert.Equal(t, ErrEmailNotUnique, err)
+	got, err = sr.GetByUsername(ctx, "jill")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "ｊｉｌｌ", got.Username)
+	}
+	sr.Close()
+
+	// the users get the forms of a changed policy as the database opens
+	exact := &domain.Canonicalizer{EmailLocalPart: domain.EmailLocalPartExact}
+	ur, err = NewUserRepository(SQLiteKind, &SQLiteArgs{Path: path, Canonicalizer: exact})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr = ur.(*sqliteUserRepository)
+	got, err = sr.GetByEmail(ctx, "Jack@gmail.com")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "Jack", got.Username)
+	}
+	_, err = sr.Store(ctx, &domain.User{Username: "another", Email: "jack@gmail.com", CanonicalUsername: "another", CanonicalEmail: "jack@gmail.com"})
+	assert.Nil(t, err)
+	sr.Close()
+
+	// which collide under the lowercased local parts
+	_, err = NewUserRepository(SQLiteKind, &SQLiteArgs{Path: path})
+	assert.True(t, errors.Is(err, ErrEmailNotUnique), err)
+}
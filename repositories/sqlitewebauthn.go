@@ -0,0 +1,82 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// sqliteWebAuthnCredentialRepository keeps the webauthn credentials in the
+// database of the sqlite user repository
+type sqliteWebAuthnCredentialRepository struct {
+	db *sql.DB
+}
+
+func (sr *sqliteWebAuthnCredentialRepository) Store(ctx context.Context, c *domain.WebAuthnCredential) error {
+	transports, err := marshalTransports(c)
+	if err != nil {
+		return err
+	}
+
+	res, err := sr.db.ExecContext(ctx, `INSERT INTO webauthn_credentials (`+webAuthnCredentialColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (id) DO NOTHING`,
+		c.ID, c.UserID, c.PublicKey, int64(c.SignCount), c.AAGUID, transports, c.CreatedAt, c.LastUsedAt)
+	if err != nil {
+		return fmt.Errorf("error while storing the webauthn credential: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("error while storing the webauthn credential: %w", err)
+	} else if n == 0 {
+		return ErrWebAuthnCredentialNotUnique
+	}
+	return nil
+}
+
+func (sr *sqliteWebAuthnCredentialRepository) GetByID(ctx context.Context, ID []byte) (*domain.WebAuthnCredential, error) {
+	c, err := scanWebAuthnCredential(sr.db.QueryRowContext(ctx, `SELECT `+webAuthnCredentialColumns+`
+		FROM webauthn_credentials WHERE id = ?`, ID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNoWebAuthnCredentialFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error while getting the webauthn credential: %w", err)
+	}
+	return c, nil
+}
+
+func (sr *sqliteWebAuthnCredentialRepository) GetByUserID(ctx context.Context, userID string) ([]*domain.WebAuthnCredential, error) {
+	rows, err := sr.db.QueryContext(ctx, `SELECT `+webAuthnCredentialColumns+`
+		FROM webauthn_credentials WHERE user_id = ? ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting the webauthn credentials: %w", err)
+	}
+	defer rows.Close()
+
+	res := make([]*domain.WebAuthnCredential, 0)
+	for rows.Next() {
+		c, err := scanWebAuthnCredential(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error while getting the webauthn credentials: %w", err)
+		}
+		res = append(res, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error while getting the webauthn credentials: %w", err)
+	}
+	return res, nil
+}
+
+func (sr *sqliteWebAuthnCredentialRepository) UpdateSignCount(ctx context.Context, ID []byte, signCount uint32) error {
+	res, err := sr.db.ExecContext(ctx, `UPDATE webauthn_credentials SET sign_count = ?, last_used_at = ? WHERE id = ?`, int64(signCount), time.Now(), ID)
+	if err != nil {
+		return fmt.Errorf("error while updating the sign count: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("error while updating the sign count: %w", err)
+	} else if n == 0 {
+		return ErrNoWebAuthnCredentialFound
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package repositories
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// TestTokenRepositoryConformance runs the contracts of the refresh token and
+// the one time token repositories against every kind, next to a user
+// repository of the kind
+func TestTokenRepositoryConformance(t *testing.T) {
+	for kind, newRepository := range userRepositoryBackends() {
+		kind, newRepository := kind, newRepository
+		t.Run(kind, func(t *testing.T) {
+			ur := newRepository(t)
+			var args interface{}
+			if kind != InMemoryKind {
+				args = &UserStoreArgs{Users: ur}
+			}
+			rtr, err := NewRefreshTokenRepository(kind, args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ottr, err := NewOneTimeTokenRepository(kind, args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			t.Run("RefreshTokens", func(t *testing.T) { testRefreshTokenRepository(t, rtr) })
+			t.Run("OneTimeTokens", func(t *testing.T) { testOneTimeTokenRepository(t, ottr) })
+		})
+	}
+
+	// the persistent kinds need the user repository of the same kind
+	im, _ := newInMemoryUserRepository(&InMemoryArgs{})
+	_, err := NewRefreshTokenRepository(SQLiteKind, &UserStoreArgs{Users: im})
+	assert.NotNil(t, err)
+	_, err = NewOneTimeTokenRepository(BoltKind, nil)
+	assert.NotNil(t, err)
+}
+
+func testRefreshTokenRepository(t *testing.T, rtr domain.RefreshTokenRepository) {
+	ctx := context.TODO()
+	now := time.Now().UTC().Truncate(time.Second)
+	token := func(hash, family, userID string) *domain.RefreshToken {
+		return &domain.RefreshToken{Hash: hash, FamilyID: family, UserID: userID, Username: "jack",
+			ExpiresAt: now.Add(time.Hour), CreatedAt: now}
+	}
+
+	_, err := rtr.GetByHash(ctx, "a")
+	assert.Equal(t, ErrNoRefreshTokenFound, err)
+	assert.Equal(t, ErrNoRefreshTokenFound, rtr.MarkUsed(ctx, "a"))
+
+	assert.Nil(t, rtr.Store(ctx, token("a", "f1", "u1")))
+	assert.Nil(t, rtr.Store(ctx, token("b", "f1", "u1")))
+	assert.Nil(t, rtr.Store(ctx, token("c", "f2", "u1")))
+	assert.Nil(t, rtr.Store(ctx, token("d", "f3", "u2")))
+	got, err := rtr.GetByHash(ctx, "a")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "f1", got.FamilyID)
+	assert.Equal(t, "u1", got.UserID)
+	assert.Equal(t, "jack", got.Username)
+	assert.False(t, got.Used)
+	assert.False(t, got.Revoked)
+	assert.True(t, now.Add(time.Hour).Equal(got.ExpiresAt), got.ExpiresAt)
+
+	// a token is rotated once
+	assert.Nil(t, rtr.MarkUsed(ctx, "a"))
+	assert.Equal(t, ErrRefreshTokenAlreadyUsed, rtr.MarkUsed(ctx, "a"))
+	got, _ = rtr.GetByHash(ctx, "a")
+	assert.True(t, got.Used)
+
+	assert.Nil(t, rtr.RevokeFamily(ctx, "f1"))
+	for hash, revoked := range map[string]bool{"a": true, "b": true, "c": false, "d": false} {
+		got, _ = rtr.GetByHash(ctx, hash)
+		assert.Equal(t, revoked, got.Revoked, hash)
+	}
+	assert.Nil(t, rtr.RevokeUser(ctx, "u1"))
+	for hash, revoked := range map[string]bool{"c": true, "d": false} {
+		got, _ = rtr.GetByHash(ctx, hash)
+		assert.Equal(t, revoked, got.Revoked, hash)
+	}
+
+	// the expired tokens are dropped when a new one is stored
+	expired := token("e", "f4", "u2")
+	expired.ExpiresAt = now.Add(-time.Hour)
+	assert.Nil(t, rtr.Store(ctx, expired))
+	assert.Nil(t, rtr.Store(ctx, token("f", "f4", "u2")))
+	_, err = rtr.GetByHash(ctx, "e")
+	assert.Equal(t, ErrNoRefreshTokenFound, err)
+}
+
+func testOneTimeTokenRepository(t *testing.T, ottr domain.OneTimeTokenRepository) {
+	ctx := context.TODO()
+	now := time.Now().UTC().Truncate(time.Second)
+	token := func(hash, userID, purpose string) *domain.OneTimeToken {
+		return &domain.OneTimeToken{Hash: hash, UserID: userID, Purpose: purpose,
+			ExpiresAt: now.Add(time.Hour), CreatedAt: now}
+	}
+
+	_, err := ottr.Consume(ctx, "a", "reset")
+	assert.Equal(t, ErrNoOneTimeTokenFound, err)
+
+	assert.Nil(t, ottr.Store(ctx, token("a", "u1", "reset")))
+	assert.Nil(t, ottr.Store(ctx, token("b", "u1", "reset")))
+	assert.Nil(t, ottr.Store(ctx, token("c", "u1", "verify")))
+
+	// the purpose must match
+	_, err = ottr.Consume(ctx, "a", "verify")
+	assert.Equal(t, ErrNoOneTimeTokenFound, err)
+	got, err := ottr.Consume(ctx, "a", "reset")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "u1", got.UserID)
+	assert.Equal(t, "reset", got.Purpose)
+	assert.True(t, now.Add(time.Hour).Equal(got.ExpiresAt), got.ExpiresAt)
+	// a token is consumed once
+	_, err = ottr.Consume(ctx, "a", "reset")
+	assert.Equal(t, ErrNoOneTimeTokenFound, err)
+
+	// only the tokens of the purpose are deleted
+	assert.Nil(t, ottr.DeleteByUser(ctx, "u1", "reset"))
+	_, err = ottr.Consume(ctx, "b", "reset")
+	assert.Equal(t, ErrNoOneTimeTokenFound, err)
+	_, err = ottr.Consume(ctx, "c", "verify")
+	assert.Nil(t, err)
+
+	// the expired tokens are dropped when a new one is stored
+	expired := token("d", "u2", "reset")
+	expired.ExpiresAt = now.Add(-time.Hour)
+	assert.Nil(t, ottr.Store(ctx, expired))
+	assert.Nil(t, ottr.Store(ctx, token("e", "u2", "reset")))
+	_, err = ottr.Consume(ctx, "d", "reset")
+	assert.Equal(t, ErrNoOneTimeTokenFound, err)
+}
+
+// a restarted server still rotates the refresh tokens it issued before
+func TestTokensSurviveRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minaria-tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.TODO()
+
+	kinds := map[string]interface{}{
+		SQLiteKind: &SQLiteArgs{Path: filepath.Join(dir, "minaria.db")},
+		BoltKind:   &BoltArgs{Dir: dir},
+	}
+	for kind, urArgs := range kinds {
+		ur, err := NewUserRepository(kind, urArgs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rtr, _ := NewRefreshTokenRepository(kind, &UserStoreArgs{Users: ur})
+		ottr, _ := NewOneTimeTokenRepository(kind, &UserStoreArgs{Users: ur})
+		assert.Nil(t, rtr.Store(ctx, &domain.RefreshToken{Hash: "a", FamilyID: "f", UserID: "u", ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now()}))
+		assert.Nil(t, ottr.Store(ctx, &domain.OneTimeToken{Hash: "b", UserID: "u", Purpose: "reset", ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now()}))
+		ur.(io.Closer).Close()
+
+		ur, err = NewUserRepository(kind, urArgs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rtr, _ = NewRefreshTokenRepository(kind, &UserStoreArgs{Users: ur})
+		ottr, _ = NewOneTimeTokenRepository(kind, &UserStoreArgs{Users: ur})
+		assert.Nil(t, rtr.MarkUsed(ctx, "a"), kind)
+		_, err = ottr.Consume(ctx, "b", "reset")
+		assert.Nil(t, err, kind)
+		ur.(io.Closer).Close()
+	}
+}
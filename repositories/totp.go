@@ -0,0 +1,44 @@
+package repositories
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// ErrNoTOTPFound ...
+var ErrNoTOTPFound = fmt.Errorf("no totp credential found")
+
+// ErrTOTPStepUsed ...
+var ErrTOTPStepUsed = fmt.Errorf("the totp time step is already used")
+
+// NewTOTPRepository returns the repository of the kind, the persistent kinds take
+// UserStoreArgs and keep the credentials next to the users
+func NewTOTPRepository(kind string, args interface{}) (domain.TOTPRepository, error) {
+
+	switch kind {
+	case InMemoryKind:
+		return newInMemoryTOTPRepository(), nil
+	case PostgresKind:
+		db, err := postgresDB(args)
+		if err != nil {
+			return nil, err
+		}
+		return &postgresTOTPRepository{db: db}, nil
+	case SQLiteKind:
+		db, err := sqliteDB(args)
+		if err != nil {
+			return nil, err
+		}
+		return &sqliteTOTPRepository{db: db}, nil
+	case BoltKind:
+		db, err := boltDB(args)
+		if err != nil {
+			return nil, err
+		}
+		return newBoltTOTPRepository(db)
+	}
+
+	return nil, errors.Wrap(ErrUnknownRepository, fmt.Sprintf("kind: %s", kind))
+}
@@ -0,0 +1,356 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// TestUserRepositoryConformance runs the same contract against every backend,
+// a new backend should be added to userRepositoryBackends
+func TestUserRepositoryConformance(t *testing.T) {
+	for kind, newRepository := range userRepositoryBackends() {
+		newRepository := newRepository
+		t.Run(kind, func(t *testing.T) {
+			testUserRepository(t, newRepository(t))
+		})
+	}
+}
+
+// userRepositoryBackends returns a constructor of an empty user repository for every kind
+func userRepositoryBackends() map[string]func(t *testing.T) domain.UserRepository {
+	tempDir := func(t *testing.T) string {
+		dir, err := ioutil.TempDir("", "minaria-conformance")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		return dir
+	}
+
+	return map[string]func(t *testing.T) domain.UserRepository{
+		InMemoryKind: func(t *testing.T) domain.UserRepository {
+			im, _ := newInMemoryUserRepository(&InMemoryArgs{})
+			return im
+		},
+		SQLiteKind: func(t *testing.T) domain.UserRepository {
+			sr, err := newSQLiteUserRepository(&SQLiteArgs{Path: filepath.Join(tempDir(t), "minaria.db")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { sr.Close() })
+			return sr
+		},
+		BoltKind: func(t *testing.T) domain.UserRepository {
+			br, err := newBoltUserRepository(&BoltArgs{Dir: tempDir(t)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { br.Close() })
+			return br
+		},
+		PostgresKind: func(t *testing.T) domain.UserRepository {
+			return getPostgresUserRepository(t)
+		},
+	}
+}
+
+// testUser returns a user with the canonical forms the usecase would give it
+func testUser(ID, username, email, password string) *domain.User {
+	canonicalUsername, _ := domain.DefaultCanonicalizer.Username(username)
+	canonicalEmail, _ := domain.DefaultCanonicalizer.Email(email)
+	return &domain.User{
+		ID:                ID,
+		Username:          username,
+		Email:             email,
+		CanonicalUsername: canonicalUsername,
+		CanonicalEmail:    canonicalEmail,
+		Password:          password,
+	}
+}
+
+func testUserRepository(t *testing.T, ur domain.UserRepository) {
+	ctx := context.TODO()
+
+	jack, err := ur.Store(ctx, testUser("", "jack", "jack@gmail.com", "hash"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.NotEmpty(t, jack.ID)
+	assert.False(t, jack.CreatedAt.IsZero())
+	assert.Nil(t, jack.DeletedAt)
+	jill, err := ur.Store(ctx, testUser("", "jill", "jill@gmail.com", "hash"))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// edit returns a change of the username and the email at the current version of the user
+	edit := func(ID, username, email string) *domain.User {
+		u := testUser(ID, username, email, "")
+		if stored, err := ur.GetByID(ctx, ID); err == nil {
+			u.Version = stored.Version
+		}
+		return u
+	}
+
+	t.Run("Store", func(t *testing.T) {
+		_, err := ur.Store(ctx, testUser(jack.ID, "another", "another@gmail.com", ""))
+		assert.NotNil(t, err)
+		_, err = ur.Store(ctx, testUser("", "jack", "another@gmail.com", ""))
+		assert.Equal(t, ErrUsernameNotUnique, err)
+		_, err = ur.Store(ctx, testUser("", "another", "jack@gmail.com", ""))
+		assert.Equal(t, ErrEmailNotUnique, err)
+		_, err = ur.GetByUsername(ctx, "another")
+		assert.Equal(t, ErrNoUserFound, err)
+	})
+
+	t.Run("Canonical", func(t *testing.T) {
+		u, err := ur.Store(ctx, testUser("", "Canonical", "Canonical@GMail.com", ""))
+		if !assert.Nil(t, err) {
+			return
+		}
+		// the typed forms are kept, the canonical ones are looked up and unique
+		got, err := ur.GetByEmail(ctx, "canonical@gmail.com")
+		if assert.Nil(t, err) {
+			assert.Equal(t, u.ID, got.ID)
+			assert.Equal(t, "Canonical", got.Username)
+			assert.Equal(t, "Canonical@GMail.com", got.Email)
+			assert.Equal(t, "canonical", got.CanonicalUsername)
+			assert.Equal(t, "canonical@gmail.com", got.CanonicalEmail)
+		}
+		_, err = ur.GetByUsername(ctx, "canonical")
+		assert.Nil(t, err)
+		_, err = ur.GetByEmail(ctx, "Canonical@GMail.com")
+		assert.Equal(t, ErrNoUserFound, err)
+
+		_, err = ur.Store(ctx, testUser("", "CANONICAL", "another@gmail.com", ""))
+		assert.Equal(t, ErrUsernameNotUnique, err)
+		_, err = ur.Store(ctx, testUser("", "another", "CANONICAL@gmail.com", ""))
+		assert.Equal(t, ErrEmailNotUnique, err)
+		_, err = ur.Update(ctx, edit(jill.ID, "jill", "canonical@GMAIL.COM"))
+		assert.Equal(t, ErrEmailNotUnique, err)
+
+		assert.Nil(t, ur.Delete(ctx, u.ID))
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		for _, get := range []func() (*domain.User, error){
+			func() (*domain.User, error) { return ur.GetByID(ctx, jack.ID) },
+			func() (*domain.User, error) { return ur.GetByUsername(ctx, "jack") },
+			func() (*domain.User, error) { return ur.GetByEmail(ctx, "jack@gmail.com") },
+		} {
+			got, err := get()
+			if assert.Nil(t, err) {
+				assert.Equal(t, jack.ID, got.ID)
+				assert.Equal(t, "jack", got.Username)
+				assert.Equal(t, "hash", got.Password)
+				assert.True(t, jack.CreatedAt.Equal(got.CreatedAt))
+			}
+		}
+
+		// the returned users are copies
+		got, _ := ur.GetByID(ctx, jack.ID)
+		got.Password = "changed"
+		got, _ = ur.GetByID(ctx, jack.ID)
+		assert.Equal(t, "hash", got.Password)
+
+		_, err := ur.GetByID(ctx, "54215f2a-b752-11eb-8529-0242ac130003")
+		assert.Equal(t, ErrNoUserFound, err)
+		_, err = ur.GetByUsername(ctx, "nobody")
+		assert.Equal(t, ErrNoUserFound, err)
+		_, err = ur.GetByEmail(ctx, "nobody@gmail.com")
+		assert.Equal(t, ErrNoUserFound, err)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		u, err := ur.Store(ctx, testUser("", "update", "update@gmail.com", "hash"))
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		changed := edit(u.ID, "updated", "updated@gmail.com")
+		changed.Password = "ignored"
+		updated, err := ur.Update(ctx, changed)
+		if assert.Nil(t, err) {
+			assert.Equal(t, "updated", updated.Username)
+			assert.Equal(t, "updated@gmail.com", updated.Email)
+			assert.Equal(t, "hash", updated.Password)
+			assert.False(t, updated.UpdatedAt.Before(u.UpdatedAt))
+		}
+		_, err = ur.GetByUsername(ctx, "update")
+		assert.Equal(t, ErrNoUserFound, err)
+		_, err = ur.GetByEmail(ctx, "update@gmail.com")
+		assert.Equal(t, ErrNoUserFound, err)
+		got, err := ur.GetByEmail(ctx, "updated@gmail.com")
+		if assert.Nil(t, err) {
+			assert.Equal(t, u.ID, got.ID)
+		}
+
+		// the old values are free again
+		_, err = ur.Update(ctx, edit(jill.ID, "update", "jill@gmail.com"))
+		assert.Nil(t, err)
+		_, err = ur.Update(ctx, edit(jill.ID, "jill", "jill@gmail.com"))
+		assert.Nil(t, err)
+
+		// keeping its own values isn't a conflict, taking another user's is
+		_, err = ur.Update(ctx, edit(u.ID, "updated", "updated@gmail.com"))
+		assert.Nil(t, err)
+		_, err = ur.Update(ctx, edit(u.ID, "jack", "updated@gmail.com"))
+		assert.Equal(t, ErrUsernameNotUnique, err)
+		_, err = ur.Update(ctx, edit(u.ID, "updated", "jack@gmail.com"))
+		assert.Equal(t, ErrEmailNotUnique, err)
+		got, _ = ur.GetByID(ctx, u.ID)
+		assert.Equal(t, "updated", got.Username)
+
+		// the user is found by the canonical forms of the new values
+		_, err = ur.Update(ctx, edit(u.ID, "Updated", "Updated@Gmail.com"))
+		assert.Nil(t, err)
+		got, err = ur.GetByUsername(ctx, "updated")
+		if assert.Nil(t, err) {
+			assert.Equal(t, u.ID, got.ID)
+			assert.Equal(t, "Updated", got.Username)
+		}
+		got, err = ur.GetByEmail(ctx, "updated@gmail.com")
+		if assert.Nil(t, err) {
+			assert.Equal(t, u.ID, got.ID)
+		}
+		_, err = ur.Store(ctx, testUser("", "UPDATED", "another@gmail.com", ""))
+		assert.Equal(t, ErrUsernameNotUnique, err)
+
+		_, err = ur.Update(ctx, edit("54215f2a-b752-11eb-8529-0242ac130003", "nobody", "nobody@gmail.com"))
+		assert.Equal(t, ErrNoUserFound, err)
+	})
+
+	t.Run("Version", func(t *testing.T) {
+		u, err := ur.Store(ctx, testUser("", "version", "version@gmail.com", ""))
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, int64(1), u.Version)
+
+		// every write moves the version on
+		assert.Nil(t, ur.MarkEmailVerified(ctx, u.ID))
+		assert.Nil(t, ur.UpdatePassword(ctx, u.ID, "hash"))
+		got, _ := ur.GetByID(ctx, u.ID)
+		assert.Equal(t, int64(3), got.Version)
+
+		// a change made from a stale read is refused and changes nothing
+		stale := testUser(u.ID, "stale", "version@gmail.com", "")
+		stale.Version = u.Version
+		_, err = ur.Update(ctx, stale)
+		assert.Equal(t, ErrConflict, err)
+		got, _ = ur.GetByID(ctx, u.ID)
+		assert.Equal(t, "version", got.Username)
+		assert.Equal(t, int64(3), got.Version)
+
+		updated, err := ur.Update(ctx, edit(u.ID, "versioned", "version@gmail.com"))
+		if assert.Nil(t, err) {
+			assert.Equal(t, int64(4), updated.Version)
+			assert.True(t, updated.Verified)
+		}
+		_, err = ur.Update(ctx, edit(u.ID, "versioned", "version@gmail.com"))
+		assert.Nil(t, err)
+
+		// the old version can't be used twice
+		_, err = ur.Update(ctx, stale)
+		assert.Equal(t, ErrConflict, err)
+
+		// a new email address isn't verified
+		updated, err = ur.Update(ctx, edit(u.ID, "versioned", "Version@Gmail.com"))
+		if assert.Nil(t, err) {
+			assert.True(t, updated.Verified)
+		}
+		updated, err = ur.Update(ctx, edit(u.ID, "versioned", "another.version@gmail.com"))
+		if assert.Nil(t, err) {
+			assert.False(t, updated.Verified)
+		}
+
+		assert.Nil(t, ur.Delete(ctx, u.ID))
+	})
+
+	t.Run("UpdatePassword and MarkEmailVerified", func(t *testing.T) {
+		assert.Nil(t, ur.UpdatePassword(ctx, jill.ID, "new hash"))
+		assert.Nil(t, ur.MarkEmailVerified(ctx, jill.ID))
+		got, err := ur.GetByID(ctx, jill.ID)
+		if assert.Nil(t, err) {
+			assert.Equal(t, "new hash", got.Password)
+			assert.True(t, got.Verified)
+		}
+
+		assert.Equal(t, ErrNoUserFound, ur.UpdatePassword(ctx, "54215f2a-b752-11eb-8529-0242ac130003", "hash"))
+		assert.Equal(t, ErrNoUserFound, ur.MarkEmailVerified(ctx, "54215f2a-b752-11eb-8529-0242ac130003"))
+	})
+
+	t.Run("List", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			_, err := ur.Store(ctx, testUser("", fmt.Sprintf("list%d", i), fmt.Sprintf("list%d@gmail.com", i), ""))
+			assert.Nil(t, err)
+		}
+
+		var IDs []string
+		after := ""
+		for {
+			page, err := ur.List(ctx, after, 2)
+			if !assert.Nil(t, err) || len(page) == 0 {
+				break
+			}
+			assert.LessOrEqual(t, len(page), 2)
+			for _, u := range page {
+				IDs = append(IDs, u.ID)
+			}
+			after = page[len(page)-1].ID
+		}
+		// jack, jill, the updated user and the five
+		assert.Len(t, IDs, 8)
+		assert.True(t, sort.StringsAreSorted(IDs))
+
+		_, err := ur.List(ctx, "", 0)
+		assert.Equal(t, ErrInvalidPage, err)
+		_, err = ur.List(ctx, "not-an-id", 10)
+		assert.Equal(t, ErrInvalidPage, err)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		u, err := ur.Store(ctx, testUser("", "delete", "delete@gmail.com", ""))
+		if !assert.Nil(t, err) {
+			return
+		}
+		before, _ := ur.List(ctx, "", 100)
+
+		assert.Nil(t, ur.Delete(ctx, u.ID))
+		assert.Equal(t, ErrNoUserFound, ur.Delete(ctx, u.ID))
+		assert.Equal(t, ErrNoUserFound, ur.Delete(ctx, "54215f2a-b752-11eb-8529-0242ac130003"))
+
+		_, err = ur.GetByID(ctx, u.ID)
+		assert.Equal(t, ErrNoUserFound, err)
+		_, err = ur.GetByUsername(ctx, "delete")
+		assert.Equal(t, ErrNoUserFound, err)
+		_, err = ur.GetByEmail(ctx, "delete@gmail.com")
+		assert.Equal(t, ErrNoUserFound, err)
+		after, _ := ur.List(ctx, "", 100)
+		assert.Len(t, after, len(before)-1)
+		for _, l := range after {
+			assert.NotEqual(t, u.ID, l.ID)
+		}
+
+		_, err = ur.Update(ctx, edit(u.ID, "deleted", "deleted@gmail.com"))
+		assert.Equal(t, ErrNoUserFound, err)
+		assert.Equal(t, ErrNoUserFound, ur.UpdatePassword(ctx, u.ID, "hash"))
+		assert.Equal(t, ErrNoUserFound, ur.MarkEmailVerified(ctx, u.ID))
+
+		// the username and the email stay taken
+		_, err = ur.Store(ctx, testUser("", "delete", "another@gmail.com", ""))
+		assert.Equal(t, ErrUsernameNotUnique, err)
+		_, err = ur.Store(ctx, testUser("", "another", "delete@gmail.com", ""))
+		assert.Equal(t, ErrEmailNotUnique, err)
+		_, err = ur.Update(ctx, edit(jill.ID, "jill", "delete@gmail.com"))
+		assert.Equal(t, ErrEmailNotUnique, err)
+	})
+}
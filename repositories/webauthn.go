@@ -0,0 +1,79 @@
+package repositories
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// ErrNoWebAuthnCredentialFound ...
+var ErrNoWebAuthnCredentialFound = fmt.Errorf("no webauthn credential found")
+
+// ErrWebAuthnCredentialNotUnique ...
+var ErrWebAuthnCredentialNotUnique = fmt.Errorf("webauthn credential id is not unique, it already exists")
+
+// NewWebAuthnCredentialRepository returns the repository of the kind, the persistent
+// kinds take UserStoreArgs and keep the credentials next to the users
+func NewWebAuthnCredentialRepository(kind string, args interface{}) (domain.WebAuthnCredentialRepository, error) {
+
+	switch kind {
+	case InMemoryKind:
+		return newInMemoryWebAuthnCredentialRepository(), nil
+	case PostgresKind:
+		db, err := postgresDB(args)
+		if err != nil {
+			return nil, err
+		}
+		return &postgresWebAuthnCredentialRepository{db: db}, nil
+	case SQLiteKind:
+		db, err := sqliteDB(args)
+		if err != nil {
+			return nil, err
+		}
+		return &sqliteWebAuthnCredentialRepository{db: db}, nil
+	case BoltKind:
+		db, err := boltDB(args)
+		if err != nil {
+			return nil, err
+		}
+		return newBoltWebAuthnCredentialRepository(db)
+	}
+
+	return nil, errors.Wrap(ErrUnknownRepository, fmt.Sprintf("kind: %s", kind))
+}
+
+// webAuthnCredentialColumns are the columns scanWebAuthnCredential reads, in
+// the tables of the postgres and the sqlite repositories
+const webAuthnCredentialColumns = `id, user_id, public_key, sign_count, aaguid, transports, created_at, last_used_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanWebAuthnCredential(row rowScanner) (*domain.WebAuthnCredential, error) {
+	c := &domain.WebAuthnCredential{}
+	var signCount int64
+	var transports string
+	if err := row.Scan(&c.ID, &c.UserID, &c.PublicKey, &signCount, &c.AAGUID, &transports, &c.CreatedAt, &c.LastUsedAt); err != nil {
+		return nil, err
+	}
+	c.SignCount = uint32(signCount)
+	if err := json.Unmarshal([]byte(transports), &c.Transports); err != nil {
+		return nil, fmt.Errorf("error while unmarshaling the transports: %w", err)
+	}
+	return c, nil
+}
+
+func marshalTransports(c *domain.WebAuthnCredential) (string, error) {
+	transports := c.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+	b, err := json.Marshal(transports)
+	if err != nil {
+		return "", fmt.Errorf("error while marshaling the transports: %w", err)
+	}
+	return string(b), nil
+}
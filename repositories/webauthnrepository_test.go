@@ -0,0 +1,135 @@
+package repositories
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// TestWebAuthnCredentialRepositoryConformance runs the contract of the webauthn
+// credential repository against every kind, next to a user repository of the kind
+func TestWebAuthnCredentialRepositoryConformance(t *testing.T) {
+	for kind, newRepository := range userRepositoryBackends() {
+		kind, newRepository := kind, newRepository
+		t.Run(kind, func(t *testing.T) {
+			ur := newRepository(t)
+			var args interface{}
+			if kind != InMemoryKind {
+				args = &UserStoreArgs{Users: ur}
+			}
+			wcr, err := NewWebAuthnCredentialRepository(kind, args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			jack, err := ur.Store(context.TODO(), testUser("", "jack", "jack@gmail.com", "hash"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			jill, err := ur.Store(context.TODO(), testUser("", "jill", "jill@gmail.com", "hash"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			testWebAuthnCredentialRepository(t, wcr, jack.ID, jill.ID)
+		})
+	}
+
+	im, _ := newInMemoryUserRepository(&InMemoryArgs{})
+	_, err := NewWebAuthnCredentialRepository(PostgresKind, &UserStoreArgs{Users: im})
+	assert.NotNil(t, err)
+}
+
+func testWebAuthnCredential(ID string, userID string, createdAt time.Time) *domain.WebAuthnCredential {
+	return &domain.WebAuthnCredential{
+		ID:         []byte(ID),
+		UserID:     userID,
+		PublicKey:  []byte("key of " + ID),
+		SignCount:  1,
+		AAGUID:     make([]byte, 16),
+		Transports: []string{"usb", "nfc"},
+		CreatedAt:  createdAt,
+		LastUsedAt: createdAt,
+	}
+}
+
+func testWebAuthnCredentialRepository(t *testing.T, wcr domain.WebAuthnCredentialRepository, jackID string, jillID string) {
+	ctx := context.TODO()
+	createdAt := time.Now().UTC().Truncate(time.Second)
+
+	_, err := wcr.GetByID(ctx, []byte("first"))
+	assert.Equal(t, ErrNoWebAuthnCredentialFound, err)
+	assert.Equal(t, ErrNoWebAuthnCredentialFound, wcr.UpdateSignCount(ctx, []byte("first"), 2))
+	creds, err := wcr.GetByUserID(ctx, jackID)
+	assert.Nil(t, err)
+	assert.Len(t, creds, 0)
+
+	assert.Nil(t, wcr.Store(ctx, testWebAuthnCredential("first", jackID, createdAt)))
+	assert.Nil(t, wcr.Store(ctx, testWebAuthnCredential("second", jackID, createdAt.Add(time.Second))))
+	assert.Nil(t, wcr.Store(ctx, testWebAuthnCredential("third", jillID, createdAt)))
+	// the IDs are unique across the users
+	assert.Equal(t, ErrWebAuthnCredentialNotUnique, wcr.Store(ctx, testWebAuthnCredential("first", jillID, createdAt)))
+
+	c, err := wcr.GetByID(ctx, []byte("first"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, jackID, c.UserID)
+	assert.Equal(t, []byte("key of first"), c.PublicKey)
+	assert.Equal(t, uint32(1), c.SignCount)
+	assert.Equal(t, make([]byte, 16), c.AAGUID)
+	assert.Equal(t, []string{"usb", "nfc"}, c.Transports)
+	assert.True(t, createdAt.Equal(c.CreatedAt), c.CreatedAt)
+
+	creds, err = wcr.GetByUserID(ctx, jackID)
+	assert.Nil(t, err)
+	if assert.Len(t, creds, 2) {
+		assert.Equal(t, []byte("first"), creds[0].ID)
+		assert.Equal(t, []byte("second"), creds[1].ID)
+	}
+
+	assert.Nil(t, wcr.UpdateSignCount(ctx, []byte("first"), 7))
+	c, _ = wcr.GetByID(ctx, []byte("first"))
+	assert.Equal(t, uint32(7), c.SignCount)
+	assert.True(t, c.LastUsedAt.After(createdAt), c.LastUsedAt)
+}
+
+func TestWebAuthnCredentialsSurviveRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minaria-webauthn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.TODO()
+
+	kinds := map[string]interface{}{
+		SQLiteKind: &SQLiteArgs{Path: filepath.Join(dir, "minaria.db")},
+		BoltKind:   &BoltArgs{Dir: dir},
+	}
+	for kind, urArgs := range kinds {
+		ur, err := NewUserRepository(kind, urArgs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jack, _ := ur.Store(ctx, testUser("", "jack", "jack@gmail.com", "hash"))
+		wcr, _ := NewWebAuthnCredentialRepository(kind, &UserStoreArgs{Users: ur})
+		assert.Nil(t, wcr.Store(ctx, testWebAuthnCredential("first", jack.ID, time.Now())), kind)
+		ur.(io.Closer).Close()
+
+		ur, err = NewUserRepository(kind, urArgs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wcr, _ = NewWebAuthnCredentialRepository(kind, &UserStoreArgs{Users: ur})
+		creds, err := wcr.GetByUserID(ctx, jack.ID)
+		assert.Nil(t, err, kind)
+		assert.Len(t, creds, 1, kind)
+		ur.(io.Closer).Close()
+	}
+}
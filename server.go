@@ -2,8 +2,11 @@ package main
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/go-openapi/runtime/middleware"
@@ -13,15 +16,22 @@ import (
 	"github.com/vahidmostofi/minaria/common"
 	"github.com/vahidmostofi/minaria/domain"
 	"github.com/vahidmostofi/minaria/handlers"
+	"github.com/vahidmostofi/minaria/mailer"
 	"github.com/vahidmostofi/minaria/repositories"
 	"github.com/vahidmostofi/minaria/usecase"
 )
 
+// the routes which send emails or create accounts are limited harder than the others
+const defaultRouteRateLimits = "/auth/register=10/1h,/auth/password/forgot=5/15m,/auth/magic-link=5/15m,/auth/verify/resend=5/15m"
+
 type Server struct {
 	Router      *mux.Router
 	HTTPServer  http.Server
 	l           *log.Logger
 	bindAddress string
+	jwt         *usecase.JWT
+	ur          domain.UserRepository
+	rs          domain.RevocationStore
 }
 
 func NewServer() *Server {
@@ -30,18 +40,262 @@ func NewServer() *Server {
 
 	s.Router = mux.NewRouter()
 
+	trusted, err := handlers.ParseTrustedProxies(viper.GetString(common.TRUSTED_PROXIES))
+	if err != nil {
+		s.l.Fatalf("Error while parsing the trusted proxies: %s", err)
+	}
+	if len(trusted) != 0 {
+		s.Router.Use(handlers.RealIP(trusted))
+	}
+
 	// health checks
 	hh := handlers.NewHealthCheck(s.l)
 	hh.AttachRouter(s.Router)
 
 	// auth handlers
-	ur, _ := repositories.NewUserRepository( // TODO
-		repositories.InMemoryKind,
-		nil,
-	)
-	uc := usecase.NewUser(s.l, ur, usecase.UserOptions{})   // TODO
+	lp, err := domain.ParseEmailLocalPartPolicy(viper.GetString(common.EMAIL_LOCAL_PART))
+	if err != nil {
+		s.l.Fatalf("Error while parsing the email local part policy: %s", err)
+	}
+	// the stores give the users the canonical forms of the policy as they open
+	canon := &domain.Canonicalizer{EmailLocalPart: lp}
+	urKind := viper.GetString(common.USER_REPO_TYPE)
+	var urArgs interface{}
+	switch urKind {
+	case "":
+		urKind = repositories.InMemoryKind
+	case repositories.PostgresKind:
+		urArgs = &repositories.PostgresArgs{
+			DSN:             viper.GetString(common.POSTGRES_DSN),
+			MaxOpenConns:    viper.GetInt(common.POSTGRES_MAX_OPEN_CONNS),
+			MaxIdleConns:    viper.GetInt(common.POSTGRES_MAX_IDLE_CONNS),
+			ConnMaxLifetime: viper.GetDuration(common.POSTGRES_CONN_MAX_LIFETIME),
+			Canonicalizer:   canon,
+		}
+	case repositories.SQLiteKind:
+		urArgs = &repositories.SQLiteArgs{Path: viper.GetString(common.SQLITE_PATH), Canonicalizer: canon}
+	case repositories.BoltKind:
+		urArgs = &repositories.BoltArgs{Dir: viper.GetString(common.DATA_DIR), Canonicalizer: canon}
+	}
+	ur, err := repositories.NewUserRepository(urKind, urArgs)
+	if err != nil {
+		s.l.Fatalf("Error while creating the user repository: %s", err)
+	}
+	s.ur = ur
+
+	// the second factors, the passkeys and the tokens are kept next to the
+	// users, an in memory store would forget them on restart and the replicas
+	// wouldn't know the tokens the others issued
+	var usArgs interface{}
+	if urKind != repositories.InMemoryKind {
+		usArgs = &repositories.UserStoreArgs{Users: ur}
+	}
+	totpr, err := repositories.NewTOTPRepository(urKind, usArgs)
+	if err != nil {
+		s.l.Fatalf("Error while creating the totp repository: %s", err)
+	}
+	rcr, err := repositories.NewRecoveryCodeRepository(urKind, usArgs)
+	if err != nil {
+		s.l.Fatalf("Error while creating the recovery code repository: %s", err)
+	}
+	wcr, err := repositories.NewWebAuthnCredentialRepository(urKind, usArgs)
+	if err != nil {
+		s.l.Fatalf("Error while creating the webauthn credential repository: %s", err)
+	}
+	rtr, err := repositories.NewRefreshTokenRepository(urKind, usArgs)
+	if err != nil {
+		s.l.Fatalf("Error while creating the refresh token repository: %s", err)
+	}
+	ottr, err := repositories.NewOneTimeTokenRepository(urKind, usArgs)
+	if err != nil {
+		s.l.Fatalf("Error while creating the one time token repository: %s", err)
+	}
+
+	// the replicas of a postgres user store share the revocations through it,
+	// an in memory or a file store is only seen by the replica which revoked
+	rsKind := viper.GetString(common.REVOCATION_STORE_TYPE)
+	if rsKind == "" {
+		rsKind = repositories.InMemoryKind
+		if urKind == repositories.PostgresKind {
+			rsKind = repositories.PostgresKind
+		}
+	}
+	var rsArgs interface{}
+	switch rsKind {
+	case repositories.FileKind:
+		rsArgs = &repositories.FileArgs{Path: viper.GetString(common.REVOCATION_STORE_PATH)}
+	case repositories.PostgresKind:
+		rsArgs = usArgs
+	}
+	if urKind == repositories.PostgresKind && rsKind != repositories.PostgresKind {
+		s.l.Warnf("The %s revocation store isn't shared, a logout is only seen by the replica which handled it", rsKind)
+	}
+	rs, err := repositories.NewRevocationStore(rsKind, rsArgs)
+	if err != nil {
+		s.l.Fatalf("Error while creating the revocation store: %s", err)
+	}
+	s.rs = rs
+
+	j, err := usecase.NewJWTFromConfig(rs)
+	if err != nil {
+		s.l.Fatalf("Error while loading the jwt signing key: %s", err)
+	}
+	s.jwt = j
+	ph, err := usecase.NewPasswordHasherForAlg(viper.GetString(common.PASSWORD_HASH_ALG))
+	if err != nil {
+		s.l.Fatalf("Error while creating the password hasher: %s", err)
+	}
+
+	mtKind := viper.GetString(common.MAIL_TRANSPORT_TYPE)
+	var mtArgs interface{}
+	switch mtKind {
+	case "", mailer.LogKind:
+		mtKind = mailer.LogKind
+		mtArgs = &mailer.LogArgs{Logger: s.l}
+	case mailer.MaildropKind:
+		mtArgs = &mailer.MaildropArgs{Dir: viper.GetString(common.MAILDROP_DIR)}
+	case mailer.SMTPKind:
+		mtArgs = &mailer.SMTPArgs{
+			Host:     viper.GetString(common.SMTP_HOST),
+			Port:     viper.GetInt(common.SMTP_PORT),
+			Username: viper.GetString(common.SMTP_USERNAME),
+			Password: viper.GetString(common.SMTP_PASSWORD),
+		}
+	}
+	mt, err := mailer.NewTransport(mtKind, mtArgs)
+	if err != nil {
+		s.l.Fatalf("Error while creating the mail transport: %s", err)
+	}
+	from := viper.GetString(common.MAIL_FROM)
+	if from == "" {
+		from = "no-reply@localhost"
+	}
+	m, err := mailer.NewMailer(from, mt, nil)
+	if err != nil {
+		s.l.Fatalf("Error while creating the mailer: %s", err)
+	}
+
+	uo := usecase.UserOptions{JWT: j, PasswordHasher: ph, Notifier: usecase.NewMailNotifier(m, viper.GetString(common.PUBLIC_URL))}
+	uo.RefreshTokenRepository = rtr
+	uo.OneTimeTokenRepository = ottr
+	if viper.IsSet(common.JWT_EXPIRES_AFTER) {
+		d := viper.GetDuration(common.JWT_EXPIRES_AFTER)
+		uo.JWTExpiresAfter = &d
+	}
+	if viper.IsSet(common.REFRESH_TOKEN_EXPIRES_AFTER) {
+		d := viper.GetDuration(common.REFRESH_TOKEN_EXPIRES_AFTER)
+		uo.RefreshExpiresAfter = &d
+	}
+	if viper.IsSet(common.PASSWORD_RESET_EXPIRES_AFTER) {
+		d := viper.GetDuration(common.PASSWORD_RESET_EXPIRES_AFTER)
+		uo.PasswordResetExpiresAfter = &d
+	}
+	if viper.IsSet(common.EMAIL_VERIFICATION_EXPIRES_AFTER) {
+		d := viper.GetDuration(common.EMAIL_VERIFICATION_EXPIRES_AFTER)
+		uo.VerificationExpiresAfter = &d
+	}
+	uo.RequireVerifiedEmail = viper.GetBool(common.EMAIL_VERIFICATION_REQUIRED)
+	uo.VerificationGracePeriod = viper.GetDuration(common.EMAIL_VERIFICATION_GRACE_PERIOD)
+	if viper.IsSet(common.MAGIC_LINK_EXPIRES_AFTER) {
+		d := viper.GetDuration(common.MAGIC_LINK_EXPIRES_AFTER)
+		uo.MagicLinkExpiresAfter = &d
+	}
+	if viper.IsSet(common.MFA_EXPIRES_AFTER) {
+		d := viper.GetDuration(common.MFA_EXPIRES_AFTER)
+		uo.MFAExpiresAfter = &d
+	}
+	uo.TOTPRepository = totpr
+	uo.RecoveryCodeRepository = rcr
+	uo.WebAuthnCredentialRepository = wcr
+	uo.TOTPIssuer = viper.GetString(common.TOTP_ISSUER)
+	uo.WebAuthnRPID = viper.GetString(common.WEBAUTHN_RP_ID)
+	uo.WebAuthnRPName = viper.GetString(common.WEBAUTHN_RP_NAME)
+	for _, o := range strings.Split(viper.GetString(common.WEBAUTHN_ORIGINS), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			uo.WebAuthnOrigins = append(uo.WebAuthnOrigins, o)
+		}
+	}
+	al := usecase.DefaultAccountLockout
+	il := usecase.DefaultIPLockout
+	if viper.IsSet(common.ACCOUNT_LOCKOUT_THRESHOLD) {
+		al.Threshold = viper.GetInt(common.ACCOUNT_LOCKOUT_THRESHOLD)
+	}
+	if viper.IsSet(common.ACCOUNT_LOCKOUT_ATTEMPTS) {
+		al.LockoutAttempts = viper.GetInt(common.ACCOUNT_LOCKOUT_ATTEMPTS)
+	}
+	if viper.IsSet(common.ACCOUNT_LOCKOUT_DURATION) {
+		al.LockoutDuration = viper.GetDuration(common.ACCOUNT_LOCKOUT_DURATION)
+	}
+	if viper.IsSet(common.IP_LOCKOUT_THRESHOLD) {
+		il.Threshold = viper.GetInt(common.IP_LOCKOUT_THRESHOLD)
+	}
+	if viper.IsSet(common.IP_LOCKOUT_ATTEMPTS) {
+		il.LockoutAttempts = viper.GetInt(common.IP_LOCKOUT_ATTEMPTS)
+	}
+	if viper.IsSet(common.IP_LOCKOUT_DURATION) {
+		il.LockoutDuration = viper.GetDuration(common.IP_LOCKOUT_DURATION)
+	}
+	if viper.IsSet(common.LOCKOUT_BASE_DELAY) {
+		al.BaseDelay = viper.GetDuration(common.LOCKOUT_BASE_DELAY)
+		il.BaseDelay = al.BaseDelay
+	}
+	if viper.IsSet(common.LOCKOUT_WINDOW) {
+		al.Window = viper.GetDuration(common.LOCKOUT_WINDOW)
+		il.Window = al.Window
+	}
+	uo.AccountLockout = &al
+	uo.IPLockout = &il
+	uo.Canonicalizer = canon
+	uc := usecase.NewUser(s.l, ur, uo)
 	ah := handlers.NewAuth(s.l, uc, domain.NewValidation()) // TODO
-	ah.AttachRouter(s.Router)
+	ar := ah.AttachRouter(s.Router)
+
+	// every public auth endpoint is rate limited per client address and route
+	if rl := viper.GetString(common.RATE_LIMIT); rl != "off" {
+		if rl == "" {
+			rl = "30/1m"
+		}
+		limit, err := handlers.ParseRateLimit(rl)
+		if err != nil {
+			s.l.Fatalf("Error while parsing the rate limit: %s", err)
+		}
+		rr := defaultRouteRateLimits
+		if viper.IsSet(common.RATE_LIMIT_ROUTES) {
+			rr = viper.GetString(common.RATE_LIMIT_ROUTES)
+		}
+		routes, err := handlers.ParseRouteRateLimits(rr)
+		if err != nil {
+			s.l.Fatalf("Error while parsing the rate limits of the routes: %s", err)
+		}
+		rlsKind := viper.GetString(common.RATE_LIMIT_STORE_TYPE)
+		if rlsKind == "" {
+			rlsKind = repositories.InMemoryKind
+		}
+		// a shared store keeps its buckets in the database of the users
+		var rlsArgs interface{}
+		if rlsKind != repositories.InMemoryKind {
+			rlsArgs = usArgs
+		}
+		rls, err := repositories.NewRateLimitStore(rlsKind, rlsArgs)
+		if err != nil {
+			s.l.Fatalf("Error while creating the rate limit store: %s", err)
+		}
+		ar.Use(handlers.NewRateLimiter(s.l, rls, limit, routes).Middleware)
+	}
+
+	// handlers of the logged in users
+	uh := handlers.NewUsers(s.l, uc, domain.NewValidation())
+	uh.AttachRouter(s.Router)
+
+	// administrative handlers, only with an admin token
+	if token := viper.GetString(common.ADMIN_TOKEN); token != "" {
+		adh := handlers.NewAdmin(s.l, uc, token)
+		adh.AttachRouter(s.Router)
+	}
+
+	// public signing keys
+	jh := handlers.NewJWKS(s.l, j)
+	jh.AttachRouter(s.Router)
 
 	// Swagger documentations
 	opts := middleware.RedocOpts{SpecURL: "/swagger.yml"}
@@ -79,8 +333,63 @@ func (s *Server) Start() {
 	}()
 }
 
+// ReloadKeys reloads the signing keyring, e.g. after the keys command rotated it
+func (s *Server) ReloadKeys() {
+	err := s.jwt.Keyring().Reload()
+	if err == usecase.ErrKeyringNotPersistent {
+		s.l.Println("The signing keys are not loaded from a keyring, nothing to reload.")
+		return
+	} else if err != nil {
+		s.l.Errorf("Error while reloading the signing keys: %s", err)
+		return
+	}
+	s.l.Println("Reloaded the signing keys.")
+}
+
+// Backup writes a snapshot of the users to the backup directory, if the user repository can
+func (s *Server) Backup() {
+	b, ok := s.ur.(domain.UserRepositoryBackuper)
+	if !ok {
+		s.l.Println("The user repository doesn't support backups.")
+		return
+	}
+
+	dir := viper.GetString(common.BACKUP_DIR)
+	if dir == "" {
+		dir = "./backups"
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		s.l.Errorf("Error while creating the backup directory: %s", err)
+		return
+	}
+
+	path := filepath.Join(dir, "users-"+time.Now().UTC().Format("20060102T150405Z")+".db")
+	err := common.WriteFileAtomicFunc(path, 0600, func(w io.Writer) error {
+		return b.Backup(context.Background(), w)
+	})
+	if err != nil {
+		s.l.Errorf("Error while backing up the users: %s", err)
+		return
+	}
+	s.l.Println("Backed up the users to", path)
+}
+
 func (s *Server) ShutDown() {
 	s.l.Println("Shutting down the server.")
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 	s.HTTPServer.Shutdown(ctx)
+
+	// the database backed repositories hold a connection pool
+	if c, ok := s.ur.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			s.l.Errorf("Error while closing the user repository: %s", err)
+		}
+	}
+	// the file revocation store holds its log open
+	if c, ok := s.rs.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			s.l.Errorf("Error while closing the revocation store: %s", err)
+		}
+	}
 }
@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// backupSignal makes the running server back up the users
+var backupSignal os.Signal = syscall.SIGUSR1
@@ -0,0 +1,6 @@
+package main
+
+import "os"
+
+// backupSignal is nil as windows has no SIGUSR1
+var backupSignal os.Signal
@@ -0,0 +1,119 @@
+package usecase
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+var errInvalidCBOR = fmt.Errorf("invalid cbor")
+
+// the nesting WebAuthn needs is shallow, the limit keeps a crafted input from exhausting the stack
+const cborMaxDepth = 16
+
+// decodeCBOR decodes the first RFC 8949 data item of b and returns the rest of b.
+// It supports the subset WebAuthn uses: the integers are int64, the byte and text
+// strings are []byte and string, the arrays are []interface{} and the maps are
+// map[interface{}]interface{}. Indefinite lengths, tags and floats aren't supported.
+func decodeCBOR(b []byte) (interface{}, []byte, error) {
+	return decodeCBORItem(b, 0)
+}
+
+func decodeCBORItem(b []byte, depth int) (interface{}, []byte, error) {
+	if depth > cborMaxDepth || len(b) == 0 {
+		return nil, nil, errInvalidCBOR
+	}
+
+	major := b[0] >> 5
+	info := b[0] & 0x1f
+	b = b[1:]
+
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info == 24 && len(b) >= 1:
+		arg, b = uint64(b[0]), b[1:]
+	case info == 25 && len(b) >= 2:
+		arg, b = uint64(binary.BigEndian.Uint16(b)), b[2:]
+	case info == 26 && len(b) >= 4:
+		arg, b = uint64(binary.BigEndian.Uint32(b)), b[4:]
+	case info == 27 && len(b) >= 8:
+		arg, b = binary.BigEndian.Uint64(b), b[8:]
+	default:
+		return nil, nil, errInvalidCBOR
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, errInvalidCBOR
+		}
+		return int64(arg), b, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, errInvalidCBOR
+		}
+		return -1 - int64(arg), b, nil
+	case 2, 3:
+		if arg > uint64(len(b)) {
+			return nil, nil, errInvalidCBOR
+		}
+		s := b[:arg]
+		if major == 3 {
+			return string(s), b[arg:], nil
+		}
+		return append([]byte{}, s...), b[arg:], nil
+	case 4:
+		// every item is at least a byte long
+		if arg > uint64(len(b)) {
+			return nil, nil, errInvalidCBOR
+		}
+		a := make([]interface{}, arg)
+		for i := range a {
+			var err error
+			if a[i], b, err = decodeCBORItem(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+		}
+		return a, b, nil
+	case 5:
+		if arg > uint64(len(b))/2 {
+			return nil, nil, errInvalidCBOR
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, rest, err := decodeCBORItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				// the maps of WebAuthn only have integer and text keys
+				return nil, nil, errInvalidCBOR
+			}
+			v, rest, err := decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			if _, ok := m[k]; ok {
+				return nil, nil, errInvalidCBOR
+			}
+			m[k] = v
+			b = rest
+		}
+		return m, b, nil
+	case 7:
+		switch info {
+		case 20:
+			return false, b, nil
+		case 21:
+			return true, b, nil
+		case 22:
+			return nil, b, nil
+		}
+	}
+
+	return nil, nil, errInvalidCBOR
+}
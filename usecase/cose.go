@@ -0,0 +1,93 @@
+package usecase
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// the RFC 8152 algorithms of the supported credential public keys, in the order they are preferred
+const (
+	coseAlgES256 int64 = -7
+	coseAlgEdDSA int64 = -8
+	coseAlgRS256 int64 = -257
+)
+
+var coseAlgs = []int64{coseAlgES256, coseAlgEdDSA, coseAlgRS256}
+
+var errUnsupportedCOSEKey = fmt.Errorf("unsupported cose key")
+
+// coseKey is a parsed credential public key
+type coseKey struct {
+	alg    int64
+	public crypto.PublicKey
+}
+
+// parseCOSEKey parses the cbor encoded COSE_Key of a credential
+func parseCOSEKey(b []byte) (*coseKey, error) {
+	v, rest, err := decodeCBOR(b)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok || len(rest) != 0 {
+		return nil, errInvalidCBOR
+	}
+
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	crv, _ := m[int64(-1)].(int64)
+	x, _ := m[int64(-2)].([]byte)
+	y, _ := m[int64(-3)].([]byte)
+
+	switch {
+	case alg == coseAlgES256 && kty == 2 && crv == 1:
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errUnsupportedCOSEKey
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errUnsupportedCOSEKey
+		}
+		return &coseKey{alg: alg, public: pub}, nil
+
+	case alg == coseAlgEdDSA && kty == 1 && crv == 6:
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errUnsupportedCOSEKey
+		}
+		return &coseKey{alg: alg, public: ed25519.PublicKey(x)}, nil
+
+	case alg == coseAlgRS256 && kty == 3:
+		// the rsa parameters reuse the labels -1 and -2 for n and e
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errUnsupportedCOSEKey
+		}
+		exp := new(big.Int).SetBytes(e)
+		return &coseKey{alg: alg, public: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}}, nil
+	}
+
+	return nil, errUnsupportedCOSEKey
+}
+
+// verify checks the signature of the message, WebAuthn signs the message
+// without hashing it first for EdDSA and with SHA-256 for the others
+func (k *coseKey) verify(msg, sig []byte) bool {
+	switch pub := k.public.(type) {
+	case *ecdsa.PublicKey:
+		h := sha256.Sum256(msg)
+		return ecdsa.VerifyASN1(pub, h[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, msg, sig)
+	case *rsa.PublicKey:
+		h := sha256.Sum256(msg)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], sig) == nil
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"github.com/vahidmostofi/minaria/common"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+const defaultIssuer = "minaria"
+
+const defaultSigningAlg = "HS256"
+
+const defaultKeyringAlg = "ES256"
+
+var ErrKeyCantSign = fmt.Errorf("the signing key has no private part")
+var ErrNoRevocationStore = fmt.Errorf("no revocation store is configured")
+
+type JWTOptions struct {
+	// the key used to sign and verify the tokens, a key which is loaded
+	// from a public key can only verify
+	Key *SigningKey
+
+	// the keys used to sign and verify the tokens, Key is ignored if it's set
+	Keyring *Keyring
+
+	// the iss claim, it is always checked on verification
+	Issuer string
+
+	// the aud claim, it is only checked on verification if it's not empty
+	Audience string
+
+	// the revoked tokens and token generations, the tokens are never
+	// considered revoked if it's nil
+	Revocations domain.RevocationStore
+}
+
+// JWT signs and verifies the jwt tokens, it can be used on its own
+// by other services to verify the tokens issued by minaria.
+type JWT struct {
+	keys        *Keyring
+	issuer      string
+	audience    string
+	revocations domain.RevocationStore
+}
+
+func NewJWT(opts JWTOptions) *JWT {
+	j := &JWT{keys: opts.Keyring, issuer: opts.Issuer, audience: opts.Audience, revocations: opts.Revocations}
+	if j.keys == nil {
+		j.keys = NewKeyring(opts.Key)
+	}
+	if j.issuer == "" {
+		j.issuer = defaultIssuer
+	}
+	return j
+}
+
+// NewJWTFromConfig returns a JWT which is configured through viper. If JWT_KEYRING_DIR
+// is set the keys are loaded from the keyring, otherwise HS256 uses JWT_SIGN_KEY, with
+// JWT_PREVIOUS_SIGN_KEYS accepted for verification, and the other algorithms load the
+// PEM file in JWT_PRIVATE_KEY_FILE.
+func NewJWTFromConfig(revocations domain.RevocationStore) (*JWT, error) {
+	alg := viper.GetString(common.JWT_SIGNING_ALG)
+	kid := viper.GetString(common.JWT_KEY_ID)
+
+	var kr *Keyring
+	switch {
+	case viper.GetString(common.JWT_KEYRING_DIR) != "":
+		if alg == "" {
+			alg = defaultKeyringAlg
+		}
+		var err error
+		kr, err = LoadKeyring(viper.GetString(common.JWT_KEYRING_DIR), alg)
+		if err != nil {
+			return nil, err
+		}
+	case alg == "" || strings.HasPrefix(alg, "HS"):
+		if alg != "" && alg != defaultSigningAlg {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedSigningAlg, alg)
+		}
+		previous := []*SigningKey{}
+		for _, secret := range strings.Split(viper.GetString(common.JWT_PREVIOUS_SIGN_KEYS), ",") {
+			if secret = strings.TrimSpace(secret); secret != "" {
+				previous = append(previous, NewHMACSigningKey("", []byte(secret)))
+			}
+		}
+		kr = NewKeyring(NewHMACSigningKey(kid, []byte(viper.GetString(common.JWT_SIGN_KEY))), previous...)
+	default:
+		b, err := ioutil.ReadFile(viper.GetString(common.JWT_PRIVATE_KEY_FILE))
+		if err != nil {
+			return nil, fmt.Errorf("error while reading the private key file: %w", err)
+		}
+		key, err := ParsePrivateKeyPEM(kid, alg, b)
+		if err != nil {
+			return nil, err
+		}
+		kr = NewKeyring(key)
+	}
+
+	return NewJWT(JWTOptions{
+		Keyring:     kr,
+		Issuer:      viper.GetString(common.JWT_ISSUER),
+		Audience:    viper.GetString(common.JWT_AUDIENCE),
+		Revocations: revocations,
+	}), nil
+}
+
+// Keyring returns the keys the tokens are signed and verified with
+func (j *JWT) Keyring() *Keyring {
+	return j.keys
+}
+
+// Sign fills the issuer, audience, issued at, jti and generation claims and returns the signed token
+func (j *JWT) Sign(ctx context.Context, claims *domain.Claims) (string, error) {
+	key, err := j.keys.Active()
+	if err != nil {
+		return "", err
+	}
+	if !key.CanSign() {
+		return "", ErrKeyCantSign
+	}
+
+	claims.Issuer = j.issuer
+	claims.Audience = j.audience
+	claims.IssuedAt = time.Now().Unix()
+	claims.Id = uuid.New().String()
+	if j.revocations != nil {
+		claims.Generation, err = j.revocations.Generation(ctx, claims.Subject)
+		if err != nil {
+			return "", fmt.Errorf("error while getting the token generation: %w", err)
+		}
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	ss, err := token.SignedString(key.private)
+	if err != nil {
+		return "", fmt.Errorf("error while signing the token: %w", err)
+	}
+
+	return ss, nil
+}
+
+// Verify checks the signature, expiry, issuer and audience of the access token
+func (j *JWT) Verify(ctx context.Context, tokenString string) (*domain.Claims, error) {
+	return j.VerifyPurpose(ctx, tokenString, "")
+}
+
+// VerifyPurpose is Verify for the tokens which are issued for a purpose other
+// than access, e.g. the email verification tokens. A token is only accepted for
+// the purpose it was issued for.
+func (j *JWT) VerifyPurpose(ctx context.Context, tokenString string, purpose string) (*domain.Claims, error) {
+	claims := &domain.Claims{}
+
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		var key *SigningKey
+		if kid, ok := token.Header["kid"].(string); ok {
+			if key, ok = j.keys.Get(kid); !ok {
+				return nil, fmt.Errorf("unknown or retired key: %s", kid)
+			}
+		} else {
+			// the tokens issued before kid was introduced don't have it
+			var err error
+			if key, err = j.keys.Active(); err != nil {
+				return nil, err
+			}
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %s", token.Header["alg"])
+		}
+		return key.public, nil
+	})
+	if err != nil {
+		return nil, domain.ErrInvalidToken
+	}
+
+	if !claims.VerifyIssuer(j.issuer, true) {
+		return nil, domain.ErrInvalidToken
+	}
+	if j.audience != "" && !claims.VerifyAudience(j.audience, true) {
+		return nil, domain.ErrInvalidToken
+	}
+	if claims.Subject == "" || claims.ExpiresAt == 0 || claims.Purpose != purpose {
+		return nil, domain.ErrInvalidToken
+	}
+
+	if j.revocations != nil {
+		revoked, err := j.revocations.IsRevoked(ctx, claims.Id)
+		if err != nil {
+			return nil, fmt.Errorf("error while checking the token revocation: %w", err)
+		}
+		generation, err := j.revocations.Generation(ctx, claims.Subject)
+		if err != nil {
+			return nil, fmt.Errorf("error while getting the token generation: %w", err)
+		}
+		if revoked || claims.Generation < generation {
+			return nil, domain.ErrInvalidToken
+		}
+	}
+
+	return claims, nil
+}
+
+// Revoke denies the token until it expires
+func (j *JWT) Revoke(ctx context.Context, claims *domain.Claims) error {
+	if j.revocations == nil {
+		return ErrNoRevocationStore
+	}
+	return j.revocations.Revoke(ctx, claims.Id, time.Unix(claims.ExpiresAt, 0))
+}
+
+// RevokeAll denies every token issued for the user so far
+func (j *JWT) RevokeAll(ctx context.Context, userID string) error {
+	if j.revocations == nil {
+		return ErrNoRevocationStore
+	}
+	_, err := j.revocations.BumpGeneration(ctx, userID)
+	return err
+}
+
+// KeySet returns the public keys the tokens can be verified with
+func (j *JWT) KeySet() *domain.JSONWebKeySet {
+	return j.keys.KeySet()
+}
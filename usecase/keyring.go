@@ -0,0 +1,302 @@
+package usecase
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/vahidmostofi/minaria/common"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+type KeyState string
+
+const (
+	// KeyStateActive is the state of the key new tokens are signed with
+	KeyStateActive KeyState = "active"
+
+	// KeyStateVerifyOnly keys are not used for signing anymore, but the tokens
+	// they signed are still accepted until the key is retired
+	KeyStateVerifyOnly KeyState = "verify-only"
+
+	// KeyStateRetired keys are neither used for signing nor for verification
+	KeyStateRetired KeyState = "retired"
+)
+
+const keyringFileName = "keyring.json"
+
+var ErrNoActiveKey = fmt.Errorf("keyring has no active key")
+var ErrKeyNotFound = fmt.Errorf("key not found in the keyring")
+var ErrKeyringNotPersistent = fmt.Errorf("keyring is not backed by a directory")
+
+// KeyringEntry is a key with its state in the keyring
+type KeyringEntry struct {
+	Key           *SigningKey
+	State         KeyState
+	CreatedAt     time.Time
+	DeactivatedAt time.Time
+}
+
+// Keyring holds the signing keys, tokens are signed with the only active key and
+// verified with any key that isn't retired. A keyring loaded with LoadKeyring is
+// persisted to its directory, one PEM file per key and a keyring.json manifest.
+type Keyring struct {
+	mu      sync.RWMutex
+	entries []*KeyringEntry
+	dir     string
+}
+
+type keyringManifestEntry struct {
+	ID            string    `json:"kid"`
+	Alg           string    `json:"alg"`
+	State         KeyState  `json:"state"`
+	CreatedAt     time.Time `json:"created_at"`
+	DeactivatedAt time.Time `json:"deactivated_at,omitempty"`
+}
+
+type keyringManifest struct {
+	Keys []keyringManifestEntry `json:"keys"`
+}
+
+// NewKeyring returns an in memory keyring, the active key signs and the other
+// keys are only used for verification.
+func NewKeyring(active *SigningKey, verifyOnly ...*SigningKey) *Keyring {
+	now := time.Now()
+	kr := &Keyring{}
+	kr.entries = append(kr.entries, &KeyringEntry{Key: active, State: KeyStateActive, CreatedAt: now})
+	for _, k := range verifyOnly {
+		kr.entries = append(kr.entries, &KeyringEntry{Key: k, State: KeyStateVerifyOnly, CreatedAt: now, DeactivatedAt: now})
+	}
+	return kr
+}
+
+// LoadKeyring loads the keyring from the directory, if the directory has no keyring
+// yet a new one with a single active key for alg is created.
+func LoadKeyring(dir, alg string) (*Keyring, error) {
+	kr := &Keyring{dir: dir}
+
+	_, err := os.Stat(filepath.Join(dir, keyringFileName))
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("error while creating the keyring directory: %w", err)
+		}
+		if _, err := kr.Rotate(alg, 0); err != nil {
+			return nil, err
+		}
+		return kr, nil
+	}
+
+	if err := kr.Reload(); err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+// Reload reads the keyring from its directory again, it picks up the
+// rotations done by other processes, e.g. the keys command.
+func (kr *Keyring) Reload() error {
+	if kr.dir == "" {
+		return ErrKeyringNotPersistent
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(kr.dir, keyringFileName))
+	if err != nil {
+		return fmt.Errorf("error while reading the keyring: %w", err)
+	}
+	m := &keyringManifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return fmt.Errorf("error while parsing the keyring: %w", err)
+	}
+
+	entries := []*KeyringEntry{}
+	for _, me := range m.Keys {
+		e := &KeyringEntry{State: me.State, CreatedAt: me.CreatedAt, DeactivatedAt: me.DeactivatedAt}
+		if me.State == KeyStateRetired {
+			// the key material of retired keys is never needed again
+			e.Key = &SigningKey{ID: me.ID, Method: jwt.GetSigningMethod(me.Alg)}
+			entries = append(entries, e)
+			continue
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(kr.dir, me.ID+".pem"))
+		if err != nil {
+			return fmt.Errorf("error while reading key %s: %w", me.ID, err)
+		}
+		e.Key, err = ParsePrivateKeyPEM(me.ID, me.Alg, b)
+		if err != nil {
+			return fmt.Errorf("error while parsing key %s: %w", me.ID, err)
+		}
+		entries = append(entries, e)
+	}
+
+	kr.mu.Lock()
+	kr.entries = entries
+	kr.mu.Unlock()
+	return nil
+}
+
+// Active returns the key new tokens are signed with
+func (kr *Keyring) Active() (*SigningKey, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	for _, e := range kr.entries {
+		if e.State == KeyStateActive {
+			return e.Key, nil
+		}
+	}
+	return nil, ErrNoActiveKey
+}
+
+// Get returns the key with the ID if it isn't retired
+func (kr *Keyring) Get(ID string) (*SigningKey, bool) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	for _, e := range kr.entries {
+		if e.Key.ID == ID && e.State != KeyStateRetired {
+			return e.Key, true
+		}
+	}
+	return nil, false
+}
+
+// Entries returns a copy of the keyring's entries
+func (kr *Keyring) Entries() []KeyringEntry {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	entries := make([]KeyringEntry, 0, len(kr.entries))
+	for _, e := range kr.entries {
+		entries = append(entries, *e)
+	}
+	return entries
+}
+
+// KeySet returns the public part of every key that isn't retired
+func (kr *Keyring) KeySet() *domain.JSONWebKeySet {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	ks := &domain.JSONWebKeySet{Keys: []domain.JSONWebKey{}}
+	for _, e := range kr.entries {
+		if e.State == KeyStateRetired {
+			continue
+		}
+		if jwk, ok := e.Key.JWK(); ok {
+			ks.Keys = append(ks.Keys, jwk)
+		}
+	}
+	return ks
+}
+
+// Rotate generates a new active key for alg, the previous active key becomes
+// verify-only so the tokens it signed stay valid. The verify-only keys which
+// were deactivated more than retireAfter ago are retired, zero keeps them.
+func (kr *Keyring) Rotate(alg string, retireAfter time.Duration) (*SigningKey, error) {
+	key, err := GenerateSigningKey(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	var path string
+	if kr.dir != "" {
+		b, err := key.MarshalPrivateKeyPEM()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(kr.dir, key.ID+".pem")
+		if err := common.WriteFileAtomic(path, b, 0600); err != nil {
+			return nil, fmt.Errorf("error while writing the key: %w", err)
+		}
+	}
+
+	// the new state is built on copies, the keyring keeps signing with the
+	// old active key if the manifest can't be written
+	now := time.Now()
+	entries := make([]*KeyringEntry, 0, len(kr.entries)+1)
+	for _, e := range kr.entries {
+		c := *e
+		switch {
+		case c.State == KeyStateActive:
+			c.State = KeyStateVerifyOnly
+			c.DeactivatedAt = now
+		case c.State == KeyStateVerifyOnly && retireAfter > 0 && now.Sub(c.DeactivatedAt) > retireAfter:
+			c.State = KeyStateRetired
+		}
+		entries = append(entries, &c)
+	}
+	entries = append(entries, &KeyringEntry{Key: key, State: KeyStateActive, CreatedAt: now})
+
+	if err := kr.persist(entries); err != nil {
+		if path != "" {
+			os.Remove(path)
+		}
+		return nil, err
+	}
+	kr.entries = entries
+	return key, nil
+}
+
+// Retire stops accepting the tokens signed with the key, the active key can't be retired
+func (kr *Keyring) Retire(ID string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	for i, e := range kr.entries {
+		if e.Key.ID != ID {
+			continue
+		}
+		if e.State == KeyStateActive {
+			return fmt.Errorf("key %s is active, rotate it first", ID)
+		}
+
+		// like Rotate, the key is only retired once the manifest says so
+		entries := append([]*KeyringEntry(nil), kr.entries...)
+		c := *e
+		c.State = KeyStateRetired
+		entries[i] = &c
+		if err := kr.persist(entries); err != nil {
+			return err
+		}
+		kr.entries = entries
+		if kr.dir != "" {
+			os.Remove(filepath.Join(kr.dir, ID+".pem"))
+		}
+		return nil
+	}
+	return ErrKeyNotFound
+}
+
+// persist writes the manifest of the entries, the caller must hold the lock
+func (kr *Keyring) persist(entries []*KeyringEntry) error {
+	if kr.dir == "" {
+		return nil
+	}
+
+	m := keyringManifest{Keys: []keyringManifestEntry{}}
+	for _, e := range entries {
+		me := keyringManifestEntry{ID: e.Key.ID, State: e.State, CreatedAt: e.CreatedAt, DeactivatedAt: e.DeactivatedAt}
+		if e.Key.Method != nil {
+			me.Alg = e.Key.Method.Alg()
+		}
+		m.Keys = append(m.Keys, me)
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error while marshaling the keyring: %w", err)
+	}
+	if err := common.WriteFileAtomic(filepath.Join(kr.dir, keyringFileName), b, 0600); err != nil {
+		return fmt.Errorf("error while writing the keyring: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package usecase
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+func signTestToken(t *testing.T, j *JWT) string {
+	token, err := j.Sign(context.TODO(), &domain.Claims{StandardClaims: jwt.StandardClaims{Subject: "user-id", ExpiresAt: time.Now().Add(time.Minute).Unix()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestKeyringRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minaria-keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kr, err := LoadKeyring(dir, "ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := NewJWT(JWTOptions{Keyring: kr})
+	first, _ := kr.Active()
+	oldToken := signTestToken(t, j)
+
+	// another process, e.g. the keys command, rotates the keyring
+	other, err := LoadKeyring(dir, "ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := other.Rotate("EdDSA", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kr.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	active, _ := kr.Active()
+	assert.Equal(t, second.ID, active.ID)
+	assert.Len(t, j.KeySet().Keys, 2)
+
+	// the old tokens keep working, the new ones are signed with the new key
+	_, err = j.Verify(context.TODO(), oldToken)
+	assert.Nil(t, err)
+
+	newToken := signTestToken(t, j)
+	parsed, _, _ := new(jwt.Parser).ParseUnverified(newToken, &domain.Claims{})
+	assert.Equal(t, second.ID, parsed.Header["kid"])
+	_, err = j.Verify(context.TODO(), newToken)
+	assert.Nil(t, err)
+
+	assert.NotNil(t, kr.Retire(second.ID))
+	if err := kr.Retire(first.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = j.Verify(context.TODO(), oldToken)
+	assert.Equal(t, domain.ErrInvalidToken, err)
+	assert.Len(t, j.KeySet().Keys, 1)
+
+	// retiring survives a reload
+	if err := other.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	_, ok := other.Get(first.ID)
+	assert.False(t, ok)
+}
+
+func TestKeyringRotateRetiresOldKeys(t *testing.T) {
+	first, _ := GenerateSigningKey("ES256")
+	kr := NewKeyring(first)
+
+	second, err := kr.Rotate("ES256", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ok := kr.Get(first.ID)
+	assert.True(t, ok)
+
+	// first was deactivated just now, it's not old enough to be retired
+	if _, err := kr.Rotate("ES256", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	_, ok = kr.Get(first.ID)
+	assert.True(t, ok)
+
+	if _, err := kr.Rotate("ES256", time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	_, ok = kr.Get(first.ID)
+	assert.False(t, ok)
+	_, ok = kr.Get(second.ID)
+	assert.False(t, ok)
+}
+
+// a rotation which can't be written leaves the keyring as it was
+func TestKeyringRotateFailsWhole(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minaria-keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kr, err := LoadKeyring(dir, "ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := kr.Active()
+
+	// the manifest can't be replaced by a file anymore
+	manifest := filepath.Join(dir, keyringFileName)
+	if err := os.Remove(manifest); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(manifest, 0700); err != nil {
+		t.Fatal(err)
+	}
+	_, err = kr.Rotate("ES256", 0)
+	assert.NotNil(t, err)
+
+	active, _ := kr.Active()
+	assert.Equal(t, first.ID, active.ID)
+	entries := kr.Entries()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, KeyStateActive, entries[0].State)
+	}
+	keys, _ := filepath.Glob(filepath.Join(dir, "*.pem"))
+	assert.Len(t, keys, 1)
+}
+
+func TestKeyringPreviousHMACKeys(t *testing.T) {
+	old := NewJWT(JWTOptions{Key: NewHMACSigningKey("", []byte("old secret"))})
+	oldToken := signTestToken(t, old)
+
+	j := NewJWT(JWTOptions{Keyring: NewKeyring(
+		NewHMACSigningKey("", []byte("new secret")),
+		NewHMACSigningKey("", []byte("old secret")),
+	)})
+
+	_, err := j.Verify(context.TODO(), oldToken)
+	assert.Nil(t, err)
+	_, err = old.Verify(context.TODO(), signTestToken(t, j))
+	assert.Equal(t, domain.ErrInvalidToken, err)
+}
@@ -0,0 +1,157 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vahidmostofi/minaria/domain"
+	"github.com/vahidmostofi/minaria/repositories"
+)
+
+// LockoutPolicy decides how long the logins of a key are refused after its failed attempts
+type LockoutPolicy struct {
+	// the failures which are allowed without a delay
+	Threshold int
+
+	// the delay after the first failure beyond Threshold, it doubles with each further failure
+	BaseDelay time.Duration
+
+	// the failures after which the logins are refused for LockoutDuration, 0 disables the lockout
+	LockoutAttempts int
+	LockoutDuration time.Duration
+
+	// the failures are forgotten once this long passes without another failure
+	Window time.Duration
+}
+
+// DefaultAccountLockout is the lockout policy of the accounts, the accounts
+// are identified by the email address so the unknown ones are locked the same way
+var DefaultAccountLockout = LockoutPolicy{
+	Threshold:       5,
+	BaseDelay:       time.Second,
+	LockoutAttempts: 10,
+	LockoutDuration: 15 * time.Minute,
+	Window:          time.Hour,
+}
+
+// DefaultIPLockout is the lockout policy of the client addresses, it's looser
+// than the accounts' as many users can share an address
+var DefaultIPLockout = LockoutPolicy{
+	Threshold:       20,
+	BaseDelay:       time.Second,
+	LockoutAttempts: 100,
+	LockoutDuration: 15 * time.Minute,
+	Window:          time.Hour,
+}
+
+// retryAfter returns how long the logins are refused for after the failures, and
+// whether it's the lockout rather than the backoff
+func (p *LockoutPolicy) retryAfter(f *domain.LoginFailures, now time.Time) (time.Duration, bool) {
+	if p.LockoutAttempts > 0 && f.Count >= p.LockoutAttempts {
+		return f.LastFailedAt.Add(p.LockoutDuration).Sub(now), true
+	}
+	if f.Count < p.Threshold || p.BaseDelay <= 0 {
+		return 0, false
+	}
+
+	// the shift is bounded so the delay can't overflow
+	n := f.Count - p.Threshold
+	if n > 20 {
+		n = 20
+	}
+	delay := p.BaseDelay << uint(n)
+	if p.LockoutDuration > 0 && delay > p.LockoutDuration {
+		delay = p.LockoutDuration
+	}
+	return f.LastFailedAt.Add(delay).Sub(now), false
+}
+
+// accountLockoutKey takes the canonical email, with the exact local part policy
+// the addresses which differ in case are different accounts
+func accountLockoutKey(email string) string {
+	return "account:" + email
+}
+
+func ipLockoutKey(ip string) string {
+	return "ip:" + ip
+}
+
+// checkLockout returns a *domain.LockoutError if the account or the client
+// address of the context has to wait before the next login attempt, without
+// an email address only the client address is checked
+func (uc *User) checkLockout(ctx context.Context, email string) error {
+	now := time.Now()
+
+	if email != "" {
+		f, err := uc.lac.Get(ctx, accountLockoutKey(email))
+		if err != nil {
+			return fmt.Errorf("error while getting the failed login attempts: %w", err)
+		}
+		if d, locked := uc.accountLockout.retryAfter(f, now); d > 0 {
+			if locked {
+				return &domain.LockoutError{Err: domain.ErrAccountLocked, RetryAfter: d}
+			}
+			return &domain.LockoutError{Err: domain.ErrLoginThrottled, RetryAfter: d}
+		}
+	}
+
+	ip := domain.ClientIPFromContext(ctx)
+	if ip == "" {
+		return nil
+	}
+	f, err := uc.lac.Get(ctx, ipLockoutKey(ip))
+	if err != nil {
+		return fmt.Errorf("error while getting the failed login attempts: %w", err)
+	}
+	// an address is only throttled, a locked resource would point at the account
+	if d, _ := uc.ipLockout.retryAfter(f, now); d > 0 {
+		return &domain.LockoutError{Err: domain.ErrLoginThrottled, RetryAfter: d}
+	}
+	return nil
+}
+
+// loginFailed records a failed attempt of the account and the client address
+// of the context, the login fails anyway so the errors are only logged
+func (uc *User) loginFailed(ctx context.Context, email string) {
+	if email != "" {
+		f, err := uc.lac.Fail(ctx, accountLockoutKey(email), uc.accountLockout.Window)
+		if err != nil {
+			uc.l.Errorf("Error while recording a failed login attempt: %s.", err.Error())
+		} else if f.Count == uc.accountLockout.LockoutAttempts {
+			uc.l.Warnf("An account is locked after %d failed login attempts.", f.Count)
+		}
+	}
+
+	if ip := domain.ClientIPFromContext(ctx); ip != "" {
+		f, err := uc.lac.Fail(ctx, ipLockoutKey(ip), uc.ipLockout.Window)
+		if err != nil {
+			uc.l.Errorf("Error while recording a failed login attempt: %s.", err.Error())
+		} else if f.Count == uc.ipLockout.LockoutAttempts {
+			uc.l.Warnf("The address %s is throttled after %d failed login attempts.", ip, f.Count)
+		}
+	}
+}
+
+// loginSucceeded forgets the failures of the account, the client address keeps
+// its failures as one valid account mustn't let it guess the others' passwords
+func (uc *User) loginSucceeded(ctx context.Context, email string) {
+	if err := uc.lac.Reset(ctx, accountLockoutKey(email)); err != nil {
+		uc.l.Errorf("Error while resetting the failed login attempts: %s.", err.Error())
+	}
+}
+
+func (uc *User) UnlockAccount(ctx context.Context, userID string) error {
+	user, err := uc.r.GetByID(ctx, userID)
+	if err == repositories.ErrNoUserFound {
+		return domain.ErrNoUserFound
+	} else if err != nil {
+		return fmt.Errorf("error while getting the user: %w", err)
+	}
+
+	if err := uc.lac.Reset(ctx, accountLockoutKey(user.CanonicalEmail)); err != nil {
+		return fmt.Errorf("error while resetting the failed login attempts: %w", err)
+	}
+	uc.l.Infof("Account of user %s is unlocked.", user.ID)
+	return nil
+}
@@ -0,0 +1,253 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-openapi/strfmt"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+	"github.com/vahidmostofi/minaria/repositories"
+)
+
+func TestLockoutPolicy(t *testing.T) {
+	p := &LockoutPolicy{Threshold: 3, BaseDelay: time.Second, LockoutAttempts: 6, LockoutDuration: time.Minute}
+	now := time.Now()
+
+	tests := []struct {
+		count  int
+		wait   time.Duration
+		locked bool
+	}{
+		{0, 0, false},
+		{2, 0, false},
+		{3, time.Second, false},
+		{4, 2 * time.Second, false},
+		{5, 4 * time.Second, false},
+		{6, time.Minute, true},
+		{60, time.Minute, true},
+	}
+	for _, tt := range tests {
+		d, locked := p.retryAfter(&domain.LoginFailures{Count: tt.count, LastFailedAt: now}, now)
+		assert.Equal(t, tt.wait, d, tt.count)
+		assert.Equal(t, tt.locked, locked, tt.count)
+	}
+
+	// the backoff is capped by the lockout duration and passes with time
+	p.LockoutAttempts = 0
+	d, locked := p.retryAfter(&domain.LoginFailures{Count: 1000, LastFailedAt: now}, now)
+	assert.Equal(t, time.Minute, d)
+	assert.False(t, locked)
+	d, _ = p.retryAfter(&domain.LoginFailures{Count: 4, LastFailedAt: now.Add(-time.Hour)}, now)
+	assert.True(t, d <= 0)
+}
+
+func TestLoginLockout(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	ur := getUserRepository(t)
+	lac, _ := repositories.NewLoginAttemptCounter(repositories.InMemoryKind, nil)
+	uc := NewUser(l, ur, UserOptions{
+		LoginAttemptCounter: lac,
+		AccountLockout:      &LockoutPolicy{Threshold: 2, BaseDelay: time.Hour, LockoutAttempts: 3, LockoutDuration: time.Hour, Window: time.Hour},
+		IPLockout:           &LockoutPolicy{Threshold: 6, BaseDelay: time.Hour, Window: time.Hour},
+	})
+	ctx := domain.WithClientIP(context.TODO(), "192.0.2.1")
+
+	login := func(email, password string) error {
+		_, err := uc.Login(ctx, &domain.LoginDTO{Identifier: email, Password: strfmt.Password(password)})
+		return err
+	}
+	refusedWith := func(err, want error) bool {
+		var lerr *domain.LockoutError
+		return errors.As(err, &lerr) && lerr.Err == want && lerr.RetryAfter > 59*time.Minute
+	}
+
+	// a successful login forgets the failures
+	assert.Equal(t, domain.ErrEmailPasswordNotMatch, login("jack@gmail.com", "wrong"))
+	assert.Nil(t, login("jack@gmail.com", "1234567"))
+
+	assert.Equal(t, domain.ErrEmailPasswordNotMatch, login("jack@gmail.com", "wrong"))
+	assert.Equal(t, domain.ErrEmailPasswordNotMatch, login("jack@gmail.com", "wrong"))
+	err := login("jack@gmail.com", "1234567")
+	assert.True(t, refusedWith(err, domain.ErrLoginThrottled), err)
+	assert.True(t, errors.Is(err, domain.ErrLoginThrottled))
+
+	// the unknown accounts are throttled the same way
+	assert.Equal(t, domain.ErrEmailPasswordNotMatch, login("nobody@gmail.com", "wrong"))
+	assert.Equal(t, domain.ErrEmailPasswordNotMatch, login("nobody@gmail.com", "wrong"))
+	assert.True(t, refusedWith(login("nobody@gmail.com", "wrong"), domain.ErrLoginThrottled))
+
+	lac.Fail(ctx, accountLockoutKey("jack@gmail.com"), time.Hour)
+	assert.True(t, refusedWith(login("jack@gmail.com", "1234567"), domain.ErrAccountLocked))
+	// the username shares the lockout of the email address
+	assert.True(t, refusedWith(login("jack", "1234567"), domain.ErrAccountLocked))
+
+	assert.Equal(t, domain.ErrNoUserFound, uc.UnlockAccount(ctx, "no-such-id"))
+	jack, _ := ur.GetByEmail(ctx, "jack@gmail.com")
+	assert.Nil(t, uc.UnlockAccount(ctx, jack.ID))
+	assert.Nil(t, login("jack@gmail.com", "1234567"))
+
+	// the successful logins didn't forget the failures of the address
+	assert.Equal(t, domain.ErrEmailPasswordNotMatch, login("john@gmail.com", "wrong"))
+	err = login("jack@gmail.com", "1234567")
+	assert.True(t, refusedWith(err, domain.ErrLoginThrottled), err)
+	ctx = domain.WithClientIP(context.TODO(), "192.0.2.2")
+	assert.Nil(t, login("jack@gmail.com", "1234567"))
+}
+
+// the failed logins of an email before it was registered don't fail the registration
+func TestRegisterLockedEmail(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	lac, _ := repositories.NewLoginAttemptCounter(repositories.InMemoryKind, nil)
+	uc := NewUser(l, getUserRepository(t), UserOptions{
+		LoginAttemptCounter: lac,
+		AccountLockout:      &LockoutPolicy{LockoutAttempts: 1, LockoutDuration: time.Hour, Window: time.Hour},
+	})
+	ctx := context.TODO()
+
+	lac.Fail(ctx, accountLockoutKey("gholi@gmail.com"), time.Hour)
+	_, err := uc.Login(ctx, &domain.LoginDTO{Identifier: "gholi@gmail.com", Password: "password"})
+	var lerr *domain.LockoutError
+	assert.True(t, errors.As(err, &lerr), err)
+
+	res, err := uc.Create(ctx, &domain.RegisterDTO{Username: "gholi", Email: "gholi@gmail.com", Password: "password", RepeatPassword: "password"})
+	if assert.Nil(t, err) {
+		assert.NotEmpty(t, res.Token)
+		assert.NotEmpty(t, res.RefreshToken)
+	}
+}
+
+// the codes of the second factor can't be guessed past the lockout by logging in again
+func TestMFALockout(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	lac, _ := repositories.NewLoginAttemptCounter(repositories.InMemoryKind, nil)
+	uc := NewUser(l, getUserRepository(t), UserOptions{
+		LoginAttemptCounter: lac,
+		AccountLockout:      &LockoutPolicy{LockoutAttempts: 3, LockoutDuration: time.Hour, Window: time.Hour},
+	})
+	ctx := context.TODO()
+	ld := &domain.LoginDTO{Identifier: "jack", Password: "1234567"}
+
+	session, err := uc.Login(ctx, ld)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, _ := uc.Verify(ctx, session.Token)
+	enrollment, err := uc.EnrollTOTP(ctx, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := totpEncoding.DecodeString(enrollment.Secret)
+	step := totpStep(time.Now())
+	if _, err := uc.ConfirmTOTP(ctx, claims, &domain.MFACodeDTO{Code: hotp(key, uint64(step), totpDigits)}); err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(vd *domain.MFAVerifyDTO) error {
+		res, err := uc.Login(ctx, ld)
+		if err != nil {
+			return err
+		}
+		vd.MFAToken = res.MFAToken
+		_, err = uc.VerifyMFA(ctx, vd)
+		return err
+	}
+
+	// a verified code forgets the failures, the password alone doesn't
+	assert.Equal(t, domain.ErrInvalidMFACode, verify(&domain.MFAVerifyDTO{Code: "000000"}))
+	assert.Equal(t, domain.ErrInvalidMFACode, verify(&domain.MFAVerifyDTO{RecoveryCode: "aaaa-aaaa-aaaa-aaaa"}))
+	assert.Nil(t, verify(&domain.MFAVerifyDTO{Code: hotp(key, uint64(step+1), totpDigits)}))
+
+	assert.Equal(t, domain.ErrInvalidMFACode, verify(&domain.MFAVerifyDTO{Code: "000000"}))
+	assert.Equal(t, domain.ErrInvalidMFACode, verify(&domain.MFAVerifyDTO{Code: "000000"}))
+	res, err := uc.Login(ctx, ld)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, domain.ErrInvalidMFACode, verify(&domain.MFAVerifyDTO{RecoveryCode: "aaaa-aaaa-aaaa-aaaa"}))
+
+	// the locked account can't log in, nor use a challenge it got before
+	var lerr *domain.LockoutError
+	err = verify(&domain.MFAVerifyDTO{Code: "000000"})
+	assert.True(t, errors.As(err, &lerr) && lerr.Err == domain.ErrAccountLocked, err)
+	_, err = uc.VerifyMFA(ctx, &domain.MFAVerifyDTO{MFAToken: res.MFAToken, Code: hotp(key, uint64(step-1), totpDigits)})
+	assert.True(t, errors.As(err, &lerr) && lerr.Err == domain.ErrAccountLocked, err)
+}
+
+// a stolen access token can't be used to guess the password at the email change
+func TestEmailChangeLockout(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	ur := getUserRepository(t)
+	lac, _ := repositories.NewLoginAttemptCounter(repositories.InMemoryKind, nil)
+	uc := NewUser(l, ur, UserOptions{
+		LoginAttemptCounter: lac,
+		AccountLockout:      &LockoutPolicy{LockoutAttempts: 3, LockoutDuration: time.Hour, Window: time.Hour},
+	})
+	ctx := context.TODO()
+	u, _ := ur.GetByEmail(ctx, "jack@gmail.com")
+	claims := &domain.Claims{StandardClaims: jwt.StandardClaims{Subject: u.ID}}
+
+	for i := 0; i < 3; i++ {
+		_, err := uc.UpdateProfile(ctx, claims, 0, &domain.UpdateProfileDTO{Email: "jack@provider.net", CurrentPassword: "wrong"})
+		assert.Equal(t, domain.ErrCurrentPasswordNotMatch, err)
+	}
+
+	// the right password is refused now, and so is the login
+	var lerr *domain.LockoutError
+	_, err := uc.UpdateProfile(ctx, claims, 0, &domain.UpdateProfileDTO{Email: "jack@provider.net", CurrentPassword: "1234567"})
+	if assert.True(t, errors.As(err, &lerr)) {
+		assert.Equal(t, domain.ErrAccountLocked, lerr.Err)
+	}
+	_, err = uc.Login(ctx, &domain.LoginDTO{Identifier: "jack@gmail.com", Password: "1234567"})
+	assert.True(t, errors.As(err, &lerr))
+}
+
+func TestChangePasswordLockout(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	ur := getUserRepository(t)
+	lac, _ := repositories.NewLoginAttemptCounter(repositories.InMemoryKind, nil)
+	uc := NewUser(l, ur, UserOptions{
+		LoginAttemptCounter: lac,
+		AccountLockout:      &LockoutPolicy{LockoutAttempts: 3, LockoutDuration: time.Hour, Window: time.Hour},
+	})
+	ctx := context.TODO()
+	session, err := uc.Login(ctx, &domain.LoginDTO{Identifier: "jack@gmail.com", Password: "1234567"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := uc.Verify(ctx, session.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	change := func(current string) error {
+		_, err := uc.ChangePassword(ctx, claims, &domain.ChangePasswordDTO{CurrentPassword: strfmt.Password(current), Password: "7654321", RepeatPassword: "7654321"})
+		return err
+	}
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, domain.ErrCurrentPasswordNotMatch, change("wrong"))
+	}
+
+	// the right password is refused now, and so is the login
+	var lerr *domain.LockoutError
+	if assert.True(t, errors.As(change("1234567"), &lerr)) {
+		assert.Equal(t, domain.ErrAccountLocked, lerr.Err)
+	}
+	_, err = uc.Login(ctx, &domain.LoginDTO{Identifier: "jack@gmail.com", Password: "1234567"})
+	assert.True(t, errors.As(err, &lerr))
+}
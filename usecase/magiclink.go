@@ -0,0 +1,106 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/vahidmostofi/minaria/domain"
+	"github.com/vahidmostofi/minaria/repositories"
+)
+
+func (uc *User) SendMagicLink(ctx context.Context, md *domain.MagicLinkDTO) error {
+	// the links are refused like the passwords, the caller can't tell either,
+	// the account is locked by its canonical email like at Login
+	email, err := uc.canon.Email(md.Email.String())
+	if err != nil {
+		email = md.Email.String()
+	}
+	err = uc.checkLockout(ctx, email)
+	var lerr *domain.LockoutError
+	if errors.As(err, &lerr) {
+		uc.l.Infof("Login link refused: %s.", err.Error())
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	user, err := uc.getByEmail(ctx, md.Email.String())
+	if err == repositories.ErrNoUserFound {
+		// the caller must not be able to tell whether the email is registered
+		uc.l.Infof("Login link requested for an unknown email.")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error while getting the user: %w", err)
+	}
+
+	claims := &domain.Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   user.ID,
+			ExpiresAt: time.Now().Add(uc.magicExpiresAfter).Unix(),
+		},
+		Purpose: domain.MagicLinkPurpose,
+		Email:   user.Email,
+	}
+
+	token, err := uc.jwt.Sign(ctx, claims)
+	if err != nil {
+		return fmt.Errorf("error while signing the login link token: %w", err)
+	}
+
+	if err := uc.magicLinkSender.SendMagicLink(ctx, user, token); err != nil {
+		return fmt.Errorf("error while sending the login link: %w", err)
+	}
+	return nil
+}
+
+func (uc *User) LoginByMagicLink(ctx context.Context, ld *domain.MagicLinkLoginDTO) (*domain.JWTDTO, error) {
+	if err := uc.checkLockout(ctx, ""); err != nil {
+		return nil, err
+	}
+
+	claims, err := uc.jwt.VerifyPurpose(ctx, ld.Token, domain.MagicLinkPurpose)
+	if err == domain.ErrInvalidToken {
+		uc.loginFailed(ctx, "")
+		return nil, domain.ErrInvalidMagicLinkToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	// the link is single use, revoking it first keeps two concurrent clicks from both logging in
+	if err := uc.jwt.Revoke(ctx, claims); err != nil {
+		return nil, fmt.Errorf("error while revoking the login link token: %w", err)
+	}
+
+	user, err := uc.r.GetByID(ctx, claims.Subject)
+	if err == repositories.ErrNoUserFound {
+		return nil, domain.ErrInvalidMagicLinkToken
+	} else if err != nil {
+		return nil, fmt.Errorf("error while getting the user: %w", err)
+	}
+
+	// the link was sent to an address the user no longer has
+	if user.Email != claims.Email {
+		return nil, domain.ErrInvalidMagicLinkToken
+	}
+
+	// opening the link proves the ownership of the address as much as the verification link does
+	if !user.Verified {
+		if err := uc.r.MarkEmailVerified(ctx, user.ID); err != nil {
+			return nil, fmt.Errorf("error while marking the email as verified: %w", err)
+		}
+	}
+
+	mfa, err := uc.mfaEnabled(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa {
+		return uc.mfaChallenge(ctx, user)
+	}
+
+	return uc.issueTokens(ctx, user.ID, user.Username, uuid.New().String())
+}
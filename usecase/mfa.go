@@ -0,0 +1,231 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	qrcode "github.com/skip2/go-qrcode"
+	"github.com/vahidmostofi/minaria/domain"
+	"github.com/vahidmostofi/minaria/repositories"
+)
+
+// mfaEnabled reports whether the user has a confirmed second factor
+func (uc *User) mfaEnabled(ctx context.Context, userID string) (bool, error) {
+	c, err := uc.totpr.GetByUserID(ctx, userID)
+	if err == repositories.ErrNoTOTPFound {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("error while getting the totp credential: %w", err)
+	}
+	return c.Confirmed, nil
+}
+
+// mfaChallenge returns the mfa token which proves the password step of the login
+func (uc *User) mfaChallenge(ctx context.Context, u *domain.User) (*domain.JWTDTO, error) {
+	claims := &domain.Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   u.ID,
+			ExpiresAt: time.Now().Add(uc.mfaExpiresAfter).Unix(),
+		},
+		Purpose: domain.MFARequiredPurpose,
+	}
+
+	token, err := uc.jwt.Sign(ctx, claims)
+	if err != nil {
+		return nil, fmt.Errorf("error while signing the mfa token: %w", err)
+	}
+
+	return &domain.JWTDTO{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int64(uc.mfaExpiresAfter.Seconds()),
+	}, nil
+}
+
+func (uc *User) VerifyMFA(ctx context.Context, vd *domain.MFAVerifyDTO) (*domain.JWTDTO, error) {
+	claims, err := uc.jwt.VerifyPurpose(ctx, vd.MFAToken, domain.MFARequiredPurpose)
+	if err == domain.ErrInvalidToken {
+		return nil, domain.ErrInvalidMFAToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	// the token is single use whether the code is right or not
+	if err := uc.jwt.Revoke(ctx, claims); err != nil {
+		return nil, fmt.Errorf("error while revoking the mfa token: %w", err)
+	}
+
+	user, err := uc.r.GetByID(ctx, claims.Subject)
+	if err == repositories.ErrNoUserFound {
+		return nil, domain.ErrInvalidMFAToken
+	} else if err != nil {
+		return nil, fmt.Errorf("error while getting the user: %w", err)
+	}
+
+	c, err := uc.totpr.GetByUserID(ctx, user.ID)
+	if err == repositories.ErrNoTOTPFound {
+		return nil, domain.ErrInvalidMFAToken
+	} else if err != nil {
+		return nil, fmt.Errorf("error while getting the totp credential: %w", err)
+	}
+	if !c.Confirmed {
+		return nil, domain.ErrInvalidMFAToken
+	}
+
+	// a wrong code is a failed login of the account like a wrong password, the
+	// password step doesn't forget the failures so the codes can't be guessed
+	// past the lockout by logging in again
+	if err := uc.checkLockout(ctx, user.CanonicalEmail); err != nil {
+		return nil, err
+	}
+
+	if vd.Code != "" {
+		step, ok := validateTOTP(c.Secret, vd.Code, time.Now(), c.LastUsedStep)
+		if !ok {
+			uc.loginFailed(ctx, user.CanonicalEmail)
+			return nil, domain.ErrInvalidMFACode
+		}
+		err = uc.totpr.UseStep(ctx, user.ID, step)
+		if err == repositories.ErrTOTPStepUsed {
+			// another login used the same code in the meantime
+			uc.loginFailed(ctx, user.CanonicalEmail)
+			return nil, domain.ErrInvalidMFACode
+		} else if err != nil {
+			return nil, fmt.Errorf("error while storing the used totp step: %w", err)
+		}
+	} else {
+		err = uc.rcr.Consume(ctx, user.ID, hashRecoveryCode(vd.RecoveryCode))
+		if err == repositories.ErrNoRecoveryCodeFound {
+			uc.loginFailed(ctx, user.CanonicalEmail)
+			return nil, domain.ErrInvalidMFACode
+		} else if err != nil {
+			return nil, fmt.Errorf("error while consuming the recovery code: %w", err)
+		}
+		uc.l.Infof("User %s logged in with a recovery code.", user.ID)
+	}
+	uc.loginSucceeded(ctx, user.CanonicalEmail)
+
+	return uc.issueTokens(ctx, user.ID, user.Username, uuid.New().String())
+}
+
+func (uc *User) EnrollTOTP(ctx context.Context, claims *domain.Claims) (*domain.TOTPEnrollmentDTO, error) {
+	user, err := uc.r.GetByID(ctx, claims.Subject)
+	if err == repositories.ErrNoUserFound {
+		return nil, domain.ErrNoUserFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error while getting the user: %w", err)
+	}
+
+	enabled, err := uc.mfaEnabled(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, domain.ErrMFAAlreadyEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("error while generating the totp secret: %w", err)
+	}
+
+	// a pending enrollment is replaced, only the last scanned secret can be confirmed
+	err = uc.totpr.Store(ctx, &domain.TOTPCredential{UserID: user.ID, Secret: secret, CreatedAt: time.Now()})
+	if err != nil {
+		return nil, fmt.Errorf("error while storing the totp credential: %w", err)
+	}
+
+	uri := totpURI(uc.totpIssuer, user.Email, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		return nil, fmt.Errorf("error while encoding the qr code: %w", err)
+	}
+
+	return &domain.TOTPEnrollmentDTO{Secret: secret, URI: uri, QRCode: png}, nil
+}
+
+func (uc *User) ConfirmTOTP(ctx context.Context, claims *domain.Claims, cd *domain.MFACodeDTO) (*domain.RecoveryCodesDTO, error) {
+	c, err := uc.totpr.GetByUserID(ctx, claims.Subject)
+	if err == repositories.ErrNoTOTPFound {
+		return nil, domain.ErrNoMFAEnrollment
+	} else if err != nil {
+		return nil, fmt.Errorf("error while getting the totp credential: %w", err)
+	}
+	if c.Confirmed {
+		return nil, domain.ErrMFAAlreadyEnabled
+	}
+
+	step, ok := validateTOTP(c.Secret, cd.Code, time.Now(), 0)
+	if !ok {
+		return nil, domain.ErrInvalidMFACode
+	}
+
+	// the codes are stored first, an enabled second factor without them could lock the user out
+	res, err := uc.replaceRecoveryCodes(ctx, c.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := uc.totpr.Confirm(ctx, c.UserID, step); err != nil {
+		return nil, fmt.Errorf("error while confirming the totp credential: %w", err)
+	}
+	return res, nil
+}
+
+func (uc *User) RegenerateRecoveryCodes(ctx context.Context, claims *domain.Claims) (*domain.RecoveryCodesDTO, error) {
+	enabled, err := uc.mfaEnabled(ctx, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, domain.ErrMFANotEnabled
+	}
+	return uc.replaceRecoveryCodes(ctx, claims.Subject)
+}
+
+// replaceRecoveryCodes generates a new set of recovery codes, the old set is invalidated
+func (uc *User) replaceRecoveryCodes(ctx context.Context, userID string) (*domain.RecoveryCodesDTO, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, fmt.Errorf("error while generating the recovery codes: %w", err)
+		}
+		codes[i] = code
+		hashes[i] = hashRecoveryCode(code)
+	}
+
+	if err := uc.rcr.Replace(ctx, userID, hashes); err != nil {
+		return nil, fmt.Errorf("error while storing the recovery codes: %w", err)
+	}
+	return &domain.RecoveryCodesDTO{Codes: codes}, nil
+}
+
+// the number of the codes in a set of recovery codes
+const recoveryCodeCount = 10
+
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// generateRecoveryCode returns a code of 80 bits in groups of four characters, e.g. 2k4m-7xq3-fh6p-a5zt
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	s := recoveryCodeEncoding.EncodeToString(b)
+	return s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16], nil
+}
+
+// hashRecoveryCode returns the stored form of the code, the dashes, the spaces
+// and the case don't matter. Like the tokens the codes have enough entropy
+// that a fast hash is sufficient.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashToken(code)
+}
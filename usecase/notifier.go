@@ -0,0 +1,90 @@
+package usecase
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// logNotifier writes the account messages to the log instead of sending them,
+// the tokens are only logged at debug level
+type logNotifier struct {
+	l *log.Logger
+}
+
+// NewLogNotifier returns a notifier which only logs, it's meant for development
+func NewLogNotifier(l *log.Logger) domain.AccountNotifier {
+	return &logNotifier{l: l}
+}
+
+func (n *logNotifier) SendPasswordReset(ctx context.Context, u *domain.User, token string) error {
+	n.l.Infof("Password reset requested for user %s.", u.ID)
+	n.l.Debugf("Password reset token for %s: %s", u.Email, token)
+	return nil
+}
+
+func (n *logNotifier) SendEmailVerification(ctx context.Context, u *domain.User, token string) error {
+	n.l.Infof("Email verification requested for user %s.", u.ID)
+	n.l.Debugf("Email verification token for %s: %s", u.Email, token)
+	return nil
+}
+
+func (n *logNotifier) SendEmailChanged(ctx context.Context, u *domain.User, newEmail string) error {
+	n.l.Infof("Email address of user %s is changed.", u.ID)
+	return nil
+}
+
+func (n *logNotifier) SendMagicLink(ctx context.Context, u *domain.User, token string) error {
+	n.l.Infof("Login link requested for user %s.", u.ID)
+	n.l.Debugf("Login link token for %s: %s", u.Email, token)
+	return nil
+}
+
+// mailNotifier sends the account messages as the templated emails
+type mailNotifier struct {
+	m         domain.Mailer
+	publicURL string
+}
+
+// NewMailNotifier returns a notifier which sends emails, the links in them
+// point to publicURL, the address the service is reachable at
+func NewMailNotifier(m domain.Mailer, publicURL string) domain.AccountNotifier {
+	return &mailNotifier{m: m, publicURL: strings.TrimRight(publicURL, "/")}
+}
+
+func (n *mailNotifier) SendPasswordReset(ctx context.Context, u *domain.User, token string) error {
+	// the link opens the page which asks for the new password and posts it to /auth/password/reset
+	return n.m.Send(ctx, u.Email, domain.PasswordResetTemplate, &domain.AccountMailData{
+		Username: u.Username,
+		Link:     n.publicURL + "/password/reset?token=" + url.QueryEscape(token),
+		Token:    token,
+	})
+}
+
+func (n *mailNotifier) SendEmailVerification(ctx context.Context, u *domain.User, token string) error {
+	return n.m.Send(ctx, u.Email, domain.EmailVerificationTemplate, &domain.AccountMailData{
+		Username: u.Username,
+		Link:     n.publicURL + "/auth/verify?token=" + url.QueryEscape(token),
+		Token:    token,
+	})
+}
+
+func (n *mailNotifier) SendEmailChanged(ctx context.Context, u *domain.User, newEmail string) error {
+	// the notice goes to the old address, its owner may not be the one who changed it
+	return n.m.Send(ctx, u.Email, domain.EmailChangedTemplate, &domain.AccountMailData{
+		Username: u.Username,
+		Email:    newEmail,
+	})
+}
+
+func (n *mailNotifier) SendMagicLink(ctx context.Context, u *domain.User, token string) error {
+	// the link opens the page which posts the token to /auth/magic-link/login
+	return n.m.Send(ctx, u.Email, domain.MagicLinkTemplate, &domain.AccountMailData{
+		Username: u.Username,
+		Link:     n.publicURL + "/login/magic?token=" + url.QueryEscape(token),
+		Token:    token,
+	})
+}
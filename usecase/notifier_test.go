@@ -0,0 +1,44 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+type capturingMailer struct {
+	to       string
+	template string
+	data     *domain.AccountMailData
+}
+
+func (m *capturingMailer) Send(ctx context.Context, to string, template string, data interface{}) error {
+	m.to, m.template, m.data = to, template, data.(*domain.AccountMailData)
+	return nil
+}
+
+func TestMailNotifier(t *testing.T) {
+	m := &capturingMailer{}
+	n := NewMailNotifier(m, "https://minaria.io/")
+	u := &domain.User{Username: "jack", Email: "jack@gmail.com"}
+
+	assert.Nil(t, n.SendEmailVerification(context.TODO(), u, "a+b"))
+	assert.Equal(t, "jack@gmail.com", m.to)
+	assert.Equal(t, domain.EmailVerificationTemplate, m.template)
+	assert.Equal(t, &domain.AccountMailData{Username: "jack", Link: "https://minaria.io/auth/verify?token=a%2Bb", Token: "a+b"}, m.data)
+
+	assert.Nil(t, n.SendPasswordReset(context.TODO(), u, "token"))
+	assert.Equal(t, domain.PasswordResetTemplate, m.template)
+	assert.Equal(t, "https://minaria.io/password/reset?token=token", m.data.Link)
+
+	assert.Nil(t, n.SendMagicLink(context.TODO(), u, "token"))
+	assert.Equal(t, domain.MagicLinkTemplate, m.template)
+	assert.Equal(t, "https://minaria.io/login/magic?token=token", m.data.Link)
+
+	assert.Nil(t, n.SendEmailChanged(context.TODO(), u, "jack@provider.net"))
+	assert.Equal(t, "jack@gmail.com", m.to)
+	assert.Equal(t, domain.EmailChangedTemplate, m.template)
+	assert.Equal(t, &domain.AccountMailData{Username: "jack", Email: "jack@provider.net"}, m.data)
+}
@@ -0,0 +1,221 @@
+package usecase
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vahidmostofi/minaria/domain"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const Argon2idAlg = "argon2id"
+
+const BcryptAlg = "bcrypt"
+
+var ErrLegacyHashCantHash = fmt.Errorf("the legacy hasher can only verify")
+
+// Argon2idHasher hashes the passwords with argon2id, the defaults follow the
+// OWASP recommendation of 19 MiB of memory and 2 iterations
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{Memory: 19 * 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+}
+
+// Hash returns $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<hash>
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("error while generating the salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != Argon2idAlg {
+		return false, false, domain.ErrUnsupportedPasswordHash
+	}
+
+	var (
+		version, memory, iterations uint32
+		parallelism                 uint8
+	)
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, domain.ErrUnsupportedPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+		return false, false, domain.ErrUnsupportedPasswordHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, domain.ErrUnsupportedPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, domain.ErrUnsupportedPasswordHash
+	}
+
+	other := argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, false, nil
+	}
+
+	needsRehash := memory != h.Memory || iterations != h.Iterations || parallelism != h.Parallelism ||
+		uint32(len(salt)) != h.SaltLength || uint32(len(key)) != h.KeyLength
+	return true, needsRehash, nil
+}
+
+// BcryptHasher hashes the passwords with bcrypt, its modular crypt format
+// $2a$<cost>$<salt and hash> already carries the cost
+type BcryptHasher struct {
+	Cost int
+}
+
+func NewBcryptHasher() *BcryptHasher {
+	return &BcryptHasher{Cost: bcrypt.DefaultCost}
+}
+
+// bcryptMaxPasswordBytes is where bcrypt stops reading the password, the
+// rest would be ignored without an error
+const bcryptMaxPasswordBytes = 72
+
+// Hash rejects the passwords bcrypt would truncate with domain.ErrPasswordTooLong
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	if len(password) > bcryptMaxPasswordBytes {
+		return "", domain.ErrPasswordTooLong
+	}
+	b, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", fmt.Errorf("error while hashing the password: %w", err)
+	}
+	return string(b), nil
+}
+
+func (h *BcryptHasher) Verify(password, encoded string) (bool, bool, error) {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return false, false, domain.ErrUnsupportedPasswordHash
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, false, nil
+	} else if err != nil {
+		return false, false, fmt.Errorf("error while comparing the password: %w", err)
+	}
+
+	return true, cost != h.Cost, nil
+}
+
+// LegacyHasher verifies the unsalted hex digests the passwords used to be
+// stored as, every match needs a rehash and it can't hash new passwords
+type LegacyHasher struct {
+	HashMethod crypto.Hash
+}
+
+func NewLegacyHasher(hashMethod crypto.Hash) *LegacyHasher {
+	return &LegacyHasher{HashMethod: hashMethod}
+}
+
+func (h *LegacyHasher) Hash(password string) (string, error) {
+	return "", ErrLegacyHashCantHash
+}
+
+func (h *LegacyHasher) Verify(password, encoded string) (bool, bool, error) {
+	digest, err := hex.DecodeString(encoded)
+	if err != nil || len(digest) != h.HashMethod.Size() {
+		return false, false, domain.ErrUnsupportedPasswordHash
+	}
+
+	hh := h.HashMethod.New()
+	hh.Write([]byte(password))
+	if subtle.ConstantTimeCompare(hh.Sum(nil), digest) != 1 {
+		return false, false, nil
+	}
+	return true, true, nil
+}
+
+// hashAlgorithm returns the identifier of the modular crypt format of encoded,
+// like argon2id or 2a, and an empty string for the legacy digests
+func hashAlgorithm(encoded string) string {
+	parts := strings.SplitN(encoded, "$", 3)
+	if len(parts) != 3 || parts[0] != "" {
+		return ""
+	}
+	return parts[1]
+}
+
+type passwordHasher struct {
+	preferred domain.PasswordHasher
+	fallbacks []domain.PasswordHasher
+}
+
+// NewPasswordHasher returns a hasher which hashes with preferred and verifies with
+// preferred or any of the fallbacks, a hash verified by a fallback needs a rehash.
+func NewPasswordHasher(preferred domain.PasswordHasher, fallbacks ...domain.PasswordHasher) domain.PasswordHasher {
+	return &passwordHasher{preferred: preferred, fallbacks: fallbacks}
+}
+
+// NewPasswordHasherForAlg returns a hasher which hashes with alg, argon2id or bcrypt,
+// and verifies every supported format
+func NewPasswordHasherForAlg(alg string) (domain.PasswordHasher, error) {
+	switch alg {
+	case "", Argon2idAlg:
+		return NewPasswordHasher(NewArgon2idHasher(), NewBcryptHasher(), NewLegacyHasher(crypto.SHA256)), nil
+	case BcryptAlg:
+		return NewPasswordHasher(NewBcryptHasher(), NewArgon2idHasher(), NewLegacyHasher(crypto.SHA256)), nil
+	}
+	return nil, fmt.Errorf("unsupported password hash algorithm: %s", alg)
+}
+
+// Hash rejects the passwords longer than domain.MaxPasswordLength, whatever the validation let through
+func (ph *passwordHasher) Hash(password string) (string, error) {
+	if utf8.RuneCountInString(password) > domain.MaxPasswordLength {
+		return "", domain.ErrPasswordTooLong
+	}
+	return ph.preferred.Hash(password)
+}
+
+// Verify doesn't hash the passwords longer than domain.MaxPasswordLength, they
+// can't have been hashed so they don't match
+func (ph *passwordHasher) Verify(password, encoded string) (bool, bool, error) {
+	if utf8.RuneCountInString(password) > domain.MaxPasswordLength {
+		return false, false, nil
+	}
+
+	match, needsRehash, err := ph.preferred.Verify(password, encoded)
+	if err != domain.ErrUnsupportedPasswordHash {
+		return match, needsRehash, err
+	}
+
+	for _, h := range ph.fallbacks {
+		match, _, err := h.Verify(password, encoded)
+		if err == domain.ErrUnsupportedPasswordHash {
+			continue
+		}
+		return match, match, err
+	}
+
+	return false, false, domain.ErrUnsupportedPasswordHash
+}
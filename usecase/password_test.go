@@ -0,0 +1,130 @@
+package usecase
+
+import (
+	"context"
+	"crypto"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordHashers(t *testing.T) {
+	tests := []struct {
+		name   string
+		hasher domain.PasswordHasher
+		prefix string
+	}{
+		{name: "argon2id", hasher: NewArgon2idHasher(), prefix: "$argon2id$v=19$m=19456,t=2,p=1$"},
+		{name: "bcrypt", hasher: &BcryptHasher{Cost: bcrypt.MinCost}, prefix: "$2a$04$"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.hasher.Hash("password")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.True(t, strings.HasPrefix(encoded, tt.prefix), encoded)
+
+			other, _ := tt.hasher.Hash("password")
+			assert.NotEqual(t, encoded, other, "the hashes must be salted")
+
+			match, needsRehash, err := tt.hasher.Verify("password", encoded)
+			assert.Nil(t, err)
+			assert.True(t, match)
+			assert.False(t, needsRehash)
+
+			match, _, err = tt.hasher.Verify("wrong password", encoded)
+			assert.Nil(t, err)
+			assert.False(t, match)
+
+			_, _, err = tt.hasher.Verify("password", "8bb0cf6eb9b17d0f7d22b456f121257dc1254e1f01665370476383ea776df414")
+			assert.Equal(t, domain.ErrUnsupportedPasswordHash, err)
+		})
+	}
+}
+
+func TestPasswordHasherNeedsRehash(t *testing.T) {
+	weak := &Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	weakHash, _ := weak.Hash("password")
+	bcryptHash, _ := (&BcryptHasher{Cost: bcrypt.MinCost}).Hash("password")
+	legacyHash := "8bb0cf6eb9b17d0f7d22b456f121257dc1254e1f01665370476383ea776df414" // SHA256 of 1234567
+
+	ph := NewPasswordHasher(NewArgon2idHasher(), &BcryptHasher{Cost: bcrypt.MinCost}, NewLegacyHasher(crypto.SHA256))
+
+	tests := []struct {
+		name        string
+		password    string
+		encoded     string
+		match       bool
+		needsRehash bool
+	}{
+		{name: "outdated argon2id parameters", password: "password", encoded: weakHash, match: true, needsRehash: true},
+		{name: "fallback algorithm", password: "password", encoded: bcryptHash, match: true, needsRehash: true},
+		{name: "legacy digest", password: "1234567", encoded: legacyHash, match: true, needsRehash: true},
+		{name: "legacy digest wrong password", password: "123456", encoded: legacyHash, match: false, needsRehash: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, needsRehash, err := ph.Verify(tt.password, tt.encoded)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.match, match)
+			assert.Equal(t, tt.needsRehash, needsRehash)
+		})
+	}
+
+	_, _, err := ph.Verify("password", "$unknown$hash")
+	assert.Equal(t, domain.ErrUnsupportedPasswordHash, err)
+}
+
+func TestLoginRehashesLegacyPassword(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	ur := getUserRepository(t)
+	uc := NewUser(l, ur, UserOptions{})
+
+	u, _ := ur.GetByEmail(context.TODO(), "jack@gmail.com")
+	assert.Len(t, u.Password, 64)
+
+	_, err := uc.Login(context.TODO(), &domain.LoginDTO{Identifier: "jack@gmail.com", Password: "1234567"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ = ur.GetByEmail(context.TODO(), "jack@gmail.com")
+	assert.True(t, strings.HasPrefix(u.Password, "$argon2id$"), u.Password)
+
+	// the new hash works as well
+	_, err = uc.Login(context.TODO(), &domain.LoginDTO{Identifier: "jack@gmail.com", Password: "1234567"})
+	assert.Nil(t, err)
+	_, err = uc.Login(context.TODO(), &domain.LoginDTO{Identifier: "jack@gmail.com", Password: "123456"})
+	assert.Equal(t, domain.ErrEmailPasswordNotMatch, err)
+}
+
+func TestPasswordTooLong(t *testing.T) {
+	// bcrypt would ignore everything after the 72nd byte
+	bh := &BcryptHasher{Cost: bcrypt.MinCost}
+	_, err := bh.Hash(strings.Repeat("é", 37))
+	assert.Equal(t, domain.ErrPasswordTooLong, err)
+	_, err = bh.Hash(strings.Repeat("a", 72))
+	assert.Nil(t, err)
+
+	ph, _ := NewPasswordHasherForAlg(Argon2idAlg)
+	long := strings.Repeat("a", domain.MaxPasswordLength+1)
+	_, err = ph.Hash(long)
+	assert.Equal(t, domain.ErrPasswordTooLong, err)
+	encoded, err := ph.Hash(long[1:])
+	if !assert.Nil(t, err) {
+		return
+	}
+	match, _, err := ph.Verify(long, encoded)
+	assert.Nil(t, err)
+	assert.False(t, match)
+}
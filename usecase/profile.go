@@ -0,0 +1,120 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vahidmostofi/minaria/domain"
+	"github.com/vahidmostofi/minaria/repositories"
+)
+
+func (uc *User) GetProfile(ctx context.Context, claims *domain.Claims) (*domain.ProfileDTO, error) {
+	user, err := uc.r.GetByID(ctx, claims.Subject)
+	if err == repositories.ErrNoUserFound {
+		return nil, domain.ErrNoUserFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error while getting the user: %w", err)
+	}
+	return profile(user), nil
+}
+
+func (uc *User) UpdateProfile(ctx context.Context, claims *domain.Claims, version int64, pd *domain.UpdateProfileDTO) (*domain.ProfileDTO, error) {
+	user, err := uc.r.GetByID(ctx, claims.Subject)
+	if err == repositories.ErrNoUserFound {
+		return nil, domain.ErrNoUserFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error while getting the user: %w", err)
+	}
+	if version == 0 {
+		version = user.Version
+	} else if version != user.Version {
+		return nil, domain.ErrConflict
+	}
+
+	// the repository compares the version again, another change can land between the two
+	changed := *user
+	changed.Version = version
+	if pd.Username != "" {
+		canonical, err := uc.canon.Username(pd.Username)
+		if err != nil {
+			return nil, err
+		}
+		changed.Username, changed.CanonicalUsername = pd.Username, canonical
+	}
+	if pd.Email != "" {
+		canonical, err := uc.canon.Email(pd.Email.String())
+		if err != nil {
+			return nil, err
+		}
+		changed.Email, changed.CanonicalEmail = pd.Email.String(), canonical
+	}
+	// the email address is where the password can be reset, an access token
+	// alone mustn't be enough to take the account over
+	if changed.CanonicalEmail != user.CanonicalEmail {
+		if pd.CurrentPassword == "" {
+			return nil, domain.ErrCurrentPasswordRequired
+		}
+		if err := uc.verifyCurrentPassword(ctx, user, pd.CurrentPassword.String()); err != nil {
+			return nil, err
+		}
+	}
+
+	updated, err := uc.r.Update(ctx, &changed)
+	switch err {
+	case nil:
+	case repositories.ErrConflict:
+		return nil, domain.ErrConflict
+	case repositories.ErrNoUserFound:
+		return nil, domain.ErrNoUserFound
+	case repositories.ErrUsernameNotUnique:
+		return nil, domain.ErrUsernameAlreadyTaken
+	case repositories.ErrEmailNotUnique:
+		return nil, domain.ErrEmailAlreadyTaken
+	default:
+		return nil, fmt.Errorf("error while updating the user: %w", err)
+	}
+
+	// the new address isn't verified, the user can ask for another link if sending this one fails
+	if updated.CanonicalEmail != user.CanonicalEmail {
+		if err := uc.sendVerification(ctx, updated); err != nil {
+			uc.l.Errorf("Error while sending the verification link to user %s: %s.", updated.ID, err.Error())
+		}
+		if err := uc.notifier.SendEmailChanged(ctx, user, updated.Email); err != nil {
+			uc.l.Errorf("Error while sending the email change notice to user %s: %s.", updated.ID, err.Error())
+		}
+	}
+
+	return profile(updated), nil
+}
+
+// verifyCurrentPassword checks the password of the logged in user before a
+// sensitive change, a wrong one is a failed login of the account so an access
+// token can't be used to guess it
+func (uc *User) verifyCurrentPassword(ctx context.Context, user *domain.User, password string) error {
+	if err := uc.checkLockout(ctx, user.CanonicalEmail); err != nil {
+		return err
+	}
+
+	match, _, err := uc.hasher.Verify(password, user.Password)
+	if err != nil {
+		return fmt.Errorf("error while verifying the password: %w", err)
+	}
+	if !match {
+		uc.loginFailed(ctx, user.CanonicalEmail)
+		return domain.ErrCurrentPasswordNotMatch
+	}
+	return nil
+}
+
+// profile returns the fields of the user its owner can see
+func profile(u *domain.User) *domain.ProfileDTO {
+	return &domain.ProfileDTO{
+		ID:        u.ID,
+		Username:  u.Username,
+		Email:     u.Email,
+		Verified:  u.Verified,
+		Version:   u.Version,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+	}
+}
@@ -0,0 +1,267 @@
+package usecase
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+var ErrUnsupportedSigningAlg = fmt.Errorf("unsupported signing algorithm")
+var ErrKeyDoesNotMatchAlg = fmt.Errorf("key type doesn't match the signing algorithm")
+var ErrNoPEMBlock = fmt.Errorf("no PEM block found")
+
+// SigningKey is a key the tokens are signed or verified with, a key loaded
+// from a public key can only verify.
+type SigningKey struct {
+	// the kid header of the tokens signed with this key
+	ID     string
+	Method jwt.SigningMethod
+
+	private interface{}
+	public  interface{}
+}
+
+// NewHMACSigningKey returns a symmetric key for HS256, if ID is empty it is derived
+// from the secret, which reveals nothing a signed token doesn't already reveal.
+func NewHMACSigningKey(ID string, secret []byte) *SigningKey {
+	if ID == "" {
+		h := sha256.Sum256(append([]byte("minaria kid "), secret...))
+		ID = base64.RawURLEncoding.EncodeToString(h[:9])
+	}
+	return &SigningKey{ID: ID, Method: jwt.SigningMethodHS256, private: secret, public: secret}
+}
+
+// GenerateSigningKey returns a new random key for the given asymmetric algorithm
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	var (
+		private crypto.PrivateKey
+		err     error
+	)
+	switch alg {
+	case "RS256", "RS384", "RS512":
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ES384":
+		private, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ES512":
+		private, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "EdDSA":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedSigningAlg, alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while generating the key: %w", err)
+	}
+
+	return NewSigningKey("", alg, private)
+}
+
+// NewSigningKey returns a key for the given algorithm, the private key must be an
+// *rsa.PrivateKey, *ecdsa.PrivateKey or ed25519.PrivateKey. If ID is empty the
+// RFC 7638 thumbprint of the public key is used.
+func NewSigningKey(ID, alg string, private crypto.PrivateKey) (*SigningKey, error) {
+	var public interface{}
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		public = &k.PublicKey
+	case *ecdsa.PrivateKey:
+		public = &k.PublicKey
+	case ed25519.PrivateKey:
+		public = k.Public()
+	default:
+		return nil, ErrKeyDoesNotMatchAlg
+	}
+
+	k, err := newPublicSigningKey(ID, alg, public)
+	if err != nil {
+		return nil, err
+	}
+	k.private = private
+	return k, nil
+}
+
+func newPublicSigningKey(ID, alg string, public interface{}) (*SigningKey, error) {
+	method := jwt.GetSigningMethod(alg)
+	if method == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedSigningAlg, alg)
+	}
+
+	var ok bool
+	switch m := method.(type) {
+	case *jwt.SigningMethodRSA:
+		_, ok = public.(*rsa.PublicKey)
+	case *jwt.SigningMethodECDSA:
+		var pk *ecdsa.PublicKey
+		pk, ok = public.(*ecdsa.PublicKey)
+		ok = ok && pk.Curve.Params().BitSize == m.CurveBits
+	case *SigningMethodEdDSA:
+		_, ok = public.(ed25519.PublicKey)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedSigningAlg, alg)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyDoesNotMatchAlg, alg)
+	}
+
+	k := &SigningKey{ID: ID, Method: method, public: public}
+	if k.ID == "" {
+		jwk, _ := k.JWK()
+		k.ID = thumbprint(jwk)
+	}
+	return k, nil
+}
+
+// ParsePrivateKeyPEM parses a PKCS #8, PKCS #1 or SEC 1 encoded private key
+func ParsePrivateKeyPEM(ID, alg string, b []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, ErrNoPEMBlock
+	}
+
+	var (
+		private interface{}
+		err     error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing the private key: %w", err)
+	}
+
+	return NewSigningKey(ID, alg, private)
+}
+
+// ParsePublicKeyPEM parses a PKIX or PKCS #1 encoded public key, the returned key
+// can only be used for verification.
+func ParsePublicKeyPEM(ID, alg string, b []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, ErrNoPEMBlock
+	}
+
+	var (
+		public interface{}
+		err    error
+	)
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		public, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing the public key: %w", err)
+	}
+
+	return newPublicSigningKey(ID, alg, public)
+}
+
+// MarshalPrivateKeyPEM returns the PKCS #8 PEM encoding of the private key
+func (k *SigningKey) MarshalPrivateKeyPEM() ([]byte, error) {
+	if !k.CanSign() || k.IsSymmetric() {
+		return nil, ErrKeyCantSign
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(k.private)
+	if err != nil {
+		return nil, fmt.Errorf("error while marshaling the private key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// CanSign is false for the keys which are loaded from a public key
+func (k *SigningKey) CanSign() bool {
+	return k.private != nil
+}
+
+// IsSymmetric is true for the HMAC keys, they are never published
+func (k *SigningKey) IsSymmetric() bool {
+	_, ok := k.Method.(*jwt.SigningMethodHMAC)
+	return ok
+}
+
+// JWK returns the public part of the key, it returns false for symmetric keys
+func (k *SigningKey) JWK() (domain.JSONWebKey, bool) {
+	jwk := domain.JSONWebKey{Use: "sig", Kid: k.ID, Alg: k.Method.Alg()}
+
+	switch pk := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pk.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pk.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pk.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pk.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(padLeft(pk.X.Bytes(), size))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(padLeft(pk.Y.Bytes(), size))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pk)
+	default:
+		return domain.JSONWebKey{}, false
+	}
+
+	return jwk, true
+}
+
+// thumbprint returns the RFC 7638 thumbprint of the key
+func thumbprint(jwk domain.JSONWebKey) string {
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+
+	b, _ := json.Marshal(members)
+	h := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+func padLeft(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	out := make([]byte, size)
+	copy(out[size-len(b):], b)
+	return out
+}
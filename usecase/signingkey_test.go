@@ -0,0 +1,130 @@
+package usecase
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+func generatePEMKeys(t *testing.T, alg string) ([]byte, []byte) {
+	var (
+		private crypto.Signer
+		err     error
+	)
+	switch alg {
+	case "RS256":
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+}
+
+func TestAsymmetricSigning(t *testing.T) {
+	for _, alg := range []string{"RS256", "ES256", "EdDSA"} {
+		t.Run(alg, func(t *testing.T) {
+			privatePEM, publicPEM := generatePEMKeys(t, alg)
+
+			key, err := ParsePrivateKeyPEM("", alg, privatePEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.True(t, key.CanSign())
+			assert.NotEmpty(t, key.ID)
+
+			signer := NewJWT(JWTOptions{Key: key})
+			token, err := signer.Sign(context.TODO(), &domain.Claims{StandardClaims: jwt.StandardClaims{Subject: "user-id", ExpiresAt: time.Now().Add(time.Minute).Unix()}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parsed, _, err := new(jwt.Parser).ParseUnverified(token, &domain.Claims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, alg, parsed.Header["alg"])
+			assert.Equal(t, key.ID, parsed.Header["kid"])
+
+			// the verifying services only hold the public key
+			publicKey, err := ParsePublicKeyPEM("", alg, publicPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.False(t, publicKey.CanSign())
+			assert.Equal(t, key.ID, publicKey.ID)
+
+			verifier := NewJWT(JWTOptions{Key: publicKey})
+			claims, err := verifier.Verify(context.TODO(), token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, "user-id", claims.Subject)
+
+			_, err = verifier.Sign(context.TODO(), &domain.Claims{})
+			assert.Equal(t, ErrKeyCantSign, err)
+
+			ks := verifier.KeySet()
+			if assert.Len(t, ks.Keys, 1) {
+				assert.Equal(t, key.ID, ks.Keys[0].Kid)
+				assert.Equal(t, alg, ks.Keys[0].Alg)
+				assert.Equal(t, "sig", ks.Keys[0].Use)
+			}
+		})
+	}
+}
+
+func TestAsymmetricSigningRejectsOtherAlgorithms(t *testing.T) {
+	privatePEM, publicPEM := generatePEMKeys(t, "RS256")
+
+	_, err := ParsePrivateKeyPEM("", "ES256", privatePEM)
+	assert.True(t, errors.Is(err, ErrKeyDoesNotMatchAlg))
+
+	_, err = ParsePrivateKeyPEM("", "none", privatePEM)
+	assert.True(t, errors.Is(err, ErrUnsupportedSigningAlg))
+
+	publicKey, err := ParsePublicKeyPEM("rsa", "RS256", publicPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := NewJWT(JWTOptions{Key: publicKey})
+
+	// the public key is public, it must not be accepted as an HMAC secret
+	hmacSigner := NewJWT(JWTOptions{Key: NewHMACSigningKey("rsa", publicPEM)})
+	token, err := hmacSigner.Sign(context.TODO(), &domain.Claims{StandardClaims: jwt.StandardClaims{Subject: "user-id", ExpiresAt: time.Now().Add(time.Minute).Unix()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = verifier.Verify(context.TODO(), token)
+	assert.Equal(t, domain.ErrInvalidToken, err)
+	assert.Empty(t, hmacSigner.KeySet().Keys)
+}
@@ -0,0 +1,74 @@
+package usecase
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHOTPVectors(t *testing.T) {
+	// RFC 6238 appendix B, SHA1
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		step := totpStep(time.Unix(tt.time, 0))
+		assert.Equal(t, tt.code, hotp(key, uint64(step), 8))
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, secret, 32)
+	key, _ := totpEncoding.DecodeString(secret)
+
+	now := time.Unix(1111111111, 0)
+	current := totpStep(now)
+	code := func(step int64) string { return hotp(key, uint64(step), totpDigits) }
+
+	tests := []struct {
+		name         string
+		code         string
+		lastUsedStep int64
+		step         int64
+		ok           bool
+	}{
+		{name: "current step", code: code(current), step: current, ok: true},
+		{name: "previous step", code: code(current - 1), step: current - 1, ok: true},
+		{name: "next step", code: code(current + 1), step: current + 1, ok: true},
+		{name: "with spaces", code: code(current)[:3] + " " + code(current)[3:], step: current, ok: true},
+		{name: "too old", code: code(current - 2)},
+		{name: "too new", code: code(current + 2)},
+		{name: "already used", code: code(current), lastUsedStep: current},
+		{name: "older than the used one", code: code(current - 1), lastUsedStep: current},
+		{name: "wrong length", code: code(current)[:5]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := validateTOTP(strings.ToLower(secret), tt.code, now, tt.lastUsedStep)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.step, step)
+		})
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := totpURI("minaria", "jack@gmail.com", "JBSWY3DPEHPK3PXP")
+	assert.Equal(t, "otpauth://totp/minaria:jack@gmail.com?algorithm=SHA1&digits=6&issuer=minaria&period=30&secret=JBSWY3DPEHPK3PXP", uri)
+}
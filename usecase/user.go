@@ -2,16 +2,16 @@ package usecase
 
 import (
 	"context"
-	"crypto"
-	"crypto/subtle"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
-
-	"github.com/go-openapi/strfmt"
-
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"github.com/vahidmostofi/minaria/common"
@@ -20,18 +20,117 @@ import (
 )
 
 type UserOptions struct {
-	// default is 31 days
-	jwtExpiresAfter *time.Duration
+	// default is 15 minutes
+	JWTExpiresAfter *time.Duration
+
+	// default is 30 days
+	RefreshExpiresAfter *time.Duration
+
+	// default is an in memory repository, the replicas must share it or a
+	// refresh token only works on the replica which issued it
+	RefreshTokenRepository domain.RefreshTokenRepository
+
+	// default is HS256 with the JWT_SIGN_KEY and an in memory revocation store,
+	// see NewJWTFromConfig for the other algorithms
+	JWT *JWT
+
+	// default is argon2id, verifying bcrypt and the legacy SHA256 hashes as well
+	PasswordHasher domain.PasswordHasher
+
+	// default is 1 hour
+	PasswordResetExpiresAfter *time.Duration
+
+	// default is an in memory repository, like the RefreshTokenRepository
+	OneTimeTokenRepository domain.OneTimeTokenRepository
+
+	// default only logs the messages
+	Notifier domain.AccountNotifier
+
+	// default is an in memory counter, the replicas must share it
+	LoginAttemptCounter domain.LoginAttemptCounter
+
+	// default is DefaultAccountLockout
+	AccountLockout *LockoutPolicy
+
+	// default is DefaultIPLockout
+	IPLockout *LockoutPolicy
+
+	// default is 15 minutes
+	MagicLinkExpiresAfter *time.Duration
+
+	// default is the Notifier
+	MagicLinkSender domain.MagicLinkSender
+
+	// default is 24 hours
+	VerificationExpiresAfter *time.Duration
 
-	// default is SHA256
-	hashMethod *crypto.Hash
+	// the users with an unverified email can't log in when it's set
+	RequireVerifiedEmail bool
+
+	// when RequireVerifiedEmail is set the unverified users can still log in
+	// for this long after the registration, default is 0
+	VerificationGracePeriod time.Duration
+
+	// default is an in memory repository, a persistent user repository needs
+	// a persistent one or the second factors are forgotten on restart
+	TOTPRepository domain.TOTPRepository
+
+	// the issuer shown by the authenticator apps, default is minaria
+	TOTPIssuer string
+
+	// default is 5 minutes
+	MFAExpiresAfter *time.Duration
+
+	// default is an in memory repository, like the TOTPRepository
+	RecoveryCodeRepository domain.RecoveryCodeRepository
+
+	// default is an in memory repository, like the TOTPRepository
+	WebAuthnCredentialRepository domain.WebAuthnCredentialRepository
+
+	// the domain the credentials are scoped to, default is localhost
+	WebAuthnRPID string
+
+	// the name the authenticators show, default is minaria
+	WebAuthnRPName string
+
+	// the origins of the pages which run the ceremonies, default is http://localhost:9090
+	WebAuthnOrigins []string
+
+	// default is domain.DefaultCanonicalizer
+	Canonicalizer *domain.Canonicalizer
 }
 
 type User struct {
-	l               *log.Logger
-	r               domain.UserRepository
-	jwtExpiresAfter time.Duration
-	hashMethod      crypto.Hash
+	l                   *log.Logger
+	r                   domain.UserRepository
+	rtr                 domain.RefreshTokenRepository
+	jwt                 *JWT
+	jwtExpiresAfter     time.Duration
+	refreshExpiresAfter time.Duration
+	hasher              domain.PasswordHasher
+	resetExpiresAfter   time.Duration
+	ottr                domain.OneTimeTokenRepository
+	notifier            domain.AccountNotifier
+	lac                 domain.LoginAttemptCounter
+	accountLockout      LockoutPolicy
+	ipLockout           LockoutPolicy
+	magicExpiresAfter   time.Duration
+	magicLinkSender     domain.MagicLinkSender
+	verifyExpiresAfter  time.Duration
+	requireVerified     bool
+	verifyGracePeriod   time.Duration
+	totpr               domain.TOTPRepository
+	totpIssuer          string
+	mfaExpiresAfter     time.Duration
+	rcr                 domain.RecoveryCodeRepository
+	wcr                 domain.WebAuthnCredentialRepository
+	webAuthnRPID        string
+	webAuthnRPName      string
+	webAuthnOrigins     []string
+	canon               domain.Canonicalizer
+
+	// the hash of a random password, the logins of the unknown emails verify it in place of a user's hash
+	dummyHash string
 }
 
 func NewUser(l *log.Logger, r domain.UserRepository, opts UserOptions) domain.UserUsecase {
@@ -39,50 +138,536 @@ func NewUser(l *log.Logger, r domain.UserRepository, opts UserOptions) domain.Us
 	u.l = l
 	u.r = r
 
-	if opts.jwtExpiresAfter != nil {
-		u.jwtExpiresAfter = *opts.jwtExpiresAfter
+	if opts.JWTExpiresAfter != nil {
+		u.jwtExpiresAfter = *opts.JWTExpiresAfter
 	} else {
-		d, _ := time.ParseDuration("744h")
+		d, _ := time.ParseDuration("15m")
 		u.jwtExpiresAfter = time.Duration(d)
 	}
-	if opts.hashMethod != nil {
-		u.hashMethod = *opts.hashMethod
+	if opts.RefreshExpiresAfter != nil {
+		u.refreshExpiresAfter = *opts.RefreshExpiresAfter
+	} else {
+		d, _ := time.ParseDuration("720h")
+		u.refreshExpiresAfter = time.Duration(d)
+	}
+	if opts.RefreshTokenRepository != nil {
+		u.rtr = opts.RefreshTokenRepository
+	} else {
+		u.rtr, _ = repositories.NewRefreshTokenRepository(repositories.InMemoryKind, nil)
+	}
+	if opts.JWT != nil {
+		u.jwt = opts.JWT
+	} else {
+		rs, _ := repositories.NewRevocationStore(repositories.InMemoryKind, nil)
+		u.jwt = NewJWT(JWTOptions{Key: NewHMACSigningKey("", []byte(viper.GetString(common.JWT_SIGN_KEY))), Revocations: rs})
+	}
+	if opts.PasswordHasher != nil {
+		u.hasher = opts.PasswordHasher
+	} else {
+		u.hasher, _ = NewPasswordHasherForAlg(Argon2idAlg)
+	}
+	if opts.PasswordResetExpiresAfter != nil {
+		u.resetExpiresAfter = *opts.PasswordResetExpiresAfter
+	} else {
+		d, _ := time.ParseDuration("1h")
+		u.resetExpiresAfter = time.Duration(d)
+	}
+	if opts.OneTimeTokenRepository != nil {
+		u.ottr = opts.OneTimeTokenRepository
+	} else {
+		u.ottr, _ = repositories.NewOneTimeTokenRepository(repositories.InMemoryKind, nil)
+	}
+	if opts.Notifier != nil {
+		u.notifier = opts.Notifier
+	} else {
+		u.notifier = NewLogNotifier(l)
+	}
+	if opts.LoginAttemptCounter != nil {
+		u.lac = opts.LoginAttemptCounter
+	} else {
+		u.lac, _ = repositories.NewLoginAttemptCounter(repositories.InMemoryKind, nil)
+	}
+	if opts.AccountLockout != nil {
+		u.accountLockout = *opts.AccountLockout
+	} else {
+		u.accountLockout = DefaultAccountLockout
+	}
+	if opts.IPLockout != nil {
+		u.ipLockout = *opts.IPLockout
+	} else {
+		u.ipLockout = DefaultIPLockout
+	}
+	if opts.MagicLinkExpiresAfter != nil {
+		u.magicExpiresAfter = *opts.MagicLinkExpiresAfter
+	} else {
+		d, _ := time.ParseDuration("15m")
+		u.magicExpiresAfter = time.Duration(d)
+	}
+	if opts.MagicLinkSender != nil {
+		u.magicLinkSender = opts.MagicLinkSender
+	} else {
+		u.magicLinkSender = u.notifier
+	}
+	if opts.VerificationExpiresAfter != nil {
+		u.verifyExpiresAfter = *opts.VerificationExpiresAfter
+	} else {
+		d, _ := time.ParseDuration("24h")
+		u.verifyExpiresAfter = time.Duration(d)
+	}
+	u.requireVerified = opts.RequireVerifiedEmail
+	u.verifyGracePeriod = opts.VerificationGracePeriod
+	if opts.TOTPRepository != nil {
+		u.totpr = opts.TOTPRepository
+	} else {
+		u.totpr, _ = repositories.NewTOTPRepository(repositories.InMemoryKind, nil)
+	}
+	if opts.TOTPIssuer != "" {
+		u.totpIssuer = opts.TOTPIssuer
+	} else {
+		u.totpIssuer = "minaria"
+	}
+	if opts.MFAExpiresAfter != nil {
+		u.mfaExpiresAfter = *opts.MFAExpiresAfter
+	} else {
+		d, _ := time.ParseDuration("5m")
+		u.mfaExpiresAfter = time.Duration(d)
+	}
+	if opts.RecoveryCodeRepository != nil {
+		u.rcr = opts.RecoveryCodeRepository
+	} else {
+		u.rcr, _ = repositories.NewRecoveryCodeRepository(repositories.InMemoryKind, nil)
+	}
+	if opts.WebAuthnCredentialRepository != nil {
+		u.wcr = opts.WebAuthnCredentialRepository
 	} else {
-		u.hashMethod = crypto.SHA256
+		u.wcr, _ = repositories.NewWebAuthnCredentialRepository(repositories.InMemoryKind, nil)
+	}
+	if opts.WebAuthnRPID != "" {
+		u.webAuthnRPID = opts.WebAuthnRPID
+	} else {
+		u.webAuthnRPID = "localhost"
+	}
+	if opts.WebAuthnRPName != "" {
+		u.webAuthnRPName = opts.WebAuthnRPName
+	} else {
+		u.webAuthnRPName = "minaria"
+	}
+	if len(opts.WebAuthnOrigins) != 0 {
+		u.webAuthnOrigins = opts.WebAuthnOrigins
+	} else {
+		u.webAuthnOrigins = []string{"http://localhost:9090"}
+	}
+	if opts.Canonicalizer != nil {
+		u.canon = *opts.Canonicalizer
+	} else {
+		u.canon = domain.DefaultCanonicalizer
 	}
 
+	// without the dummy hash the logins would tell the unknown emails apart, the
+	// hasher is the one the passwords are hashed with so it has the same cost
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		l.Fatalf("Error while generating the dummy password: %s", err)
+	}
+	dummyHash, err := u.hasher.Hash(hex.EncodeToString(b))
+	if err != nil {
+		l.Fatalf("Error while hashing the dummy password: %s", err)
+	}
+	u.dummyHash = dummyHash
+
 	return u
 }
 
-func (uc *User) LoginByEmail(ctx context.Context, ld *domain.LoginDTO) (*domain.JWTDTO, error) {
-	user, err := uc.r.GetByEmail(ctx, ld.Email.String())
+func (uc *User) Login(ctx context.Context, ld *domain.LoginDTO) (*domain.JWTDTO, error) {
+	// every path verifies a hash of the current algorithm, so an unknown identifier
+	// or a failing repository takes as long as a wrong password and the timing
+	// doesn't tell which users have an account
+	user, canonical, err := uc.getByIdentifier(ctx, ld.Identifier)
+	var encoded string
+	switch {
+	case err == nil:
+		encoded = user.Password
+		// a hash of another algorithm, like a legacy digest, can verify much faster
+		// than the dummy hash, so the dummy hash is verified on top of it
+		if hashAlgorithm(encoded) != hashAlgorithm(uc.dummyHash) {
+			uc.hasher.Verify(ld.Password.String(), uc.dummyHash)
+		}
+	case err == repositories.ErrNoUserFound:
+		user = nil
+		encoded = uc.dummyHash
+	default:
+		uc.hasher.Verify(ld.Password.String(), uc.dummyHash)
+		return nil, fmt.Errorf("error while getting the user: %w", err)
+	}
 
+	// the account is locked by its canonical email whichever identifier is used,
+	// the unknown ones are locked by the canonical identifier itself
+	account := canonical
+	if user != nil {
+		account = user.CanonicalEmail
+	}
+	if err := uc.checkLockout(ctx, account); err != nil {
+		return nil, err
+	}
+
+	match, needsRehash, err := uc.hasher.Verify(ld.Password.String(), encoded)
+	if user == nil {
+		// the same error as a wrong password, the handler can't tell them apart either
+		uc.loginFailed(ctx, account)
+		return nil, domain.ErrEmailPasswordNotMatch
+	}
 	if err != nil {
-		if err == repositories.ErrNoUserFound {
-			return nil, domain.ErrNoUserFound
-		}
+		return nil, fmt.Errorf("error while verifying the password: %w", err)
+	}
+	if !match {
+		uc.loginFailed(ctx, account)
+		return nil, domain.ErrEmailPasswordNotMatch
 	}
 
-	hashedPassword := uc.hash([]byte(ld.Password))
+	if !uc.canLogin(user) {
+		return nil, domain.ErrEmailNotVerified
+	}
+
+	if needsRehash {
+		// the user already logged in, failing to upgrade the hash can wait for the next login
+		if err := uc.rehash(ctx, user.ID, ld.Password.String()); err != nil {
+			uc.l.Errorf("Error while rehashing the password of user %s: %s.", user.ID, err.Error())
+		}
+	}
 
-	currentedHashedPassword, err := hex.DecodeString(user.Password)
+	mfa, err := uc.mfaEnabled(ctx, user.ID)
 	if err != nil {
-		return nil, fmt.Errorf("error while decoding hex string: %w", err)
+		return nil, err
+	}
+	if mfa {
+		// the failures are forgotten once the second factor is verified too
+		return uc.mfaChallenge(ctx, user)
 	}
+	uc.loginSucceeded(ctx, account)
+
+	return uc.issueTokens(ctx, user.ID, user.Username, uuid.New().String())
+}
 
-	if subtle.ConstantTimeCompare(hashedPassword, currentedHashedPassword) == 1 {
-		token, err := uc.generateJWT(user.ID, user.Username)
+// getByIdentifier gets the user by the email address if the identifier is one,
+// otherwise by the username, the usernames can't have an @. It also returns the
+// canonical form of the identifier it looked up, or the identifier itself if it
+// has none.
+func (uc *User) getByIdentifier(ctx context.Context, identifier string) (*domain.User, string, error) {
+	if strings.Contains(identifier, "@") {
+		canonical, err := uc.canon.Email(identifier)
 		if err != nil {
-			return nil, fmt.Errorf("error while generating jwt token: %w", err)
+			return nil, identifier, repositories.ErrNoUserFound
+		}
+		u, err := uc.r.GetByEmail(ctx, canonical)
+		return u, canonical, err
+	}
+
+	canonical, err := uc.canon.Username(identifier)
+	if err != nil {
+		return nil, identifier, repositories.ErrNoUserFound
+	}
+	u, err := uc.r.GetByUsername(ctx, canonical)
+	return u, canonical, err
+}
+
+// getByEmail gets the user by the canonical form of the email address, an
+// address which has none can't belong to a user
+func (uc *User) getByEmail(ctx context.Context, email string) (*domain.User, error) {
+	canonical, err := uc.canon.Email(email)
+	if err != nil {
+		return nil, repositories.ErrNoUserFound
+	}
+	return uc.r.GetByEmail(ctx, canonical)
+}
+
+// canLogin reports whether the user is allowed to log in regarding the email verification
+func (uc *User) canLogin(u *domain.User) bool {
+	if !uc.requireVerified || u.Verified {
+		return true
+	}
+	return time.Since(u.CreatedAt) < uc.verifyGracePeriod
+}
+
+// rehash stores the password hashed with the current algorithm and parameters
+func (uc *User) rehash(ctx context.Context, ID, password string) error {
+	hashed, err := uc.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	return uc.r.UpdatePassword(ctx, ID, hashed)
+}
+
+func (uc *User) Refresh(ctx context.Context, rd *domain.RefreshDTO) (*domain.JWTDTO, error) {
+	hash := hashToken(rd.RefreshToken)
+
+	rt, err := uc.rtr.GetByHash(ctx, hash)
+	if err != nil {
+		if err == repositories.ErrNoRefreshTokenFound {
+			return nil, domain.ErrInvalidRefreshToken
+		}
+		return nil, fmt.Errorf("error while getting the refresh token: %w", err)
+	}
+
+	if rt.Revoked || time.Now().After(rt.ExpiresAt) {
+		return nil, domain.ErrInvalidRefreshToken
+	}
+
+	// the sessions of a deleted user end with it, whatever their tokens say
+	user, err := uc.r.GetByID(ctx, rt.UserID)
+	if err == repositories.ErrNoUserFound || (err == nil && user.DeletedAt != nil) {
+		if err := uc.rtr.RevokeFamily(ctx, rt.FamilyID); err != nil {
+			return nil, fmt.Errorf("error while revoking the refresh token family: %w", err)
+		}
+		return nil, domain.ErrInvalidRefreshToken
+	} else if err != nil {
+		return nil, fmt.Errorf("error while getting the user: %w", err)
+	}
+
+	err = uc.rtr.MarkUsed(ctx, hash)
+	if err == repositories.ErrRefreshTokenAlreadyUsed {
+		// an already rotated token showed up again, either the client or an attacker
+		// holds a stolen copy, we can't tell which one so the whole family goes.
+		uc.l.Warnf("Refresh token reuse detected for user %s, revoking the token family.", rt.UserID)
+		if err := uc.rtr.RevokeFamily(ctx, rt.FamilyID); err != nil {
+			return nil, fmt.Errorf("error while revoking the refresh token family: %w", err)
 		}
-		return &domain.JWTDTO{Token: token}, nil
+		return nil, domain.ErrRefreshTokenReused
+	} else if err != nil {
+		return nil, fmt.Errorf("error while marking the refresh token as used: %w", err)
 	}
 
-	return nil, domain.ErrEmailPasswordNotMatch
+	return uc.issueTokens(ctx, user.ID, user.Username, rt.FamilyID)
+}
+
+func (uc *User) Verify(ctx context.Context, token string) (*domain.Claims, error) {
+	return uc.jwt.Verify(ctx, token)
+}
+
+func (uc *User) Logout(ctx context.Context, claims *domain.Claims, everywhere bool) error {
+	if everywhere {
+		return uc.revokeSessions(ctx, claims.Subject)
+	}
+
+	if err := uc.jwt.Revoke(ctx, claims); err != nil {
+		return fmt.Errorf("error while revoking the token: %w", err)
+	}
+	if claims.SessionID != "" {
+		if err := uc.rtr.RevokeFamily(ctx, claims.SessionID); err != nil {
+			return fmt.Errorf("error while revoking the refresh tokens: %w", err)
+		}
+	}
+	return nil
+}
+
+func (uc *User) ForgotPassword(ctx context.Context, fd *domain.ForgotPasswordDTO) error {
+	user, err := uc.getByEmail(ctx, fd.Email.String())
+	if err == repositories.ErrNoUserFound {
+		// the caller must not be able to tell whether the email is registered
+		uc.l.Infof("Password reset requested for an unknown email.")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error while getting the user: %w", err)
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return fmt.Errorf("error while generating reset token: %w", err)
+	}
+
+	now := time.Now()
+	err = uc.ottr.Store(ctx, &domain.OneTimeToken{
+		Hash:      hashToken(token),
+		UserID:    user.ID,
+		Purpose:   domain.PasswordResetPurpose,
+		ExpiresAt: now.Add(uc.resetExpiresAfter),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return fmt.Errorf("error while storing reset token: %w", err)
+	}
+
+	if err := uc.notifier.SendPasswordReset(ctx, user, token); err != nil {
+		return fmt.Errorf("error while sending reset token: %w", err)
+	}
+	return nil
+}
+
+func (uc *User) ResetPassword(ctx context.Context, rd *domain.ResetPasswordDTO) error {
+	if rd.Password.String() != rd.RepeatPassword.String() {
+		return domain.ErrPasswordsDoNotMatch
+	}
+
+	// the token is single use, a password which can't be set mustn't burn it
+	hashed, err := uc.hasher.Hash(rd.Password.String())
+	if err == domain.ErrPasswordTooLong {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("error while hashing the password: %w", err)
+	}
+
+	t, err := uc.ottr.Consume(ctx, hashToken(rd.Token), domain.PasswordResetPurpose)
+	if err == repositories.ErrNoOneTimeTokenFound {
+		return domain.ErrInvalidResetToken
+	} else if err != nil {
+		return fmt.Errorf("error while consuming reset token: %w", err)
+	}
+	if time.Now().After(t.ExpiresAt) {
+		return domain.ErrInvalidResetToken
+	}
+
+	// the user can be deleted after the link was sent
+	if err := uc.r.UpdatePassword(ctx, t.UserID, hashed); err == repositories.ErrNoUserFound {
+		return domain.ErrInvalidResetToken
+	} else if err != nil {
+		return fmt.Errorf("error while updating the password: %w", err)
+	}
+
+	// whoever knew the old password must not stay logged in
+	if err := uc.revokeSessions(ctx, t.UserID); err != nil {
+		return err
+	}
+	if err := uc.ottr.DeleteByUser(ctx, t.UserID, domain.PasswordResetPurpose); err != nil {
+		return fmt.Errorf("error while deleting the other reset tokens: %w", err)
+	}
+
+	return nil
+}
+
+func (uc *User) ChangePassword(ctx context.Context, claims *domain.Claims, cd *domain.ChangePasswordDTO) (*domain.JWTDTO, error) {
+	if cd.Password.String() != cd.RepeatPassword.String() {
+		return nil, domain.ErrPasswordsDoNotMatch
+	}
+
+	user, err := uc.r.GetByID(ctx, claims.Subject)
+	if err == repositories.ErrNoUserFound {
+		return nil, domain.ErrNoUserFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error while getting the user: %w", err)
+	}
+
+	if err := uc.verifyCurrentPassword(ctx, user, cd.CurrentPassword.String()); err != nil {
+		return nil, err
+	}
+
+	if err := uc.rehash(ctx, user.ID, cd.Password.String()); err == domain.ErrPasswordTooLong {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("error while updating the password: %w", err)
+	}
+
+	// the current session gets a fresh pair of tokens either way, revoking
+	// every session is simpler than sparing it when the others go as well
+	if cd.LogoutOtherSessions {
+		if err := uc.revokeSessions(ctx, user.ID); err != nil {
+			return nil, err
+		}
+	} else if err := uc.Logout(ctx, claims, false); err != nil {
+		return nil, err
+	}
+	return uc.issueTokens(ctx, user.ID, user.Username, uuid.New().String())
+}
+
+// DeleteUser soft deletes the user, its jwts and refresh tokens are revoked
+// as they'd keep working until they expire otherwise
+func (uc *User) DeleteUser(ctx context.Context, userID string) error {
+	err := uc.r.Delete(ctx, userID)
+	if err == repositories.ErrNoUserFound {
+		return domain.ErrNoUserFound
+	} else if err != nil {
+		return fmt.Errorf("error while deleting the user: %w", err)
+	}
+
+	if err := uc.revokeSessions(ctx, userID); err != nil {
+		return err
+	}
+	uc.l.Infof("User %s is deleted.", userID)
+	return nil
+}
+
+// revokeSessions revokes every jwt and refresh token of the user
+func (uc *User) revokeSessions(ctx context.Context, userID string) error {
+	if err := uc.jwt.RevokeAll(ctx, userID); err != nil {
+		return fmt.Errorf("error while revoking the user's tokens: %w", err)
+	}
+	if err := uc.rtr.RevokeUser(ctx, userID); err != nil {
+		return fmt.Errorf("error while revoking the user's refresh tokens: %w", err)
+	}
+	return nil
+}
+
+func (uc *User) VerifyEmail(ctx context.Context, token string) error {
+	claims, err := uc.jwt.VerifyPurpose(ctx, token, domain.EmailVerificationPurpose)
+	if err == domain.ErrInvalidToken {
+		return domain.ErrInvalidVerificationToken
+	} else if err != nil {
+		return err
+	}
+
+	user, err := uc.r.GetByID(ctx, claims.Subject)
+	if err == repositories.ErrNoUserFound {
+		return domain.ErrInvalidVerificationToken
+	} else if err != nil {
+		return fmt.Errorf("error while getting the user: %w", err)
+	}
+
+	// the token only proves the ownership of the address it was sent to
+	if user.Email != claims.Email {
+		return domain.ErrInvalidVerificationToken
+	}
+	if user.Verified {
+		return nil
+	}
+
+	if err := uc.r.MarkEmailVerified(ctx, user.ID); err != nil {
+		return fmt.Errorf("error while marking the email as verified: %w", err)
+	}
+	return nil
+}
+
+func (uc *User) ResendVerification(ctx context.Context, rd *domain.ResendVerificationDTO) error {
+	user, err := uc.getByEmail(ctx, rd.Email.String())
+	if err == repositories.ErrNoUserFound {
+		// the caller must not be able to tell whether the email is registered
+		uc.l.Infof("Verification link requested for an unknown email.")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error while getting the user: %w", err)
+	}
+
+	if user.Verified {
+		return nil
+	}
+	return uc.sendVerification(ctx, user)
+}
+
+// sendVerification signs a verification token for the user's current email address and sends it
+func (uc *User) sendVerification(ctx context.Context, u *domain.User) error {
+	claims := &domain.Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   u.ID,
+			ExpiresAt: time.Now().Add(uc.verifyExpiresAfter).Unix(),
+		},
+		Purpose: domain.EmailVerificationPurpose,
+		Email:   u.Email,
+	}
+
+	token, err := uc.jwt.Sign(ctx, claims)
+	if err != nil {
+		return fmt.Errorf("error while signing the verification token: %w", err)
+	}
+
+	if err := uc.notifier.SendEmailVerification(ctx, u, token); err != nil {
+		return fmt.Errorf("error while sending the verification token: %w", err)
+	}
+	return nil
 }
 
 func (uc *User) CheckEmailAvailable(ctx context.Context, email string) error {
-	_, err := uc.r.GetByEmail(ctx, email)
+	canonical, err := uc.canon.Email(email)
+	if err != nil {
+		return err
+	}
+	_, err = uc.r.GetByEmail(ctx, canonical)
 
 	if err != nil {
 		if err == repositories.ErrNoUserFound {
@@ -96,7 +681,11 @@ func (uc *User) CheckEmailAvailable(ctx context.Context, email string) error {
 }
 
 func (uc *User) CheckUsernameAvailable(ctx context.Context, username string) error {
-	_, err := uc.r.GetByUsername(ctx, username)
+	canonical, err := uc.canon.Username(username)
+	if err != nil {
+		return err
+	}
+	_, err = uc.r.GetByUsername(ctx, canonical)
 
 	if err != nil {
 		if err == repositories.ErrNoUserFound {
@@ -110,45 +699,114 @@ func (uc *User) CheckUsernameAvailable(ctx context.Context, username string) err
 }
 
 func (uc *User) Create(ctx context.Context, r *domain.RegisterDTO) (*domain.JWTDTO, error) {
-	rawPassword := r.Password
-
 	if r.Password.String() != r.RepeatPassword.String() {
 		return nil, domain.ErrPasswordsDoNotMatch
 	}
 
-	if uc.CheckEmailAvailable(ctx, r.Email.String()) != nil {
-		return nil, domain.ErrEmailAlreadyTaken
+	canonicalEmail, err := uc.canon.Email(r.Email.String())
+	if err != nil {
+		return nil, err
+	}
+	canonicalUsername, err := uc.canon.Username(r.Username)
+	if err != nil {
+		return nil, err
 	}
 
-	if uc.CheckUsernameAvailable(ctx, r.Username) != nil {
-		return nil, domain.ErrUsernameAlreadyTaken
+	hashed, err := uc.hasher.Hash(r.Password.String())
+	if err == domain.ErrPasswordTooLong {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("error while hashing the password: %w", err)
 	}
 
-	u := domain.User{Username: r.Username, Password: hex.EncodeToString(uc.hash([]byte(r.Password))), Email: r.Email.String()}
+	u := domain.User{
+		Username:          r.Username,
+		Email:             r.Email.String(),
+		CanonicalUsername: canonicalUsername,
+		CanonicalEmail:    canonicalEmail,
+		Password:          hashed,
+	}
 
+	// the repository is the only check of the uniqueness, a check before it
+	// would race with the other registrations
 	usr, err := uc.r.Store(ctx, &u)
-	if err != nil {
+	if err == repositories.ErrEmailNotUnique {
+		return nil, domain.ErrEmailAlreadyTaken
+	} else if err == repositories.ErrUsernameNotUnique {
+		return nil, domain.ErrUsernameAlreadyTaken
+	} else if err != nil {
 		return nil, err
 	}
 
-	return uc.LoginByEmail(ctx, &domain.LoginDTO{strfmt.Email(usr.Email), strfmt.Password(rawPassword)})
-}
+	// the user can ask for another link, the registration must not fail because of the notifier
+	if err := uc.sendVerification(ctx, usr); err != nil {
+		uc.l.Errorf("Error while sending the verification link to user %s: %s.", usr.ID, err.Error())
+	}
 
-func (uc *User) hash(in []byte) []byte {
-	h := uc.hashMethod.New()
-	h.Write(in)
-	return h.Sum(nil)
+	// the password was just set, logging in with it would only verify the hash
+	// again and could run into the lockout of the failed logins of the email
+	if !uc.canLogin(usr) {
+		return nil, domain.ErrEmailNotVerified
+	}
+	return uc.issueTokens(ctx, usr.ID, usr.Username, uuid.New().String())
 }
 
-func (uc *User) generateJWT(ID, Username string) (string, error) {
-	signKey := []byte(viper.GetString(common.JWT_SIGN_KEY))
-	claims := &jwt.StandardClaims{Id: ID, ExpiresAt: time.Now().Add(uc.jwtExpiresAfter).Unix()}
+// issueTokens returns a new jwt and a new refresh token which belongs to the given family
+func (uc *User) issueTokens(ctx context.Context, ID, Username, familyID string) (*domain.JWTDTO, error) {
+	token, err := uc.generateJWT(ctx, ID, familyID)
+	if err != nil {
+		return nil, fmt.Errorf("error while generating jwt token: %w", err)
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	ss, err := token.SignedString(signKey)
+	refreshToken, err := randomToken()
 	if err != nil {
-		return "", fmt.Errorf("error while signing the token: %w", err)
+		return nil, fmt.Errorf("error while generating refresh token: %w", err)
+	}
+
+	now := time.Now()
+	err = uc.rtr.Store(ctx, &domain.RefreshToken{
+		Hash:      hashToken(refreshToken),
+		FamilyID:  familyID,
+		UserID:    ID,
+		Username:  Username,
+		ExpiresAt: now.Add(uc.refreshExpiresAfter),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error while storing refresh token: %w", err)
+	}
+
+	return &domain.JWTDTO{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(uc.jwtExpiresAfter.Seconds()),
+	}, nil
+}
+
+// randomToken returns an opaque url safe token with 256 bits of entropy
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the form of a random token which is stored, the token
+// has enough entropy that a fast hash is sufficient.
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+func (uc *User) generateJWT(ctx context.Context, ID, sessionID string) (string, error) {
+	claims := &domain.Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   ID,
+			ExpiresAt: time.Now().Add(uc.jwtExpiresAfter).Unix(),
+		},
+		SessionID: sessionID,
 	}
 
-	return ss, nil
+	return uc.jwt.Sign(ctx, claims)
 }
@@ -2,8 +2,12 @@ package usecase
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/go-openapi/strfmt"
@@ -16,7 +20,7 @@ import (
 func getUserRepository(t *testing.T) domain.UserRepository {
 	ur, err := repositories.NewUserRepository(
 		repositories.InMemoryKind,
-		repositories.InMemoryArgs{Data: []*domain.User{{Email: "jack@gmail.com"}}},
+		nil,
 	)
 
 	if err != nil {
@@ -61,7 +65,7 @@ func TestCreateUser(t *testing.T) {
 	ur := getUserRepository(t)
 
 	uc := NewUser(l, ur, UserOptions{})
-	rd := &domain.RegisterDTO{"gholi", strfmt.Email("gholi@gmail.com"), strfmt.Password("password"), strfmt.Password("password")}
+	rd := &domain.RegisterDTO{Username: "gholi", Email: strfmt.Email("gholi@gmail.com"), Password: strfmt.Password("password"), RepeatPassword: strfmt.Password("password")}
 
 	jwtDTO, err := uc.Create(context.TODO(), rd)
 	if err != nil {
@@ -82,10 +86,12 @@ func TestCreateUser(t *testing.T) {
 	assert.Nil(t, claims.Valid())
 	assert.True(t, claims.VerifyAudience(u.ID, false))
 
+	// which one is reported if both are taken is up to the repository
+	rd.Username = "another"
 	_, err = uc.Create(context.TODO(), rd)
 	assert.Equal(t, err, domain.ErrEmailAlreadyTaken)
 
-	rd.Email = "another@gmail.com"
+	rd.Username, rd.Email = "gholi", "another@gmail.com"
 	_, err = uc.Create(context.TODO(), rd)
 	assert.Equal(t, err, domain.ErrUsernameAlreadyTaken)
 
@@ -93,3 +99,585 @@ func TestCreateUser(t *testing.T) {
 	_, err = uc.Create(context.TODO(), rd)
 	assert.Equal(t, err, domain.ErrPasswordsDoNotMatch)
 }
+
+func TestCanonicalIdentities(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	ur := getUserRepository(t)
+	uc := NewUser(l, ur, UserOptions{})
+	ctx := context.TODO()
+
+	// the case, the width and the compatibility forms don't make another account
+	assert.Equal(t, domain.ErrEmailAlreadyTaken, uc.CheckEmailAvailable(ctx, "Jack@GMAIL.com"))
+	assert.Equal(t, domain.ErrUsernameAlreadyTaken, uc.CheckUsernameAvailable(ctx, "JACK"))
+	assert.Equal(t, domain.ErrUsernameAlreadyTaken, uc.CheckUsernameAvailable(ctx, "ｊａｃｋ"))
+	assert.Equal(t, domain.ErrInvalidUsername, uc.CheckUsernameAvailable(ctx, "jack sparrow"))
+
+	rd := &domain.RegisterDTO{Username: "Gholi", Email: "Gholi@Gmail.com", Password: "password", RepeatPassword: "password"}
+	if _, err := uc.Create(ctx, rd); err != nil {
+		t.Fatal(err)
+	}
+	rd.Email, rd.Username = "GHOLI@gmail.com", "another"
+	_, err := uc.Create(ctx, rd)
+	assert.Equal(t, domain.ErrEmailAlreadyTaken, err)
+	rd.Email, rd.Username = "another@gmail.com", "gholi"
+	_, err = uc.Create(ctx, rd)
+	assert.Equal(t, domain.ErrUsernameAlreadyTaken, err)
+	rd.Username = "gholi\u0007"
+	_, err = uc.Create(ctx, rd)
+	assert.Equal(t, domain.ErrInvalidUsername, err)
+
+	// the typed forms are kept for display
+	u, err := ur.GetByEmail(ctx, "gholi@gmail.com")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "Gholi", u.Username)
+		assert.Equal(t, "Gholi@Gmail.com", u.Email)
+	}
+
+	for _, identifier := range []string{"gholi@gmail.com", "GHOLI@GMAIL.COM", "gholi", "ＧＨＯＬＩ"} {
+		_, err := uc.Login(ctx, &domain.LoginDTO{Identifier: identifier, Password: "password"})
+		assert.Nil(t, err, identifier)
+	}
+
+	// with the exact local part only the domain is case insensitive
+	exact := NewUser(l, ur, UserOptions{Canonicalizer: &domain.Canonicalizer{EmailLocalPart: domain.EmailLocalPartExact}})
+	assert.Equal(t, domain.ErrEmailAlreadyTaken, exact.CheckEmailAvailable(ctx, "jack@GMAIL.COM"))
+	assert.Nil(t, exact.CheckEmailAvailable(ctx, "Jack@gmail.com"))
+}
+
+// failingUserRepository fails to get the users by email
+type failingUserRepository struct {
+	domain.UserRepository
+}
+
+func (r *failingUserRepository) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
+	return nil, fmt.Errorf("connection refused")
+}
+
+func TestLoginTiming(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	ur := getUserRepository(t)
+	// without the lockout the failures don't throttle the measured logins
+	opts := UserOptions{AccountLockout: &LockoutPolicy{}}
+	uc := NewUser(l, ur, opts)
+	failing := NewUser(l, &failingUserRepository{ur}, opts)
+	ctx := context.TODO()
+
+	login := func(uc domain.UserUsecase, email, password string) (time.Duration, error) {
+		start := time.Now()
+		_, err := uc.Login(ctx, &domain.LoginDTO{Identifier: email, Password: strfmt.Password(password)})
+		return time.Since(start), err
+	}
+
+	// the first login upgrades the legacy hash to the one the dummy hash uses,
+	// john keeps the legacy hash as only the wrong passwords are tried
+	if _, err := login(uc, "jack@gmail.com", "1234567"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := login(uc, "nobody@gmail.com", "1234567")
+	assert.Equal(t, domain.ErrEmailPasswordNotMatch, err)
+	_, err = login(uc, "jack@gmail.com", "wrong")
+	assert.Equal(t, domain.ErrEmailPasswordNotMatch, err)
+	_, err = login(uc, "john@gmail.com", "wrong")
+	assert.Equal(t, domain.ErrEmailPasswordNotMatch, err)
+	_, err = login(failing, "jack@gmail.com", "1234567")
+	assert.NotNil(t, err)
+	assert.NotEqual(t, domain.ErrEmailPasswordNotMatch, err)
+
+	// the cases take turns so a busy machine slows all of them alike
+	const rounds = 15
+	var unknown, wrong, legacy, failed []time.Duration
+	for i := 0; i < rounds; i++ {
+		d, _ := login(uc, "nobody@gmail.com", "1234567")
+		unknown = append(unknown, d)
+		d, _ = login(uc, "jack@gmail.com", "wrong")
+		wrong = append(wrong, d)
+		d, _ = login(uc, "john@gmail.com", "wrong")
+		legacy = append(legacy, d)
+		d, _ = login(failing, "jack@gmail.com", "1234567")
+		failed = append(failed, d)
+	}
+
+	median := func(ds []time.Duration) time.Duration {
+		sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+		return ds[len(ds)/2]
+	}
+	medians := []time.Duration{median(unknown), median(wrong), median(legacy), median(failed)}
+	for _, a := range medians {
+		for _, b := range medians {
+			assert.True(t, 2*a < 3*b, "the login timings differ: unknown %s, wrong password %s, legacy hash %s, failing repository %s",
+				medians[0], medians[1], medians[2], medians[3])
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	ur := getUserRepository(t)
+
+	uc := NewUser(l, ur, UserOptions{})
+	rd := &domain.RegisterDTO{Username: "gholi", Email: strfmt.Email("gholi@gmail.com"), Password: strfmt.Password("password"), RepeatPassword: strfmt.Password("password")}
+
+	first, err := uc.Create(context.TODO(), rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Greater(t, len(first.RefreshToken), 0)
+
+	second, err := uc.Refresh(context.TODO(), &domain.RefreshDTO{RefreshToken: first.RefreshToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Greater(t, len(second.Token), 0)
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+
+	_, err = uc.Refresh(context.TODO(), &domain.RefreshDTO{RefreshToken: "not a token"})
+	assert.Equal(t, domain.ErrInvalidRefreshToken, err)
+
+	// the first token is already rotated, using it again revokes the whole family
+	_, err = uc.Refresh(context.TODO(), &domain.RefreshDTO{RefreshToken: first.RefreshToken})
+	assert.Equal(t, domain.ErrRefreshTokenReused, err)
+
+	_, err = uc.Refresh(context.TODO(), &domain.RefreshDTO{RefreshToken: second.RefreshToken})
+	assert.Equal(t, domain.ErrInvalidRefreshToken, err)
+
+	// a user deleted in the repository can't refresh the sessions it still has
+	third, err := uc.Login(context.TODO(), &domain.LoginDTO{Identifier: "gholi", Password: "password"})
+	if !assert.Nil(t, err) {
+		return
+	}
+	u, _ := ur.GetByUsername(context.TODO(), "gholi")
+	assert.Nil(t, ur.Delete(context.TODO(), u.ID))
+	_, err = uc.Refresh(context.TODO(), &domain.RefreshDTO{RefreshToken: third.RefreshToken})
+	assert.Equal(t, domain.ErrInvalidRefreshToken, err)
+}
+
+func TestDeleteUser(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	ur := getUserRepository(t)
+	uc := NewUser(l, ur, UserOptions{})
+	ctx := context.TODO()
+
+	res, err := uc.Login(ctx, &domain.LoginDTO{Identifier: "jack", Password: "1234567"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jack, _ := ur.GetByUsername(ctx, "jack")
+
+	assert.Equal(t, domain.ErrNoUserFound, uc.DeleteUser(ctx, "no-such-id"))
+	assert.Nil(t, uc.DeleteUser(ctx, jack.ID))
+	assert.Equal(t, domain.ErrNoUserFound, uc.DeleteUser(ctx, jack.ID))
+
+	// the sessions of the user end with it
+	_, err = uc.Verify(ctx, res.Token)
+	assert.Equal(t, domain.ErrInvalidToken, err)
+	_, err = uc.Refresh(ctx, &domain.RefreshDTO{RefreshToken: res.RefreshToken})
+	assert.Equal(t, domain.ErrInvalidRefreshToken, err)
+	_, err = uc.Login(ctx, &domain.LoginDTO{Identifier: "jack", Password: "1234567"})
+	assert.Equal(t, domain.ErrEmailPasswordNotMatch, err)
+}
+
+type capturingNotifier struct {
+	resetTokens        map[string]string
+	verificationTokens map[string]string
+	magicLinkTokens    map[string]string
+	emailChanges       map[string]string
+}
+
+func newCapturingNotifier() *capturingNotifier {
+	return &capturingNotifier{resetTokens: make(map[string]string), verificationTokens: make(map[string]string),
+		magicLinkTokens: make(map[string]string), emailChanges: make(map[string]string)}
+}
+
+func (n *capturingNotifier) SendPasswordReset(ctx context.Context, u *domain.User, token string) error {
+	n.resetTokens[u.Email] = token
+	return nil
+}
+
+func (n *capturingNotifier) SendEmailVerification(ctx context.Context, u *domain.User, token string) error {
+	n.verificationTokens[u.Email] = token
+	return nil
+}
+
+func (n *capturingNotifier) SendEmailChanged(ctx context.Context, u *domain.User, newEmail string) error {
+	n.emailChanges[u.Email] = newEmail
+	return nil
+}
+
+func (n *capturingNotifier) SendMagicLink(ctx context.Context, u *domain.User, token string) error {
+	n.magicLinkTokens[u.Email] = token
+	return nil
+}
+
+func TestPasswordReset(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	ur := getUserRepository(t)
+	n := newCapturingNotifier()
+	uc := NewUser(l, ur, UserOptions{Notifier: n})
+	ctx := context.TODO()
+
+	session, err := uc.Login(ctx, &domain.LoginDTO{Identifier: "jack@gmail.com", Password: "1234567"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, uc.ForgotPassword(ctx, &domain.ForgotPasswordDTO{Email: "nobody@gmail.com"}))
+	assert.Len(t, n.resetTokens, 0)
+
+	assert.Nil(t, uc.ForgotPassword(ctx, &domain.ForgotPasswordDTO{Email: "jack@gmail.com"}))
+	first := n.resetTokens["jack@gmail.com"]
+	assert.Nil(t, uc.ForgotPassword(ctx, &domain.ForgotPasswordDTO{Email: "jack@gmail.com"}))
+	second := n.resetTokens["jack@gmail.com"]
+	assert.NotEqual(t, first, second)
+
+	err = uc.ResetPassword(ctx, &domain.ResetPasswordDTO{Token: second, Password: "new password", RepeatPassword: "another password"})
+	assert.Equal(t, domain.ErrPasswordsDoNotMatch, err)
+
+	err = uc.ResetPassword(ctx, &domain.ResetPasswordDTO{Token: "not a token", Password: "new password", RepeatPassword: "new password"})
+	assert.Equal(t, domain.ErrInvalidResetToken, err)
+
+	err = uc.ResetPassword(ctx, &domain.ResetPasswordDTO{Token: second, Password: "new password", RepeatPassword: "new password"})
+	assert.Nil(t, err)
+
+	// the token is single use and the other tokens of the user are gone
+	err = uc.ResetPassword(ctx, &domain.ResetPasswordDTO{Token: second, Password: "new password", RepeatPassword: "new password"})
+	assert.Equal(t, domain.ErrInvalidResetToken, err)
+	err = uc.ResetPassword(ctx, &domain.ResetPasswordDTO{Token: first, Password: "new password", RepeatPassword: "new password"})
+	assert.Equal(t, domain.ErrInvalidResetToken, err)
+
+	// the existing sessions are revoked
+	_, err = uc.Verify(ctx, session.Token)
+	assert.Equal(t, domain.ErrInvalidToken, err)
+	_, err = uc.Refresh(ctx, &domain.RefreshDTO{RefreshToken: session.RefreshToken})
+	assert.Equal(t, domain.ErrInvalidRefreshToken, err)
+
+	_, err = uc.Login(ctx, &domain.LoginDTO{Identifier: "jack@gmail.com", Password: "1234567"})
+	assert.Equal(t, domain.ErrEmailPasswordNotMatch, err)
+	_, err = uc.Login(ctx, &domain.LoginDTO{Identifier: "jack@gmail.com", Password: "new password"})
+	assert.Nil(t, err)
+}
+
+func TestPasswordResetRejections(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	ur := getUserRepository(t)
+	n := newCapturingNotifier()
+	uc := NewUser(l, ur, UserOptions{Notifier: n})
+	ctx := context.TODO()
+
+	// a password which can't be set keeps the token
+	assert.Nil(t, uc.ForgotPassword(ctx, &domain.ForgotPasswordDTO{Email: "jack@gmail.com"}))
+	token := n.resetTokens["jack@gmail.com"]
+	long := strfmt.Password(strings.Repeat("a", domain.MaxPasswordLength+1))
+	err := uc.ResetPassword(ctx, &domain.ResetPasswordDTO{Token: token, Password: long, RepeatPassword: long})
+	assert.Equal(t, domain.ErrPasswordTooLong, err)
+	err = uc.ResetPassword(ctx, &domain.ResetPasswordDTO{Token: token, Password: "new password", RepeatPassword: "new password"})
+	assert.Nil(t, err)
+
+	// the link of a deleted user is just invalid
+	assert.Nil(t, uc.ForgotPassword(ctx, &domain.ForgotPasswordDTO{Email: "jack@gmail.com"}))
+	token = n.resetTokens["jack@gmail.com"]
+	u, _ := ur.GetByEmail(ctx, "jack@gmail.com")
+	assert.Nil(t, uc.DeleteUser(ctx, u.ID))
+	err = uc.ResetPassword(ctx, &domain.ResetPasswordDTO{Token: token, Password: "new password", RepeatPassword: "new password"})
+	assert.Equal(t, domain.ErrInvalidResetToken, err)
+}
+
+func TestPasswordResetExpires(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	n := newCapturingNotifier()
+	expiresAfter := -time.Second
+	uc := NewUser(l, getUserRepository(t), UserOptions{Notifier: n, PasswordResetExpiresAfter: &expiresAfter})
+
+	assert.Nil(t, uc.ForgotPassword(context.TODO(), &domain.ForgotPasswordDTO{Email: "jack@gmail.com"}))
+	err := uc.ResetPassword(context.TODO(), &domain.ResetPasswordDTO{Token: n.resetTokens["jack@gmail.com"], Password: "new password", RepeatPassword: "new password"})
+	assert.Equal(t, domain.ErrInvalidResetToken, err)
+}
+
+func TestEmailVerification(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	n := newCapturingNotifier()
+	uc := NewUser(l, getUserRepository(t), UserOptions{Notifier: n, RequireVerifiedEmail: true})
+	ctx := context.TODO()
+
+	rd := &domain.RegisterDTO{Username: "vahid", Email: "vahid@gmail.com", Password: "1234567", RepeatPassword: "1234567"}
+	_, err := uc.Create(ctx, rd)
+	assert.Equal(t, domain.ErrEmailNotVerified, err)
+	token := n.verificationTokens["vahid@gmail.com"]
+	assert.NotEmpty(t, token)
+
+	ld := &domain.LoginDTO{Identifier: "vahid@gmail.com", Password: "1234567"}
+	_, err = uc.Login(ctx, ld)
+	assert.Equal(t, domain.ErrEmailNotVerified, err)
+
+	// the existing users aren't verified either
+	_, err = uc.Login(ctx, &domain.LoginDTO{Identifier: "jack@gmail.com", Password: "1234567"})
+	assert.Equal(t, domain.ErrEmailNotVerified, err)
+
+	// the verification token isn't an access token
+	_, err = uc.Verify(ctx, token)
+	assert.Equal(t, domain.ErrInvalidToken, err)
+
+	assert.Equal(t, domain.ErrInvalidVerificationToken, uc.VerifyEmail(ctx, "not a token"))
+
+	assert.Nil(t, uc.ResendVerification(ctx, &domain.ResendVerificationDTO{Email: "nobody@gmail.com"}))
+	assert.Len(t, n.verificationTokens, 1)
+
+	assert.Nil(t, uc.VerifyEmail(ctx, token))
+	_, err = uc.Login(ctx, ld)
+	assert.Nil(t, err)
+
+	// verifying twice is harmless and nothing is resent to a verified address
+	assert.Nil(t, uc.VerifyEmail(ctx, token))
+	delete(n.verificationTokens, "vahid@gmail.com")
+	assert.Nil(t, uc.ResendVerification(ctx, &domain.ResendVerificationDTO{Email: "vahid@gmail.com"}))
+	assert.Len(t, n.verificationTokens, 0)
+}
+
+func TestEmailVerificationGracePeriod(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	n := newCapturingNotifier()
+	uc := NewUser(l, getUserRepository(t), UserOptions{Notifier: n, RequireVerifiedEmail: true, VerificationGracePeriod: time.Hour})
+
+	rd := &domain.RegisterDTO{Username: "vahid", Email: "vahid@gmail.com", Password: "1234567", RepeatPassword: "1234567"}
+	res, err := uc.Create(context.TODO(), rd)
+	assert.Nil(t, err)
+	assert.NotNil(t, res)
+	assert.NotEmpty(t, n.verificationTokens["vahid@gmail.com"])
+
+	// an access token can't verify the email
+	assert.Equal(t, domain.ErrInvalidVerificationToken, uc.VerifyEmail(context.TODO(), res.Token))
+}
+
+func TestMagicLink(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	n := newCapturingNotifier()
+	sender := newCapturingNotifier()
+	uc := NewUser(l, getUserRepository(t), UserOptions{Notifier: n, MagicLinkSender: sender, RequireVerifiedEmail: true})
+	ctx := context.TODO()
+
+	assert.Nil(t, uc.SendMagicLink(ctx, &domain.MagicLinkDTO{Email: "nobody@gmail.com"}))
+	assert.Len(t, sender.magicLinkTokens, 0)
+
+	assert.Nil(t, uc.SendMagicLink(ctx, &domain.MagicLinkDTO{Email: "jack@gmail.com"}))
+	token := sender.magicLinkTokens["jack@gmail.com"]
+	assert.NotEmpty(t, token)
+	assert.Len(t, n.magicLinkTokens, 0)
+
+	// the login token isn't an access token
+	_, err := uc.Verify(ctx, token)
+	assert.Equal(t, domain.ErrInvalidToken, err)
+
+	_, err = uc.LoginByMagicLink(ctx, &domain.MagicLinkLoginDTO{Token: "not a token"})
+	assert.Equal(t, domain.ErrInvalidMagicLinkToken, err)
+
+	// the link verifies the email, so the unverified user can log in
+	res, err := uc.LoginByMagicLink(ctx, &domain.MagicLinkLoginDTO{Token: token})
+	if assert.Nil(t, err) {
+		claims, err := uc.Verify(ctx, res.Token)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, claims.Subject)
+	}
+	_, err = uc.Login(ctx, &domain.LoginDTO{Identifier: "jack@gmail.com", Password: "1234567"})
+	assert.Nil(t, err)
+
+	_, err = uc.LoginByMagicLink(ctx, &domain.MagicLinkLoginDTO{Token: token})
+	assert.Equal(t, domain.ErrInvalidMagicLinkToken, err)
+}
+
+func TestMFA(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	uc := NewUser(l, getUserRepository(t), UserOptions{})
+	ctx := context.TODO()
+	ld := &domain.LoginDTO{Identifier: "jack@gmail.com", Password: "1234567"}
+
+	session, err := uc.Login(ctx, ld)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, _ := uc.Verify(ctx, session.Token)
+
+	_, err = uc.ConfirmTOTP(ctx, claims, &domain.MFACodeDTO{Code: "123456"})
+	assert.Equal(t, domain.ErrNoMFAEnrollment, err)
+
+	enrollment, err := uc.EnrollTOTP(ctx, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/minaria:jack@gmail.com?"))
+	assert.Equal(t, "\x89PNG", string(enrollment.QRCode[:4]))
+	key, _ := totpEncoding.DecodeString(enrollment.Secret)
+	code := func(step int64) string { return hotp(key, uint64(step), totpDigits) }
+	step := totpStep(time.Now())
+
+	// the secret isn't used before it's confirmed
+	res, err := uc.Login(ctx, ld)
+	assert.Nil(t, err)
+	assert.False(t, res.MFARequired)
+
+	_, err = uc.ConfirmTOTP(ctx, claims, &domain.MFACodeDTO{Code: "000000"})
+	assert.Equal(t, domain.ErrInvalidMFACode, err)
+	recovery, err := uc.ConfirmTOTP(ctx, claims, &domain.MFACodeDTO{Code: code(step)})
+	assert.Nil(t, err)
+	assert.Len(t, recovery.Codes, 10)
+	_, err = uc.EnrollTOTP(ctx, claims)
+	assert.Equal(t, domain.ErrMFAAlreadyEnabled, err)
+
+	res, err = uc.Login(ctx, ld)
+	assert.Nil(t, err)
+	assert.True(t, res.MFARequired)
+	assert.Empty(t, res.Token)
+	assert.Empty(t, res.RefreshToken)
+
+	// the mfa token isn't an access token
+	_, err = uc.Verify(ctx, res.MFAToken)
+	assert.Equal(t, domain.ErrInvalidToken, err)
+
+	// the code of the confirmation can't be replayed and a wrong code burns the mfa token
+	_, err = uc.VerifyMFA(ctx, &domain.MFAVerifyDTO{MFAToken: res.MFAToken, Code: code(step)})
+	assert.Equal(t, domain.ErrInvalidMFACode, err)
+	_, err = uc.VerifyMFA(ctx, &domain.MFAVerifyDTO{MFAToken: res.MFAToken, Code: code(step + 1)})
+	assert.Equal(t, domain.ErrInvalidMFAToken, err)
+
+	res, _ = uc.Login(ctx, ld)
+	session, err = uc.VerifyMFA(ctx, &domain.MFAVerifyDTO{MFAToken: res.MFAToken, Code: code(step + 1)})
+	if assert.Nil(t, err) {
+		_, err = uc.Verify(ctx, session.Token)
+		assert.Nil(t, err)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	uc := NewUser(l, getUserRepository(t), UserOptions{})
+	ctx := context.TODO()
+	ld := &domain.LoginDTO{Identifier: "jack@gmail.com", Password: "1234567"}
+
+	session, _ := uc.Login(ctx, ld)
+	claims, _ := uc.Verify(ctx, session.Token)
+
+	_, err := uc.RegenerateRecoveryCodes(ctx, claims)
+	assert.Equal(t, domain.ErrMFANotEnabled, err)
+
+	enrollment, _ := uc.EnrollTOTP(ctx, claims)
+	key, _ := totpEncoding.DecodeString(enrollment.Secret)
+	first, err := uc.ConfirmTOTP(ctx, claims, &domain.MFACodeDTO{Code: hotp(key, uint64(totpStep(time.Now())), totpDigits)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(code string) error {
+		res, err := uc.Login(ctx, ld)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = uc.VerifyMFA(ctx, &domain.MFAVerifyDTO{MFAToken: res.MFAToken, RecoveryCode: code})
+		return err
+	}
+
+	// the codes are case and dash insensitive and each of them works once
+	assert.Nil(t, verify(strings.ToUpper(strings.ReplaceAll(first.Codes[0], "-", ""))))
+	assert.Equal(t, domain.ErrInvalidMFACode, verify(first.Codes[0]))
+	assert.Equal(t, domain.ErrInvalidMFACode, verify("aaaa-bbbb-cccc-dddd"))
+
+	second, err := uc.RegenerateRecoveryCodes(ctx, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, second.Codes, 10)
+	assert.Equal(t, domain.ErrInvalidMFACode, verify(first.Codes[1]))
+	assert.Nil(t, verify(second.Codes[1]))
+}
+
+func TestUpdateProfile(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	n := newCapturingNotifier()
+	ur := getUserRepository(t)
+	uc := NewUser(l, ur, UserOptions{Notifier: n})
+	ctx := context.TODO()
+
+	rd := &domain.RegisterDTO{Username: "vahid", Email: "vahid@gmail.com", Password: "1234567", RepeatPassword: "1234567"}
+	_, err := uc.Create(ctx, rd)
+	assert.Nil(t, err)
+	assert.Nil(t, uc.VerifyEmail(ctx, n.verificationTokens["vahid@gmail.com"]))
+	u, _ := ur.GetByEmail(ctx, "vahid@gmail.com")
+	claims := &domain.Claims{StandardClaims: jwt.StandardClaims{Subject: u.ID}}
+
+	p, err := uc.GetProfile(ctx, claims)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.True(t, p.Verified)
+	read := p.Version
+
+	// a new username keeps the verification
+	p, err = uc.UpdateProfile(ctx, claims, read, &domain.UpdateProfileDTO{Username: "Vahid_M"})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "Vahid_M", p.Username)
+	assert.Equal(t, "vahid@gmail.com", p.Email)
+	assert.True(t, p.Verified)
+	assert.Equal(t, read+1, p.Version)
+
+	// the version which was read is stale now
+	_, err = uc.UpdateProfile(ctx, claims, read, &domain.UpdateProfileDTO{Username: "another"})
+	assert.Equal(t, domain.ErrConflict, err)
+
+	_, err = uc.UpdateProfile(ctx, claims, 0, &domain.UpdateProfileDTO{Username: "JACK"})
+	assert.Equal(t, domain.ErrUsernameAlreadyTaken, err)
+	_, err = uc.UpdateProfile(ctx, claims, 0, &domain.UpdateProfileDTO{Email: "Jack@Gmail.com", CurrentPassword: "1234567"})
+	assert.Equal(t, domain.ErrEmailAlreadyTaken, err)
+
+	// the email address needs the current password, another case of the same address doesn't
+	_, err = uc.UpdateProfile(ctx, claims, 0, &domain.UpdateProfileDTO{Email: "vahid@provider.net"})
+	assert.Equal(t, domain.ErrCurrentPasswordRequired, err)
+	_, err = uc.UpdateProfile(ctx, claims, 0, &domain.UpdateProfileDTO{Email: "vahid@provider.net", CurrentPassword: "7654321"})
+	assert.Equal(t, domain.ErrCurrentPasswordNotMatch, err)
+	p, err = uc.UpdateProfile(ctx, claims, 0, &domain.UpdateProfileDTO{Email: "Vahid@Gmail.com"})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.True(t, p.Verified)
+	assert.Len(t, n.emailChanges, 0)
+
+	// 0 is whichever version is stored, a new address has to be verified again
+	// and the old one is told about the change
+	p, err = uc.UpdateProfile(ctx, claims, 0, &domain.UpdateProfileDTO{Email: "vahid@provider.net", CurrentPassword: "1234567"})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "Vahid_M", p.Username)
+	assert.False(t, p.Verified)
+	assert.Equal(t, read+3, p.Version)
+	assert.NotEmpty(t, n.verificationTokens["vahid@provider.net"])
+	assert.Equal(t, "vahid@provider.net", n.emailChanges["Vahid@Gmail.com"])
+
+	_, err = uc.GetProfile(ctx, &domain.Claims{StandardClaims: jwt.StandardClaims{Subject: "nobody"}})
+	assert.Equal(t, domain.ErrNoUserFound, err)
+}
@@ -0,0 +1,379 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vahidmostofi/minaria/domain"
+	"github.com/vahidmostofi/minaria/repositories"
+)
+
+// how long the client has to finish a ceremony
+const webAuthnTimeout = 5 * time.Minute
+
+// the authenticator data flags
+const (
+	authDataUserPresent  byte = 0x01
+	authDataUserVerified byte = 0x04
+	authDataAttested     byte = 0x40
+)
+
+// clientData is the part of the CollectedClientData which is checked
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// authenticatorData is the parsed authenticator data, the attested
+// credential members are only set in the registration
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+// decodeBase64URL decodes the base64url encoded members of the responses, with or without padding
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func parseAuthenticatorData(b []byte) (*authenticatorData, error) {
+	if len(b) < 37 {
+		return nil, domain.ErrInvalidWebAuthnResponse
+	}
+	ad := &authenticatorData{rpIDHash: b[:32], flags: b[32], signCount: binary.BigEndian.Uint32(b[33:37])}
+	if ad.flags&authDataAttested == 0 {
+		return ad, nil
+	}
+
+	b = b[37:]
+	if len(b) < 18 {
+		return nil, domain.ErrInvalidWebAuthnResponse
+	}
+	ad.aaguid = b[:16]
+	l := int(binary.BigEndian.Uint16(b[16:18]))
+	b = b[18:]
+	if l == 0 || l > 1023 || len(b) < l {
+		return nil, domain.ErrInvalidWebAuthnResponse
+	}
+	ad.credentialID = b[:l]
+	b = b[l:]
+
+	// the key is followed by the extensions, decoding it tells where it ends
+	_, rest, err := decodeCBOR(b)
+	if err != nil {
+		return nil, domain.ErrInvalidWebAuthnResponse
+	}
+	ad.publicKey = b[:len(b)-len(rest)]
+	return ad, nil
+}
+
+// newWebAuthnChallenge stores a single use challenge for the ceremony
+func (uc *User) newWebAuthnChallenge(ctx context.Context, userID, purpose string) (string, error) {
+	challenge, err := randomToken()
+	if err != nil {
+		return "", fmt.Errorf("error while generating the challenge: %w", err)
+	}
+
+	now := time.Now()
+	err = uc.ottr.Store(ctx, &domain.OneTimeToken{
+		Hash:      hashToken(challenge),
+		UserID:    userID,
+		Purpose:   purpose,
+		ExpiresAt: now.Add(webAuthnTimeout),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error while storing the challenge: %w", err)
+	}
+	return challenge, nil
+}
+
+// verifyClientData checks the client data of a ceremony and consumes its
+// challenge, it returns the challenge's record
+func (uc *User) verifyClientData(ctx context.Context, raw []byte, typ, purpose string) (*domain.OneTimeToken, error) {
+	cd := &clientData{}
+	if err := json.Unmarshal(raw, cd); err != nil {
+		return nil, domain.ErrInvalidWebAuthnResponse
+	}
+	if cd.Type != typ || cd.CrossOrigin || !uc.webAuthnOrigin(cd.Origin) {
+		return nil, domain.ErrInvalidWebAuthnResponse
+	}
+
+	t, err := uc.ottr.Consume(ctx, hashToken(cd.Challenge), purpose)
+	if err == repositories.ErrNoOneTimeTokenFound {
+		return nil, domain.ErrInvalidWebAuthnResponse
+	} else if err != nil {
+		return nil, fmt.Errorf("error while consuming the challenge: %w", err)
+	}
+	if time.Now().After(t.ExpiresAt) {
+		return nil, domain.ErrInvalidWebAuthnResponse
+	}
+	return t, nil
+}
+
+func (uc *User) webAuthnOrigin(origin string) bool {
+	for _, o := range uc.webAuthnOrigins {
+		if o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyAuthenticatorData checks the relying party and that the user was present and verified
+func (uc *User) verifyAuthenticatorData(ad *authenticatorData) error {
+	h := sha256.Sum256([]byte(uc.webAuthnRPID))
+	if subtle.ConstantTimeCompare(ad.rpIDHash, h[:]) != 1 {
+		return domain.ErrInvalidWebAuthnResponse
+	}
+	// the credentials replace the password, so they are two factors on their own
+	if ad.flags&authDataUserPresent == 0 || ad.flags&authDataUserVerified == 0 {
+		return domain.ErrInvalidWebAuthnResponse
+	}
+	return nil
+}
+
+func webAuthnDescriptors(creds []*domain.WebAuthnCredential) []domain.WebAuthnCredentialDescriptor {
+	res := make([]domain.WebAuthnCredentialDescriptor, len(creds))
+	for i, c := range creds {
+		res[i] = domain.WebAuthnCredentialDescriptor{
+			Type:       "public-key",
+			ID:         base64.RawURLEncoding.EncodeToString(c.ID),
+			Transports: c.Transports,
+		}
+	}
+	return res
+}
+
+func (uc *User) BeginWebAuthnRegistration(ctx context.Context, claims *domain.Claims) (*domain.WebAuthnCreationOptionsDTO, error) {
+	user, err := uc.r.GetByID(ctx, claims.Subject)
+	if err == repositories.ErrNoUserFound {
+		return nil, domain.ErrNoUserFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error while getting the user: %w", err)
+	}
+
+	creds, err := uc.wcr.GetByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting the webauthn credentials: %w", err)
+	}
+
+	challenge, err := uc.newWebAuthnChallenge(ctx, user.ID, domain.WebAuthnRegistrationPurpose)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &domain.WebAuthnCreationOptionsDTO{}
+	o := &res.PublicKey
+	o.Challenge = challenge
+	o.RP = domain.WebAuthnRelyingParty{ID: uc.webAuthnRPID, Name: uc.webAuthnRPName}
+	// the user handle is the ID, it doesn't reveal anything about the user
+	o.User = domain.WebAuthnUserEntity{
+		ID:          base64.RawURLEncoding.EncodeToString([]byte(user.ID)),
+		Name:        user.Email,
+		DisplayName: user.Username,
+	}
+	for _, alg := range coseAlgs {
+		o.PubKeyCredParams = append(o.PubKeyCredParams, domain.WebAuthnCredentialParameter{Type: "public-key", Alg: alg})
+	}
+	o.Timeout = webAuthnTimeout.Milliseconds()
+	o.ExcludeCredentials = webAuthnDescriptors(creds)
+	o.AuthenticatorSelection = domain.WebAuthnAuthenticatorSelection{ResidentKey: "preferred", UserVerification: "required"}
+	o.Attestation = "none"
+
+	return res, nil
+}
+
+// FinishWebAuthnRegistration verifies the new credential and stores it. The
+// attestation statement isn't verified, the attestation "none" is requested
+// and the authenticator models aren't restricted.
+func (uc *User) FinishWebAuthnRegistration(ctx context.Context, claims *domain.Claims, rd *domain.WebAuthnRegistrationDTO) error {
+	rawClientData, err := decodeBase64URL(rd.Response.ClientDataJSON)
+	if err != nil {
+		return domain.ErrInvalidWebAuthnResponse
+	}
+	t, err := uc.verifyClientData(ctx, rawClientData, "webauthn.create", domain.WebAuthnRegistrationPurpose)
+	if err != nil {
+		return err
+	}
+	if t.UserID != claims.Subject {
+		return domain.ErrInvalidWebAuthnResponse
+	}
+
+	rawAttestation, err := decodeBase64URL(rd.Response.AttestationObject)
+	if err != nil {
+		return domain.ErrInvalidWebAuthnResponse
+	}
+	// the attestation object is the only item, nothing may follow it
+	v, rest, err := decodeCBOR(rawAttestation)
+	if err != nil || len(rest) != 0 {
+		return domain.ErrInvalidWebAuthnResponse
+	}
+	attestation, _ := v.(map[interface{}]interface{})
+	rawAuthData, _ := attestation["authData"].([]byte)
+
+	ad, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return err
+	}
+	if err := uc.verifyAuthenticatorData(ad); err != nil {
+		return err
+	}
+	if ad.credentialID == nil {
+		return domain.ErrInvalidWebAuthnResponse
+	}
+	if rawID, err := decodeBase64URL(rd.RawID); err != nil || !bytes.Equal(rawID, ad.credentialID) {
+		return domain.ErrInvalidWebAuthnResponse
+	}
+	if _, err := parseCOSEKey(ad.publicKey); err != nil {
+		return domain.ErrInvalidWebAuthnResponse
+	}
+
+	now := time.Now()
+	err = uc.wcr.Store(ctx, &domain.WebAuthnCredential{
+		ID:         ad.credentialID,
+		UserID:     t.UserID,
+		PublicKey:  ad.publicKey,
+		SignCount:  ad.signCount,
+		AAGUID:     ad.aaguid,
+		Transports: rd.Response.Transports,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	})
+	if err == repositories.ErrWebAuthnCredentialNotUnique {
+		return domain.ErrWebAuthnCredentialExists
+	} else if err != nil {
+		return fmt.Errorf("error while storing the webauthn credential: %w", err)
+	}
+	return nil
+}
+
+// BeginWebAuthnLogin returns the options of the login ceremony, with an email address
+// the credentials of the user are allowed, otherwise the discoverable credentials
+func (uc *User) BeginWebAuthnLogin(ctx context.Context, ld *domain.WebAuthnLoginDTO) (*domain.WebAuthnRequestOptionsDTO, error) {
+	var userID string
+	creds := []*domain.WebAuthnCredential{}
+	if ld.Email != "" {
+		user, err := uc.getByEmail(ctx, ld.Email.String())
+		if err != nil && err != repositories.ErrNoUserFound {
+			return nil, fmt.Errorf("error while getting the user: %w", err)
+		}
+		// an unknown email gets the same answer as a user without credentials
+		if user != nil {
+			userID = user.ID
+			if creds, err = uc.wcr.GetByUserID(ctx, user.ID); err != nil {
+				return nil, fmt.Errorf("error while getting the webauthn credentials: %w", err)
+			}
+		}
+	}
+
+	challenge, err := uc.newWebAuthnChallenge(ctx, userID, domain.WebAuthnLoginPurpose)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &domain.WebAuthnRequestOptionsDTO{}
+	o := &res.PublicKey
+	o.Challenge = challenge
+	o.RPID = uc.webAuthnRPID
+	o.Timeout = webAuthnTimeout.Milliseconds()
+	o.AllowCredentials = webAuthnDescriptors(creds)
+	o.UserVerification = "required"
+
+	return res, nil
+}
+
+// FinishWebAuthnLogin verifies the assertion of a registered credential and
+// returns the tokens, a passkey is both factors so the login skips the totp
+func (uc *User) FinishWebAuthnLogin(ctx context.Context, ad *domain.WebAuthnAssertionDTO) (*domain.JWTDTO, error) {
+	rawClientData, err := decodeBase64URL(ad.Response.ClientDataJSON)
+	if err != nil {
+		return nil, domain.ErrInvalidWebAuthnResponse
+	}
+	t, err := uc.verifyClientData(ctx, rawClientData, "webauthn.get", domain.WebAuthnLoginPurpose)
+	if err != nil {
+		return nil, err
+	}
+
+	rawID, err := decodeBase64URL(ad.RawID)
+	if err != nil {
+		return nil, domain.ErrInvalidWebAuthnResponse
+	}
+	cred, err := uc.wcr.GetByID(ctx, rawID)
+	if err == repositories.ErrNoWebAuthnCredentialFound {
+		return nil, domain.ErrInvalidWebAuthnResponse
+	} else if err != nil {
+		return nil, fmt.Errorf("error while getting the webauthn credential: %w", err)
+	}
+	// the login which started with an email address must end with a credential of the user
+	if t.UserID != "" && t.UserID != cred.UserID {
+		return nil, domain.ErrInvalidWebAuthnResponse
+	}
+	if ad.Response.UserHandle != "" {
+		userHandle, err := decodeBase64URL(ad.Response.UserHandle)
+		if err != nil || string(userHandle) != cred.UserID {
+			return nil, domain.ErrInvalidWebAuthnResponse
+		}
+	}
+
+	rawAuthData, err := decodeBase64URL(ad.Response.AuthenticatorData)
+	if err != nil {
+		return nil, domain.ErrInvalidWebAuthnResponse
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := uc.verifyAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+
+	sig, err := decodeBase64URL(ad.Response.Signature)
+	if err != nil {
+		return nil, domain.ErrInvalidWebAuthnResponse
+	}
+	key, err := parseCOSEKey(cred.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing the stored public key: %w", err)
+	}
+	clientDataHash := sha256.Sum256(rawClientData)
+	if !key.verify(append(append([]byte{}, rawAuthData...), clientDataHash[:]...), sig) {
+		return nil, domain.ErrInvalidWebAuthnResponse
+	}
+
+	// a counter which doesn't increase means the authenticator was cloned
+	if (authData.signCount != 0 || cred.SignCount != 0) && authData.signCount <= cred.SignCount {
+		uc.l.Warnf("Webauthn signature counter of user %s didn't increase, the authenticator may be cloned.", cred.UserID)
+		return nil, domain.ErrInvalidWebAuthnResponse
+	}
+	if err := uc.wcr.UpdateSignCount(ctx, cred.ID, authData.signCount); err != nil {
+		return nil, fmt.Errorf("error while updating the signature counter: %w", err)
+	}
+
+	user, err := uc.r.GetByID(ctx, cred.UserID)
+	if err == repositories.ErrNoUserFound {
+		return nil, domain.ErrInvalidWebAuthnResponse
+	} else if err != nil {
+		return nil, fmt.Errorf("error while getting the user: %w", err)
+	}
+	if !uc.canLogin(user) {
+		return nil, domain.ErrEmailNotVerified
+	}
+
+	return uc.issueTokens(ctx, user.ID, user.Username, uuid.New().String())
+}
@@ -0,0 +1,325 @@
+package usecase
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/vahidmostofi/minaria/domain"
+)
+
+// cborMap keeps the order of the keys, so the encoding is deterministic
+type cborMap [][2]interface{}
+
+// encodeCBOR encodes the subset of cbor the software authenticator needs
+func encodeCBOR(v interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 1<<8:
+			return []byte{major<<5 | 24, byte(n)}
+		case n < 1<<16:
+			b := []byte{major<<5 | 25, 0, 0}
+			binary.BigEndian.PutUint16(b[1:], uint16(n))
+			return b
+		default:
+			b := []byte{major<<5 | 26, 0, 0, 0, 0}
+			binary.BigEndian.PutUint32(b[1:], uint32(n))
+			return b
+		}
+	}
+
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case int64:
+		return encodeCBOR(int(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case cborMap:
+		b := head(5, uint64(len(v)))
+		for _, kv := range v {
+			b = append(b, encodeCBOR(kv[0])...)
+			b = append(b, encodeCBOR(kv[1])...)
+		}
+		return b
+	}
+	panic("unsupported cbor type")
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	// RFC 8949 appendix A
+	tests := []struct {
+		hex  string
+		want interface{}
+	}{
+		{"00", int64(0)},
+		{"17", int64(23)},
+		{"1818", int64(24)},
+		{"1903e8", int64(1000)},
+		{"1b000000e8d4a51000", int64(1000000000000)},
+		{"20", int64(-1)},
+		{"3863", int64(-100)},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"6449455446", "IETF"},
+		{"83010203", []interface{}{int64(1), int64(2), int64(3)}},
+		{"a201020304", map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}},
+		{"f5", true},
+		{"f6", nil},
+	}
+
+	for _, tt := range tests {
+		b, _ := hex.DecodeString(tt.hex)
+		v, rest, err := decodeCBOR(b)
+		assert.Nil(t, err, tt.hex)
+		assert.Len(t, rest, 0, tt.hex)
+		assert.Equal(t, tt.want, v, tt.hex)
+	}
+
+	for _, invalid := range []string{
+		"",
+		"19ff",       // truncated argument
+		"4401",       // truncated byte string
+		"5f42010243", // indefinite length
+		"a201020102", // duplicated key
+		"a1f5f5",     // boolean key
+		"c11a514b67b0",
+		"fb3ff199999999999a",
+		"818181818181818181818181818181818181818100", // too deep
+	} {
+		b, _ := hex.DecodeString(invalid)
+		_, _, err := decodeCBOR(b)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+// softAuthenticator is a WebAuthn authenticator in software, it creates a
+// discoverable credential and signs the assertions like a real one would
+type softAuthenticator struct {
+	origin     string
+	credID     []byte
+	userHandle []byte
+	signCount  uint32
+	flags      byte
+
+	ecKey *ecdsa.PrivateKey
+	edKey ed25519.PrivateKey
+}
+
+func newSoftAuthenticator(t *testing.T, alg int64) *softAuthenticator {
+	a := &softAuthenticator{origin: "http://localhost:9090", credID: make([]byte, 16), flags: authDataUserPresent | authDataUserVerified}
+	rand.Read(a.credID)
+
+	var err error
+	switch alg {
+	case coseAlgES256:
+		a.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case coseAlgEdDSA:
+		_, a.edKey, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	if a.ecKey != nil {
+		x := make([]byte, 32)
+		y := make([]byte, 32)
+		a.ecKey.X.FillBytes(x)
+		a.ecKey.Y.FillBytes(y)
+		return encodeCBOR(cborMap{{1, 2}, {3, -7}, {-1, 1}, {-2, x}, {-3, y}})
+	}
+	return encodeCBOR(cborMap{{1, 1}, {3, -8}, {-1, 6}, {-2, []byte(a.edKey.Public().(ed25519.PublicKey))}})
+}
+
+func (a *softAuthenticator) authData(rpID string, attested bool) []byte {
+	h := sha256.Sum256([]byte(rpID))
+	b := append([]byte{}, h[:]...)
+	flags := a.flags
+	if attested {
+		flags |= authDataAttested
+	}
+	b = append(b, flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[33:], a.signCount)
+	if attested {
+		b = append(b, make([]byte, 16)...)
+		b = append(b, byte(len(a.credID)>>8), byte(len(a.credID)))
+		b = append(b, a.credID...)
+		b = append(b, a.coseKey()...)
+	}
+	return b
+}
+
+func (a *softAuthenticator) clientData(typ, challenge string) []byte {
+	b, _ := json.Marshal(&clientData{Type: typ, Challenge: challenge, Origin: a.origin})
+	return b
+}
+
+func (a *softAuthenticator) create(o *domain.WebAuthnCreationOptionsDTO) *domain.WebAuthnRegistrationDTO {
+	a.userHandle, _ = decodeBase64URL(o.PublicKey.User.ID)
+	attestation := encodeCBOR(cborMap{
+		{"fmt", "none"},
+		{"attStmt", cborMap{}},
+		{"authData", a.authData(o.PublicKey.RP.ID, true)},
+	})
+
+	enc := base64.RawURLEncoding.EncodeToString
+	return &domain.WebAuthnRegistrationDTO{
+		RawID: enc(a.credID),
+		Type:  "public-key",
+		Response: domain.WebAuthnAttestationResponse{
+			ClientDataJSON:    enc(a.clientData("webauthn.create", o.PublicKey.Challenge)),
+			AttestationObject: enc(attestation),
+			Transports:        []string{"internal"},
+		},
+	}
+}
+
+func (a *softAuthenticator) get(o *domain.WebAuthnRequestOptionsDTO) *domain.WebAuthnAssertionDTO {
+	a.signCount++
+	authData := a.authData(o.PublicKey.RPID, false)
+	cd := a.clientData("webauthn.get", o.PublicKey.Challenge)
+	h := sha256.Sum256(cd)
+	msg := append(append([]byte{}, authData...), h[:]...)
+
+	var sig []byte
+	if a.ecKey != nil {
+		digest := sha256.Sum256(msg)
+		sig, _ = ecdsa.SignASN1(rand.Reader, a.ecKey, digest[:])
+	} else {
+		sig = ed25519.Sign(a.edKey, msg)
+	}
+
+	enc := base64.RawURLEncoding.EncodeToString
+	return &domain.WebAuthnAssertionDTO{
+		RawID: enc(a.credID),
+		Type:  "public-key",
+		Response: domain.WebAuthnAssertionResponse{
+			ClientDataJSON:    enc(cd),
+			AuthenticatorData: enc(authData),
+			Signature:         enc(sig),
+			UserHandle:        enc(a.userHandle),
+		},
+	}
+}
+
+func TestWebAuthn(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+
+	uc := NewUser(l, getUserRepository(t), UserOptions{})
+	ctx := context.TODO()
+
+	session, err := uc.Login(ctx, &domain.LoginDTO{Identifier: "jack@gmail.com", Password: "1234567"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, _ := uc.Verify(ctx, session.Token)
+
+	register := func(a *softAuthenticator) error {
+		o, err := uc.BeginWebAuthnRegistration(ctx, claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return uc.FinishWebAuthnRegistration(ctx, claims, a.create(o))
+	}
+	login := func(a *softAuthenticator, email string) (*domain.JWTDTO, error) {
+		o, err := uc.BeginWebAuthnLogin(ctx, &domain.WebAuthnLoginDTO{Email: strfmt.Email(email)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return uc.FinishWebAuthnLogin(ctx, a.get(o))
+	}
+
+	platform := newSoftAuthenticator(t, coseAlgES256)
+	roaming := newSoftAuthenticator(t, coseAlgEdDSA)
+	assert.Nil(t, register(platform))
+	assert.Nil(t, register(roaming))
+	assert.Equal(t, domain.ErrWebAuthnCredentialExists, register(platform))
+
+	// the attestation object must not be followed by anything
+	trailing := newSoftAuthenticator(t, coseAlgES256)
+	o, _ := uc.BeginWebAuthnRegistration(ctx, claims)
+	rd := trailing.create(o)
+	attestation, _ := base64.RawURLEncoding.DecodeString(rd.Response.AttestationObject)
+	rd.Response.AttestationObject = base64.RawURLEncoding.EncodeToString(append(attestation, 0x00))
+	assert.Equal(t, domain.ErrInvalidWebAuthnResponse, uc.FinishWebAuthnRegistration(ctx, claims, rd))
+
+	o, _ = uc.BeginWebAuthnRegistration(ctx, claims)
+	assert.Len(t, o.PublicKey.ExcludeCredentials, 2)
+	assert.Equal(t, "localhost", o.PublicKey.RP.ID)
+
+	res, err := login(platform, "")
+	if assert.Nil(t, err) {
+		c, err := uc.Verify(ctx, res.Token)
+		assert.Nil(t, err)
+		assert.Equal(t, claims.Subject, c.Subject)
+	}
+	_, err = login(roaming, "jack@gmail.com")
+	assert.Nil(t, err)
+
+	ro, _ := uc.BeginWebAuthnLogin(ctx, &domain.WebAuthnLoginDTO{Email: "jack@gmail.com"})
+	assert.Len(t, ro.PublicKey.AllowCredentials, 2)
+	ro, _ = uc.BeginWebAuthnLogin(ctx, &domain.WebAuthnLoginDTO{Email: "nobody@gmail.com"})
+	assert.Len(t, ro.PublicKey.AllowCredentials, 0)
+
+	// the assertion can't be replayed
+	ro, _ = uc.BeginWebAuthnLogin(ctx, &domain.WebAuthnLoginDTO{})
+	assertion := platform.get(ro)
+	_, err = uc.FinishWebAuthnLogin(ctx, assertion)
+	assert.Nil(t, err)
+	_, err = uc.FinishWebAuthnLogin(ctx, assertion)
+	assert.Equal(t, domain.ErrInvalidWebAuthnResponse, err)
+
+	// the login which started for another user
+	_, err = login(platform, "john@gmail.com")
+	assert.Equal(t, domain.ErrInvalidWebAuthnResponse, err)
+
+	// a tampered signature
+	ro, _ = uc.BeginWebAuthnLogin(ctx, &domain.WebAuthnLoginDTO{})
+	assertion = roaming.get(ro)
+	assertion.Response.Signature = base64.RawURLEncoding.EncodeToString(make([]byte, 64))
+	_, err = uc.FinishWebAuthnLogin(ctx, assertion)
+	assert.Equal(t, domain.ErrInvalidWebAuthnResponse, err)
+
+	// a cloned authenticator has a stale counter
+	platform.signCount = 1
+	_, err = login(platform, "")
+	assert.Equal(t, domain.ErrInvalidWebAuthnResponse, err)
+	platform.signCount = 100
+
+	// another origin
+	platform.origin = "https://evil.example"
+	_, err = login(platform, "")
+	assert.Equal(t, domain.ErrInvalidWebAuthnResponse, err)
+	platform.origin = "http://localhost:9090"
+
+	// the user wasn't verified
+	platform.flags = authDataUserPresent
+	_, err = login(platform, "")
+	assert.Equal(t, domain.ErrInvalidWebAuthnResponse, err)
+	platform.flags = authDataUserPresent | authDataUserVerified
+
+	_, err = login(platform, "")
+	assert.Nil(t, err)
+}